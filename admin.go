@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// adminAuthorized reports whether the request carries ADMIN_API_KEY. Without the key
+// set, no request is.
+func adminAuthorized(r *http.Request) bool {
+	key := os.Getenv("ADMIN_API_KEY")
+	given := requestAPIKey(r)
+	return key != "" && subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1
+}
+
+// requireAdmin wraps a handler changing the configuration or reading the whole
+// database so it answers 401 unless the request carries ADMIN_API_KEY, as an API key
+// or bearer token
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			log.Printf("Rejected %s %s from %s: missing or invalid admin key", r.Method, r.URL.Path, remoteHost(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="septic-monitor"`)
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// CompactResponse reports the rows removed by a compaction and the database size around it
+type CompactResponse struct {
+	Downsampled int   `json:"downsampled"` // Readings rolled up into aggregates
+	Purged      int   `json:"purged"`      // Readings and telemetry samples past the retention period
+	SizeBefore  int64 `json:"size_before"`
+	SizeAfter   int64 `json:"size_after"`
+}
+
+func handleGetDBStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := db.GetStats(r.Context())
+	if err != nil {
+		log.Printf("Error getting database stats: %v", err)
+		http.Error(w, "Failed to get database stats", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleCompactDB runs the database maintenance in one go: the downsampling and purging
+// of the retention job, whether or not it's due, then a vacuum
+func handleCompactDB(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	before, err := db.Size(r.Context())
+	if err != nil {
+		log.Printf("Error getting database size: %v", err)
+		http.Error(w, "Failed to compact database", http.StatusInternalServerError)
+		return
+	}
+
+	downsampled, purged := expireReadings(r.Context())
+
+	after, err := db.Compact(r.Context())
+	if err != nil {
+		log.Printf("Error compacting database: %v", err)
+		http.Error(w, "Failed to compact database", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Database compacted from %d to %d bytes", before, after)
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CompactResponse{Downsampled: downsampled, Purged: purged, SizeBefore: before, SizeAfter: after})
+}
+
+// checkDatabase runs the startup consistency check and raises a data loss alert if the
+// database is damaged or lost readings committed before the restart, e.g. to an SD card
+// that acknowledged writes it never made. A consistent database resolves the alert.
+func checkDatabase(ctx context.Context) {
+	c, err := db.CheckConsistency(ctx)
+	if err != nil {
+		log.Printf("Error checking database consistency: %v", err)
+		return
+	}
+
+	if c.Integrity != "ok" {
+		log.Printf("Database integrity check failed: %s", c.Integrity)
+	}
+	if c.RolledBack {
+		log.Printf("Database rolled back: latest reading is %d, reading %d of %s was committed before the restart",
+			c.LatestID, c.Watermark.ID, c.Watermark.CreatedAt.Format(time.RFC3339))
+	}
+
+	updateHardLimit(defaultDeviceID, db.AlertKindDataLoss, !c.OK())
+}
+
+// RecalibrateRequest describes a correction of historical levels, e.g. after fixing
+// SENSOR_HEIGHT: levels measured 5 too low are corrected with an offset of 5
+type RecalibrateRequest struct {
+	DeviceID string     `json:"device_id"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	Scale    *float64   `json:"scale,omitempty"` // Default: 1
+	Offset   float64    `json:"offset"`
+	DryRun   bool       `json:"dry_run"`
+}
+
+// RecalibrateResponse reports the readings a recalibration changed, or would change on a dry run
+type RecalibrateResponse struct {
+	Readings int64                    `json:"readings"`
+	DryRun   bool                     `json:"dry_run"`
+	Preview  []db.RecalibratedReading `json:"preview"` // The first readings with their old and new level
+}
+
+// recalibratePreviewSize is the number of readings listed in the recalibration preview
+const recalibratePreviewSize = 10
+
+// handleRecalibrate corrects the stored levels of a device in bulk. Derived values
+// such as the widget percentage are computed from the levels when read, so they
+// follow the corrected levels.
+func handleRecalibrate(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RecalibrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.DeviceID == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	recalibration := db.Recalibration{DeviceID: req.DeviceID, Scale: 1, Offset: req.Offset}
+	if req.Scale != nil {
+		if *req.Scale <= 0 {
+			http.Error(w, "scale must be positive", http.StatusBadRequest)
+			return
+		}
+		recalibration.Scale = *req.Scale
+	}
+	if req.From != nil {
+		recalibration.From = *req.From
+	}
+	if req.To != nil {
+		recalibration.To = *req.To
+	}
+
+	n, preview, err := db.RecalibrateReadings(r.Context(), recalibration, recalibratePreviewSize, req.DryRun)
+	if err != nil {
+		log.Printf("Error recalibrating readings: %v", err)
+		http.Error(w, "Failed to recalibrate readings", http.StatusInternalServerError)
+		return
+	}
+
+	if !req.DryRun {
+		log.Printf("Recalibrated %d readings of device %s: scale %g, offset %g", n, req.DeviceID, recalibration.Scale, recalibration.Offset)
+		logCompliance(r.Context(), db.ComplianceRecalibration, req.DeviceID, map[string]any{
+			"scale":    recalibration.Scale,
+			"offset":   recalibration.Offset,
+			"from":     req.From,
+			"to":       req.To,
+			"readings": n,
+		})
+
+		// The storage policy compares new readings against the last stored one
+		lastStoredMux.Lock()
+		delete(lastStored, req.DeviceID)
+		lastStoredMux.Unlock()
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecalibrateResponse{Readings: n, DryRun: req.DryRun, Preview: preview})
+}
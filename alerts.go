@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/email"
+	"sceptic-monitor/internal/incident"
+	"sceptic-monitor/internal/notify"
+	"sceptic-monitor/internal/opsgenie"
+	"sceptic-monitor/internal/pagerduty"
+	"sceptic-monitor/internal/sms"
+	"sceptic-monitor/internal/telegram"
+	"sceptic-monitor/internal/voice"
+	"sceptic-monitor/internal/webhook"
+)
+
+// alertMux serializes alert state transitions driven by incoming readings
+var alertMux sync.Mutex
+
+// checkAndNotify checks if level threshold is reached and sends SMS if needed.
+// receivedAt is when the reading came in, for measuring the alert latency.
+func checkAndNotify(deviceID string, level float64, receivedAt time.Time) {
+	// Alerts are evaluated in the background and outlive the request that triggered them
+	ctx := context.Background()
+
+	alert, previousRound, deliveries := evaluateAlert(ctx, deviceID, level)
+	if len(deliveries) == 0 {
+		return
+	}
+
+	// Deliver outside the lock so acknowledgments can come in meanwhile
+	if dispatchAlert(ctx, alert.ID, measureLatency(*alert, receivedAt, deliveries)) {
+		if err := db.MarkAlertNotified(ctx, alert.ID); err != nil {
+			log.Printf("Error updating alert: %v", err)
+		}
+		return
+	}
+
+	// Nothing got through, allow the next reading to retry
+	if err := db.SetAlertLastNotified(ctx, alert.ID, previousRound); err != nil {
+		log.Printf("Error updating alert: %v", err)
+	}
+}
+
+// evaluateAlert moves the alert of the device through its lifecycle for the level and
+// returns the active alert with the deliveries now due for it, if any. The previous
+// notification round is returned so it can be restored if all deliveries fail.
+func evaluateAlert(ctx context.Context, deviceID string, level float64) (*db.Alert, *time.Time, []delivery) {
+	alertMux.Lock()
+	defer alertMux.Unlock()
+
+	thresholds := levelThresholds()
+	if len(thresholds) == 0 {
+		return nil, nil, nil // No threshold configured
+	}
+
+	alert, err := db.GetActiveAlert(ctx, deviceID, db.AlertKindLevel)
+	if err != nil {
+		log.Printf("Error getting active alert: %v", err)
+		return nil, nil, nil
+	}
+
+	// With a clear threshold, the alert only resolves once the level has dropped below it,
+	// so a level hovering around the threshold doesn't raise alert after alert
+	clearLevel, hysteresis := clearThreshold(thresholds[0].level)
+
+	// Check if level has reached or exceeded the lowest threshold
+	if level < thresholds[0].level {
+		if alert == nil {
+			return nil, nil, nil
+		}
+
+		// Alerts at a threshold with the once policy are notified of the resolution too
+		threshold := thresholdAt(thresholds, alert.Threshold, thresholds[0])
+		if !hysteresis {
+			// Level below threshold, resolve any active alert
+			resolveAlert(ctx, *alert, fmt.Sprintf("level %.2f is back below the threshold", level))
+			if threshold.once && alert.LastNotifiedAt != nil {
+				goBackground(func() {
+					notifyRecoveryOn(ctx, alert.ID, deviceID, alert.Severity, thresholdBackends(threshold), fmt.Sprintf("Level %.2f is back below the threshold", level))
+				})
+			}
+		} else if level < clearLevel {
+			resolveAlert(ctx, *alert, fmt.Sprintf("level %.2f is back below the clear threshold", level))
+			goBackground(func() {
+				notifyRecoveryOn(ctx, alert.ID, deviceID, alert.Severity, thresholdBackends(threshold), fmt.Sprintf("Level %.2f is back to normal", level))
+			})
+		}
+		return nil, nil, nil
+	}
+
+	// The highest threshold reached
+	reached := thresholds[0]
+	for _, t := range thresholds {
+		if level >= t.level {
+			reached = t
+		}
+	}
+
+	if alert == nil {
+		alert = &db.Alert{
+			ID:        fmt.Sprintf("septic-monitor-%d", time.Now().UnixNano()),
+			DeviceID:  deviceID,
+			Kind:      db.AlertKindLevel,
+			Severity:  reached.severity,
+			Level:     level,
+			Threshold: reached.level,
+			State:     db.AlertRaised,
+			RaisedAt:  time.Now(),
+		}
+		if err := db.CreateAlert(ctx, *alert); err != nil {
+			log.Printf("Error creating alert: %v", err)
+			return nil, nil, nil
+		}
+		log.Printf("Alert %s raised: level %.2f reached %s threshold %.2f", alert.ID, level, reached.name, reached.level)
+	}
+
+	// Alerts escalate to higher thresholds, and stay at the highest one reached until resolved
+	escalated := false
+	if reached.level > alert.Threshold {
+		if err := db.SetAlertThreshold(ctx, alert.ID, reached.severity, reached.level); err != nil {
+			log.Printf("Error updating alert: %v", err)
+		}
+		alert.Severity = reached.severity
+		alert.Threshold = reached.level
+		escalated = true
+		log.Printf("Alert %s escalated to %s threshold: level %.2f", alert.ID, reached.name, level)
+	}
+
+	// The threshold the alert is at, unless it is no longer configured
+	threshold := thresholdAt(thresholds, alert.Threshold, reached)
+
+	if alert.State == db.AlertAcknowledged {
+		log.Printf("Alert acknowledged, skipping notification (level: %.2f, threshold: %.2f)", level, threshold.level)
+		return nil, nil, nil
+	}
+
+	// With hysteresis or the once policy, alerts are notified once when raised and once per escalation
+	if (hysteresis || threshold.once) && alert.LastNotifiedAt != nil && !escalated {
+		return nil, nil, nil
+	}
+
+	// Prevent duplicate notifications within the cooldown period of the threshold
+	if !hysteresis && !escalated && alert.LastNotifiedAt != nil && time.Since(*alert.LastNotifiedAt) < threshold.cooldown {
+		log.Printf("Notification already sent recently, skipping (level: %.2f, threshold: %.2f, cooldown: %v)", level, threshold.level, threshold.cooldown)
+		return nil, nil, nil
+	}
+
+	// Claim this notification round before delivering outside the lock
+	previousRound := alert.LastNotifiedAt
+	now := time.Now()
+	if err := db.SetAlertLastNotified(ctx, alert.ID, &now); err != nil {
+		log.Printf("Error updating alert: %v", err)
+		return nil, nil, nil
+	}
+
+	return alert, previousRound, alertDeliveries(ctx, alert.ID, deviceID, threshold, level)
+}
+
+// alertDeliveries returns the deliveries notifying about the alert at the threshold on
+// every channel configured for it
+func alertDeliveries(ctx context.Context, id, deviceID string, threshold levelThreshold, level float64) []delivery {
+	label := deviceLabel(ctx, deviceID)
+	message := fmt.Sprintf("Alert: Level %.2f has reached the threshold of %.2f", level, threshold.level)
+	if label != "" {
+		message = fmt.Sprintf("Alert (%s): Level %.2f has reached the threshold of %.2f", label, level, threshold.level)
+	}
+	if threshold.message != "" {
+		device := label
+		if device == "" {
+			device = deviceID
+		}
+		message = strings.NewReplacer(
+			"{name}", threshold.name,
+			"{level}", fmt.Sprintf("%.2f", level),
+			"{threshold}", fmt.Sprintf("%.2f", threshold.level),
+			"{device}", device,
+		).Replace(threshold.message)
+	}
+
+	// Send messages first, by SMS to the site's recipients as well
+	severity := threshold.severity
+	deliveries := backendDeliveries(ctx, id, deviceID, severity, thresholdBackends(threshold), message)
+
+	// Open incidents, repeated creates are deduplicated by the alert ID
+	for _, notifier := range incidentNotifiers() {
+		deliveries = append(deliveries, delivery{
+			channel: notifier.Name(),
+			send: func(ctx context.Context) error {
+				ctx, cancel := notifyContext(ctx)
+				defer cancel()
+
+				return notifier.Create(ctx, incident.Alert{ID: id, Summary: message, Severity: severity})
+			},
+		})
+	}
+
+	// Post the alert to the webhooks, e.g. for home automation
+	alert := db.Alert{ID: id, DeviceID: deviceID, Kind: db.AlertKindLevel, Severity: severity, Level: level, Threshold: threshold.level}
+	event := alertEvent(ctx, webhook.EventAlert, alert, message)
+	broadcastAlertEvent(event)
+	deliveries = append(deliveries, webhookDeliveries(ctx, event)...)
+	deliveries = append(deliveries, mqttDeliveries(event)...)
+
+	// Critical levels also trigger voice calls, an SMS at night is easy to sleep through.
+	// The call can be delayed to give recipients the chance to acknowledge the SMS first.
+	if severity == incident.SeverityCritical {
+		voiceMessage := fmt.Sprintf("Critical septic tank alert. The level is %.0f and has reached the critical threshold of %.0f.", level, threshold.level)
+		if label != "" {
+			voiceMessage = fmt.Sprintf("Critical septic tank alert for %s. The level is %.0f and has reached the critical threshold of %.0f.", label, level, threshold.level)
+		}
+		deliveries = append(deliveries, delivery{
+			channel: "voice",
+			delay:   voiceCallDelay(),
+			send: func(ctx context.Context) error {
+				return callVoice(ctx, id, voiceMessage)
+			},
+		})
+	}
+
+	return deliveries
+}
+
+// delivery is a notification of an alert on one channel
+type delivery struct {
+	channel string
+	delay   time.Duration // Wait before delivering, giving recipients time to acknowledge
+	send    func(ctx context.Context) error
+}
+
+// dispatchAlert runs the deliveries of an alert in order of their delay, skipping the
+// remaining ones once the alert is acknowledged on any channel or resolved.
+// It reports whether any delivery succeeded.
+func dispatchAlert(ctx context.Context, id string, deliveries []delivery) bool {
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].delay < deliveries[j].delay
+	})
+
+	start := time.Now()
+	delivered := false
+	for _, d := range deliveries {
+		if wait := d.delay - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		if !alertPending(ctx, id) {
+			log.Printf("Alert %s acknowledged or resolved, skipping %s delivery", id, d.channel)
+			continue
+		}
+
+		if !channelAllowed(d.channel) {
+			log.Printf("Circuit breaker of %s open, skipping delivery of alert %s", d.channel, id)
+			continue
+		}
+
+		err := d.send(ctx)
+		recordDelivery(d.channel, err)
+		if err != nil {
+			log.Printf("Error delivering alert %s via %s: %v", id, d.channel, err)
+			continue
+		}
+
+		log.Printf("Alert %s delivered via %s", id, d.channel)
+		delivered = true
+	}
+
+	return delivered
+}
+
+// alertPending reports whether the alert is still active and unacknowledged
+func alertPending(ctx context.Context, id string) bool {
+	pending, err := db.IsAlertPending(ctx, id)
+	if err != nil {
+		log.Printf("Error getting alert state: %v", err)
+		return false
+	}
+	return pending
+}
+
+// resolveAlert resolves the alert, closes it in external incident tools and tells the
+// webhooks, MQTT and the live streams once the condition has cleared
+func resolveAlert(ctx context.Context, alert db.Alert, reason string) {
+	id := alert.ID
+	if err := db.ResolveAlert(ctx, id); err != nil {
+		log.Printf("Error resolving alert: %v", err)
+		return
+	}
+
+	log.Printf("Alert %s resolved: %s", id, reason)
+
+	goBackground(func() { notifyWebhooksResolved(ctx, alert, reason) })
+	broadcastAlertEvent(alertEvent(ctx, webhook.EventResolved, alert, "Resolved: "+reason))
+	goBackground(func() { publishResolved(ctx, alert, reason) })
+
+	for _, notifier := range incidentNotifiers() {
+		nctx, cancel := notifyContext(ctx)
+		if err := notifier.Close(nctx, id); err != nil {
+			log.Printf("Error closing %s incident: %v", notifier.Name(), err)
+		}
+		cancel()
+	}
+}
+
+// acknowledgeAlert acknowledges the active alert, suppressing further notifications until
+// the level drops below the threshold. An empty id acknowledges whichever alert is active.
+// It reports whether a matching alert was pending.
+func acknowledgeAlert(ctx context.Context, id string) bool {
+	if id == "" {
+		alert, err := db.GetLatestPendingAlert(ctx)
+		if err != nil {
+			log.Printf("Error getting active alert: %v", err)
+			return false
+		}
+		if alert == nil {
+			return false
+		}
+		id = alert.ID
+	}
+
+	acknowledged, err := db.AcknowledgeAlert(ctx, id)
+	if err != nil {
+		log.Printf("Error acknowledging alert: %v", err)
+		return false
+	}
+	if !acknowledged {
+		return false
+	}
+
+	log.Printf("Alert %s acknowledged", id)
+
+	for _, notifier := range incidentNotifiers() {
+		nctx, cancel := notifyContext(ctx)
+		if err := notifier.Acknowledge(nctx, id); err != nil {
+			log.Printf("Error acknowledging %s incident: %v", notifier.Name(), err)
+		}
+		cancel()
+	}
+	return true
+}
+
+// incidentNotifiers returns the configured incident management tools
+func incidentNotifiers() []incident.Notifier {
+	var notifiers []incident.Notifier
+	if pagerduty.Enabled() {
+		notifiers = append(notifiers, pagerduty.Notifier{})
+	}
+	if opsgenie.Enabled() {
+		notifiers = append(notifiers, opsgenie.Notifier{})
+	}
+	return notifiers
+}
+
+// notifyContext derives a context bounding a call to an external notification API,
+// from NOTIFY_TIMEOUT in seconds (default: 10 seconds)
+func notifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := 10 * time.Second
+	if timeoutStr := os.Getenv("NOTIFY_TIMEOUT"); timeoutStr != "" {
+		seconds, err := strconv.Atoi(timeoutStr)
+		if err == nil && seconds > 0 {
+			timeout = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid NOTIFY_TIMEOUT value: %s, using default 10 seconds", timeoutStr)
+		}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// clearThreshold returns the level an alert at the threshold resolves below from
+// LEVEL_CLEAR_THRESHOLD, if configured below the threshold
+func clearThreshold(threshold float64) (float64, bool) {
+	level, ok := envLevel("LEVEL_CLEAR_THRESHOLD")
+	level -= vacationOffset() // Lowered with the thresholds in vacation mode
+	if ok && level >= threshold {
+		log.Printf("Invalid LEVEL_CLEAR_THRESHOLD value: %.2f is not below the threshold", level)
+		return 0, false
+	}
+	return level, ok
+}
+
+// voiceCallDelay returns how long to wait before calling about a critical alert,
+// from VOICE_CALL_DELAY in minutes (default: 0, call right away)
+func voiceCallDelay() time.Duration {
+	delayStr := setting("VOICE_CALL_DELAY")
+	if delayStr == "" {
+		return 0
+	}
+
+	delayMinutes, err := strconv.Atoi(delayStr)
+	if err != nil {
+		log.Printf("Invalid VOICE_CALL_DELAY value: %v, calling right away", err)
+		return 0
+	}
+
+	return time.Duration(delayMinutes) * time.Minute
+}
+
+// callVoice places a voice call to every configured number, failing only if no call was placed.
+// Once the SMS budget no longer covers a call, the number gets the message as an SMS instead.
+func callVoice(ctx context.Context, alertID, message string) error {
+	var lastErr error
+	called := false
+	for _, phoneNumber := range voice.PhoneNumbers() {
+		release, ok := reserveBudget(ctx, "voice")
+		if !ok {
+			log.Printf("SMS budget does not cover a voice call to %s, sending an SMS instead", phoneNumber)
+			if err := sendSMS(ctx, alertID, phoneNumber, message); err != nil {
+				log.Printf("Error sending SMS to %s: %v", phoneNumber, err)
+				lastErr = err
+				continue
+			}
+			called = true
+			continue
+		}
+
+		nctx, cancel := notifyContext(ctx)
+		msgID, err := voice.CallTo(nctx, phoneNumber, message)
+		cancel()
+		if err != nil {
+			release()
+			log.Printf("Error placing voice call to %s: %v", phoneNumber, err)
+			lastErr = err
+			continue
+		}
+
+		if _, err := db.SaveNotification(ctx, alertID, "voice", phoneNumber, message, msgID, sms.StatusSent); err != nil {
+			log.Printf("Error recording voice notification: %v", err)
+		}
+		release()
+		called = true
+	}
+
+	if !called {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no voice phone numbers configured")
+		}
+		return lastErr
+	}
+	return nil
+}
+
+// alertRecipients returns the phone numbers alerted about a device: the configured
+// number plus the recipients of the device's site
+func alertRecipients(ctx context.Context, deviceID string) []string {
+	var recipients []string
+	if phoneNumber := setting("SMS_PHONE_NUMBER"); phoneNumber != "" {
+		recipients = append(recipients, phoneNumber)
+	}
+
+	site, err := db.GetDeviceSite(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error getting device site: %v", err)
+	}
+	if site != nil {
+		recipients = append(recipients, site.Recipients...)
+	}
+
+	return recipients
+}
+
+// notifierBackend is a messaging backend that can be enabled in NOTIFIERS
+type notifierBackend struct {
+	notifier notify.Notifier
+	byPhone  bool // Sent to every alert recipient's phone number, otherwise once to the backend's own destination
+}
+
+// notifierBackends are the available messaging backends by name
+var notifierBackends = map[string]notifierBackend{
+	"sms":      {notifier: sms.Notifier{}, byPhone: true},
+	"email":    {notifier: email.Notifier{}},
+	"telegram": {notifier: telegram.Notifier{}},
+}
+
+// messageNotifiers returns the messaging backends alerts of the severity are sent on,
+// as a comma-separated list of backend names. NOTIFIERS_WARNING and NOTIFIERS_CRITICAL
+// override NOTIFIERS (default: sms) for their severity, e.g. to send warnings by email
+// only and save SMS credits for critical alerts.
+func messageNotifiers(severity string) []notifierBackend {
+	key := "NOTIFIERS_" + strings.ToUpper(severity)
+	names := setting(key)
+	if severity == "" || names == "" {
+		key = "NOTIFIERS"
+		names = setting(key)
+	}
+	if names == "" {
+		names = "sms"
+	}
+
+	return parseNotifiers(key, names)
+}
+
+// parseNotifiers returns the messaging backends of a comma-separated list of backend names,
+// logging invalid entries of the variable key
+func parseNotifiers(key, names string) []notifierBackend {
+	var backends []notifierBackend
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		backend, ok := notifierBackends[name]
+		if !ok {
+			log.Printf("Invalid %s entry: %q", key, name)
+			continue
+		}
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+// thresholdBackends returns the messaging backends alerts at the threshold are sent on
+func thresholdBackends(t levelThreshold) []notifierBackend {
+	if t.notifiers != "" {
+		return parseNotifiers(thresholdKey(t.name, "NOTIFIERS"), t.notifiers)
+	}
+	return messageNotifiers(t.severity)
+}
+
+// messageDeliveries returns a delivery of the message on every messaging backend configured for the severity
+func messageDeliveries(ctx context.Context, id, deviceID, severity, message string) []delivery {
+	return backendDeliveries(ctx, id, deviceID, severity, messageNotifiers(severity), message)
+}
+
+// backendDeliveries returns a delivery of the message on every one of the messaging backends,
+// and to every recipient wanting alerts of the severity on the channels they chose
+func backendDeliveries(ctx context.Context, id, deviceID, severity string, backends []notifierBackend, message string) []delivery {
+	recipients := alertRecipients(ctx, deviceID)
+
+	var deliveries []delivery
+	for _, backend := range backends {
+		deliveries = append(deliveries, delivery{
+			channel: backend.notifier.Name(),
+			send: func(ctx context.Context) error {
+				return notifyAll(ctx, backend, id, recipients, message)
+			},
+		})
+	}
+	return append(deliveries, recipientDeliveries(ctx, id, severity, backends, recipients, message)...)
+}
+
+// notifyAll sends the message on the backend, once per recipient if it is addressed by
+// phone number. It fails only if no recipient got it.
+func notifyAll(ctx context.Context, backend notifierBackend, alertID string, recipients []string, message string) error {
+	if !backend.byPhone {
+		return sendNotification(ctx, backend.notifier, alertID, "", message)
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("phone number not configured")
+	}
+
+	var lastErr error
+	sent := false
+	for _, phoneNumber := range recipients {
+		if err := sendNotification(ctx, backend.notifier, alertID, phoneNumber, message); err != nil {
+			log.Printf("Error sending %s to %s: %v", backend.notifier.Name(), phoneNumber, err)
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+
+	if !sent {
+		return lastErr
+	}
+	return nil
+}
+
+// sendNotification sends a message and records it so delivery reports can be correlated with it.
+// alertID links the message to the alert it notifies about, empty for other messages.
+// Messages beyond the monthly SMS budget are not sent, leaving alerts to the other channels.
+func sendNotification(ctx context.Context, n notify.Notifier, alertID, recipient, message string) error {
+	// The reservation is released once the message is recorded, or refunded if it wasn't sent
+	if paidChannels[n.Name()] {
+		release, ok := reserveBudget(ctx, n.Name())
+		if !ok {
+			return errBudgetExhausted
+		}
+		defer release()
+	}
+
+	nctx, cancel := notifyContext(ctx)
+	msgID, err := n.Send(nctx, notify.Alert{ID: alertID, Recipient: recipient, Message: message})
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.SaveNotification(ctx, alertID, n.Name(), recipient, message, msgID, sms.StatusSent); err != nil {
+		log.Printf("Error recording %s notification: %v", n.Name(), err)
+	}
+	return nil
+}
+
+// sendSMS sends an SMS whichever backends alerts go to, e.g. to reply to SMS commands
+func sendSMS(ctx context.Context, alertID, phoneNumber, message string) error {
+	return sendNotification(ctx, sms.Notifier{}, alertID, phoneNumber, message)
+}
+
+func handleGetAlerts(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", db.AlertRaised, db.AlertNotified, db.AlertAcknowledged, db.AlertResolved:
+	default:
+		http.Error(w, "state must be raised, notified, acknowledged or resolved", http.StatusBadRequest)
+		return
+	}
+
+	// Filter by kind, e.g. kind=overflow for the overflow history
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "", db.AlertKindLevel, db.AlertKindFloatSwitch, db.AlertKindOverflow, db.AlertKindRisk, db.AlertKindPumpOutDue, db.AlertKindDataLoss, db.AlertKindStale,
+		db.AlertKindLatency, db.AlertKindVacationRise:
+	default:
+		http.Error(w, "kind must be level, float_switch, overflow, risk, pump_out_due, data_loss, stale, latency_slo or vacation_rise", http.StatusBadRequest)
+		return
+	}
+
+	alerts, err := db.GetAlerts(r.Context(), state, kind, 100)
+	if err != nil {
+		log.Printf("Error getting alerts: %v", err)
+		http.Error(w, "Failed to get alerts", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// handleAcknowledgeAlert acknowledges an alert through the API, e.g. from a dashboard
+// or an incident tool webhook
+func handleAcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !acknowledgeAlert(r.Context(), r.PathValue("id")) {
+		http.Error(w, "Alert not active", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: "Alert acknowledged",
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/parquet"
+)
+
+// runAnalyzeExport writes the dataset at DATABASE_PATH or DATABASE_URL for offline
+// analysis: a table to a Parquet file, or all of them to a DuckDB database
+func runAnalyzeExport(args []string) error {
+	flags := flag.NewFlagSet("analyze-export", flag.ExitOnError)
+	format := flags.String("format", "", "parquet or duckdb (default: from the file extension, .duckdb for duckdb)")
+	table := flags.String("table", "readings", "table written to a Parquet file: "+strings.Join(exportTableNames(), ", "))
+	chunk := flags.Int("chunk", 100000, "rows read and written at a time, a row group each in Parquet")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: analyze-export [flags] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected the export file")
+	}
+	if *chunk < 1 {
+		return fmt.Errorf("chunk must be positive")
+	}
+	file := flags.Arg(0)
+	if *format == "" {
+		*format = "parquet"
+		if filepath.Ext(file) == ".duckdb" {
+			*format = "duckdb"
+		}
+	}
+
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch *format {
+	case "parquet":
+		t, ok := exportTable(*table)
+		if !ok {
+			return fmt.Errorf("unknown table %q, expected one of %s", *table, strings.Join(exportTableNames(), ", "))
+		}
+		return exportParquet(ctx, file, t, *chunk)
+	case "duckdb":
+		return exportDuckDB(ctx, file, *chunk)
+	default:
+		return fmt.Errorf("unknown format %q, expected parquet or duckdb", *format)
+	}
+}
+
+func exportTable(name string) (db.ExportTable, bool) {
+	for _, t := range db.ExportTables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return db.ExportTable{}, false
+}
+
+func exportTableNames() []string {
+	names := make([]string, len(db.ExportTables))
+	for i, t := range db.ExportTables {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// parquetColumns maps the columns of an exported table to Parquet columns
+func parquetColumns(t db.ExportTable) []parquet.Column {
+	columns := make([]parquet.Column, len(t.Columns))
+	for i, c := range t.Columns {
+		columns[i] = parquet.Column{Name: c.Name, Nullable: c.Nullable}
+		switch c.Type {
+		case db.ColumnInt:
+			columns[i].Type = parquet.Int64
+		case db.ColumnFloat:
+			columns[i].Type = parquet.Double
+		case db.ColumnText:
+			columns[i].Type = parquet.String
+		case db.ColumnTime:
+			columns[i].Type = parquet.Timestamp
+		}
+	}
+	return columns
+}
+
+// exportParquet writes the table to a Parquet file, in row groups of the chunk size.
+// The file is written next to its destination and renamed once complete.
+func exportParquet(ctx context.Context, file string, t db.ExportTable, chunk int) error {
+	f, err := os.CreateTemp(filepath.Dir(file), ".export-*.parquet")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := parquet.NewWriter(f, parquetColumns(t))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	n := 0
+	err = t.Export(ctx, chunk, func(rows [][]any) error {
+		n += len(rows)
+		return w.WriteRowGroup(rows)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", t.Name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	fmt.Printf("Exported %d rows of %s to %s\n", n, t.Name, file)
+	return nil
+}
+
+// exportDuckDB writes every table to a DuckDB database, replacing the tables of an
+// existing one. The tables go through Parquet files loaded with the duckdb command,
+// which needs to be installed.
+func exportDuckDB(ctx context.Context, file string, chunk int) error {
+	duckdb, err := exec.LookPath("duckdb")
+	if err != nil {
+		return fmt.Errorf("duckdb command not found, install it or export to Parquet: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "septic-export-")
+	if err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var script strings.Builder
+	script.WriteString("BEGIN TRANSACTION;\n")
+	for _, t := range db.ExportTables {
+		tableFile := filepath.Join(dir, t.Name+".parquet")
+		if err := exportParquet(ctx, tableFile, t, chunk); err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "CREATE OR REPLACE TABLE %s AS SELECT * FROM read_parquet('%s');\n",
+			t.Name, strings.ReplaceAll(tableFile, "'", "''"))
+	}
+	script.WriteString("COMMIT;\n")
+
+	cmd := exec.CommandContext(ctx, duckdb, file)
+	cmd.Stdin = strings.NewReader(script.String())
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to load tables into %s: %w: %s", file, err, strings.TrimSpace(output.String()))
+	}
+
+	fmt.Printf("Exported %s to %s\n", strings.Join(exportTableNames(), ", "), file)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// BacklogRequest is a chunk of readings buffered by a device while it was offline.
+// Every reading carries a sequence number incremented by one per reading.
+type BacklogRequest struct {
+	Readings []BacklogReading `json:"readings"`
+	SentAt   *time.Time       `json:"sent_at,omitempty"` // Device clock time when the chunk was sent
+	Reset    bool             `json:"reset,omitempty"`   // Restart sequence numbering, e.g. after a reflash
+}
+
+// BacklogReading is a buffered reading with its sequence number
+type BacklogReading struct {
+	Seq       int64      `json:"seq"`
+	Level     float64    `json:"level"`
+	Timestamp *time.Time `json:"timestamp,omitempty"` // Device clock time of the reading
+}
+
+// BacklogResponse tells the device the highest sequence number stored so far;
+// the device drops everything up to it and resumes uploading after it
+type BacklogResponse struct {
+	AcceptedSeq int64 `json:"accepted_seq"`
+}
+
+// handleUploadBacklog accepts a chunk of buffered readings. Readings already stored are
+// skipped and the chunk is cut at the first gap, so retried or reordered uploads
+// never cause duplicates or holes in the history.
+func handleUploadBacklog(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID := r.PathValue("id")
+	if !authorizeIngest(w, r, deviceID) {
+		return
+	}
+
+	// Parse request body
+	var req BacklogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Reset {
+		if err := db.ResetDeviceSeq(r.Context(), deviceID); err != nil {
+			log.Printf("Error resetting device sequence: %v", err)
+			http.Error(w, "Failed to reset sequence", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lastSeq, err := db.GetDeviceLastSeq(r.Context(), deviceID)
+	if err != nil {
+		log.Printf("Error getting device sequence: %v", err)
+		http.Error(w, "Failed to save data", http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(req.Readings, func(i, j int) bool {
+		return req.Readings[i].Seq < req.Readings[j].Seq
+	})
+
+	// Offset of the device clock, measured on the chunk as a whole
+	now := time.Now()
+	var offset time.Duration
+	if req.SentAt != nil {
+		offset = backlogClockOffset(r.Context(), deviceID, *req.SentAt, now)
+	}
+
+	// Take the contiguous run of readings following the last accepted one. The storage
+	// policy applies within the chunk, the readings predate those stored since.
+	source := remoteHost(r)
+	deadband, interval := storagePolicy()
+	var reference, newest *db.Reading
+	var accepted []db.Reading
+	var skew *time.Duration
+	expected := lastSeq + 1
+	for _, reading := range req.Readings {
+		if reading.Seq < expected {
+			continue // Already stored
+		}
+		if reading.Seq > expected {
+			break // Gap, the device has to resend from expected
+		}
+		expected++
+
+		stored := db.Reading{Level: reading.Level, Timestamp: now, Transport: db.TransportBacklog, Source: source}
+		if reading.Timestamp != nil {
+			stored.RawTimestamp = reading.Timestamp
+			stored.Timestamp = reading.Timestamp.Add(offset)
+			if req.SentAt == nil && !plausibleBacklogTime(*reading.Timestamp, now) {
+				d := reading.Timestamp.Sub(now)
+				skew = &d
+				stored.Timestamp = now
+			}
+		}
+		stored = prepareReading(r.Context(), deviceID, stored, false)
+		newest = &stored
+
+		if db.IsTrustedQuality(stored.Quality) && interval > 0 {
+			if reference != nil && repeatsReading(*reference, stored, deadband, interval) {
+				continue
+			}
+			reference = &stored
+		}
+		accepted = append(accepted, stored)
+	}
+	if skew != nil {
+		flagClockSkew(r.Context(), deviceID, *skew)
+	}
+
+	if err := db.SaveBacklog(r.Context(), deviceID, expected-1, accepted); err != nil {
+		log.Printf("Error saving backlog: %v", err)
+		http.Error(w, "Failed to save data", http.StatusInternalServerError)
+		return
+	}
+
+	logComplianceReadings(r.Context(), deviceID, accepted...)
+	for _, reading := range accepted {
+		announceReading(deviceID, reading)
+	}
+
+	if err := db.TouchDevice(r.Context(), deviceID, ""); err != nil {
+		log.Printf("Error updating device: %v", err)
+	}
+
+	if newest != nil {
+		log.Printf("Accepted %d backlog readings from device %s (up to seq %d), %d stored",
+			expected-1-lastSeq, deviceID, expected-1, len(accepted))
+
+		// Only the newest reading reflects the current level, whether stored or not
+		if level := newest.Level; db.IsTrustedQuality(newest.Quality) {
+			goBackground(func() { checkAndNotify(deviceID, level, now) })
+		}
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(BacklogResponse{AcceptedSeq: expected - 1})
+}
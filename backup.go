@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"sceptic-monitor/internal/db"
+)
+
+// handleBackup serves a consistent snapshot of the database, taken while the server
+// keeps running. Copying data.db instead risks a corrupt copy of a file being written.
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "septic-backup-")
+	if err != nil {
+		log.Printf("Error creating backup directory: %v", err)
+		http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "backup.db")
+	if err := db.Backup(r.Context(), file); err != nil {
+		if errors.Is(err, db.ErrBackupUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		log.Printf("Error backing up database: %v", err)
+		http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Printf("Error opening backup: %v", err)
+		http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Send response
+	name := "septic-monitor-" + time.Now().UTC().Format("20060102-150405") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("Error sending backup: %v", err)
+	}
+}
+
+// handleRestore replaces the database with the backup in the body, as served by
+// /api/admin/backup, and reloads the settings stored in it. Backups larger than
+// RESTORE_MAX_MB megabytes (default: 1024) are refused.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(envLimit("RESTORE_MAX_MB", 1024))<<20)
+
+	f, err := os.CreateTemp("", "septic-restore-*.db")
+	if err != nil {
+		log.Printf("Error creating restore file: %v", err)
+		http.Error(w, "Failed to restore database", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Backup too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read backup", http.StatusBadRequest)
+		return
+	}
+
+	if err := restoreDatabase(r.Context(), f.Name()); err != nil {
+		switch {
+		case errors.Is(err, db.ErrBackupUnsupported):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case errors.Is(err, db.ErrInvalidBackup):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Error restoring database: %v", err)
+			http.Error(w, "Failed to restore database", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreDatabase restores the backup in the file and reloads the settings stored in it
+func restoreDatabase(ctx context.Context, file string) error {
+	if err := db.Restore(ctx, file); err != nil {
+		return err
+	}
+	if err := loadSettings(ctx); err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+	return nil
+}
+
+// runBackup writes a consistent snapshot of the database at DATABASE_PATH to a file,
+// safe to run next to the server, e.g. from cron
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: backup <file>")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected the backup file")
+	}
+
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Backup(context.Background(), flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("Database backed up to %s\n", flags.Arg(0))
+	return nil
+}
+
+// runRestore replaces the database at DATABASE_PATH with a backup. A running server
+// keeps the settings it loaded, restore through /api/admin/restore then.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: restore <file>")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected the backup file")
+	}
+
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Restore(context.Background(), flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("Database restored from %s\n", flags.Arg(0))
+	return nil
+}
+
+// openDatabase opens the database configured in the environment or the .env file
+func openDatabase() error {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found.")
+	}
+	return db.Init()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// errBudgetExhausted is returned for messages the monthly SMS budget no longer covers
+var errBudgetExhausted = errors.New("monthly SMS budget exhausted")
+
+// budgetReserved is the cost of the paid messages being sent, not yet recorded as
+// notifications. budgetMux guards it so concurrent alerts can't overrun the budget
+// together, without holding up each other while a message is sent.
+var (
+	budgetMux      sync.Mutex
+	budgetReserved float64
+)
+
+// paidChannels are the channels charged to the SMS budget, both are billed by smsapi.pl
+var paidChannels = map[string]bool{"sms": true, "voice": true}
+
+// SMSBudget is the spending on paid messages in the current month
+type SMSBudget struct {
+	Month     string  `json:"month"`  // e.g. 2026-10
+	Budget    float64 `json:"budget"` // 0 if unlimited
+	Spent     float64 `json:"spent"`
+	Remaining float64 `json:"remaining"`
+	SMS       int     `json:"sms"`
+	Voice     int     `json:"voice"`
+	Exhausted bool    `json:"exhausted"`
+}
+
+// messageCost returns the cost of a message on a paid channel, from SMS_COST and
+// VOICE_COST (default: 1, making SMS_MONTHLY_BUDGET a message count). Setting them
+// to the provider's prices in points budgets the prepaid credit instead.
+func messageCost(channel string) float64 {
+	key := "SMS_COST"
+	if channel == "voice" {
+		key = "VOICE_COST"
+	}
+
+	cost, ok := envLevel(key)
+	if !ok || cost < 0 {
+		return 1
+	}
+	return cost
+}
+
+// currentSMSBudget returns the spending of the current calendar month against
+// SMS_MONTHLY_BUDGET (default: 0, unlimited)
+func currentSMSBudget(ctx context.Context) (*SMSBudget, error) {
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	smsCount, err := db.CountNotifications(ctx, "sms", monthStart)
+	if err != nil {
+		return nil, err
+	}
+	voiceCount, err := db.CountNotifications(ctx, "voice", monthStart)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &SMSBudget{
+		Month: monthStart.Format("2006-01"),
+		Spent: float64(smsCount)*messageCost("sms") + float64(voiceCount)*messageCost("voice"),
+		SMS:   smsCount,
+		Voice: voiceCount,
+	}
+	if budget, ok := envLevel("SMS_MONTHLY_BUDGET"); ok && budget > 0 {
+		b.Budget = budget
+		b.Remaining = max(budget-b.Spent, 0)
+		b.Exhausted = b.Remaining < messageCost("sms")
+	}
+
+	return b, nil
+}
+
+// reserveBudget reserves the cost of another message on the channel if the budget covers
+// it, counting the messages other alerts are sending meanwhile. The caller releases the
+// reservation once the message is recorded as a notification, or wasn't sent after all.
+// If the budget can't be determined the message is allowed, an alert matters more.
+func reserveBudget(ctx context.Context, channel string) (release func(), ok bool) {
+	budgetMux.Lock()
+	defer budgetMux.Unlock()
+
+	cost := messageCost(channel)
+	b, err := currentSMSBudget(ctx)
+	if err != nil {
+		log.Printf("Error getting SMS budget: %v", err)
+	} else if b.Budget > 0 && b.Remaining-budgetReserved < cost {
+		return nil, false
+	}
+
+	budgetReserved += cost
+	return func() {
+		budgetMux.Lock()
+		budgetReserved -= cost
+		budgetMux.Unlock()
+	}, true
+}
+
+func handleGetSMSBudget(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	budget, err := currentSMSBudget(r.Context())
+	if err != nil {
+		log.Printf("Error getting SMS budget: %v", err)
+		http.Error(w, "Failed to get SMS budget", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(budget)
+}
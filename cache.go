@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// cacheControl returns the Cache-Control header of the dashboard's aggregate and history
+// responses: fresh for CACHE_MAX_AGE seconds (default: 30), then served stale for up to
+// CACHE_STALE_WHILE_REVALIDATE seconds (default: 300) while revalidated in the
+// background. Shared caches only store them when the read endpoints are open, responses
+// behind credentials are cached by the browser alone. It returns "" if CACHE_MAX_AGE is 0.
+func cacheControl() string {
+	maxAge := envSeconds("CACHE_MAX_AGE", 30*time.Second)
+	if maxAge <= 0 {
+		return ""
+	}
+	staleWhileRevalidate := envSeconds("CACHE_STALE_WHILE_REVALIDATE", 5*time.Minute)
+
+	scope := "public"
+	if readAuthConfigured() {
+		scope = "private"
+	}
+	return fmt.Sprintf("%s, max-age=%d, stale-while-revalidate=%d", scope, int(maxAge.Seconds()), int(staleWhileRevalidate.Seconds()))
+}
+
+// cacheControlWriter sets the Cache-Control header on successful responses only, so
+// a cache in front doesn't hold on to errors
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status == http.StatusOK {
+		w.Header().Set("Cache-Control", w.value)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheable wraps a GET handler of aggregates or history so a CDN or reverse-proxy cache
+// can absorb the dashboard traffic, e.g. during an alert storm
+func cacheable(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := cacheControl()
+		if value == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			handler(w, r)
+			return
+		}
+		handler(&cacheControlWriter{ResponseWriter: w, value: value}, r)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// CalibrationResponse is the current calibration of a device with its history
+type CalibrationResponse struct {
+	Current *db.Calibration  `json:"current"` // Null if the device reports calibrated levels itself
+	History []db.Calibration `json:"history"`
+}
+
+// CalibrationRequest sets the calibration of a device directly
+type CalibrationRequest struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// CalibrationPointRequest records a known level in a calibration session. Without
+// raw_level, the level the sensor last reported is taken.
+type CalibrationPointRequest struct {
+	Level    *float64 `json:"level"`
+	RawLevel *float64 `json:"raw_level"`
+}
+
+// deviceCalibration returns the calibration applied to the readings of a device at ingest,
+// nil if it has none
+func deviceCalibration(ctx context.Context, deviceID string) *db.Calibration {
+	c, err := db.GetCalibration(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error getting calibration: %v", err)
+		return nil
+	}
+	return c
+}
+
+// calibrate applies the calibration to the level of a reading, keeping the raw level
+func calibrate(c *db.Calibration, reading db.Reading) db.Reading {
+	if c == nil || (c.Scale == 1 && c.Offset == 0) {
+		return reading
+	}
+
+	raw := reading.Level
+	reading.RawLevel = &raw
+	reading.Level = math.Round(c.Apply(raw)*1000) / 1000
+	return reading
+}
+
+// fitCalibration computes the scale and offset mapping the raw levels of the points to
+// their known levels. A single point only corrects the offset, more points are fitted
+// by least squares.
+func fitCalibration(points []db.CalibrationPoint) (scale, offset float64, err error) {
+	switch len(points) {
+	case 0:
+		return 0, 0, errors.New("no calibration points recorded")
+	case 1:
+		return 1, points[0].Level - points[0].RawLevel, nil
+	}
+
+	n := float64(len(points))
+	var sumRaw, sumLevel float64
+	for _, p := range points {
+		sumRaw += p.RawLevel
+		sumLevel += p.Level
+	}
+	meanRaw, meanLevel := sumRaw/n, sumLevel/n
+
+	var cov, variance float64
+	for _, p := range points {
+		cov += (p.RawLevel - meanRaw) * (p.Level - meanLevel)
+		variance += (p.RawLevel - meanRaw) * (p.RawLevel - meanRaw)
+	}
+	if variance == 0 {
+		return 0, 0, errors.New("the sensor reported the same raw level at every point")
+	}
+
+	// Distance sensors read less as the level rises, so the scale may be negative
+	scale = cov / variance
+	if scale == 0 {
+		return 0, 0, errors.New("the known levels don't change with the raw level")
+	}
+	return scale, meanLevel - scale*meanRaw, nil
+}
+
+// saveCalibration makes the calibration current and records it in the compliance log
+func saveCalibration(ctx context.Context, c db.Calibration) (*db.Calibration, error) {
+	saved, err := db.SaveCalibration(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Calibration of device %s set: scale %g, offset %g", c.DeviceID, c.Scale, c.Offset)
+	logCompliance(ctx, db.ComplianceCalibration, c.DeviceID, saved)
+
+	// The storage policy compares new readings against the last stored one
+	lastStoredMux.Lock()
+	delete(lastStored, c.DeviceID)
+	lastStoredMux.Unlock()
+
+	return saved, nil
+}
+
+func handleCalibration(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetCalibration(w, r)
+	case http.MethodPut:
+		handlePutCalibration(w, r)
+	case http.MethodDelete:
+		handleResetCalibration(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetCalibration serves the current calibration of a device and its history
+func handleGetCalibration(w http.ResponseWriter, r *http.Request) {
+	history, err := db.GetCalibrations(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting calibrations: %v", err)
+		http.Error(w, "Failed to get calibration", http.StatusInternalServerError)
+		return
+	}
+
+	response := CalibrationResponse{History: history}
+	if len(history) > 0 {
+		response.Current = &history[0]
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePutCalibration sets the calibration of a device directly, e.g. one computed elsewhere
+func handlePutCalibration(w http.ResponseWriter, r *http.Request) {
+	var req CalibrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Scale == 0 {
+		http.Error(w, "scale must not be 0", http.StatusBadRequest)
+		return
+	}
+
+	writeCalibration(w, r, db.Calibration{DeviceID: r.PathValue("id"), Scale: req.Scale, Offset: req.Offset})
+}
+
+// handleResetCalibration stops calibrating the readings of a device, recorded in the history
+// as the identity calibration
+func handleResetCalibration(w http.ResponseWriter, r *http.Request) {
+	writeCalibration(w, r, db.Calibration{DeviceID: r.PathValue("id"), Scale: 1})
+}
+
+// writeCalibration saves the calibration and responds with it
+func writeCalibration(w http.ResponseWriter, r *http.Request, c db.Calibration) {
+	saved, err := saveCalibration(r.Context(), c)
+	if err != nil {
+		log.Printf("Error saving calibration: %v", err)
+		http.Error(w, "Failed to save calibration", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func handleCalibrationSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetCalibrationSession(w, r)
+	case http.MethodPost:
+		handleStartCalibrationSession(w, r)
+	case http.MethodDelete:
+		handleCancelCalibrationSession(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStartCalibrationSession opens a calibration session for a device, replacing any open one
+func handleStartCalibrationSession(w http.ResponseWriter, r *http.Request) {
+	session, err := db.StartCalibrationSession(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error starting calibration session: %v", err)
+		http.Error(w, "Failed to start calibration session", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(session)
+}
+
+// openCalibrationSession returns the open calibration session of the device in the path,
+// responding with an error if there is none
+func openCalibrationSession(w http.ResponseWriter, r *http.Request) *db.CalibrationSession {
+	session, err := db.GetOpenCalibrationSession(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting calibration session: %v", err)
+		http.Error(w, "Failed to get calibration session", http.StatusInternalServerError)
+		return nil
+	}
+	if session == nil {
+		http.Error(w, "No calibration session open", http.StatusNotFound)
+		return nil
+	}
+	return session
+}
+
+// handleGetCalibrationSession serves the open calibration session with its points so far
+func handleGetCalibrationSession(w http.ResponseWriter, r *http.Request) {
+	session := openCalibrationSession(w, r)
+	if session == nil {
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(session)
+}
+
+// handleCancelCalibrationSession closes the open calibration session without applying it
+func handleCancelCalibrationSession(w http.ResponseWriter, r *http.Request) {
+	session := openCalibrationSession(w, r)
+	if session == nil {
+		return
+	}
+
+	if err := db.EndCalibrationSession(r.Context(), session.ID, db.CalibrationCancelled); err != nil {
+		log.Printf("Error cancelling calibration session: %v", err)
+		http.Error(w, "Failed to cancel calibration session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAddCalibrationPoint records a known level, e.g. the empty or full mark, with the
+// raw level the sensor reports at it
+func handleAddCalibrationPoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CalibrationPointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Level == nil {
+		http.Error(w, "level is required", http.StatusBadRequest)
+		return
+	}
+
+	session := openCalibrationSession(w, r)
+	if session == nil {
+		return
+	}
+
+	point := db.CalibrationPoint{Level: *req.Level, RecordedAt: time.Now()}
+	if req.RawLevel != nil {
+		point.RawLevel = *req.RawLevel
+	} else {
+		raw, err := db.GetLatestRawLevel(r.Context(), session.DeviceID)
+		if err != nil {
+			log.Printf("Error getting raw level: %v", err)
+			http.Error(w, "Failed to get raw level", http.StatusInternalServerError)
+			return
+		}
+		if raw == nil {
+			http.Error(w, "The device has not reported a level yet, pass raw_level", http.StatusConflict)
+			return
+		}
+		point.RawLevel = *raw
+	}
+
+	if err := db.AddCalibrationPoint(r.Context(), session.ID, point); err != nil {
+		log.Printf("Error adding calibration point: %v", err)
+		http.Error(w, "Failed to add calibration point", http.StatusInternalServerError)
+		return
+	}
+	session.Points = append(session.Points, point)
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(session)
+}
+
+// handleCompleteCalibrationSession computes the calibration from the points of the open
+// session and applies it to the readings ingested from now on
+func handleCompleteCalibrationSession(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := openCalibrationSession(w, r)
+	if session == nil {
+		return
+	}
+
+	scale, offset, err := fitCalibration(session.Points)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Can't compute calibration: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.EndCalibrationSession(r.Context(), session.ID, db.CalibrationApplied); err != nil {
+		log.Printf("Error completing calibration session: %v", err)
+		http.Error(w, "Failed to complete calibration session", http.StatusInternalServerError)
+		return
+	}
+
+	writeCalibration(w, r, db.Calibration{DeviceID: session.DeviceID, Scale: scale, Offset: offset, SessionID: &session.ID})
+}
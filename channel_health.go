@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/incident"
+	"sceptic-monitor/internal/notify"
+)
+
+// Circuit breaker states of a notification channel
+const (
+	breakerClosed   = "closed"    // Deliveries go through
+	breakerOpen     = "open"      // Deliveries are skipped until the cooldown is over
+	breakerHalfOpen = "half_open" // One trial delivery decides whether to close or reopen
+)
+
+// ChannelHealth is the delivery record of a notification channel since startup
+type ChannelHealth struct {
+	Channel             string     `json:"channel"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastFailure         *time.Time `json:"last_failure"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Breaker             string     `json:"breaker"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"` // End of the cooldown while the breaker is open
+	LastCheck           *time.Time `json:"last_check,omitempty"` // Credential check, for backends supporting it
+	CheckError          string     `json:"check_error,omitempty"`
+
+	trial bool // Trial delivery of the half-open breaker in progress
+}
+
+// channelHealth holds the health per channel name
+var (
+	channelHealthMux sync.Mutex
+	channelHealth    = map[string]*ChannelHealth{}
+)
+
+// channelHealthOf returns the health of the channel, channelHealthMux must be held
+func channelHealthOf(channel string) *ChannelHealth {
+	h, ok := channelHealth[channel]
+	if !ok {
+		h = &ChannelHealth{Channel: channel, Breaker: breakerClosed}
+		channelHealth[channel] = h
+	}
+	return h
+}
+
+// breakerSettings returns the consecutive failures opening the breaker of a channel from
+// NOTIFY_BREAKER_FAILURES (default: 5, 0 disables the breaker) and how long it stays
+// open from NOTIFY_BREAKER_COOLDOWN in seconds (default: 300)
+func breakerSettings() (int, time.Duration) {
+	failures := 5
+	if s := os.Getenv("NOTIFY_BREAKER_FAILURES"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err == nil && v >= 0 {
+			failures = v
+		} else {
+			log.Printf("Invalid NOTIFY_BREAKER_FAILURES value: %s, using default 5", s)
+		}
+	}
+	return failures, envSeconds("NOTIFY_BREAKER_COOLDOWN", 5*time.Minute)
+}
+
+// channelAllowed reports whether a delivery on the channel may go ahead. Once the
+// cooldown of an open breaker is over, a single trial delivery is let through.
+func channelAllowed(channel string) bool {
+	channelHealthMux.Lock()
+	defer channelHealthMux.Unlock()
+
+	h := channelHealthOf(channel)
+	switch h.Breaker {
+	case breakerOpen:
+		if time.Now().Before(*h.OpenUntil) {
+			return false
+		}
+		h.Breaker = breakerHalfOpen
+		h.OpenUntil = nil
+		h.trial = true
+		return true
+	case breakerHalfOpen:
+		if h.trial {
+			return false
+		}
+		h.trial = true
+		return true
+	}
+	return true
+}
+
+// recordDelivery records the outcome of a delivery on the channel, opening its breaker
+// after too many consecutive failures or when the trial delivery fails
+func recordDelivery(channel string, err error) {
+	// Messages held back by the SMS budget say nothing about the channel
+	if errors.Is(err, errBudgetExhausted) {
+		return
+	}
+
+	channelHealthMux.Lock()
+	defer channelHealthMux.Unlock()
+
+	h := channelHealthOf(channel)
+	h.trial = false
+	now := time.Now()
+	if err == nil {
+		h.LastSuccess = &now
+		h.ConsecutiveFailures = 0
+		if h.Breaker != breakerClosed {
+			log.Printf("Notification channel %s recovered, closing its circuit breaker", channel)
+		}
+		h.Breaker = breakerClosed
+		h.OpenUntil = nil
+		return
+	}
+
+	h.LastFailure = &now
+	h.LastError = err.Error()
+	h.ConsecutiveFailures++
+
+	failures, cooldown := breakerSettings()
+	if failures > 0 && (h.Breaker == breakerHalfOpen || h.ConsecutiveFailures >= failures) {
+		openUntil := now.Add(cooldown)
+		h.Breaker = breakerOpen
+		h.OpenUntil = &openUntil
+		log.Printf("Notification channel %s failed %d times in a row, skipping it for %v", channel, h.ConsecutiveFailures, cooldown)
+	}
+}
+
+// recordCheck records the outcome of a credential check of the channel
+func recordCheck(channel string, err error) {
+	channelHealthMux.Lock()
+	defer channelHealthMux.Unlock()
+
+	h := channelHealthOf(channel)
+	now := time.Now()
+	h.LastCheck = &now
+	h.CheckError = ""
+	if err != nil {
+		h.CheckError = err.Error()
+	}
+}
+
+// alertBackends returns every messaging backend alerts may be sent on, whatever the
+// severity and threshold, once each
+func alertBackends() []notifierBackend {
+	backends := messageNotifiers("")
+	backends = append(backends, messageNotifiers(incident.SeverityWarning)...)
+	backends = append(backends, messageNotifiers(incident.SeverityCritical)...)
+	for _, t := range levelThresholds() {
+		if t.notifiers != "" {
+			backends = append(backends, parseNotifiers(thresholdKey(t.name, "NOTIFIERS"), t.notifiers)...)
+		}
+	}
+
+	var unique []notifierBackend
+	seen := map[string]bool{}
+	for _, backend := range backends {
+		if !seen[backend.notifier.Name()] {
+			seen[backend.notifier.Name()] = true
+			unique = append(unique, backend)
+		}
+	}
+	return unique
+}
+
+// checkChannels checks the credentials of every messaging backend in use that
+// supports it, without sending a message
+func checkChannels(ctx context.Context) {
+	for _, backend := range alertBackends() {
+		checker, ok := backend.notifier.(notify.Checker)
+		if !ok {
+			continue
+		}
+
+		cctx, cancel := notifyContext(ctx)
+		err := checker.Check(cctx)
+		cancel()
+		if err != nil {
+			log.Printf("Notification channel %s check failed: %v", backend.notifier.Name(), err)
+		}
+		recordCheck(backend.notifier.Name(), err)
+	}
+}
+
+// startChannelChecks checks the messaging backends every NOTIFY_CHECK_INTERVAL seconds
+// (default: 3600, 0 disables the checks), so a revoked token shows up in the channel
+// health before an alert needs it
+func startChannelChecks() {
+	interval := envSeconds("NOTIFY_CHECK_INTERVAL", time.Hour)
+	if interval <= 0 {
+		return
+	}
+
+	every(interval, func() { checkChannels(jobsCtx) })
+}
+
+// handleGetChannelHealth serves the health of every notification channel, ordered by name
+func handleGetChannelHealth(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// List the backends in use even before their first delivery
+	backends := alertBackends()
+	channelHealthMux.Lock()
+	for _, backend := range backends {
+		channelHealthOf(backend.notifier.Name())
+	}
+	channels := []ChannelHealth{}
+	for _, h := range channelHealth {
+		channels = append(channels, *h)
+	}
+	channelHealthMux.Unlock()
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Channel < channels[j].Channel
+	})
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(channels)
+}
@@ -0,0 +1,216 @@
+// Package client is a Go client for the septic monitor HTTP API, for programs
+// sending readings to the server or reacting to the tank level.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Alert states
+const (
+	AlertRaised       = "raised"
+	AlertNotified     = "notified"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// Reading is a level reading sent to the server
+type Reading struct {
+	Level     float64    `json:"level"`
+	Timestamp *time.Time `json:"timestamp,omitempty"` // Device clock time, the server receive time if nil
+	DeviceID  string     `json:"device_id,omitempty"`
+	Battery   *float64   `json:"battery,omitempty"`
+	RSSI      *float64   `json:"rssi,omitempty"`
+	Firmware  string     `json:"firmware,omitempty"`
+	Error     string     `json:"error,omitempty"`     // Sensor fault reported instead of a reading
+	Simulated bool       `json:"simulated,omitempty"` // Test reading, stored but never alerted on
+	Overflow  *bool      `json:"overflow,omitempty"`  // Leak sensor state sent instead of a reading
+}
+
+// Alert is an alert raised by the server
+type Alert struct {
+	ID             string     `json:"id"`
+	DeviceID       string     `json:"device_id"`
+	Kind           string     `json:"kind"`
+	Severity       string     `json:"severity"`
+	Level          float64    `json:"level"`
+	Threshold      float64    `json:"threshold"`
+	State          string     `json:"state"`
+	RaisedAt       time.Time  `json:"raised_at"`
+	NotifiedAt     *time.Time `json:"notified_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+}
+
+// Device is a sensor node known to the server
+type Device struct {
+	ID         string     `json:"id"`
+	Firmware   string     `json:"firmware,omitempty"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	ErrorCount int        `json:"error_count"`
+	LastError  string     `json:"last_error,omitempty"`
+	SiteID     string     `json:"site_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Location   string     `json:"location,omitempty"`
+	Notes      string     `json:"notes,omitempty"`
+}
+
+// Client talks to a septic monitor server
+type Client struct {
+	BaseURL    string // e.g. http://septic.local:8080
+	APIKey     string // Sent as X-API-Key, required to send readings if the server has INGEST_API_KEYS
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send sends a reading to the ingest endpoint
+func (c *Client) Send(ctx context.Context, reading Reading) error {
+	return c.do(ctx, http.MethodPost, "/api", reading, nil)
+}
+
+// Level returns the latest trusted level
+func (c *Client) Level(ctx context.Context) (float64, error) {
+	var level float64
+	if err := c.do(ctx, http.MethodGet, "/api/level", nil, &level); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+// DeviceLevel returns the latest trusted level of a device
+func (c *Client) DeviceLevel(ctx context.Context, deviceID string) (float64, error) {
+	var level float64
+	path := "/api/level?" + url.Values{"device": {deviceID}}.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &level); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+// Alerts returns the latest alerts, optionally only those in the given state
+func (c *Client) Alerts(ctx context.Context, state string) ([]Alert, error) {
+	path := "/api/alerts"
+	if state != "" {
+		path += "?" + url.Values{"state": {state}}.Encode()
+	}
+
+	var alerts []Alert
+	if err := c.do(ctx, http.MethodGet, path, nil, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// AcknowledgeAlert acknowledges an active alert, suppressing further notifications
+func (c *Client) AcknowledgeAlert(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/alerts/"+url.PathEscape(id)+"/ack", nil, nil)
+}
+
+// Devices returns the devices known to the server
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var devices []Device
+	if err := c.do(ctx, http.MethodGet, "/api/devices?include=firmware,last_seen", nil, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Subscribe polls the latest level every interval and sends it on the returned
+// channel whenever it changes, until ctx is done. Errors are passed to onError,
+// if set, and polling continues.
+func (c *Client) Subscribe(ctx context.Context, interval time.Duration, onError func(error)) <-chan float64 {
+	levels := make(chan float64)
+
+	go func() {
+		defer close(levels)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last *float64
+		for {
+			level, err := c.Level(ctx)
+			if err != nil && onError != nil && ctx.Err() == nil {
+				onError(err)
+			}
+			if err == nil && (last == nil || *last != level) {
+				last = &level
+				select {
+				case levels <- level:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return levels
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out, if set
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// clockSkewTolerance returns how far a device clock may be off before its timestamps
+// are corrected, from CLOCK_SKEW_TOLERANCE in seconds (default: 300 seconds = 5 minutes)
+func clockSkewTolerance() time.Duration {
+	tolerance := 300
+	if s := os.Getenv("CLOCK_SKEW_TOLERANCE"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			tolerance = v
+		} else {
+			log.Printf("Invalid CLOCK_SKEW_TOLERANCE value: %q, using default 300 seconds", s)
+		}
+	}
+
+	return time.Duration(tolerance) * time.Second
+}
+
+// correctClockSkew returns the time to store for a reading the device timestamped
+// as ts and the server received at now. Readings are expected to arrive right after
+// they are taken, so a timestamp far from now means the device clock is wrong, e.g.
+// an RTC-less node reporting 1970 after a power loss, and the receive time is used instead.
+func correctClockSkew(ctx context.Context, deviceID string, ts, now time.Time) time.Time {
+	skew := ts.Sub(now)
+	if skew.Abs() <= clockSkewTolerance() {
+		return ts
+	}
+
+	flagClockSkew(ctx, deviceID, skew)
+	return now
+}
+
+// plausibleBacklogTime reports whether a backlog reading timestamped ts by the device
+// may be kept as is, for chunks sent without sent_at to measure the clock against.
+// Buffered readings are old by design, only a time in the future or before the clock
+// could have been set is wrong.
+func plausibleBacklogTime(ts, now time.Time) bool {
+	return !ts.Before(saneClockFloor) && ts.Sub(now) <= clockSkewTolerance()
+}
+
+// backlogClockOffset returns the offset to add to the timestamps of a backlog chunk
+// the device sent at sentAt by its own clock, zero when the clock is within tolerance
+func backlogClockOffset(ctx context.Context, deviceID string, sentAt, now time.Time) time.Duration {
+	offset := now.Sub(sentAt)
+	if offset.Abs() <= clockSkewTolerance() {
+		return 0
+	}
+
+	flagClockSkew(ctx, deviceID, -offset)
+	return offset
+}
+
+// flagClockSkew records the detected skew on the device so it shows up in the inventory
+func flagClockSkew(ctx context.Context, deviceID string, skew time.Duration) {
+	log.Printf("Device %s clock is off by %v, correcting timestamps", deviceID, skew.Round(time.Second))
+
+	if err := db.SetDeviceClockSkew(ctx, deviceID, skew); err != nil {
+		log.Printf("Error flagging device clock skew: %v", err)
+	}
+}
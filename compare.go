@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// compareDefaultRange is the time range compared when none is given
+const compareDefaultRange = 7 * 24 * time.Hour
+
+// levelPoint is a reading in a level history
+type levelPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     float64   `json:"level"`
+}
+
+// comparedDevice is the level history of one device in a comparison
+type comparedDevice struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name,omitempty"`
+	SiteID   string       `json:"site_id,omitempty"`
+	Readings []levelPoint `json:"readings"`
+}
+
+// Comparison is the level history of several devices over the same time range
+type Comparison struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Devices []comparedDevice `json:"devices"`
+}
+
+// parseComparison parses the devices (comma-separated IDs, default: all devices),
+// from and to (default: the last 7 days) query parameters of a comparison
+func parseComparison(r *http.Request) (ids []string, from, to time.Time, err error) {
+	from, to, err = parseTimeRange(r)
+	if err != nil {
+		return nil, from, to, err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-compareDefaultRange)
+	}
+	if !from.Before(to) {
+		return nil, from, to, fmt.Errorf("from must be before to")
+	}
+
+	for _, id := range strings.Split(r.URL.Query().Get("devices"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, from, to, nil
+}
+
+// compareDevices returns the level history of the devices, all devices if none are given
+func compareDevices(ctx context.Context, ids []string, from, to time.Time) (*Comparison, error) {
+	devices, err := db.GetDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	known := map[string]db.Device{}
+	for _, d := range devices {
+		known[d.ID] = d
+	}
+
+	if len(ids) == 0 {
+		for _, d := range devices {
+			ids = append(ids, d.ID)
+		}
+	}
+
+	comparison := &Comparison{From: from, To: to, Devices: []comparedDevice{}}
+	for _, id := range ids {
+		readings, err := db.GetDeviceReadings(ctx, id, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get readings: %w", err)
+		}
+
+		device := comparedDevice{ID: id, Name: known[id].Name, SiteID: known[id].SiteID, Readings: []levelPoint{}}
+		for _, reading := range readings {
+			device.Readings = append(device.Readings, levelPoint{Timestamp: reading.Timestamp, Level: reading.Level})
+		}
+		comparison.Devices = append(comparison.Devices, device)
+	}
+
+	return comparison, nil
+}
+
+func handleGetComparison(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, from, to, err := parseComparison(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comparison, err := compareDevices(r.Context(), ids, from, to)
+	if err != nil {
+		log.Printf("Error comparing devices: %v", err)
+		http.Error(w, "Failed to compare devices", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// compareColors are the line colors of the compared devices, repeating after the last one
+var compareColors = []string{"#1565c0", "#c62828", "#2e7d32", "#f9a825", "#6a1b9a", "#00838f", "#ef6c00", "#4e342e"}
+
+// Size of the plot area of the comparison chart
+const (
+	compareChartWidth  = 800
+	compareChartHeight = 300
+)
+
+var compareTemplate = template.Must(template.New("compare").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Tank comparison</title>
+<style>
+body { font-family: sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; color: #222; }
+svg { width: 100%; height: auto; border: 1px solid #ddd; }
+.grid { stroke: #eee; }
+.axis { font-size: 10px; fill: #666; }
+.legend span { display: inline-block; margin-right: 1.5rem; }
+.legend i { display: inline-block; width: 1rem; height: 0.25rem; vertical-align: middle; margin-right: 0.3rem; }
+nav a { margin-right: 1rem; }
+</style>
+</head>
+<body>
+<h1>Tank comparison</h1>
+<nav>{{range .Ranges}}<a href="{{.URL}}">{{.Label}}</a>{{end}}</nav>
+<p>{{.From}} – {{.To}}</p>
+<svg viewBox="-40 -10 {{.ViewWidth}} {{.ViewHeight}}">
+{{range .Grid}}<line class="grid" x1="0" y1="{{.Y}}" x2="{{$.Width}}" y2="{{.Y}}"/>
+<text class="axis" x="-5" y="{{.Y}}" text-anchor="end" dominant-baseline="middle">{{.Label}}</text>
+{{end}}{{range .Lines}}<polyline fill="none" stroke="{{.Color}}" stroke-width="2" points="{{.Points}}"/>
+{{end}}</svg>
+<p class="legend">{{range .Lines}}<span><i style="background: {{.Color}}"></i>{{.Label}} ({{.Count}} readings)</span>{{end}}</p>
+</body>
+</html>
+`))
+
+// compareLine is one device's polyline on the comparison chart
+type compareLine struct {
+	Label  string
+	Color  string
+	Points string
+	Count  int
+}
+
+// compareGridLine is a horizontal level gridline on the comparison chart
+type compareGridLine struct {
+	Y     float64
+	Label string
+}
+
+// compareRange is a quick link to compare over a recent period
+type compareRange struct {
+	Label string
+	URL   string
+}
+
+// handleCompare renders the levels of several devices overlaid on one chart,
+// with the same query parameters as /api/compare
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, from, to, err := parseComparison(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comparison, err := compareDevices(r.Context(), ids, from, to)
+	if err != nil {
+		log.Printf("Error comparing devices: %v", err)
+		http.Error(w, "Failed to compare devices", http.StatusInternalServerError)
+		return
+	}
+
+	// Scale the levels to the highest one, or the gauge maximum if configured
+	maxLevel, _ := widgetMaxLevel()
+	for _, d := range comparison.Devices {
+		for _, p := range d.Readings {
+			maxLevel = max(maxLevel, p.Level)
+		}
+	}
+	if maxLevel <= 0 {
+		maxLevel = 1
+	}
+
+	span := comparison.To.Sub(comparison.From).Seconds()
+	var lines []compareLine
+	for i, d := range comparison.Devices {
+		label := d.ID
+		if d.Name != "" {
+			label = d.Name
+		}
+
+		points := make([]string, 0, len(d.Readings))
+		for _, p := range d.Readings {
+			x := p.Timestamp.Sub(comparison.From).Seconds() / span * compareChartWidth
+			y := compareChartHeight - p.Level/maxLevel*compareChartHeight
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+
+		lines = append(lines, compareLine{
+			Label:  label,
+			Color:  compareColors[i%len(compareColors)],
+			Points: strings.Join(points, " "),
+			Count:  len(d.Readings),
+		})
+	}
+
+	var grid []compareGridLine
+	for i := 0; i <= 4; i++ {
+		grid = append(grid, compareGridLine{
+			Y:     compareChartHeight - float64(i)/4*compareChartHeight,
+			Label: fmt.Sprintf("%.0f", maxLevel*float64(i)/4),
+		})
+	}
+
+	// Quick links keep the selected devices
+	var ranges []compareRange
+	for _, period := range []struct {
+		label string
+		d     time.Duration
+	}{{"24 hours", 24 * time.Hour}, {"7 days", 7 * 24 * time.Hour}, {"30 days", 30 * 24 * time.Hour}} {
+		query := r.URL.Query()
+		query.Del("to")
+		query.Set("from", time.Now().Add(-period.d).UTC().Format(time.RFC3339))
+		ranges = append(ranges, compareRange{Label: period.label, URL: "?" + query.Encode()})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	data := map[string]any{
+		"From":       comparison.From.Format("2006-01-02 15:04"),
+		"To":         comparison.To.Format("2006-01-02 15:04"),
+		"Ranges":     ranges,
+		"Grid":       grid,
+		"Lines":      lines,
+		"Width":      compareChartWidth,
+		"ViewWidth":  compareChartWidth + 50,
+		"ViewHeight": compareChartHeight + 20,
+	}
+	if err := compareTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering comparison: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// fieldDistance is the pseudo-field of ultrasonic sensors reporting the distance from
+// the sensor down to the surface, converted to a level using SENSOR_HEIGHT
+const fieldDistance = "distance"
+
+// compatFields maps field names used by popular open-source sensor firmwares
+// to the fields of Request
+var compatFields = map[string]string{
+	"distance":        fieldDistance,
+	"distance_cm":     fieldDistance,
+	"water_level":     "level",
+	"water_level_cm":  "level",
+	"level_cm":        "level",
+	"battery_voltage": "battery",
+	"vbat":            "battery",
+	"batt":            "battery",
+	"wifi_rssi":       "rssi",
+	"temp":            "temperature",
+	"temperature_c":   "temperature",
+	"fw_version":      "firmware",
+	"version":         "firmware",
+	"device":          "device_id",
+	"device_name":     "device_id",
+	"chip_id":         "device_id",
+}
+
+// ingestFieldMap returns the field mapping applied to ingested payloads: the built-in
+// firmware aliases when INGEST_COMPAT is enabled, plus or overridden by INGEST_FIELD_MAP
+// entries of the form "source=target,...". It returns nil if no mapping is configured.
+func ingestFieldMap() map[string]string {
+	fields := map[string]string{}
+	if os.Getenv("INGEST_COMPAT") == "true" {
+		for source, target := range compatFields {
+			fields[source] = target
+		}
+	}
+
+	if mapStr := os.Getenv("INGEST_FIELD_MAP"); mapStr != "" {
+		for _, entry := range strings.Split(mapStr, ",") {
+			source, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || source == "" || target == "" {
+				log.Printf("Invalid INGEST_FIELD_MAP entry: %q", entry)
+				continue
+			}
+			fields[source] = target
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// remapFields renames the fields of a JSON payload according to the mapping,
+// fields already present under their target name take precedence. A distance
+// is converted to a level using SENSOR_HEIGHT.
+func remapFields(body []byte, fields map[string]string) ([]byte, error) {
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	for source, target := range fields {
+		value, ok := payload[source]
+		if !ok || source == target {
+			continue
+		}
+		delete(payload, source)
+		if _, exists := payload[target]; !exists {
+			payload[target] = value
+		}
+	}
+
+	if distance, ok := payload[fieldDistance]; ok {
+		delete(payload, fieldDistance)
+
+		d, ok := distance.(float64)
+		if !ok {
+			return nil, fmt.Errorf("distance must be a number")
+		}
+		height, ok := envLevel("SENSOR_HEIGHT")
+		if !ok {
+			return nil, fmt.Errorf("SENSOR_HEIGHT must be configured to convert distances to levels")
+		}
+		if _, exists := payload["level"]; !exists {
+			payload["level"] = height - d
+		}
+	}
+
+	// Firmwares often send numeric chip IDs
+	if id, ok := payload["device_id"].(float64); ok {
+		payload["device_id"] = fmt.Sprintf("%.0f", id)
+	}
+
+	return json.Marshal(payload)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// TemperatureCompensationRequest enables the temperature compensation of a device
+type TemperatureCompensationRequest struct {
+	SensorHeight         *float64 `json:"sensor_height"`         // Level at the sensor, defaults to SENSOR_HEIGHT
+	ReferenceTemperature *float64 `json:"reference_temperature"` // °C the firmware assumes, defaults to 20
+}
+
+// defaultReferenceTemperature is the temperature most ultrasonic firmwares assume,
+// converting echo times at 343 m/s
+const defaultReferenceTemperature = 20.0
+
+// speedOfSound returns the speed of sound in dry air at the temperature in °C, in m/s
+func speedOfSound(celsius float64) float64 {
+	return 331.3 * math.Sqrt(1+celsius/273.15)
+}
+
+// deviceTemperature returns the latest temperature a device reported within
+// TEMPERATURE_MAX_AGE seconds (default: 3600), or false if there is none
+func deviceTemperature(ctx context.Context, deviceID string) (float64, bool) {
+	since := time.Now().Add(-envSeconds("TEMPERATURE_MAX_AGE", time.Hour))
+	p, err := db.GetLatestTelemetry(ctx, deviceID, metricTemperature, since)
+	if err != nil {
+		log.Printf("Error getting temperature: %v", err)
+		return 0, false
+	}
+	if p == nil {
+		return 0, false
+	}
+	return p.Value, true
+}
+
+// compensateTemperature corrects the level of a reading for the speed of sound at the
+// temperature the device last reported, if temperature compensation is enabled for it.
+// The sensor measured the distance down to the surface assuming the speed of sound at
+// the reference temperature, the distance scales with the actual speed.
+func compensateTemperature(ctx context.Context, deviceID string, reading db.Reading) db.Reading {
+	c, err := db.GetTemperatureCompensation(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error getting temperature compensation: %v", err)
+		return reading
+	}
+	if c == nil {
+		return reading
+	}
+
+	temperature, ok := deviceTemperature(ctx, deviceID)
+	if !ok {
+		return reading
+	}
+
+	height, ok := envLevel("SENSOR_HEIGHT")
+	if c.SensorHeight != nil {
+		height, ok = *c.SensorHeight, true
+	}
+	if !ok {
+		log.Printf("No sensor height for device %s, set it or SENSOR_HEIGHT to compensate for temperature", deviceID)
+		return reading
+	}
+
+	distance := (height - reading.Level) * speedOfSound(temperature) / speedOfSound(c.ReferenceTemperature)
+	reading.Level = math.Round((height-distance)*1000) / 1000
+	return reading
+}
+
+func handleTemperatureCompensation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetTemperatureCompensation(w, r)
+	case http.MethodPut:
+		handlePutTemperatureCompensation(w, r)
+	case http.MethodDelete:
+		handleDeleteTemperatureCompensation(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetTemperatureCompensation serves the temperature compensation of a device
+func handleGetTemperatureCompensation(w http.ResponseWriter, r *http.Request) {
+	c, err := db.GetTemperatureCompensation(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting temperature compensation: %v", err)
+		http.Error(w, "Failed to get temperature compensation", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, "Temperature compensation not enabled", http.StatusNotFound)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handlePutTemperatureCompensation enables temperature compensation for the readings of a
+// device ingested from now on
+func handlePutTemperatureCompensation(w http.ResponseWriter, r *http.Request) {
+	var req TemperatureCompensationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SensorHeight == nil {
+		if _, ok := envLevel("SENSOR_HEIGHT"); !ok {
+			http.Error(w, "sensor_height is required unless SENSOR_HEIGHT is configured", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := db.TemperatureCompensation{
+		DeviceID:             r.PathValue("id"),
+		SensorHeight:         req.SensorHeight,
+		ReferenceTemperature: defaultReferenceTemperature,
+	}
+	if req.ReferenceTemperature != nil {
+		c.ReferenceTemperature = *req.ReferenceTemperature
+	}
+	if c.ReferenceTemperature <= -273.15 {
+		http.Error(w, "reference_temperature must be above absolute zero", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := db.SaveTemperatureCompensation(r.Context(), c)
+	if err != nil {
+		log.Printf("Error saving temperature compensation: %v", err)
+		http.Error(w, "Failed to save temperature compensation", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+// handleDeleteTemperatureCompensation stops compensating the readings of a device for temperature
+func handleDeleteTemperatureCompensation(w http.ResponseWriter, r *http.Request) {
+	if err := db.DeleteTemperatureCompensation(r.Context(), r.PathValue("id")); err != nil {
+		log.Printf("Error deleting temperature compensation: %v", err)
+		http.Error(w, "Failed to delete temperature compensation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
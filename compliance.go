@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// ComplianceReading is the data of a reading in the compliance log
+type ComplianceReading struct {
+	Level     float64   `json:"level"`
+	RawLevel  *float64  `json:"raw_level,omitempty"` // Before calibration, if calibrated at ingest
+	Timestamp time.Time `json:"timestamp"`
+	Quality   string    `json:"quality"`
+}
+
+// complianceEnabled reports whether readings and maintenance events are recorded in the
+// compliance log, from COMPLIANCE_LOG
+func complianceEnabled() bool {
+	return os.Getenv("COMPLIANCE_LOG") == "true"
+}
+
+// logCompliance appends the data of a reading or event to the compliance log, if enabled
+func logCompliance(ctx context.Context, kind, deviceID string, data any) {
+	if !complianceEnabled() {
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding compliance entry: %v", err)
+		return
+	}
+	if _, err := db.AppendCompliance(ctx, kind, deviceID, string(encoded)); err != nil {
+		log.Printf("Error appending %s to compliance log: %v", kind, err)
+	}
+}
+
+// logComplianceReadings appends stored readings to the compliance log
+func logComplianceReadings(ctx context.Context, deviceID string, readings ...db.Reading) {
+	for _, r := range readings {
+		logCompliance(ctx, db.ComplianceReading, deviceID, ComplianceReading{
+			Level:     r.Level,
+			RawLevel:  r.RawLevel,
+			Timestamp: r.Timestamp.UTC(),
+			Quality:   r.Quality,
+		})
+	}
+}
+
+// handleComplianceExport exports the compliance log entries recorded between the optional
+// from and to query parameters as CSV, or as JSON lines with format=jsonl. Each entry
+// carries the hash of the previous one, so the export can be verified on its own.
+func handleComplianceExport(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := db.GetComplianceEntries(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error getting compliance log: %v", err)
+		http.Error(w, "Failed to get compliance log", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "compliance-log-" + time.Now().Format(time.DateOnly) + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seq", "recorded_at", "kind", "device_id", "data", "prev_hash", "hash"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.FormatInt(e.Seq, 10),
+			e.RecordedAt.Format(db.ComplianceTimeLayout),
+			e.Kind,
+			e.DeviceID,
+			e.Data,
+			e.PrevHash,
+			e.Hash,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error writing compliance export: %v", err)
+	}
+}
+
+// handleComplianceVerify recomputes the hash chain of the compliance log and reports
+// the first entry that was changed, removed or reordered, if any
+func handleComplianceVerify(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	verification, err := db.VerifyCompliance(r.Context())
+	if err != nil {
+		log.Printf("Error verifying compliance log: %v", err)
+		http.Error(w, "Failed to verify compliance log", http.StatusInternalServerError)
+		return
+	}
+	if !verification.Valid {
+		log.Printf("Compliance log chain broken at entry %d", *verification.FirstInvalid)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+)
+
+// Kinds of values a setting takes
+const (
+	settingLevel      = "level"      // Level, e.g. a threshold
+	settingMinutes    = "minutes"    // Whole number of minutes
+	settingNotifiers  = "notifiers"  // Comma-separated messaging backend names
+	settingThresholds = "thresholds" // LEVEL_THRESHOLDS entries
+	settingSeverity   = "severity"   // warning or critical
+	settingNotify     = "notify"     // repeat or once
+	settingTime       = "time"       // RFC 3339 time
+	settingText       = "text"
+)
+
+// configSettings are the settings that can be changed through /api/config without a
+// restart, by kind. The settings of named thresholds are matched by thresholdSetting.
+var configSettings = map[string]string{
+	"LEVEL_THRESHOLD":           settingLevel,
+	"LEVEL_CRITICAL_THRESHOLD":  settingLevel,
+	"LEVEL_CLEAR_THRESHOLD":     settingLevel,
+	"LEVEL_THRESHOLDS":          settingThresholds,
+	"SMS_COOLDOWN":              settingMinutes,
+	"SMS_PHONE_NUMBER":          settingText,
+	"VOICE_CALL_DELAY":          settingMinutes,
+	"NOTIFIERS":                 settingNotifiers,
+	"NOTIFIERS_WARNING":         settingNotifiers,
+	"NOTIFIERS_CRITICAL":        settingNotifiers,
+	"VACATION_FROM":             settingTime,
+	"VACATION_UNTIL":            settingTime,
+	"VACATION_THRESHOLD_OFFSET": settingLevel,
+	"VACATION_MAX_RISE":         settingLevel,
+}
+
+// thresholdSettings are the kinds of the THRESHOLD_<NAME>_<SETTING> settings by suffix
+var thresholdSettings = map[string]string{
+	"_SEVERITY":  settingSeverity,
+	"_MESSAGE":   settingText,
+	"_COOLDOWN":  settingMinutes,
+	"_NOTIFIERS": settingNotifiers,
+	"_NOTIFY":    settingNotify,
+}
+
+// storedSettings caches the settings stored in the database, which override the environment
+var (
+	storedSettingsMux sync.RWMutex
+	storedSettings    = map[string]string{}
+)
+
+// loadSettings loads the stored settings into the cache
+func loadSettings(ctx context.Context) error {
+	settings, err := db.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	storedSettingsMux.Lock()
+	storedSettings = settings
+	storedSettingsMux.Unlock()
+	return nil
+}
+
+// setting returns the value of a setting, stored through /api/config or from the environment
+func setting(key string) string {
+	storedSettingsMux.RLock()
+	value, ok := storedSettings[key]
+	storedSettingsMux.RUnlock()
+	if ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// settingKind returns the kind of value of a setting, or false if it can't be changed
+// through the API
+func settingKind(key string) (string, bool) {
+	if kind, ok := configSettings[key]; ok {
+		return kind, true
+	}
+
+	if name, ok := strings.CutPrefix(key, "THRESHOLD_"); ok {
+		for suffix, kind := range thresholdSettings {
+			if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+				return kind, true
+			}
+		}
+	}
+	return "", false
+}
+
+// validateSetting checks a value of a setting, empty values unset it
+func validateSetting(key, value string) error {
+	kind, ok := settingKind(key)
+	if !ok {
+		return fmt.Errorf("%s can't be configured through the API", key)
+	}
+	if value == "" {
+		return nil
+	}
+
+	switch kind {
+	case settingLevel:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number", key)
+		}
+	case settingMinutes:
+		if minutes, err := strconv.Atoi(value); err != nil || minutes < 0 {
+			return fmt.Errorf("%s must be a whole number of minutes", key)
+		}
+	case settingNotifiers:
+		for _, name := range strings.Split(value, ",") {
+			if _, ok := notifierBackends[strings.TrimSpace(name)]; !ok {
+				return fmt.Errorf("%s: unknown notifier %q", key, name)
+			}
+		}
+	case settingThresholds:
+		for _, entry := range strings.Split(value, ",") {
+			name, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if _, err := strconv.ParseFloat(strings.TrimSpace(level), 64); !ok || name == "" || err != nil {
+				return fmt.Errorf("%s: invalid entry %q, expected name=level", key, entry)
+			}
+		}
+	case settingSeverity:
+		if value != incident.SeverityWarning && value != incident.SeverityCritical {
+			return fmt.Errorf("%s must be warning or critical", key)
+		}
+	case settingNotify:
+		if value != notifyRepeat && value != notifyOnce {
+			return fmt.Errorf("%s must be repeat or once", key)
+		}
+	case settingTime:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%s must be an RFC 3339 time", key)
+		}
+	}
+	return nil
+}
+
+// currentConfig returns the effective value of every configured setting that can be
+// changed through the API, including the settings of the configured thresholds
+func currentConfig() map[string]string {
+	keys := map[string]bool{}
+	for key := range configSettings {
+		keys[key] = true
+	}
+	for _, t := range levelThresholds() {
+		for suffix := range thresholdSettings {
+			keys[thresholdKey(t.name, suffix[1:])] = true
+		}
+	}
+	storedSettingsMux.RLock()
+	for key := range storedSettings {
+		keys[key] = true
+	}
+	storedSettingsMux.RUnlock()
+
+	config := map[string]string{}
+	for key := range keys {
+		if value := setting(key); value != "" {
+			config[key] = value
+		}
+	}
+	return config
+}
+
+// handleConfig handles GET and PUT requests on the settings
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetConfig(w, r)
+	case http.MethodPut:
+		handlePutConfig(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetConfig serves the effective thresholds, cooldowns and notification settings
+func handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(currentConfig())
+}
+
+// handlePutConfig stores the settings in the body, taking effect right away. A null value
+// removes the stored setting, falling back to the environment again. Settings not in the
+// body are left as they are. It needs ADMIN_API_KEY, the settings decide who is alerted.
+func handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	var body map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	settings := map[string]string{}
+	var removed []string
+	for key, value := range body {
+		if value == nil {
+			if _, ok := settingKind(key); !ok {
+				http.Error(w, fmt.Sprintf("%s can't be configured through the API", key), http.StatusBadRequest)
+				return
+			}
+			removed = append(removed, key)
+			continue
+		}
+
+		if err := validateSetting(key, strings.TrimSpace(*value)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings[key] = strings.TrimSpace(*value)
+	}
+
+	if err := storeSettings(r.Context(), settings, removed); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	changed := append(removed, sortedKeys(settings)...)
+	log.Printf("Settings changed through the API: %s", strings.Join(changed, ", "))
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(currentConfig())
+}
+
+// storeSettings saves the settings and removes the removed ones, in the database and
+// in the cache
+func storeSettings(ctx context.Context, settings map[string]string, removed []string) error {
+	if err := db.SaveSettings(ctx, settings, removed); err != nil {
+		return err
+	}
+
+	storedSettingsMux.Lock()
+	for key, value := range settings {
+		storedSettings[key] = value
+	}
+	for _, key := range removed {
+		delete(storedSettings, key)
+	}
+	storedSettingsMux.Unlock()
+	return nil
+}
+
+// sortedKeys returns the keys of the settings in order
+func sortedKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the dashboard web UI, built into the binary so it is served without any files next to it
+//
+//go:embed web
+var webFiles embed.FS
+
+// dashboardHandler serves the dashboard at / and its assets
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sceptic-monitor/internal/db"
+)
+
+// newToken returns a random token for a link or a credential
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash a token is stored under, so the database doesn't hold
+// working credentials
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeviceTokenResponse is a newly created device token with its secret, only shown once
+type DeviceTokenResponse struct {
+	db.DeviceToken
+	Token string `json:"token"`
+}
+
+// handleDeviceTokens lists the tokens of a device with GET and creates one with POST
+func handleDeviceTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetDeviceTokens(w, r)
+	case http.MethodPost:
+		handleCreateDeviceToken(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleGetDeviceTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := db.GetDeviceTokens(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting device tokens: %v", err)
+		http.Error(w, "Failed to get device tokens", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleCreateDeviceToken provisions a device with a new token, registering the device
+// if needed. With {"rotate": true} the other tokens of the device are revoked at once,
+// e.g. to replace a compromised one.
+func handleCreateDeviceToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Label  string `json:"label"`
+		Rotate bool   `json:"rotate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	deviceID := r.PathValue("id")
+	token, err := newToken()
+	if err != nil {
+		log.Printf("Error generating device token: %v", err)
+		http.Error(w, "Failed to create device token", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := db.CreateDeviceToken(r.Context(), deviceID, strings.TrimSpace(body.Label), hashToken(token), body.Rotate)
+	if err != nil {
+		log.Printf("Error creating device token: %v", err)
+		http.Error(w, "Failed to create device token", http.StatusInternalServerError)
+		return
+	}
+	if body.Rotate {
+		log.Printf("Device token %d created for %s, other tokens revoked", t.ID, deviceID)
+	} else {
+		log.Printf("Device token %d created for %s", t.ID, deviceID)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(DeviceTokenResponse{DeviceToken: *t, Token: token})
+}
+
+// handleRevokeDeviceToken revokes a token of a device, readings submitted with it are
+// refused from then on. The token is kept for the record.
+func handleRevokeDeviceToken(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE method
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("token"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	deviceID := r.PathValue("id")
+	revoked, err := db.RevokeDeviceToken(r.Context(), deviceID, id)
+	if err != nil {
+		log.Printf("Error revoking device token: %v", err)
+		http.Error(w, "Failed to revoke device token", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Device token not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Device token %d of %s revoked", id, deviceID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
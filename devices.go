@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/ttn"
+)
+
+// configDownlinkPort is the LoRaWAN FPort carrying encoded device configs
+const configDownlinkPort = 1
+
+// Telemetry metrics reported by devices alongside their readings
+const (
+	metricBattery     = "battery"
+	metricRSSI        = "rssi"
+	metricTemperature = "temperature"
+)
+
+// saveTelemetry stores the battery, RSSI and temperature values included in a reading, if any
+func saveTelemetry(ctx context.Context, req Request) {
+	metrics := map[string]*float64{
+		metricBattery:     req.Battery,
+		metricRSSI:        req.RSSI,
+		metricTemperature: req.Temperature,
+	}
+
+	for metric, value := range metrics {
+		if value == nil {
+			continue
+		}
+		if err := db.SaveTelemetry(ctx, req.DeviceID, metric, *value); err != nil {
+			log.Printf("Error saving %s telemetry: %v", metric, err)
+		}
+	}
+}
+
+// deviceSummary is the inventory view of a device, optional fields are
+// only included when requested
+type deviceSummary struct {
+	ID               string     `json:"id"`
+	Firmware         *string    `json:"firmware,omitempty"`
+	LastSeen         *time.Time `json:"last_seen,omitempty"`
+	ErrorCount       int        `json:"error_count"`
+	LastError        string     `json:"last_error,omitempty"`
+	ClockSkew        int64      `json:"clock_skew,omitempty"`
+	SiteID           string     `json:"site_id,omitempty"`
+	Name             string     `json:"name,omitempty"`
+	Location         string     `json:"location,omitempty"`
+	Notes            string     `json:"notes,omitempty"`
+	LastPumpOut      *time.Time `json:"last_pump_out,omitempty"`
+	DaysSincePumpOut *float64   `json:"days_since_pump_out,omitempty"`
+}
+
+func handleGetDevices(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the comma-separated list of optional fields, e.g. include=firmware,last_seen
+	include := map[string]bool{}
+	for _, field := range strings.Split(r.URL.Query().Get("include"), ",") {
+		include[strings.TrimSpace(field)] = true
+	}
+
+	devices, err := db.GetDevices(r.Context())
+	if err != nil {
+		log.Printf("Error getting devices: %v", err)
+		http.Error(w, "Failed to get devices", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]deviceSummary, 0, len(devices))
+	for _, d := range devices {
+		summary := deviceSummary{
+			ID:         d.ID,
+			ErrorCount: d.ErrorCount,
+			LastError:  d.LastError,
+			ClockSkew:  d.ClockSkew,
+			SiteID:     d.SiteID,
+			Name:       d.Name,
+			Location:   d.Location,
+			Notes:      d.Notes,
+		}
+		if include["firmware"] {
+			summary.Firmware = &d.Firmware
+		}
+		if include["last_seen"] {
+			summary.LastSeen = d.LastSeen
+		}
+		if include["pump_out"] {
+			last, err := db.GetLastPumpOut(r.Context(), d.ID)
+			if err != nil {
+				log.Printf("Error getting last pump-out: %v", err)
+				http.Error(w, "Failed to get devices", http.StatusInternalServerError)
+				return
+			}
+			if last != nil {
+				days := daysSince(last.PumpedAt)
+				summary.LastPumpOut = &last.PumpedAt
+				summary.DaysSincePumpOut = &days
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summaries)
+}
+
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetDevice(w, r)
+	case http.MethodPut:
+		handlePutDeviceInfo(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := db.GetDevice(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting device: %v", err)
+		http.Error(w, "Failed to get device", http.StatusInternalServerError)
+		return
+	}
+	if device == nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(device)
+}
+
+// handlePutDeviceInfo sets the display name, location and notes of a device
+func handlePutDeviceInfo(w http.ResponseWriter, r *http.Request) {
+	var info db.DeviceInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	info.Name = strings.TrimSpace(info.Name)
+	info.Location = strings.TrimSpace(info.Location)
+
+	if err := db.SaveDeviceInfo(r.Context(), r.PathValue("id"), info); err != nil {
+		log.Printf("Error saving device info: %v", err)
+		http.Error(w, "Failed to save device info", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(info)
+}
+
+// deviceLabel returns how a device is referred to in messages: its name and location
+// if set, its ID otherwise. The default device of single-tank setups goes unnamed
+// unless given a name.
+func deviceLabel(ctx context.Context, deviceID string) string {
+	device, err := db.GetDevice(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error getting device: %v", err)
+	}
+
+	if device == nil || device.Name == "" {
+		if deviceID == defaultDeviceID {
+			return ""
+		}
+		return deviceID
+	}
+
+	if device.Location != "" {
+		return device.Name + ", " + device.Location
+	}
+	return device.Name
+}
+
+func handleGetTelemetry(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric != metricBattery && metric != metricRSSI && metric != metricTemperature {
+		http.Error(w, "metric must be battery, rssi or temperature", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := db.GetTelemetry(r.Context(), r.PathValue("id"), metric, from, to)
+	if err != nil {
+		log.Printf("Error getting telemetry: %v", err)
+		http.Error(w, "Failed to get telemetry", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(points)
+}
+
+// handleDeviceConfig serves the configuration of a device to the device itself, by its
+// ingest key, or to anyone reading the levels, and changes it with ADMIN_API_KEY
+func handleDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !readAuthorized(r) && !adminAuthorized(r) && !ingestAuthorized(r, r.PathValue("id")) {
+			rejectRead(w, r)
+			return
+		}
+		handleGetDeviceConfig(w, r)
+	case http.MethodPut:
+		requireAdmin(handlePutDeviceConfig)(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetDeviceConfig serves the desired configuration of a device with an ETag,
+// so devices only download it when it has changed
+func handleGetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := deviceConfig(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting device config: %v", err)
+		http.Error(w, "Failed to get device config", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(config)
+	if err != nil {
+		http.Error(w, "Failed to encode device config", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+
+	// Device already has the current config
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func handlePutDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	var config db.DeviceConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateDeviceConfig(config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceID := r.PathValue("id")
+	if err := db.SaveDeviceConfig(r.Context(), deviceID, config); err != nil {
+		log.Printf("Error saving device config: %v", err)
+		http.Error(w, "Failed to save device config", http.StatusInternalServerError)
+		return
+	}
+
+	// LoRaWAN devices can't poll for their config, push it as a downlink instead
+	if ttn.Enabled() {
+		queueConfigDownlink(r.Context(), deviceID, config)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(config)
+}
+
+func handleGetDownlinks(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	downlinks, err := db.GetDownlinks(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting downlinks: %v", err)
+		http.Error(w, "Failed to get downlinks", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(downlinks)
+}
+
+// queueConfigDownlink queues the device config as a TTN downlink and records the outcome
+func queueConfigDownlink(ctx context.Context, deviceID string, config db.DeviceConfig) {
+	payload := encodeConfigDownlink(config)
+	downlink := db.Downlink{
+		Port:    configDownlinkPort,
+		Payload: hex.EncodeToString(payload),
+		Status:  "queued",
+	}
+
+	nctx, cancel := notifyContext(ctx)
+	defer cancel()
+
+	if err := ttn.QueueDownlink(nctx, deviceID, configDownlinkPort, payload); err != nil {
+		log.Printf("Error queuing config downlink for device %s: %v", deviceID, err)
+		downlink.Status = "failed"
+		downlink.Error = err.Error()
+	}
+
+	if err := db.SaveDownlink(ctx, deviceID, downlink); err != nil {
+		log.Printf("Error recording downlink: %v", err)
+	}
+}
+
+// encodeConfigDownlink packs a device config into 8 bytes to fit LoRaWAN payload limits:
+//
+//	bytes 0-1: reporting interval in seconds (big endian, capped at 65535)
+//	bytes 2-3: buzzer threshold rounded to a whole level (big endian, 0 disables it)
+//	bytes 4-7: sleep window start hour, start minute, end hour, end minute (0xFF when disabled)
+func encodeConfigDownlink(config db.DeviceConfig) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint16(payload[0:], uint16(min(config.ReportInterval, 0xFFFF)))
+	binary.BigEndian.PutUint16(payload[2:], uint16(min(max(math.Round(config.BuzzerThreshold), 0), 0xFFFF)))
+
+	for i := 4; i < 8; i++ {
+		payload[i] = 0xFF
+	}
+
+	// Sleep times were validated as HH:MM when the config was saved
+	if start, err := time.Parse("15:04", config.SleepStart); err == nil {
+		payload[4], payload[5] = byte(start.Hour()), byte(start.Minute())
+	}
+	if end, err := time.Parse("15:04", config.SleepEnd); err == nil {
+		payload[6], payload[7] = byte(end.Hour()), byte(end.Minute())
+	}
+
+	return payload
+}
+
+// deviceConfig returns the stored config of a device, or the defaults if none is stored
+func deviceConfig(ctx context.Context, deviceID string) (*db.DeviceConfig, error) {
+	config, err := db.GetDeviceConfig(ctx, deviceID)
+	if err != nil || config != nil {
+		return config, err
+	}
+
+	// Get default reporting interval from environment in seconds (default: 300 seconds = 5 minutes)
+	interval := 300
+	if s := os.Getenv("DEVICE_REPORT_INTERVAL"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			interval = v
+		} else {
+			log.Printf("Invalid DEVICE_REPORT_INTERVAL value: %q, using default 300 seconds", s)
+		}
+	}
+
+	return &db.DeviceConfig{ReportInterval: interval}, nil
+}
+
+// validateDeviceConfig checks a device config submitted through the API
+func validateDeviceConfig(config db.DeviceConfig) error {
+	if config.ReportInterval <= 0 {
+		return fmt.Errorf("report_interval must be a positive number of seconds")
+	}
+
+	if (config.SleepStart == "") != (config.SleepEnd == "") {
+		return fmt.Errorf("sleep_start and sleep_end must be set together")
+	}
+
+	for _, t := range []string{config.SleepStart, config.SleepEnd} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("invalid sleep time %q: must be HH:MM", t)
+		}
+	}
+
+	return nil
+}
+
+// parseTimeRange parses the optional RFC 3339 "from" and "to" query parameters
+func parseTimeRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+
+	if s := query.Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, fmt.Errorf("invalid from: must be an RFC 3339 timestamp")
+		}
+	}
+
+	if s := query.Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, fmt.Errorf("invalid to: must be an RFC 3339 timestamp")
+		}
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// downsampleAge returns how long readings are kept at full resolution from
+// DOWNSAMPLE_AFTER_DAYS, or false to never downsample them (the default)
+func downsampleAge() (time.Duration, bool) {
+	s := os.Getenv("DOWNSAMPLE_AFTER_DAYS")
+	if s == "" {
+		return 0, false
+	}
+
+	days, err := strconv.Atoi(s)
+	if err != nil || days <= 0 {
+		log.Printf("Invalid DOWNSAMPLE_AFTER_DAYS value: %s, keeping readings at full resolution", s)
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// downsampleBucket returns the aggregates readings are rolled up to from
+// DOWNSAMPLE_BUCKET: hour (default) or day
+func downsampleBucket() string {
+	switch bucket := os.Getenv("DOWNSAMPLE_BUCKET"); bucket {
+	case "":
+		return db.BucketHour
+	case db.BucketHour, db.BucketDay:
+		return bucket
+	default:
+		log.Printf("Invalid DOWNSAMPLE_BUCKET value: %s, using hour", bucket)
+		return db.BucketHour
+	}
+}
+
+// downsampleExpired rolls the readings stored before the cutoff up into aggregates,
+// which /api/stats reports along with the readings, and returns the number of readings
+// deleted
+func downsampleExpired(ctx context.Context, before time.Time) int {
+	bucket := downsampleBucket()
+	n, err := db.Downsample(ctx, bucket, before)
+	if err != nil {
+		log.Printf("Error downsampling readings: %v", err)
+	}
+	if n > 0 {
+		log.Printf("Downsampled %d readings stored before %s to %s aggregates", n, before.UTC().Format(time.DateOnly), bucket)
+	}
+	return int(n)
+}
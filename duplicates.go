@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// recentPost is a reading POST remembered to answer identical retries of it
+type recentPost struct {
+	at       time.Time
+	done     chan struct{} // Closed once the original has been processed
+	ok       bool          // Whether the original succeeded, set before done is closed
+	response Response
+}
+
+// recentPosts holds the recent reading POSTs by device, level and timestamp
+var (
+	recentPostsMux sync.Mutex
+	recentPosts    = map[string]*recentPost{}
+)
+
+// duplicatePostKey identifies a reading POST, retries carry the same device, level and timestamp
+func duplicatePostKey(req Request) string {
+	timestamp := ""
+	if req.Timestamp != nil {
+		timestamp = req.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%s|%v|%s", req.DeviceID, req.Level, timestamp)
+}
+
+// beginPost returns the response to an identical POST received within the window,
+// waiting for it if it is still being processed. Otherwise it returns the claimed
+// POST, which the caller processes and passes to finishPost.
+func beginPost(key string, window time.Duration) (*Response, *recentPost) {
+	for {
+		recentPostsMux.Lock()
+		post := recentPosts[key]
+		if post == nil || time.Since(post.at) >= window {
+			// Forget the posts that are out of the window while at it
+			for k, p := range recentPosts {
+				if time.Since(p.at) >= window {
+					delete(recentPosts, k)
+				}
+			}
+
+			post = &recentPost{at: time.Now(), done: make(chan struct{})}
+			recentPosts[key] = post
+			recentPostsMux.Unlock()
+			return nil, post
+		}
+		recentPostsMux.Unlock()
+
+		<-post.done
+		if post.ok {
+			return &post.response, nil
+		}
+		// A failed original is forgotten, the retry is processed in its place
+	}
+}
+
+// finishPost records the response to a POST claimed by beginPost, nil if it failed
+// so that retries are processed again
+func finishPost(key string, post *recentPost, response *Response) {
+	recentPostsMux.Lock()
+	if response != nil {
+		post.response = *response
+		post.ok = true
+	} else if recentPosts[key] == post {
+		delete(recentPosts, key)
+	}
+	recentPostsMux.Unlock()
+
+	close(post.done)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/mqtt"
+)
+
+// floatSwitchDeviceID returns the device ID float switch alerts are raised for,
+// from FLOAT_SWITCH_DEVICE (default: the default device)
+func floatSwitchDeviceID() string {
+	if id := os.Getenv("FLOAT_SWITCH_DEVICE"); id != "" {
+		return id
+	}
+	return defaultDeviceID
+}
+
+// startFloatSwitch starts watching the float switch on a GPIO value file
+// (FLOAT_SWITCH_GPIO) or MQTT topic (FLOAT_SWITCH_TOPIC), if configured
+func startFloatSwitch() {
+	deviceID := floatSwitchDeviceID()
+
+	if topic := os.Getenv("FLOAT_SWITCH_TOPIC"); topic != "" && mqtt.Enabled() {
+		mqtt.Subscribe(topic, func(_ string, payload []byte) {
+			tripped, err := parseSwitchState(string(payload))
+			if err != nil {
+				log.Printf("Invalid float switch payload on %s: %v", topic, err)
+				return
+			}
+			updateHardLimit(deviceID, db.AlertKindFloatSwitch, tripped)
+		})
+		log.Printf("Watching float switch on MQTT topic %s", topic)
+	}
+
+	if path := os.Getenv("FLOAT_SWITCH_GPIO"); path != "" {
+		pollFloatSwitchGPIO(deviceID, path)
+		log.Printf("Watching float switch on %s", path)
+	}
+}
+
+// pollFloatSwitchGPIO polls a sysfs GPIO value file, e.g. /sys/class/gpio/gpio17/value,
+// in the background every FLOAT_SWITCH_POLL seconds (default: 1). FLOAT_SWITCH_ACTIVE_LOW=true treats
+// a low input as tripped, for switches pulling the pin to ground.
+func pollFloatSwitchGPIO(deviceID, path string) {
+	interval := envSeconds("FLOAT_SWITCH_POLL", time.Second)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	activeLow := os.Getenv("FLOAT_SWITCH_ACTIVE_LOW") == "true"
+
+	lastErr := ""
+	every(interval, func() {
+		value, err := os.ReadFile(path)
+		if err != nil {
+			// Log read errors once until the input recovers
+			if err.Error() != lastErr {
+				log.Printf("Error reading float switch: %v", err)
+				lastErr = err.Error()
+			}
+			return
+		}
+		lastErr = ""
+		high := strings.TrimSpace(string(value)) == "1"
+		updateHardLimit(deviceID, db.AlertKindFloatSwitch, high != activeLow)
+	})
+}
+
+// parseSwitchState parses a switch state as sent by common MQTT bridges
+func parseSwitchState(s string) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "ON", "OPEN", "TRIPPED":
+		return true, nil
+	case "OFF", "CLOSED", "CLEAR":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(s))
+}
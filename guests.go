@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+)
+
+// guestRecipientID returns the ID of the recipient a guest link signs up
+func guestRecipientID(linkID string) string {
+	return "guest-" + linkID[:12]
+}
+
+// publicURL returns the base URL the server is reached at from PUBLIC_URL, or from
+// the request if not set
+func publicURL(r *http.Request) string {
+	if u := os.Getenv("PUBLIC_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// GuestLinkResponse is a newly created guest link with its URL, only shown once
+type GuestLinkResponse struct {
+	db.GuestLink
+	URL string `json:"url"`
+}
+
+// handleGuestLinks lists the guest links with GET and creates one with POST
+func handleGuestLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetGuestLinks(w, r)
+	case http.MethodPost:
+		handleCreateGuestLink(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleGetGuestLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := db.GetGuestLinks(r.Context())
+	if err != nil {
+		log.Printf("Error getting guest links: %v", err)
+		http.Error(w, "Failed to get guest links", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(links)
+}
+
+// handleCreateGuestLink creates a link for a guest staying until the given time, who
+// is then notified of the alerts of the given severities (default: all)
+func handleCreateGuestLink(w http.ResponseWriter, r *http.Request) {
+	var link db.GuestLink
+	if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !link.Until.After(time.Now()) {
+		http.Error(w, "until must be in the future", http.StatusBadRequest)
+		return
+	}
+	for _, severity := range link.Severities {
+		if severity != incident.SeverityWarning && severity != incident.SeverityCritical {
+			http.Error(w, "severities must be warning or critical", http.StatusBadRequest)
+			return
+		}
+	}
+	if link.Severities == nil {
+		link.Severities = []string{}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Printf("Error generating guest link: %v", err)
+		http.Error(w, "Failed to create guest link", http.StatusInternalServerError)
+		return
+	}
+	link.ID = hashToken(token)
+
+	if err := db.CreateGuestLink(r.Context(), link); err != nil {
+		log.Printf("Error creating guest link: %v", err)
+		http.Error(w, "Failed to create guest link", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Guest link created for %q until %s", link.Name, link.Until.Format(time.RFC3339))
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GuestLinkResponse{GuestLink: link, URL: publicURL(r) + "/guest/" + token})
+}
+
+// handleDeleteGuestLink revokes a guest link and removes the guest who signed up with it
+func handleDeleteGuestLink(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE method
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	deleted, err := db.DeleteGuestLink(r.Context(), id)
+	if err != nil {
+		log.Printf("Error deleting guest link: %v", err)
+		http.Error(w, "Failed to delete guest link", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Guest link not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := db.DeleteRecipient(r.Context(), guestRecipientID(id)); err != nil {
+		log.Printf("Error deleting guest recipient: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var guestTemplate = template.Must(template.New("guest").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Septic tank alerts</title>
+<style>
+body { font-family: sans-serif; max-width: 28em; margin: 2em auto; padding: 0 1em; color: #222; }
+label { display: block; margin-top: 1em; }
+input { width: 100%; box-sizing: border-box; padding: 0.4em; font-size: 1em; }
+button { margin-top: 1.5em; padding: 0.5em 1em; font-size: 1em; }
+.error { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>Septic tank alerts</h1>
+{{if .Expired}}<p>This link has expired.</p>
+{{else}}{{if .Message}}<p>{{.Message}}</p>
+{{end}}<p>Get a text message or an email if the septic tank needs attention during your stay{{if .Name}}, {{.Name}}{{end}}, e.g. to call the pump truck. You are removed automatically on {{.Until.Local.Format "2 Jan 2006"}}.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>
+{{end}}<form method="post">
+<label>Name <input name="name" value="{{.Recipient.Name}}"></label>
+<label>Mobile phone <input name="phone" type="tel" value="{{.Recipient.Phone}}"></label>
+<label>Email <input name="email" type="email" value="{{.Recipient.Email}}"></label>
+<button type="submit" name="action" value="opt-in">{{if .Recipient.ID}}Update{{else}}Get alerts{{end}}</button>
+{{if .Recipient.ID}}<button type="submit" name="action" value="opt-out">Stop alerts</button>
+{{end}}</form>
+{{end}}</body>
+</html>
+`))
+
+// handleGuest serves the page a guest opts in to the alerts on with GET, and signs
+// them up, updates their details or opts them out with POST. The token in the path
+// is all the authentication there is, so expired links are refused.
+func handleGuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	link, err := db.GetGuestLink(ctx, hashToken(r.PathValue("token")))
+	if err != nil {
+		log.Printf("Error getting guest link: %v", err)
+		http.Error(w, "Failed to get guest link", http.StatusInternalServerError)
+		return
+	}
+	if link == nil || !link.Until.After(time.Now()) {
+		renderGuest(w, http.StatusNotFound, map[string]any{"Expired": true})
+		return
+	}
+
+	id := guestRecipientID(link.ID)
+	recipient, err := db.GetRecipient(ctx, id)
+	if err != nil {
+		log.Printf("Error getting recipient: %v", err)
+		http.Error(w, "Failed to get recipient", http.StatusInternalServerError)
+		return
+	}
+	if recipient == nil {
+		recipient = &db.Recipient{}
+	}
+
+	data := map[string]any{"Name": link.Name, "Until": link.Until, "Recipient": recipient}
+	if r.Method == http.MethodGet {
+		renderGuest(w, http.StatusOK, data)
+		return
+	}
+
+	if r.FormValue("action") == "opt-out" {
+		if _, err := db.DeleteRecipient(ctx, id); err != nil {
+			log.Printf("Error deleting recipient: %v", err)
+			http.Error(w, "Failed to opt out", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Guest %s opted out", id)
+		data["Recipient"] = &db.Recipient{}
+		data["Message"] = "You will no longer receive alerts."
+		renderGuest(w, http.StatusOK, data)
+		return
+	}
+
+	guest := db.Recipient{
+		ID:         id,
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		Phone:      strings.TrimSpace(r.FormValue("phone")),
+		Email:      strings.TrimSpace(r.FormValue("email")),
+		Channels:   []string{},
+		Severities: link.Severities,
+		ExpiresAt:  &link.Until,
+	}
+	// Show the details as entered, signed up only once saved
+	entered := guest
+	entered.ID = recipient.ID
+	data["Recipient"] = &entered
+	if guest.Phone != "" {
+		guest.Channels = append(guest.Channels, "sms")
+	}
+	if guest.Email != "" {
+		guest.Channels = append(guest.Channels, "email")
+	}
+	if len(guest.Channels) == 0 {
+		data["Error"] = "Enter a mobile phone number or an email address."
+		renderGuest(w, http.StatusBadRequest, data)
+		return
+	}
+	if guest.Email != "" && !strings.Contains(guest.Email, "@") {
+		data["Error"] = "Enter a valid email address."
+		renderGuest(w, http.StatusBadRequest, data)
+		return
+	}
+
+	if err := db.SaveRecipient(ctx, guest); err != nil {
+		log.Printf("Error saving recipient: %v", err)
+		http.Error(w, "Failed to save recipient", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Guest %s opted in until %s", id, link.Until.Format(time.RFC3339))
+
+	data["Recipient"] = &guest
+	data["Message"] = "You are signed up for alerts."
+	renderGuest(w, http.StatusOK, data)
+}
+
+func renderGuest(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := guestTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering guest page: %v", err)
+	}
+}
+
+// recipientExpired reports whether the recipient has expired, e.g. a guest whose stay is over
+func recipientExpired(r db.Recipient, now time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(now)
+}
+
+// startGuestCleanup removes the expired recipients and guest links every hour. Expired
+// recipients are no longer notified in the meantime.
+func startGuestCleanup() {
+	every(time.Hour, func() {
+		n, err := db.DeleteExpiredGuests(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Error deleting expired guests: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d expired recipients", n)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/mqtt"
+)
+
+// HADevice is the device block of a Home Assistant discovery config, grouping the
+// entities of a sensor
+type HADevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// HAEntity is a Home Assistant MQTT discovery config
+type HAEntity struct {
+	Name          string   `json:"name"`
+	UniqueID      string   `json:"unique_id"`
+	ObjectID      string   `json:"object_id"`
+	StateTopic    string   `json:"state_topic"`
+	ValueTemplate string   `json:"value_template"`
+	DeviceClass   string   `json:"device_class,omitempty"`
+	StateClass    string   `json:"state_class,omitempty"`
+	Icon          string   `json:"icon,omitempty"`
+	Device        HADevice `json:"device"`
+}
+
+// haConfig is the discovery config of an entity of a Home Assistant component, e.g. sensor
+type haConfig struct {
+	component string
+	entity    HAEntity
+}
+
+// haAnnounced holds the devices whose discovery configs went out since the last connect
+var (
+	haAnnouncedMux sync.Mutex
+	haAnnounced    = map[string]bool{}
+)
+
+// haDiscoveryPrefix returns the Home Assistant discovery prefix from HA_DISCOVERY_PREFIX
+// (default: homeassistant), or "" if HA_DISCOVERY is off or readings aren't published to MQTT
+func haDiscoveryPrefix() string {
+	if os.Getenv("HA_DISCOVERY") != "true" || mqttPublishPrefix() == "" {
+		return ""
+	}
+	if prefix := os.Getenv("HA_DISCOVERY_PREFIX"); prefix != "" {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return "homeassistant"
+}
+
+// startHADiscovery announces the known devices to Home Assistant on every connect to the
+// broker, Home Assistant also resubscribes and picks up the retained configs after a restart
+func startHADiscovery() {
+	if haDiscoveryPrefix() == "" {
+		return
+	}
+
+	mqtt.OnConnect(func() {
+		haAnnouncedMux.Lock()
+		haAnnounced = map[string]bool{}
+		haAnnouncedMux.Unlock()
+
+		times, err := db.GetLastReadingTimes(context.Background())
+		if err != nil {
+			log.Printf("Error getting devices for Home Assistant discovery: %v", err)
+			return
+		}
+		for deviceID := range times {
+			announceHADevice(deviceID)
+		}
+	})
+	log.Printf("Announcing devices to Home Assistant under %s/", haDiscoveryPrefix())
+}
+
+// announceHADevice publishes the discovery configs of a device once per connection: its
+// level, last seen time and alert state
+func announceHADevice(deviceID string) {
+	prefix := haDiscoveryPrefix()
+	if prefix == "" {
+		return
+	}
+
+	haAnnouncedMux.Lock()
+	announced := haAnnounced[deviceID]
+	haAnnounced[deviceID] = true
+	haAnnouncedMux.Unlock()
+	if announced {
+		return
+	}
+
+	for _, config := range haConfigs(deviceID) {
+		payload, err := json.Marshal(config.entity)
+		if err != nil {
+			log.Printf("Error encoding Home Assistant discovery config: %v", err)
+			continue
+		}
+
+		topic := prefix + "/" + config.component + "/" + config.entity.UniqueID + "/config"
+		if err := mqtt.Publish(topic, payload, true); err != nil {
+			log.Printf("Error publishing Home Assistant discovery config %s: %v", topic, err)
+			haAnnouncedMux.Lock()
+			delete(haAnnounced, deviceID)
+			haAnnouncedMux.Unlock()
+			return
+		}
+	}
+}
+
+// haConfigs returns the discovery configs of a device, reading the states from the
+// topics the readings and alerts are published to
+func haConfigs(deviceID string) []haConfig {
+	ctx := context.Background()
+	prefix := mqttPublishPrefix()
+	objectID := "septic_" + strings.NewReplacer("-", "_", " ", "_", "/", "_").Replace(deviceID)
+
+	device := HADevice{
+		Identifiers:  []string{"septic-monitor-" + deviceID},
+		Name:         "Septic tank " + deviceID,
+		Manufacturer: "septic-monitor",
+		Model:        "Level sensor",
+	}
+	if label := deviceLabel(ctx, deviceID); label != "" {
+		device.Name = "Septic tank " + label
+	}
+	if d, err := db.GetDevice(ctx, deviceID); err == nil && d != nil {
+		device.SWVersion = d.Firmware
+	}
+
+	return []haConfig{
+		{"sensor", HAEntity{
+			Name:          "Level",
+			UniqueID:      objectID + "_level",
+			ObjectID:      objectID + "_level",
+			StateTopic:    mqttTopic(prefix, deviceID, "level"),
+			ValueTemplate: "{{ value_json.level }}",
+			StateClass:    "measurement",
+			Icon:          "mdi:car-coolant-level",
+			Device:        device,
+		}},
+		{"sensor", HAEntity{
+			Name:          "Last seen",
+			UniqueID:      objectID + "_last_seen",
+			ObjectID:      objectID + "_last_seen",
+			StateTopic:    mqttTopic(prefix, deviceID, "level"),
+			ValueTemplate: "{{ value_json.timestamp }}",
+			DeviceClass:   "timestamp",
+			Device:        device,
+		}},
+		{"binary_sensor", HAEntity{
+			Name:          "Alert",
+			UniqueID:      objectID + "_alert",
+			ObjectID:      objectID + "_alert",
+			StateTopic:    mqttTopic(prefix, deviceID, "alert"),
+			ValueTemplate: "{{ 'ON' if value_json.event == 'alert' else 'OFF' }}",
+			DeviceClass:   "problem",
+			Device:        device,
+		}},
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+	"sceptic-monitor/internal/webhook"
+)
+
+// hardLimit describes the alerts raised by a binary sensor, or another on/off condition,
+// rather than a level threshold
+type hardLimit struct {
+	severity     string
+	message      string // Notification when the sensor trips
+	voiceMessage string // Voice call when the sensor trips, none if empty
+	cleared      string // Reason logged when the sensor clears
+	recovery     string // Notification when the sensor clears, if any
+}
+
+// hardLimits are the alert kinds of binary sensors and other on/off conditions
+var hardLimits = map[string]hardLimit{
+	db.AlertKindFloatSwitch: {
+		severity:     incident.SeverityCritical,
+		message:      "Float switch hard limit reached, the tank is about to overflow",
+		voiceMessage: "Critical septic tank alert. The float switch hard limit has been reached.",
+		cleared:      "float switch cleared",
+	},
+	db.AlertKindOverflow: {
+		severity:     incident.SeverityCritical,
+		message:      "Overflow detected by the leak sensor at the tank",
+		voiceMessage: "Critical septic tank alert. The tank is overflowing.",
+		cleared:      "leak sensor is dry again",
+		recovery:     "Overflow sensor is dry again",
+	},
+	db.AlertKindRisk: {
+		severity: incident.SeverityWarning,
+		message:  "Overflow risk is high, the tank is nearly full or filling fast",
+		cleared:  "overflow risk is back to normal",
+	},
+	db.AlertKindPumpOutDue: {
+		severity: incident.SeverityWarning,
+		message:  "Pump-out is due, the last one is longer ago than the configured interval",
+		cleared:  "tank pumped out",
+	},
+	db.AlertKindDataLoss: {
+		severity: incident.SeverityWarning,
+		message:  "Database check failed after a restart, recent readings may have been lost in a power cut",
+		cleared:  "database consistent after a restart",
+	},
+	db.AlertKindStale: {
+		severity: incident.SeverityWarning,
+		message:  "No reading received for too long, the sensor may be dead or its battery flat",
+		cleared:  "readings arriving again",
+		recovery: "Readings are arriving again",
+	},
+	db.AlertKindLatency: {
+		severity: incident.SeverityWarning,
+		message:  "Alert delivery is slow, notifications took longer than the latency objective",
+		cleared:  "alert delivery latency back within the objective",
+	},
+	db.AlertKindVacationRise: {
+		severity: incident.SeverityWarning,
+		message:  "Level is rising while the house is empty, the tank may be leaking or taking in groundwater",
+		cleared:  "vacation mode ended",
+	},
+}
+
+// hardLimitStates holds the last known state of each binary sensor by device and kind
+var (
+	hardLimitMux    sync.Mutex
+	hardLimitStates = map[string]bool{}
+)
+
+// updateHardLimit raises an alert of the kind when a binary sensor trips and
+// resolves it once the sensor clears. Such sensors are independent of the level
+// sensor, so the alert skips thresholds and cooldowns and goes out on every
+// channel at once. The new state is only recorded once the alert is stored, so a
+// failed insert is retried on the next update rather than waiting for a toggle.
+func updateHardLimit(deviceID, kind string, tripped bool) {
+	limit := hardLimits[kind]
+	key := deviceID + "/" + kind
+
+	hardLimitMux.Lock()
+	previous, known := hardLimitStates[key]
+	hardLimitMux.Unlock()
+
+	if known && tripped == previous {
+		return
+	}
+
+	record := func() {
+		hardLimitMux.Lock()
+		hardLimitStates[key] = tripped
+		hardLimitMux.Unlock()
+	}
+
+	ctx := context.Background()
+
+	alertMux.Lock()
+	alert, err := db.GetActiveAlert(ctx, deviceID, kind)
+	if err != nil {
+		alertMux.Unlock()
+		log.Printf("Error getting active alert: %v", err)
+		return
+	}
+
+	if !tripped {
+		if alert != nil {
+			resolveAlert(ctx, *alert, limit.cleared)
+		}
+		alertMux.Unlock()
+		record()
+
+		if alert != nil && limit.recovery != "" {
+			goBackground(func() { notifyRecovery(ctx, alert.ID, deviceID, limit.severity, limit.recovery) })
+		}
+		return
+	}
+
+	if alert != nil {
+		alertMux.Unlock()
+		record()
+		return
+	}
+
+	now := time.Now()
+	alert = &db.Alert{
+		ID:       fmt.Sprintf("septic-monitor-%d", now.UnixNano()),
+		DeviceID: deviceID,
+		Kind:     kind,
+		Severity: limit.severity,
+		// Binary sensors have no level, record them as tripped (1) at the limit (1)
+		Level:     1,
+		Threshold: 1,
+		State:     db.AlertRaised,
+		RaisedAt:  now,
+	}
+	if err := db.CreateAlert(ctx, *alert); err != nil {
+		alertMux.Unlock()
+		log.Printf("Error creating alert: %v", err)
+		return
+	}
+	if err := db.SetAlertLastNotified(ctx, alert.ID, &now); err != nil {
+		log.Printf("Error updating alert: %v", err)
+	}
+	alertMux.Unlock()
+	record()
+
+	log.Printf("Alert %s raised: %s on %s", alert.ID, kind, deviceID)
+
+	goBackground(func() {
+		if dispatchAlert(ctx, alert.ID, hardLimitDeliveries(ctx, *alert, limit)) {
+			if err := db.MarkAlertNotified(ctx, alert.ID); err != nil {
+				log.Printf("Error updating alert: %v", err)
+			}
+		}
+	})
+}
+
+// hardLimitDeliveries returns the deliveries notifying about a tripped binary sensor, all due right away
+func hardLimitDeliveries(ctx context.Context, alert db.Alert, limit hardLimit) []delivery {
+	id, deviceID := alert.ID, alert.DeviceID
+
+	prefix := "Warning"
+	if limit.severity == incident.SeverityCritical {
+		prefix = "Critical"
+	}
+
+	label := deviceLabel(ctx, deviceID)
+	message := prefix + ": " + limit.message
+	voiceMessage := limit.voiceMessage
+	if label != "" {
+		message = fmt.Sprintf("%s (%s): %s", prefix, label, limit.message)
+		voiceMessage = fmt.Sprintf("%s This concerns %s.", limit.voiceMessage, label)
+	}
+
+	deliveries := messageDeliveries(ctx, id, deviceID, limit.severity, message)
+
+	for _, notifier := range incidentNotifiers() {
+		deliveries = append(deliveries, delivery{
+			channel: notifier.Name(),
+			send: func(ctx context.Context) error {
+				ctx, cancel := notifyContext(ctx)
+				defer cancel()
+
+				return notifier.Create(ctx, incident.Alert{ID: id, Summary: message, Severity: limit.severity})
+			},
+		})
+	}
+
+	event := alertEvent(ctx, webhook.EventAlert, alert, message)
+	broadcastAlertEvent(event)
+	deliveries = append(deliveries, webhookDeliveries(ctx, event)...)
+	deliveries = append(deliveries, mqttDeliveries(event)...)
+
+	if limit.voiceMessage != "" {
+		deliveries = append(deliveries, delivery{
+			channel: "voice",
+			send: func(ctx context.Context) error {
+				return callVoice(ctx, id, voiceMessage)
+			},
+		})
+	}
+
+	return deliveries
+}
+
+// notifyRecovery tells the alert recipients that the condition behind an alert of the severity has cleared,
+// on the backends the alert went out on
+func notifyRecovery(ctx context.Context, alertID, deviceID, severity, recovery string) {
+	notifyRecoveryOn(ctx, alertID, deviceID, severity, messageNotifiers(severity), recovery)
+}
+
+// notifyRecoveryOn tells the alert recipients that the condition behind an alert of the
+// severity has cleared on the backends
+func notifyRecoveryOn(ctx context.Context, alertID, deviceID, severity string, backends []notifierBackend, recovery string) {
+	message := "Recovered: " + recovery
+	if label := deviceLabel(ctx, deviceID); label != "" {
+		message = fmt.Sprintf("Recovered (%s): %s", label, recovery)
+	}
+
+	for _, d := range backendDeliveries(ctx, alertID, deviceID, severity, backends, message) {
+		if err := d.send(ctx); err != nil {
+			log.Printf("Error sending recovery notification via %s: %v", d.channel, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// ReadinessResponse is the outcome of the readiness checks. The details after the checks
+// are left out for requests not authorized to read the levels when read auth is set.
+type ReadinessResponse struct {
+	Status         string            `json:"status"` // ok or unavailable
+	Checks         map[string]string `json:"checks"` // ok or the error, per check
+	LastReadingAt  *time.Time        `json:"last_reading_at,omitempty"`
+	LastReadingAge *float64          `json:"last_reading_age,omitempty"` // Seconds since the latest reading
+	Stale          *bool             `json:"stale,omitempty"`            // Latest reading older than STALE_AFTER, if set
+	SMS            *ChannelHealth    `json:"sms,omitempty"`              // Delivery record of the SMS provider, if alerts go by SMS
+}
+
+// handleHealthz answers liveness probes: the process is up and serving requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz answers readiness probes: the database and the reading store answer and
+// the settings are valid, or 503. The sensor going quiet or the SMS provider failing is
+// reported but doesn't fail the probe, restarting the server wouldn't fix either.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := ReadinessResponse{Status: "ok", Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			response.Status = "unavailable"
+			response.Checks[name] = err.Error()
+		} else {
+			response.Checks[name] = "ok"
+		}
+	}
+
+	check("database", db.Ping(r.Context()))
+
+	latest, err := db.GetLatestReadingTime(r.Context())
+	check("store", err)
+
+	check("config", validateConfig())
+
+	if readAuthorized(r) {
+		if latest != nil {
+			age := time.Since(*latest).Seconds()
+			response.LastReadingAt, response.LastReadingAge = latest, &age
+			if after, ok := staleAfter(); ok {
+				stale := time.Since(*latest) > after
+				response.Stale = &stale
+			}
+		}
+		response.SMS = smsHealth()
+	}
+
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// validateConfig checks the effective value of every setting that can be changed
+// through the API, so a bad value in the environment shows up like one sent to /api/config
+func validateConfig() error {
+	config := currentConfig()
+	for _, key := range sortedKeys(config) {
+		if err := validateSetting(key, config[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// smsHealth returns the delivery record of the SMS channel, or nil if no alerts go by SMS
+func smsHealth() *ChannelHealth {
+	for _, backend := range alertBackends() {
+		if backend.notifier.Name() != "sms" {
+			continue
+		}
+
+		channelHealthMux.Lock()
+		h := *channelHealthOf("sms")
+		channelHealthMux.Unlock()
+		return &h
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// Page sizes of the reading history
+const (
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+// HistoryResponse is a page of the reading history
+type HistoryResponse struct {
+	Readings   []db.HistoryReading `json:"readings"`
+	NextCursor string              `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// encodeHistoryCursor returns the opaque cursor of the page following the reading
+func encodeHistoryCursor(r db.HistoryReading) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%d", r.Timestamp.UnixNano(), r.ID))
+}
+
+// decodeHistoryCursor returns the time and ID of the last reading of the previous page
+func decodeHistoryCursor(cursor string) (time.Time, int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+
+	nanosStr, idStr, ok := strings.Cut(string(data), ":")
+	nanos, err1 := strconv.ParseInt(nanosStr, 10, 64)
+	id, err2 := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil || id <= 0 {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+
+	return time.Unix(0, nanos), id, nil
+}
+
+// readingFilter returns the filter of the readings selected by the optional device,
+// transport, source, quality and exclude_quality query parameters within the time
+// range. quality and exclude_quality take comma-separated quality flags, e.g.
+// exclude_quality=simulated,anomalous,interpolated.
+func readingFilter(r *http.Request, from, to time.Time) (db.ReadingFilter, error) {
+	query := r.URL.Query()
+	filter := db.ReadingFilter{
+		DeviceID:  query.Get("device"),
+		Transport: query.Get("transport"),
+		Source:    query.Get("source"),
+		From:      from,
+		To:        to,
+	}
+
+	for _, p := range []struct {
+		param     string
+		qualities *[]string
+	}{{"quality", &filter.Qualities}, {"exclude_quality", &filter.ExcludeQualities}} {
+		value := query.Get(p.param)
+		if value == "" {
+			continue
+		}
+		for _, quality := range strings.Split(value, ",") {
+			quality = strings.TrimSpace(quality)
+			if !slices.Contains(db.Qualities, quality) {
+				return db.ReadingFilter{}, fmt.Errorf("%s must be a list of %s", p.param, strings.Join(db.Qualities, ", "))
+			}
+			*p.qualities = append(*p.qualities, quality)
+		}
+	}
+
+	return filter, nil
+}
+
+// handleGetHistory lists stored readings, oldest first, filtered by the optional device,
+// transport, source, quality, exclude_quality, from and to query parameters. Pages hold
+// limit readings (default: 100, at most 1000), the next page is requested by passing
+// next_cursor as cursor.
+func handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit := historyDefaultLimit
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > historyMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", historyMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var afterTime time.Time
+	var afterID int64
+	if cursor := query.Get("cursor"); cursor != "" {
+		if afterTime, afterID, err = decodeHistoryCursor(cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := readingFilter(r, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch one reading more than requested to tell whether there is a next page
+	readings, err := db.GetHistory(r.Context(), filter, afterTime, afterID, limit+1)
+	if err != nil {
+		log.Printf("Error getting history: %v", err)
+		http.Error(w, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+
+	response := HistoryResponse{Readings: readings}
+	if len(readings) > limit {
+		response.Readings = readings[:limit]
+		response.NextCursor = encodeHistoryCursor(readings[limit-1])
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleGetStats serves the min, max, average and count of the readings per bucket=hour|day|week,
+// filtered by the optional device, transport, source, quality, exclude_quality, from and
+// to query parameters. Only trusted readings count unless quality is given.
+func handleGetStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	switch bucket {
+	case "":
+		bucket = db.BucketDay
+	case db.BucketHour, db.BucketDay, db.BucketWeek:
+	default:
+		http.Error(w, "bucket must be hour, day or week", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := readingFilter(r, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := db.GetLevelStats(r.Context(), bucket, filter)
+	if err != nil {
+		log.Printf("Error getting level stats: %v", err)
+		http.Error(w, "Failed to get level stats", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// SeasonalResponse summarizes the history of a device by month and by day of the week
+type SeasonalResponse struct {
+	DeviceID  string             `json:"device_id"`
+	ByMonth   []db.SeasonalUsage `json:"by_month"`
+	ByWeekday []db.SeasonalUsage `json:"by_weekday"`
+}
+
+// handleGetSeasonal serves the seasonal usage of the device given as device
+// (default: the default device) over its full history
+func handleGetSeasonal(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		deviceID = defaultDeviceID
+	}
+
+	response := SeasonalResponse{DeviceID: deviceID}
+	var err error
+	if response.ByMonth, err = db.GetSeasonalUsage(r.Context(), deviceID, db.SeasonMonth); err == nil {
+		response.ByWeekday, err = db.GetSeasonalUsage(r.Context(), deviceID, db.SeasonWeekday)
+	}
+	if err != nil {
+		log.Printf("Error getting seasonal usage: %v", err)
+		http.Error(w, "Failed to get seasonal usage", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
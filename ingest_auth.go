@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"sceptic-monitor/internal/db"
+)
+
+// ingestKeys returns the API keys accepted from sensors by device ID, from
+// INGEST_API_KEYS entries of the form "device=key,...". A key without a device, or
+// for device *, is accepted for every device.
+func ingestKeys() map[string][]string {
+	keys := map[string][]string{}
+	for _, entry := range strings.Split(os.Getenv("INGEST_API_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		deviceID, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			deviceID, key = "*", entry
+		}
+		deviceID, key = strings.TrimSpace(deviceID), strings.TrimSpace(key)
+		if deviceID == "" || key == "" {
+			log.Printf("Invalid INGEST_API_KEYS entry: %q", entry)
+			continue
+		}
+		keys[deviceID] = append(keys[deviceID], key)
+	}
+	return keys
+}
+
+// requestAPIKey returns the API key of the request, from the X-API-Key header or a
+// bearer token
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// ingestAuthorized reports whether the request may submit readings of the device: it
+// carries a key of the device or a key for every device from INGEST_API_KEYS, or an
+// active token of the device. Ingestion is open while neither keys nor tokens exist.
+func ingestAuthorized(r *http.Request, deviceID string) bool {
+	keys := ingestKeys()
+	given := requestAPIKey(r)
+	if given != "" {
+		for _, key := range append(keys[deviceID], keys["*"]...) {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+				return true
+			}
+		}
+
+		ok, err := db.UseDeviceToken(r.Context(), deviceID, hashToken(given))
+		if err != nil {
+			log.Printf("Error checking device token: %v", err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+
+	if len(keys) > 0 {
+		return false
+	}
+	return !ingestTokensRequired(r.Context())
+}
+
+// ingestTokensRequired reports whether any device has been provisioned with a token,
+// which closes ingestion to requests without a valid key. It fails closed.
+func ingestTokensRequired(ctx context.Context) bool {
+	exists, err := db.HasDeviceTokens(ctx)
+	if err != nil {
+		log.Printf("Error checking device tokens: %v", err)
+		return true
+	}
+	return exists
+}
+
+// authorizeIngest checks the API key of a request submitting readings of the device,
+// answering 401 if it isn't valid. It reports whether the request may go on.
+func authorizeIngest(w http.ResponseWriter, r *http.Request, deviceID string) bool {
+	if ingestAuthorized(r, deviceID) {
+		return true
+	}
+
+	log.Printf("Rejected readings of %s from %s: missing or invalid API key", deviceID, remoteHost(r))
+	w.Header().Set("WWW-Authenticate", `Bearer realm="septic-monitor"`)
+	http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/mqtt"
+)
+
+// subscribeMQTTReadings subscribes to the level messages on MQTT_LEVEL_TOPIC, e.g.
+// septic/+/level for sensors publishing to a broker instead of posting to /api
+func subscribeMQTTReadings() {
+	filter := os.Getenv("MQTT_LEVEL_TOPIC")
+	if filter == "" {
+		return
+	}
+
+	mqtt.Subscribe(filter, func(topic string, payload []byte) {
+		err := handleMQTTReading(filter, topic, payload)
+		if err != nil {
+			log.Printf("Error handling MQTT reading on %s: %v", topic, err)
+		}
+		recordIngest(db.TransportMQTT, topic, err)
+	})
+	log.Printf("Reading levels from MQTT topic %s", filter)
+}
+
+// handleMQTTReading stores a level message like a POST to /api. The payload is either
+// the JSON body /api accepts or a plain number, as ESPHome and Tasmota publish. Without
+// a device_id, the topic level matched by a + wildcard of the filter names the device.
+func handleMQTTReading(filter, topic string, payload []byte) error {
+	payload = bytes.TrimSpace(payload)
+
+	var req Request
+	if level, err := strconv.ParseFloat(string(payload), 64); err == nil {
+		req.Level = level
+	} else {
+		// Translate the field names of third-party sensor firmwares, if configured
+		if fields := ingestFieldMap(); fields != nil {
+			if payload, err = remapFields(payload, fields); err != nil {
+				return fmt.Errorf("invalid payload: %w", err)
+			}
+		}
+
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	if req.DeviceID == "" {
+		req.DeviceID = topicDeviceID(filter, topic)
+	}
+	req.Transport, req.Source = db.TransportMQTT, topic
+
+	_, err := saveRequest(context.Background(), req)
+	return err
+}
+
+// topicDeviceID returns the topic level matched by the first + wildcard of the filter,
+// e.g. tank-1 of septic/tank-1/level for septic/+/level, or "" if there is none
+func topicDeviceID(filter, topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range strings.Split(filter, "/") {
+		if level == "+" && i < len(levels) {
+			return levels[i]
+		}
+	}
+	return ""
+}
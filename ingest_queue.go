@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Ingestion queue depth: the HTTP ingestion requests in flight, mostly waiting on the
+// database, and the background work started by readings and alerts
+var (
+	ingestPending     atomic.Int64
+	ingestRejected    atomic.Int64
+	backgroundPending atomic.Int64
+	ingestRejectLog   atomic.Int64 // Unix time rejections were last logged, at most once a minute
+)
+
+// IngestQueue reports the ingestion queue depth and the requests turned away because of it
+type IngestQueue struct {
+	Pending       int64 `json:"pending"` // Ingestion requests in flight
+	MaxPending    int   `json:"max_pending"`
+	Background    int64 `json:"background"` // Background work not yet finished, e.g. notifications
+	MaxBackground int   `json:"max_background"`
+	Rejected      int64 `json:"rejected"` // Requests answered with 503 since startup
+}
+
+// envLimit returns the positive limit configured in the environment variable, or def
+func envLimit(key string, def int) int {
+	limit := def
+	if s := os.Getenv(key); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			log.Printf("Invalid %s value: %s, using default: %d", key, s, def)
+		} else {
+			limit = v
+		}
+	}
+	return limit
+}
+
+// ingestQueue returns the current queue depth and limits
+func ingestQueue() IngestQueue {
+	return IngestQueue{
+		Pending:       ingestPending.Load(),
+		MaxPending:    envLimit("INGEST_MAX_PENDING", 100),
+		Background:    backgroundPending.Load(),
+		MaxBackground: envLimit("INGEST_MAX_BACKGROUND", 1000),
+		Rejected:      ingestRejected.Load(),
+	}
+}
+
+// admitIngest takes a slot for an ingestion request, or answers 503 with Retry-After
+// (INGEST_RETRY_AFTER seconds, default: 5) when INGEST_MAX_PENDING requests are already
+// in flight or INGEST_MAX_BACKGROUND pieces of background work are pending, so a
+// database that falls behind sheds load instead of piling up goroutines and memory.
+// The caller releases the slot with ingestPending.Add(-1) if admitted.
+func admitIngest(w http.ResponseWriter) bool {
+	queue := ingestQueue()
+	if ingestPending.Add(1) <= int64(queue.MaxPending) && queue.Background < int64(queue.MaxBackground) {
+		return true
+	}
+	ingestPending.Add(-1)
+
+	rejected := ingestRejected.Add(1)
+	now, last := time.Now().Unix(), ingestRejectLog.Load()
+	if now-last >= 60 && ingestRejectLog.CompareAndSwap(last, now) {
+		log.Printf("Ingestion queue full (%d pending, %d in background), %d readings rejected since startup", queue.Pending, queue.Background, rejected)
+	}
+	retryAfter := envSeconds("INGEST_RETRY_AFTER", 5*time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	http.Error(w, "Server busy, retry later", http.StatusServiceUnavailable)
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIngestSources bounds the sources counted, the least recently seen is forgotten beyond
+const maxIngestSources = 1000
+
+// IngestStats counts the messages received from one ingestion source since startup
+type IngestStats struct {
+	Transport   string     `json:"transport"`
+	Source      string     `json:"source"` // Client address, or topic for MQTT and Zigbee2MQTT
+	Messages    int        `json:"messages"`
+	Errors      int        `json:"errors"`
+	ErrorRate   float64    `json:"error_rate"` // Errors per message, 0 to 1
+	LastSeen    time.Time  `json:"last_seen"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// IngestStatsResponse lists the ingestion sources, counted since the server started,
+// and the current ingestion queue depth
+type IngestStatsResponse struct {
+	Since   time.Time     `json:"since"`
+	Queue   IngestQueue   `json:"queue"`
+	Sources []IngestStats `json:"sources"`
+}
+
+// ingestStats holds the counts per transport and source
+var (
+	ingestStatsMux   sync.Mutex
+	ingestStats      = map[[2]string]*IngestStats{}
+	ingestStatsSince = time.Now()
+)
+
+// recordIngest counts a message received from the source, failed if err is set
+func recordIngest(transport, source string, err error) {
+	ingestStatsMux.Lock()
+	defer ingestStatsMux.Unlock()
+
+	key := [2]string{transport, source}
+	stats, ok := ingestStats[key]
+	if !ok {
+		if len(ingestStats) >= maxIngestSources {
+			forgetOldestIngestSource()
+		}
+		stats = &IngestStats{Transport: transport, Source: source}
+		ingestStats[key] = stats
+	}
+
+	now := time.Now()
+	stats.Messages++
+	stats.LastSeen = now
+	if err != nil {
+		stats.Errors++
+		stats.LastError = err.Error()
+		stats.LastErrorAt = &now
+	}
+}
+
+// forgetOldestIngestSource drops the least recently seen source, ingestStatsMux must be held
+func forgetOldestIngestSource() {
+	var oldest [2]string
+	var oldestSeen time.Time
+	for key, stats := range ingestStats {
+		if oldestSeen.IsZero() || stats.LastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = key, stats.LastSeen
+		}
+	}
+	delete(ingestStats, oldest)
+}
+
+// ingestStatusRecorder captures the status and error message of an ingestion response
+type ingestStatusRecorder struct {
+	http.ResponseWriter
+	status  int
+	message strings.Builder
+}
+
+func (w *ingestStatusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ingestStatusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.message.Len() < 256 {
+		w.message.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// countIngest wraps an HTTP ingestion handler to count its requests per client address,
+// responses with an error status count as errors. Requests beyond the ingestion queue
+// limits are turned away with 503 before reaching the handler.
+func countIngest(transport string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &ingestStatusRecorder{ResponseWriter: w}
+		if admitIngest(recorder) {
+			handler(recorder, r)
+			ingestPending.Add(-1)
+		}
+
+		var err error
+		if recorder.status >= 400 {
+			err = ingestError(recorder.status, recorder.message.String())
+		}
+		recordIngest(transport, remoteHost(r), err)
+	}
+}
+
+// ingestError describes a failed ingestion request by its status and error message
+func ingestError(status int, message string) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return fmt.Errorf("status %d: %s", status, message)
+}
+
+// handleGetIngestStats serves the message and error counts per ingestion source since
+// startup, ordered by transport and source, to spot a misbehaving integration
+func handleGetIngestStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := IngestStatsResponse{Since: ingestStatsSince, Queue: ingestQueue(), Sources: []IngestStats{}}
+	ingestStatsMux.Lock()
+	for _, stats := range ingestStats {
+		s := *stats
+		s.ErrorRate = float64(s.Errors) / float64(s.Messages)
+		response.Sources = append(response.Sources, s)
+	}
+	ingestStatsMux.Unlock()
+
+	sort.Slice(response.Sources, func(i, j int) bool {
+		a, b := response.Sources[i], response.Sources[j]
+		if a.Transport != b.Transport {
+			return a.Transport < b.Transport
+		}
+		return a.Source < b.Source
+	})
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// ingestWebhookEnabled reports whether generic webhook ingestion is configured
+func ingestWebhookEnabled() bool {
+	return os.Getenv("INGEST_WEBHOOK_LEVEL_PATH") != ""
+}
+
+// jsonPath looks up a dotted path such as "uplink_message.decoded_payload.level"
+// or "sensors.0.value" in a decoded JSON payload, numeric segments index arrays
+func jsonPath(payload any, path string) (any, bool) {
+	value := payload
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+// jsonNumber converts a JSON number, or a number sent as a string, to a float
+func jsonNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+// jsonTimestamp converts an RFC 3339 string or a Unix time in seconds or
+// milliseconds to a time
+func jsonTimestamp(value any) (time.Time, error) {
+	if s, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+	}
+
+	n, err := jsonNumber(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("not an RFC 3339 or Unix timestamp: %v", value)
+	}
+	if n > 1e12 {
+		return time.UnixMilli(int64(n)), nil
+	}
+	return time.Unix(int64(n), 0), nil
+}
+
+// ingestWebhookRequest extracts a reading from an arbitrary JSON payload using the path in
+// INGEST_WEBHOOK_LEVEL_PATH and the optional INGEST_WEBHOOK_TIMESTAMP_PATH,
+// INGEST_WEBHOOK_BATTERY_PATH, INGEST_WEBHOOK_TEMPERATURE_PATH and INGEST_WEBHOOK_DEVICE_PATH. INGEST_WEBHOOK_DEVICE_ID
+// names the device when the payload doesn't.
+func ingestWebhookRequest(body []byte) (Request, error) {
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Request{}, fmt.Errorf("invalid JSON")
+	}
+
+	req := Request{DeviceID: os.Getenv("INGEST_WEBHOOK_DEVICE_ID")}
+
+	levelPath := os.Getenv("INGEST_WEBHOOK_LEVEL_PATH")
+	value, ok := jsonPath(payload, levelPath)
+	if !ok {
+		return Request{}, fmt.Errorf("no level at %s", levelPath)
+	}
+	level, err := jsonNumber(value)
+	if err != nil {
+		return Request{}, fmt.Errorf("invalid level at %s: %w", levelPath, err)
+	}
+	req.Level = level
+
+	if path := os.Getenv("INGEST_WEBHOOK_TIMESTAMP_PATH"); path != "" {
+		if value, ok := jsonPath(payload, path); ok {
+			ts, err := jsonTimestamp(value)
+			if err != nil {
+				return Request{}, fmt.Errorf("invalid timestamp at %s: %w", path, err)
+			}
+			req.Timestamp = &ts
+		}
+	}
+
+	if path := os.Getenv("INGEST_WEBHOOK_BATTERY_PATH"); path != "" {
+		if value, ok := jsonPath(payload, path); ok {
+			battery, err := jsonNumber(value)
+			if err != nil {
+				return Request{}, fmt.Errorf("invalid battery at %s: %w", path, err)
+			}
+			req.Battery = &battery
+		}
+	}
+
+	if path := os.Getenv("INGEST_WEBHOOK_TEMPERATURE_PATH"); path != "" {
+		if value, ok := jsonPath(payload, path); ok {
+			temperature, err := jsonNumber(value)
+			if err != nil {
+				return Request{}, fmt.Errorf("invalid temperature at %s: %w", path, err)
+			}
+			req.Temperature = &temperature
+		}
+	}
+
+	if path := os.Getenv("INGEST_WEBHOOK_DEVICE_PATH"); path != "" {
+		if value, ok := jsonPath(payload, path); ok {
+			switch id := value.(type) {
+			case string:
+				req.DeviceID = id
+			case float64:
+				req.DeviceID = strconv.FormatFloat(id, 'f', -1, 64)
+			}
+		}
+	}
+
+	if req.DeviceID == "" {
+		req.DeviceID = defaultDeviceID
+	}
+
+	return req, nil
+}
+
+// handleIngestWebhook ingests readings from third-party services posting their own JSON
+// payloads, with the fields located by the configured paths
+func handleIngestWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	req, err := ingestWebhookRequest(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !authorizeIngest(w, r, req.DeviceID) {
+		return
+	}
+
+	// Keep the device inventory up to date
+	if err := db.TouchDevice(r.Context(), req.DeviceID, ""); err != nil {
+		log.Printf("Error updating device: %v", err)
+	}
+
+	reading := db.Reading{Level: req.Level, Timestamp: time.Now(), Transport: db.TransportWebhook, Source: remoteHost(r)}
+	if req.Timestamp != nil {
+		reading.RawTimestamp = req.Timestamp
+		reading.Timestamp = correctClockSkew(r.Context(), req.DeviceID, *req.Timestamp, time.Now())
+	}
+
+	saveTelemetry(r.Context(), req)
+
+	if _, err := ingestReading(r.Context(), req.DeviceID, reading, false); err != nil {
+		log.Printf("Error saving to database: %v", err)
+		http.Error(w, "Failed to save data", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Received and saved: %f", req.Level),
+	})
+}
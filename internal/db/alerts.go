@@ -0,0 +1,365 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Alert states, in lifecycle order
+const (
+	AlertRaised       = "raised"
+	AlertNotified     = "notified"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// Alert kinds, by what raised the alert
+const (
+	AlertKindLevel        = "level"         // Level reading reached the threshold
+	AlertKindFloatSwitch  = "float_switch"  // Hard-limit float switch tripped
+	AlertKindOverflow     = "overflow"      // Leak sensor at the tank detected an overflow
+	AlertKindRisk         = "risk"          // Overflow risk score reached the configured limit
+	AlertKindPumpOutDue   = "pump_out_due"  // Last pump-out is longer ago than the configured interval
+	AlertKindDataLoss     = "data_loss"     // Startup consistency check found the database damaged or rolled back
+	AlertKindStale        = "stale"         // No reading arrived from the device for longer than the configured duration
+	AlertKindLatency      = "latency_slo"   // Alert delivery took longer than the configured latency objective
+	AlertKindVacationRise = "vacation_rise" // Level rose by more than the configured limit while in vacation mode
+)
+
+// Alert is a threshold alert and its lifecycle timestamps
+type Alert struct {
+	ID             string     `json:"id"`
+	DeviceID       string     `json:"device_id"`
+	Kind           string     `json:"kind"`
+	Severity       string     `json:"severity"`
+	Level          float64    `json:"level"` // Level that raised the alert
+	Threshold      float64    `json:"threshold"`
+	State          string     `json:"state"`
+	RaisedAt       time.Time  `json:"raised_at"`
+	NotifiedAt     *time.Time `json:"notified_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	LastNotifiedAt *time.Time `json:"last_notified_at"` // Start of the latest notification round
+	LatencyMs      *int64     `json:"latency_ms"`       // From the reading to the first notification accepted by a provider
+}
+
+const alertColumns = "id, device_id, kind, severity, level, threshold, state, raised_at, notified_at, acknowledged_at, resolved_at, last_notified_at, latency_ms"
+
+// scanAlert scans a row selected with alertColumns
+func scanAlert(row interface{ Scan(...any) error }) (*Alert, error) {
+	var a Alert
+	var notifiedAt, acknowledgedAt, resolvedAt, lastNotifiedAt sql.NullTime
+	var latencyMs sql.NullInt64
+	err := row.Scan(&a.ID, &a.DeviceID, &a.Kind, &a.Severity, &a.Level, &a.Threshold, &a.State, &a.RaisedAt,
+		&notifiedAt, &acknowledgedAt, &resolvedAt, &lastNotifiedAt, &latencyMs)
+	if err != nil {
+		return nil, err
+	}
+
+	if latencyMs.Valid {
+		a.LatencyMs = &latencyMs.Int64
+	}
+	a.NotifiedAt = timeOrNil(notifiedAt)
+	a.AcknowledgedAt = timeOrNil(acknowledgedAt)
+	a.ResolvedAt = timeOrNil(resolvedAt)
+	a.LastNotifiedAt = timeOrNil(lastNotifiedAt)
+	return &a, nil
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// CreateAlert stores a newly raised alert
+func CreateAlert(ctx context.Context, a Alert) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO alerts (id, device_id, kind, severity, level, threshold, state, raised_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		a.ID, a.DeviceID, a.Kind, a.Severity, a.Level, a.Threshold, AlertRaised, a.RaisedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert alert: %w", err)
+	}
+
+	return nil
+}
+
+// GetActiveAlert retrieves the most recent alert of the kind for a device that is not resolved, or nil if there is none
+func GetActiveAlert(ctx context.Context, deviceID, kind string) (*Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	a, err := scanAlert(db.QueryRowContext(ctx,
+		"SELECT "+alertColumns+" FROM alerts WHERE device_id = ? AND kind = ? AND state != 'resolved' ORDER BY raised_at DESC LIMIT 1",
+		deviceID, kind,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active alert: %w", err)
+	}
+
+	return a, nil
+}
+
+// GetActiveAlerts retrieves all alerts that are not resolved, newest first
+func GetActiveAlerts(ctx context.Context) ([]Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+alertColumns+" FROM alerts WHERE state != 'resolved' ORDER BY raised_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, *a)
+	}
+
+	return alerts, rows.Err()
+}
+
+// GetLatestPendingAlert retrieves the most recent raised or notified alert of any device, or nil if there is none
+func GetLatestPendingAlert(ctx context.Context) (*Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	a, err := scanAlert(db.QueryRowContext(ctx,
+		"SELECT "+alertColumns+" FROM alerts WHERE state IN ('raised', 'notified') ORDER BY raised_at DESC LIMIT 1",
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending alert: %w", err)
+	}
+
+	return a, nil
+}
+
+// GetAlerts retrieves the most recent alerts, newest first, optionally filtered by state and kind
+func GetAlerts(ctx context.Context, state, kind string, limit int) ([]Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT " + alertColumns + " FROM alerts WHERE 1 = 1"
+	var args []any
+	if state != "" {
+		query += " AND state = ?"
+		args = append(args, state)
+	}
+	if kind != "" {
+		query += " AND kind = ?"
+		args = append(args, kind)
+	}
+	query += " ORDER BY raised_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, *a)
+	}
+
+	return alerts, rows.Err()
+}
+
+// SetAlertThreshold updates the threshold and severity of an alert, e.g. when it escalates
+// to a higher threshold
+func SetAlertThreshold(ctx context.Context, id, severity string, threshold float64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "UPDATE alerts SET severity = ?, threshold = ? WHERE id = ?", severity, threshold, id); err != nil {
+		return fmt.Errorf("failed to update alert threshold: %w", err)
+	}
+	return nil
+}
+
+// SetAlertLastNotified records the start of a notification round, nil clears it
+func SetAlertLastNotified(ctx context.Context, id string, t *time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "UPDATE alerts SET last_notified_at = ? WHERE id = ?", utcOrNil(t), id); err != nil {
+		return fmt.Errorf("failed to update alert: %w", err)
+	}
+	return nil
+}
+
+// SetAlertLatency records the delivery latency of an alert, only the first one sticks
+func SetAlertLatency(ctx context.Context, id string, latency time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "UPDATE alerts SET latency_ms = ? WHERE id = ? AND latency_ms IS NULL", latency.Milliseconds(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update alert latency: %w", err)
+	}
+	return nil
+}
+
+// GetAlertLatencies retrieves the delivery latencies in milliseconds of the alerts raised in the time range
+func GetAlertLatencies(ctx context.Context, from, to time.Time) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT latency_ms FROM alerts WHERE latency_ms IS NOT NULL AND raised_at >= ? AND raised_at <= ? ORDER BY latency_ms",
+		from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert latencies: %w", err)
+	}
+	defer rows.Close()
+
+	latencies := []int64{}
+	for rows.Next() {
+		var ms int64
+		if err := rows.Scan(&ms); err != nil {
+			return nil, fmt.Errorf("failed to scan alert latency: %w", err)
+		}
+		latencies = append(latencies, ms)
+	}
+
+	return latencies, rows.Err()
+}
+
+// MarkAlertNotified moves a raised alert to notified once a notification got through
+func MarkAlertNotified(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"UPDATE alerts SET state = ?, notified_at = ? WHERE id = ? AND state = ?",
+		AlertNotified, time.Now().UTC(), id, AlertRaised,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update alert: %w", err)
+	}
+	return nil
+}
+
+// AcknowledgeAlert moves a raised or notified alert to acknowledged and reports whether it did
+func AcknowledgeAlert(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx,
+		"UPDATE alerts SET state = ?, acknowledged_at = ? WHERE id = ? AND state IN ('raised', 'notified')",
+		AlertAcknowledged, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to acknowledge alert: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ResolveAlert moves an alert to resolved
+func ResolveAlert(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"UPDATE alerts SET state = ?, resolved_at = ? WHERE id = ? AND state != ?",
+		AlertResolved, time.Now().UTC(), id, AlertResolved,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve alert: %w", err)
+	}
+	return nil
+}
+
+// IsAlertPending reports whether the alert is raised or notified but not yet acknowledged or resolved
+func IsAlertPending(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var state string
+	err := db.QueryRowContext(ctx, "SELECT state FROM alerts WHERE id = ?", id).Scan(&state)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query alert: %w", err)
+	}
+
+	return state == AlertRaised || state == AlertNotified, nil
+}
+
+// GetAlertsRaisedAfter retrieves the alerts raised after the given time, newest first
+func GetAlertsRaisedAfter(ctx context.Context, after time.Time, limit int) ([]Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+alertColumns+" FROM alerts WHERE raised_at > ? ORDER BY raised_at DESC LIMIT ?",
+		after.UTC(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, *a)
+	}
+
+	return alerts, rows.Err()
+}
+
+// GetAlertsBetween retrieves the alerts raised in the time range, oldest first
+func GetAlertsBetween(ctx context.Context, from, to time.Time) ([]Alert, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+alertColumns+" FROM alerts WHERE raised_at >= ? AND raised_at <= ? ORDER BY raised_at ASC",
+		from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, *a)
+	}
+
+	return alerts, rows.Err()
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrBackupUnsupported is returned by Backup and Restore for PostgreSQL, which is
+// backed up with its own tools such as pg_dump
+var ErrBackupUnsupported = errors.New("backups are only supported for SQLite databases, use pg_dump for PostgreSQL")
+
+// ErrInvalidBackup is returned by Restore for a file that is not an intact SQLite
+// database of the septic monitor
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup writes a consistent snapshot of the SQLite database to the file, which must
+// not exist yet. VACUUM INTO reads the database in a single transaction, so readings
+// keep coming in meanwhile and the snapshot is compacted as a bonus.
+func Backup(ctx context.Context, file string) error {
+	if isPostgres() {
+		return ErrBackupUnsupported
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", file); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the contents of the SQLite database with the backup in the file,
+// through the SQLite online backup API so the server keeps running. The backup is
+// checked first, and upgraded to the current schema after the restore. The watermark
+// is moved to the restored database, which would otherwise be reported as rolled back.
+func Restore(ctx context.Context, file string) error {
+	if isPostgres() {
+		return ErrBackupUnsupported
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	if err := checkBackup(ctx, src); err != nil {
+		return err
+	}
+
+	if err := copyPages(ctx, src); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	if err := migrateSQLite(); err != nil {
+		return err
+	}
+
+	c, err := CheckConsistency(ctx)
+	if err != nil {
+		return err
+	}
+	if c.Integrity != "ok" {
+		return fmt.Errorf("restored database failed the integrity check: %s", c.Integrity)
+	}
+
+	log.Printf("Database restored from backup, latest reading is %d", c.LatestID)
+	return nil
+}
+
+// copyPages copies every page of the source database into the database. It holds a
+// connection of the pool meanwhile, which has to be released before querying again.
+func copyPages(ctx context.Context, src *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(dest any) error {
+		return srcConn.Raw(func(src any) error {
+			backup, err := dest.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// checkBackup verifies the file is an intact septic monitor database
+func checkBackup(ctx context.Context, src *sql.DB) error {
+	var integrity string
+	if err := src.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&integrity); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, integrity)
+	}
+
+	var tables int
+	if err := src.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'level_data'").Scan(&tables); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%w: no level_data table", ErrInvalidBackup)
+	}
+	return nil
+}
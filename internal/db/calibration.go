@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Calibration session states
+const (
+	CalibrationOpen      = "open"
+	CalibrationApplied   = "applied"
+	CalibrationCancelled = "cancelled"
+)
+
+// Calibration maps the levels reported by a device's sensor to calibrated levels,
+// level = raw level * scale + offset
+type Calibration struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Scale     float64   `json:"scale"`
+	Offset    float64   `json:"offset"`
+	SessionID *int64    `json:"session_id"` // Session it was computed from, null if set directly
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Apply returns the calibrated level of a raw level
+func (c Calibration) Apply(raw float64) float64 {
+	return raw*c.Scale + c.Offset
+}
+
+// CalibrationPoint is a known level with the raw level the sensor reported at it
+type CalibrationPoint struct {
+	Level      float64   `json:"level"`
+	RawLevel   float64   `json:"raw_level"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// CalibrationSession collects calibration points of a device, e.g. at the empty and full marks
+type CalibrationSession struct {
+	ID        int64              `json:"id"`
+	DeviceID  string             `json:"device_id"`
+	State     string             `json:"state"`
+	StartedAt time.Time          `json:"started_at"`
+	EndedAt   *time.Time         `json:"ended_at"`
+	Points    []CalibrationPoint `json:"points"`
+}
+
+const calibrationColumns = "id, device_id, scale, level_offset, session_id, created_at"
+
+// scanCalibration scans a row selected with calibrationColumns
+func scanCalibration(row interface{ Scan(...any) error }) (*Calibration, error) {
+	var c Calibration
+	var sessionID sql.NullInt64
+	if err := row.Scan(&c.ID, &c.DeviceID, &c.Scale, &c.Offset, &sessionID, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	if sessionID.Valid {
+		c.SessionID = &sessionID.Int64
+	}
+	return &c, nil
+}
+
+// GetCalibration retrieves the current calibration of a device, or nil if it has none
+func GetCalibration(ctx context.Context, deviceID string) (*Calibration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx, "SELECT "+calibrationColumns+" FROM calibrations WHERE device_id = ? ORDER BY id DESC LIMIT 1", deviceID)
+	c, err := scanCalibration(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calibration: %w", err)
+	}
+	return c, nil
+}
+
+// GetCalibrations retrieves the calibration history of a device, newest first
+func GetCalibrations(ctx context.Context, deviceID string) ([]Calibration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+calibrationColumns+" FROM calibrations WHERE device_id = ? ORDER BY id DESC", deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calibrations: %w", err)
+	}
+	defer rows.Close()
+
+	calibrations := []Calibration{}
+	for rows.Next() {
+		c, err := scanCalibration(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan calibration: %w", err)
+		}
+		calibrations = append(calibrations, *c)
+	}
+
+	return calibrations, rows.Err()
+}
+
+// SaveCalibration makes the calibration current for its device, keeping the earlier ones as history
+func SaveCalibration(ctx context.Context, c Calibration) (*Calibration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	c.CreatedAt = time.Now().UTC()
+	err := db.QueryRowContext(ctx,
+		"INSERT INTO calibrations (device_id, scale, level_offset, session_id, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		c.DeviceID, c.Scale, c.Offset, c.SessionID, c.CreatedAt,
+	).Scan(&c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert calibration: %w", err)
+	}
+	return &c, nil
+}
+
+// GetLatestRawLevel retrieves the level the sensor of a device last reported, before calibration,
+// or nil if the device has no readings
+func GetLatestRawLevel(ctx context.Context, deviceID string) (*float64, error) {
+	return store.LatestRawLevel(ctx, deviceID)
+}
+
+// StartCalibrationSession opens a calibration session for a device, cancelling any open one
+func StartCalibrationSession(ctx context.Context, deviceID string) (*CalibrationSession, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE calibration_sessions SET state = ?, ended_at = ? WHERE device_id = ? AND state = ?",
+		CalibrationCancelled, now, deviceID, CalibrationOpen,
+	); err != nil {
+		return nil, fmt.Errorf("failed to cancel open calibration session: %w", err)
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO calibration_sessions (device_id, state, started_at) VALUES (?, ?, ?) RETURNING id",
+		deviceID, CalibrationOpen, now,
+	).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert calibration session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit calibration session: %w", err)
+	}
+
+	return &CalibrationSession{ID: id, DeviceID: deviceID, State: CalibrationOpen, StartedAt: now, Points: []CalibrationPoint{}}, nil
+}
+
+// GetOpenCalibrationSession retrieves the open calibration session of a device with its
+// points, or nil if there is none
+func GetOpenCalibrationSession(ctx context.Context, deviceID string) (*CalibrationSession, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	s := CalibrationSession{DeviceID: deviceID, State: CalibrationOpen, Points: []CalibrationPoint{}}
+	err := db.QueryRowContext(ctx,
+		"SELECT id, started_at FROM calibration_sessions WHERE device_id = ? AND state = ? ORDER BY id DESC LIMIT 1",
+		deviceID, CalibrationOpen,
+	).Scan(&s.ID, &s.StartedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calibration session: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT level, raw_level, recorded_at FROM calibration_points WHERE session_id = ? ORDER BY id ASC",
+		s.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calibration points: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p CalibrationPoint
+		if err := rows.Scan(&p.Level, &p.RawLevel, &p.RecordedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan calibration point: %w", err)
+		}
+		s.Points = append(s.Points, p)
+	}
+
+	return &s, rows.Err()
+}
+
+// AddCalibrationPoint records a point in a calibration session
+func AddCalibrationPoint(ctx context.Context, sessionID int64, p CalibrationPoint) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO calibration_points (session_id, level, raw_level, recorded_at) VALUES (?, ?, ?, ?)",
+		sessionID, p.Level, p.RawLevel, p.RecordedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert calibration point: %w", err)
+	}
+	return nil
+}
+
+// EndCalibrationSession closes a calibration session as applied or cancelled
+func EndCalibrationSession(ctx context.Context, sessionID int64, state string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"UPDATE calibration_sessions SET state = ?, ended_at = ? WHERE id = ?",
+		state, time.Now().UTC(), sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update calibration session: %w", err)
+	}
+	return nil
+}
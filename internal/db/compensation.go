@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TemperatureCompensation enables the speed-of-sound correction of the readings of an
+// ultrasonic sensor, which converts echo times to distances assuming the speed of sound
+// at the reference temperature
+type TemperatureCompensation struct {
+	DeviceID             string    `json:"device_id"`
+	SensorHeight         *float64  `json:"sensor_height"`         // Level at the sensor, null to use SENSOR_HEIGHT
+	ReferenceTemperature float64   `json:"reference_temperature"` // °C the firmware assumes
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// GetTemperatureCompensation retrieves the temperature compensation of a device, or nil
+// if it is not enabled
+func GetTemperatureCompensation(ctx context.Context, deviceID string) (*TemperatureCompensation, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	c := TemperatureCompensation{DeviceID: deviceID}
+	var height sql.NullFloat64
+	err := db.QueryRowContext(ctx,
+		"SELECT sensor_height, reference_temperature, updated_at FROM temperature_compensation WHERE device_id = ?",
+		deviceID,
+	).Scan(&height, &c.ReferenceTemperature, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query temperature compensation: %w", err)
+	}
+	if height.Valid {
+		c.SensorHeight = &height.Float64
+	}
+
+	return &c, nil
+}
+
+// SaveTemperatureCompensation enables or updates the temperature compensation of a device
+func SaveTemperatureCompensation(ctx context.Context, c TemperatureCompensation) (*TemperatureCompensation, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	c.UpdatedAt = time.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO temperature_compensation (device_id, sensor_height, reference_temperature, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET
+			sensor_height = excluded.sensor_height,
+			reference_temperature = excluded.reference_temperature,
+			updated_at = excluded.updated_at`,
+		c.DeviceID, c.SensorHeight, c.ReferenceTemperature, c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save temperature compensation: %w", err)
+	}
+
+	return &c, nil
+}
+
+// DeleteTemperatureCompensation disables the temperature compensation of a device
+func DeleteTemperatureCompensation(ctx context.Context, deviceID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM temperature_compensation WHERE device_id = ?", deviceID); err != nil {
+		return fmt.Errorf("failed to delete temperature compensation: %w", err)
+	}
+
+	return nil
+}
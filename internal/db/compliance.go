@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of compliance log entries
+const (
+	ComplianceReading       = "reading"
+	CompliancePumpOut       = "pump_out"
+	ComplianceRecalibration = "recalibration"
+	ComplianceCalibration   = "calibration"
+)
+
+// ComplianceTimeLayout is the fixed-width UTC layout of recorded_at, stored as text so that
+// it sorts in order and the hashed value is read back exactly
+const ComplianceTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// complianceGenesis is the previous hash of the first entry
+const complianceGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// ComplianceEntry is an entry of the compliance log. Each entry's hash covers the hash of
+// the one before it, so changing, removing or reordering entries breaks the chain.
+type ComplianceEntry struct {
+	Seq        int64     `json:"seq"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Kind       string    `json:"kind"`
+	DeviceID   string    `json:"device_id"`
+	Data       string    `json:"data"` // JSON of the reading or event
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// complianceMux serializes appends, each one builds on the hash of the last
+var complianceMux sync.Mutex
+
+// ComputeHash returns the hex SHA-256 of the previous hash and the fields of the entry,
+// separated by newlines: prev_hash, seq, recorded_at (UTC, as 2006-01-02T15:04:05.000000000Z),
+// kind, device_id and data
+func (e ComplianceEntry) ComputeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Seq, 10),
+		e.RecordedAt.UTC().Format(ComplianceTimeLayout),
+		e.Kind,
+		e.DeviceID,
+		e.Data,
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AppendCompliance appends an entry to the compliance log, chained to the last one
+func AppendCompliance(ctx context.Context, kind, deviceID, data string) (*ComplianceEntry, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	complianceMux.Lock()
+	defer complianceMux.Unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	e := ComplianceEntry{Seq: 1, PrevHash: complianceGenesis, Kind: kind, DeviceID: deviceID, Data: data}
+	var lastSeq int64
+	var lastHash string
+	err = tx.QueryRowContext(ctx, "SELECT seq, hash FROM compliance_log ORDER BY seq DESC LIMIT 1").Scan(&lastSeq, &lastHash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("failed to query last compliance entry: %w", err)
+	default:
+		e.Seq, e.PrevHash = lastSeq+1, lastHash
+	}
+
+	e.RecordedAt = time.Now().UTC()
+	e.Hash = e.ComputeHash()
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO compliance_log (seq, recorded_at, kind, device_id, data, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.Seq, e.RecordedAt.Format(ComplianceTimeLayout), e.Kind, e.DeviceID, e.Data, e.PrevHash, e.Hash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert compliance entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit compliance entry: %w", err)
+	}
+	return &e, nil
+}
+
+// GetComplianceEntries retrieves the compliance log entries recorded in the time range,
+// oldest first. Zero times leave the range open.
+func GetComplianceEntries(ctx context.Context, from, to time.Time) ([]ComplianceEntry, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT seq, recorded_at, kind, device_id, data, prev_hash, hash FROM compliance_log WHERE 1 = 1"
+	var args []any
+	if !from.IsZero() {
+		query += " AND recorded_at >= ?"
+		args = append(args, from.UTC().Format(ComplianceTimeLayout))
+	}
+	if !to.IsZero() {
+		query += " AND recorded_at <= ?"
+		args = append(args, to.UTC().Format(ComplianceTimeLayout))
+	}
+	query += " ORDER BY seq ASC"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compliance log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []ComplianceEntry{}
+	for rows.Next() {
+		var e ComplianceEntry
+		var recordedAt string
+		if err := rows.Scan(&e.Seq, &recordedAt, &e.Kind, &e.DeviceID, &e.Data, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan compliance entry: %w", err)
+		}
+		if e.RecordedAt, err = time.Parse(ComplianceTimeLayout, recordedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse compliance entry time: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// ComplianceVerification is the outcome of checking the compliance log chain
+type ComplianceVerification struct {
+	Entries      int    `json:"entries"`
+	Valid        bool   `json:"valid"`
+	FirstInvalid *int64 `json:"first_invalid,omitempty"` // Seq of the first entry breaking the chain
+	HeadHash     string `json:"head_hash,omitempty"`     // Hash of the last entry, to be noted down as a checkpoint
+}
+
+// VerifyCompliance recomputes the hash chain of the whole compliance log
+func VerifyCompliance(ctx context.Context) (*ComplianceVerification, error) {
+	entries, err := GetComplianceEntries(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ComplianceVerification{Entries: len(entries), Valid: true}
+	prevHash := complianceGenesis
+	for i, e := range entries {
+		if e.Seq != int64(i+1) || e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+			v.Valid = false
+			v.FirstInvalid = &e.Seq
+			break
+		}
+		prevHash = e.Hash
+	}
+	if len(entries) > 0 {
+		v.HeadHash = entries[len(entries)-1].Hash
+	}
+
+	return v, nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB initializes a fresh SQLite database in a temporary directory
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close() })
+}
+
+// tamper runs the statement on the compliance log the way someone editing the database
+// file directly would, past the triggers keeping the log append-only
+func tamper(t *testing.T, stmt string, args ...any) {
+	t.Helper()
+	for _, trigger := range []string{"compliance_log_no_update", "compliance_log_no_delete"} {
+		if _, err := db.Exec("DROP TRIGGER " + trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(stmt, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// appendEntries appends n reading entries to the compliance log
+func appendEntries(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := AppendCompliance(context.Background(), ComplianceReading, "tank", `{"level":120}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendComplianceChains(t *testing.T) {
+	openTestDB(t)
+	appendEntries(t, 3)
+
+	entries, err := GetComplianceEntries(context.Background(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	if entries[0].PrevHash != complianceGenesis {
+		t.Errorf("first entry chained to %s, want the genesis hash", entries[0].PrevHash)
+	}
+	for i, e := range entries {
+		if e.Hash != e.ComputeHash() {
+			t.Errorf("entry %d: stored hash doesn't match the recomputed one", e.Seq)
+		}
+		if i > 0 && e.PrevHash != entries[i-1].Hash {
+			t.Errorf("entry %d isn't chained to entry %d", e.Seq, entries[i-1].Seq)
+		}
+	}
+
+	v, err := VerifyCompliance(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Entries != 3 || v.FirstInvalid != nil || v.HeadHash != entries[2].Hash {
+		t.Errorf("VerifyCompliance = %+v, want a valid chain of 3 headed by %s", v, entries[2].Hash)
+	}
+}
+
+func TestVerifyComplianceDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name         string
+		tamper       string
+		firstInvalid int64
+	}{
+		{"changed data", `UPDATE compliance_log SET data = '{"level":20}' WHERE seq = 2`, 2},
+		{"changed time", `UPDATE compliance_log SET recorded_at = '2020-01-01T00:00:00.000000000Z' WHERE seq = 3`, 3},
+		{"changed device", `UPDATE compliance_log SET device_id = 'other' WHERE seq = 1`, 1},
+		{"deleted entry", `DELETE FROM compliance_log WHERE seq = 2`, 3},
+		{"rehashed entry", `UPDATE compliance_log SET hash = '` + complianceGenesis + `' WHERE seq = 2`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			appendEntries(t, 4)
+
+			tamper(t, tt.tamper)
+
+			v, err := VerifyCompliance(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Valid {
+				t.Fatal("VerifyCompliance reports a tampered log as valid")
+			}
+			if v.FirstInvalid == nil || *v.FirstInvalid != tt.firstInvalid {
+				t.Errorf("first invalid entry = %v, want %d", v.FirstInvalid, tt.firstInvalid)
+			}
+		})
+	}
+}
+
+// TestVerifyComplianceDetectsRewrittenChain checks that recomputing the hash of a changed
+// entry doesn't help, the next entry still holds the original hash
+func TestVerifyComplianceDetectsRewrittenChain(t *testing.T) {
+	openTestDB(t)
+	appendEntries(t, 3)
+
+	entries, err := GetComplianceEntries(context.Background(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := entries[1]
+	forged.Data = `{"level":20}`
+	forged.Hash = forged.ComputeHash()
+	tamper(t, "UPDATE compliance_log SET data = ?, hash = ? WHERE seq = ?", forged.Data, forged.Hash, forged.Seq)
+
+	v, err := VerifyCompliance(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid || v.FirstInvalid == nil || *v.FirstInvalid != 3 {
+		t.Errorf("VerifyCompliance = %+v, want entry 3 to break the chain", v)
+	}
+}
+
+func TestComplianceLogIsAppendOnly(t *testing.T) {
+	openTestDB(t)
+	appendEntries(t, 1)
+
+	for _, stmt := range []string{
+		"UPDATE compliance_log SET data = '{}' WHERE seq = 1",
+		"DELETE FROM compliance_log WHERE seq = 1",
+	} {
+		if _, err := db.Exec(stmt); err == nil {
+			t.Errorf("%s succeeded, want the trigger to reject it", stmt)
+		}
+	}
+}
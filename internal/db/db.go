@@ -1,77 +1,1020 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
+var db *conn
 
-// Init initializes the database connection and creates the table
-func Init() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./data.db")
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
-	}
+// queryTimeout bounds every query so a held SQLite lock can't block callers indefinitely
+var queryTimeout = 5 * time.Second
 
-	// Create table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS level_data (
+// schema lists the statements creating the SQLite tables, applied in order on startup
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS level_data (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		level REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		message TEXT NOT NULL,
+		provider_id TEXT,
+		status TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_provider_id ON notifications (provider_id);`,
+	`CREATE TABLE IF NOT EXISTS telemetry (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		value REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_telemetry_device_metric ON telemetry (device_id, metric, created_at);`,
+	`CREATE TABLE IF NOT EXISTS devices (
+		id TEXT PRIMARY KEY,
+		firmware TEXT NOT NULL DEFAULT '',
+		last_seen DATETIME,
+		error_count INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS device_config (
+		device_id TEXT PRIMARY KEY,
+		report_interval INTEGER NOT NULL,
+		buzzer_threshold REAL NOT NULL DEFAULT 0,
+		sleep_start TEXT NOT NULL DEFAULT '',
+		sleep_end TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME
+	);`,
+	`CREATE TABLE IF NOT EXISTS downlinks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS alerts (
+		id TEXT PRIMARY KEY,
+		severity TEXT NOT NULL,
+		level REAL NOT NULL,
+		threshold REAL NOT NULL,
+		state TEXT NOT NULL,
+		raised_at DATETIME NOT NULL,
+		notified_at DATETIME,
+		acknowledged_at DATETIME,
+		resolved_at DATETIME,
+		last_notified_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_alerts_raised_at ON alerts (raised_at);`,
+	`CREATE TABLE IF NOT EXISTS sites (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		recipients TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS pump_outs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		pumped_at DATETIME NOT NULL,
+		source TEXT NOT NULL,
+		level_before REAL,
+		level_after REAL,
+		notes TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pump_outs_device ON pump_outs (device_id, pumped_at);`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS calibrations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		scale REAL NOT NULL,
+		level_offset REAL NOT NULL,
+		session_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_calibrations_device ON calibrations (device_id, id);`,
+	`CREATE TABLE IF NOT EXISTS calibration_sessions (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		ended_at DATETIME
+	);`,
+	`CREATE TABLE IF NOT EXISTS calibration_points (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id INTEGER NOT NULL,
 		level REAL NOT NULL,
+		raw_level REAL NOT NULL,
+		recorded_at DATETIME NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS temperature_compensation (
+		device_id TEXT PRIMARY KEY,
+		sensor_height REAL,
+		reference_temperature REAL NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS compliance_log (
+		seq INTEGER PRIMARY KEY,
+		recorded_at TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS compliance_log_no_update BEFORE UPDATE ON compliance_log
+	BEGIN SELECT RAISE(ABORT, 'compliance log is append-only'); END;`,
+	`CREATE TRIGGER IF NOT EXISTS compliance_log_no_delete BEFORE DELETE ON compliance_log
+	BEGIN SELECT RAISE(ABORT, 'compliance log is append-only'); END;`,
+	`CREATE TABLE IF NOT EXISTS level_aggregates (
+		device_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		min_level REAL NOT NULL,
+		max_level REAL NOT NULL,
+		sum_level REAL NOT NULL,
+		readings INTEGER NOT NULL,
+		PRIMARY KEY (device_id, bucket, created_at)
+	);`,
+	`CREATE TABLE IF NOT EXISTS recipients (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		phone TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		telegram_chat_id TEXT NOT NULL DEFAULT '',
+		channels TEXT NOT NULL DEFAULT '',
+		severities TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS guest_links (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		severities TEXT NOT NULL DEFAULT '',
+		until DATETIME NOT NULL,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
+	);`,
+	`CREATE TABLE IF NOT EXISTS device_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_device_tokens_device_id ON device_tokens (device_id);`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL DEFAULT 0,
+		url TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		response TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		redelivery_of INTEGER,
+		created_at DATETIME NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);`,
+}
+
+// columns lists columns added to existing tables, applied on startup after the schema
+var columns = []struct{ table, name, definition string }{
+	{"devices", "last_seq", "INTEGER NOT NULL DEFAULT 0"},
+	{"devices", "clock_skew", "INTEGER NOT NULL DEFAULT 0"},
+	{"level_data", "raw_timestamp", "DATETIME"},
+	{"level_data", "quality", "TEXT NOT NULL DEFAULT 'ok'"},
+	{"notifications", "alert_id", "TEXT NOT NULL DEFAULT ''"},
+	{"alerts", "device_id", "TEXT NOT NULL DEFAULT 'default'"}, // Alerts predating devices belong to the default device
+	{"devices", "site_id", "TEXT NOT NULL DEFAULT ''"},
+	{"alerts", "kind", "TEXT NOT NULL DEFAULT 'level'"},
+	{"devices", "name", "TEXT NOT NULL DEFAULT ''"},
+	{"devices", "location", "TEXT NOT NULL DEFAULT ''"},
+	{"devices", "notes", "TEXT NOT NULL DEFAULT ''"},
+	{"level_data", "device_id", "TEXT NOT NULL DEFAULT 'default'"}, // Readings predating devices belong to the default device
+	{"alerts", "latency_ms", "INTEGER"},
+	{"level_data", "raw_level", "REAL"}, // Level as reported by the sensor, if calibrated at ingest
+	{"level_data", "transport", "TEXT NOT NULL DEFAULT ''"},
+	{"level_data", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "vacation_only", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"recipients", "expires_at", "DATETIME"},
+}
+
+// indexes lists indexes on added columns, created on startup after the columns
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_level_data_device ON level_data (device_id, created_at);`,
+}
+
+// Init initializes the database connection and creates the tables: in PostgreSQL if
+// DATABASE_URL is set, in SQLite otherwise
+func Init() error {
+	// Query timeout in seconds
+	queryTimeout = time.Duration(envInt("DB_QUERY_TIMEOUT", 5, 1)) * time.Second
 
-	if _, err := db.Exec(createTableSQL); err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		if err := initPostgres(url); err != nil {
+			return err
+		}
+	} else if err := initSQLite(); err != nil {
+		return err
 	}
 
+	store = &sqlStore{db: db}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
-// SaveLevelData saves the level data to the database
-func SaveLevelData(level float64) error {
-	stmt, err := db.Prepare("INSERT INTO level_data (level, created_at) VALUES (?, ?)")
+// initSQLite opens the SQLite database at DATABASE_PATH and creates or upgrades its tables
+func initSQLite() error {
+	// Database file path
+	if p := os.Getenv("DATABASE_PATH"); p != "" {
+		path = p
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dsn())
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	db = &conn{sqlDB}
+
+	// SQLite allows a single writer, a single connection by default queues
+	// concurrent writes in the pool instead of failing them with SQLITE_BUSY
+	db.SetMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", 1, 0))
+	db.SetMaxIdleConns(envInt("DB_MAX_IDLE_CONNS", 1, 0))
+	db.SetConnMaxLifetime(time.Duration(envInt("DB_CONN_MAX_LIFETIME", 0, 0)) * time.Second)
+
+	return migrateSQLite()
+}
+
+// migrateSQLite creates the missing tables, columns and indexes of the SQLite database
+func migrateSQLite() error {
+	// Create tables if they don't exist
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+
+	// Add columns missing from databases created by older versions
+	for _, c := range columns {
+		if err := addColumn(c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range indexes {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// envInt reads an integer setting of at least min from the environment, falling back to def
+func envInt(key string, def, min int) int {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(level, time.Now())
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min {
+		log.Printf("Invalid %s value: %s, using default: %d", key, s, def)
+		return def
+	}
+
+	return v
+}
+
+// withTimeout derives a context bounded by the query timeout
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// addColumn adds a column to a table unless it already exists
+func addColumn(table, name, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
 	if err != nil {
-		return fmt.Errorf("failed to insert data: %w", err)
+		return fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return fmt.Errorf("failed to scan column: %w", err)
+		}
+		if column == name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, name, err)
 	}
 
 	return nil
 }
 
-// GetLatestLevelData retrieves the latest level data from the database
-func GetLatestLevelData() (float64, error) {
-	rows, err := db.Query("SELECT level FROM level_data ORDER BY created_at DESC LIMIT 1")
+// Reading quality flags set by the ingest filters
+const (
+	QualityOK           = "ok"
+	QualityInterpolated = "interpolated"
+	QualityAnomalous    = "anomalous"
+	QualityCorrected    = "corrected" // Timestamp corrected for device clock skew
+	QualitySimulated    = "simulated"
+)
+
+// Transports readings arrive over, recorded with every reading
+const (
+	TransportHTTP    = "http"
+	TransportBacklog = "backlog"
+	TransportMQTT    = "mqtt"
+	TransportWebhook = "webhook"
+	TransportZigbee  = "zigbee"
+)
+
+// trustedQualities is the SQL list of qualities fit for alerting and statistics
+const trustedQualities = "('ok', 'corrected')"
+
+// IsTrustedQuality reports whether readings of the quality may drive alerts and statistics
+func IsTrustedQuality(quality string) bool {
+	return quality == QualityOK || quality == QualityCorrected
+}
+
+// Reading is a level reading to be stored
+type Reading struct {
+	Level        float64
+	Timestamp    time.Time  // Time of the reading, corrected for device clock skew
+	RawTimestamp *time.Time // Timestamp as reported by the device, if any
+	RawLevel     *float64   // Level as reported by the sensor, if calibrated at ingest
+	Quality      string
+	Transport    string // How the reading arrived, one of the Transport constants
+	Source       string // Client address or topic the reading came from
+}
+
+// SaveReading saves the level reading of the device to the database
+func SaveReading(ctx context.Context, deviceID string, r Reading) error {
+	return store.SaveReading(ctx, deviceID, r)
+}
+
+// utcOrNil converts an optional time to UTC for storage
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// GetLatestLevelData retrieves the latest trusted level data from the database
+func GetLatestLevelData(ctx context.Context) (float64, error) {
+	return store.Latest(ctx)
+}
+
+// GetDeviceLevel retrieves the latest trusted level of a device
+func GetDeviceLevel(ctx context.Context, deviceID string) (float64, error) {
+	return store.DeviceLatest(ctx, deviceID)
+}
+
+// DeviceLevel is the latest trusted level of a device
+type DeviceLevel struct {
+	DeviceID string
+	Level    float64
+}
+
+// GetDeviceLevels retrieves the latest trusted level of every device, ordered by device ID
+func GetDeviceLevels(ctx context.Context) ([]DeviceLevel, error) {
+	return store.Levels(ctx)
+}
+
+// GetLastReadingTimes retrieves the time of the latest reading of every device, whatever its quality
+func GetLastReadingTimes(ctx context.Context) (map[string]time.Time, error) {
+	return store.LastReadingTimes(ctx)
+}
+
+// GetReadings retrieves the trusted readings between from and to, oldest first
+func GetReadings(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	return store.Range(ctx, from, to)
+}
+
+// GetDeviceReadings retrieves the trusted readings of a device within the time range, oldest first
+func GetDeviceReadings(ctx context.Context, deviceID string, from, to time.Time) ([]Reading, error) {
+	return store.DeviceRange(ctx, deviceID, from, to)
+}
+
+// Aggregation buckets
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// bucketStarts are the SQLite expressions truncating created_at to the start of its
+// bucket in UTC, weeks start on Monday
+var bucketStarts = map[string]string{
+	BucketHour: "strftime('%Y-%m-%d %H:00:00', created_at)",
+	BucketDay:  "strftime('%Y-%m-%d 00:00:00', created_at)",
+	BucketWeek: "strftime('%Y-%m-%d 00:00:00', created_at, '-6 days', 'weekday 1')",
+}
+
+// LevelStats summarizes the trusted readings in one bucket
+type LevelStats struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+// GetLevelStats aggregates the readings matching the filter per hour, day or week,
+// oldest bucket first. Without a quality filter only the trusted readings count.
+func GetLevelStats(ctx context.Context, bucket string, f ReadingFilter) ([]LevelStats, error) {
+	return store.Stats(ctx, bucket, f)
+}
+
+// Seasonal groupings
+const (
+	SeasonMonth   = "month"
+	SeasonWeekday = "weekday"
+)
+
+// seasonPeriods are the SQLite expressions extracting the period of a reading in UTC
+var seasonPeriods = map[string]string{
+	SeasonMonth:   "CAST(strftime('%m', created_at) AS INTEGER)",
+	SeasonWeekday: "CAST(strftime('%w', created_at) AS INTEGER)",
+}
+
+// SeasonalUsage summarizes the readings of a device falling in one month of the
+// year or day of the week, across the full history
+type SeasonalUsage struct {
+	Period         int     `json:"period"`           // Month 1-12 or weekday 0 (Sunday) to 6
+	AvgDailyInflow float64 `json:"avg_daily_inflow"` // Average level rise per day, pump-outs excluded
+	PeakLevel      float64 `json:"peak_level"`
+	AvgLevel       float64 `json:"avg_level"`
+	Days           int     `json:"days"` // Days with readings the figures are based on
+}
+
+// GetSeasonalUsage summarizes the trusted readings of a device by month or weekday. The
+// inflow is the sum of the rises between consecutive readings, drops such as pump-outs
+// don't count against it.
+func GetSeasonalUsage(ctx context.Context, deviceID, season string) ([]SeasonalUsage, error) {
+	return store.Seasonal(ctx, deviceID, season)
+}
+
+// HistoryReading is a stored reading as listed in the reading history
+type HistoryReading struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Level     float64   `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+	Quality   string    `json:"quality"`
+	Transport string    `json:"transport"` // Empty for readings stored before provenance was recorded
+	Source    string    `json:"source"`
+}
+
+// ReadingFilter selects the readings listed in the history or aggregated in the
+// statistics, empty fields match all readings
+type ReadingFilter struct {
+	DeviceID         string
+	Transport        string
+	Source           string
+	Qualities        []string // Only readings of these qualities, by default all in the history and the trusted ones in the statistics
+	ExcludeQualities []string // Leave out readings of these qualities, e.g. simulated
+	From             time.Time
+	To               time.Time
+}
+
+// Qualities lists the reading quality flags
+var Qualities = []string{QualityOK, QualityInterpolated, QualityAnomalous, QualityCorrected, QualitySimulated}
+
+// GetHistory retrieves up to limit stored readings matching the filter, oldest first.
+// Readings up to and including the one at afterTime with ID afterID are skipped, for
+// paging through the history.
+func GetHistory(ctx context.Context, f ReadingFilter, afterTime time.Time, afterID int64, limit int) ([]HistoryReading, error) {
+	return store.History(ctx, f, afterTime, afterID, limit)
+}
+
+// Provenance counts the readings of a device that arrived over one transport from one source
+type Provenance struct {
+	DeviceID  string    `json:"device_id"`
+	Transport string    `json:"transport"`
+	Source    string    `json:"source"`
+	Readings  int       `json:"readings"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
+
+// GetProvenance counts the stored readings per device, transport and source, optionally
+// of a single device and within a time range
+func GetProvenance(ctx context.Context, deviceID string, from, to time.Time) ([]Provenance, error) {
+	return store.Provenance(ctx, deviceID, from, to)
+}
+
+// Device is a sensor node that has reported to the server
+type Device struct {
+	ID         string     `json:"id"`
+	Firmware   string     `json:"firmware"`
+	LastSeen   *time.Time `json:"last_seen"`
+	ErrorCount int        `json:"error_count"`
+	LastError  string     `json:"last_error"`
+	ClockSkew  int64      `json:"clock_skew"` // Seconds the device clock was off when last corrected
+	SiteID     string     `json:"site_id"`
+	CreatedAt  *time.Time `json:"created_at"` // When the device was provisioned or first reported
+	DeviceInfo
+}
+
+// DeviceInfo is the human-friendly description of a device, set through the API
+type DeviceInfo struct {
+	Name     string `json:"name"` // e.g. Cabin tank
+	Location string `json:"location"`
+	Notes    string `json:"notes"`
+}
+
+// TouchDevice registers the device if needed and updates its last-seen time
+// and, when non-empty, its firmware version
+func TouchDevice(ctx context.Context, id, firmware string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO devices (id, firmware, last_seen, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			firmware = CASE WHEN excluded.firmware != '' THEN excluded.firmware ELSE devices.firmware END,
+			last_seen = excluded.last_seen`,
+		id, firmware, now, now,
+	)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query database: %w", err)
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return nil
+}
+
+// RecordDeviceError increments the error count of the device and stores the error message
+func RecordDeviceError(ctx context.Context, id, message string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO devices (id, error_count, last_error, created_at) VALUES (?, 1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			error_count = devices.error_count + 1,
+			last_error = excluded.last_error`,
+		id, message, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record device error: %w", err)
+	}
+
+	return nil
+}
+
+// GetDevices retrieves all known devices ordered by ID
+func GetDevices(ctx context.Context) ([]Device, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+deviceColumns+" FROM devices ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, *d)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetDevice retrieves a device, or nil if it isn't known
+func GetDevice(ctx context.Context, id string) (*Device, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	d, err := scanDevice(db.QueryRowContext(ctx, "SELECT "+deviceColumns+" FROM devices WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device: %w", err)
+	}
+
+	return d, nil
+}
+
+// SaveDeviceInfo sets the name, location and notes of a device, registering it if needed
+func SaveDeviceInfo(ctx context.Context, id string, info DeviceInfo) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO devices (id, name, location, notes, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			location = excluded.location,
+			notes = excluded.notes`,
+		id, info.Name, info.Location, info.Notes, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save device info: %w", err)
+	}
+
+	return nil
+}
+
+// deviceColumns are the columns scanned by scanDevice
+const deviceColumns = "id, firmware, last_seen, error_count, last_error, clock_skew, site_id, name, location, notes, created_at"
+
+func scanDevice(row interface{ Scan(...any) error }) (*Device, error) {
+	var d Device
+	var lastSeen, createdAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.Firmware, &lastSeen, &d.ErrorCount, &d.LastError, &d.ClockSkew, &d.SiteID, &d.Name, &d.Location, &d.Notes, &createdAt); err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		d.LastSeen = &lastSeen.Time
+	}
+	d.CreatedAt = timeOrNil(createdAt)
+	return &d, nil
+}
+
+// DeviceConfig is the configuration a device fetches from the server
+type DeviceConfig struct {
+	ReportInterval  int     `json:"report_interval"`  // Seconds between readings
+	BuzzerThreshold float64 `json:"buzzer_threshold"` // Level at which the local buzzer sounds, 0 disables it
+	SleepStart      string  `json:"sleep_start"`      // Start of the nightly sleep window (HH:MM), empty disables it
+	SleepEnd        string  `json:"sleep_end"`        // End of the nightly sleep window (HH:MM)
+}
+
+// GetDeviceConfig retrieves the stored configuration of a device, or nil if none is stored
+func GetDeviceConfig(ctx context.Context, deviceID string) (*DeviceConfig, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var c DeviceConfig
+	err := db.QueryRowContext(ctx,
+		"SELECT report_interval, buzzer_threshold, sleep_start, sleep_end FROM device_config WHERE device_id = ?",
+		deviceID,
+	).Scan(&c.ReportInterval, &c.BuzzerThreshold, &c.SleepStart, &c.SleepEnd)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device config: %w", err)
+	}
+
+	return &c, nil
+}
+
+// SaveDeviceConfig stores the configuration of a device
+func SaveDeviceConfig(ctx context.Context, deviceID string, c DeviceConfig) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO device_config (device_id, report_interval, buzzer_threshold, sleep_start, sleep_end, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET
+			report_interval = excluded.report_interval,
+			buzzer_threshold = excluded.buzzer_threshold,
+			sleep_start = excluded.sleep_start,
+			sleep_end = excluded.sleep_end,
+			updated_at = excluded.updated_at`,
+		deviceID, c.ReportInterval, c.BuzzerThreshold, c.SleepStart, c.SleepEnd, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save device config: %w", err)
+	}
+
+	return nil
+}
+
+// Downlink is a command queued for delivery to a LoRaWAN device
+type Downlink struct {
+	ID        int64     `json:"id"`
+	Port      int       `json:"port"`
+	Payload   string    `json:"payload"` // Hex encoded
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveDownlink records a downlink queued for a device
+func SaveDownlink(ctx context.Context, deviceID string, d Downlink) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO downlinks (device_id, port, payload, status, error, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		deviceID, d.Port, d.Payload, d.Status, d.Error, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert downlink: %w", err)
+	}
+
+	return nil
+}
+
+// GetDownlinks retrieves the downlinks queued for a device, newest first
+func GetDownlinks(ctx context.Context, deviceID string) ([]Downlink, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, port, payload, status, error, created_at FROM downlinks WHERE device_id = ? ORDER BY id DESC LIMIT 100",
+		deviceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query downlinks: %w", err)
+	}
+	defer rows.Close()
+
+	downlinks := []Downlink{}
+	for rows.Next() {
+		var d Downlink
+		if err := rows.Scan(&d.ID, &d.Port, &d.Payload, &d.Status, &d.Error, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan downlink: %w", err)
+		}
+		downlinks = append(downlinks, d)
+	}
+
+	return downlinks, rows.Err()
+}
+
+// GetDeviceLastSeq retrieves the highest backlog sequence number accepted from a device
+func GetDeviceLastSeq(ctx context.Context, id string) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var seq int64
+	err := db.QueryRowContext(ctx, "SELECT last_seq FROM devices WHERE id = ?", id).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query device sequence: %w", err)
+	}
+
+	return seq, nil
+}
+
+// SaveBacklog saves backlog readings and advances the device sequence number to
+// lastSeq in one transaction, so a chunk is never half-accepted
+func SaveBacklog(ctx context.Context, deviceID string, lastSeq int64, readings []Reading) error {
+	return store.SaveBacklog(ctx, deviceID, lastSeq, readings)
+}
+
+// ResetDeviceSeq restarts backlog sequence numbering for a device, e.g. after a reflash
+func ResetDeviceSeq(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	return setDeviceSeq(ctx, db, id, 0)
+}
+
+// SetDeviceClockSkew records the clock skew last detected on a device, zero when in sync
+func SetDeviceClockSkew(ctx context.Context, id string, skew time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO devices (id, clock_skew, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET clock_skew = excluded.clock_skew`,
+		id, int64(skew.Seconds()), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device clock skew: %w", err)
+	}
+
+	return nil
+}
+
+// execer is implemented by both *conn and *txn
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func setDeviceSeq(ctx context.Context, e execer, id string, seq int64) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO devices (id, last_seq, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_seq = excluded.last_seq`,
+		id, seq, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device sequence: %w", err)
+	}
+
+	return nil
+}
+
+// TelemetryPoint is a single device telemetry sample
+type TelemetryPoint struct {
+	Value     float64   `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveTelemetry saves a device telemetry sample such as battery voltage or RSSI
+func SaveTelemetry(ctx context.Context, deviceID, metric string, value float64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO telemetry (device_id, metric, value, created_at) VALUES (?, ?, ?, ?)",
+		deviceID, metric, value, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert telemetry: %w", err)
+	}
+
+	return nil
+}
+
+// GetTelemetry retrieves the telemetry series of a device metric, oldest first.
+// A zero from or to leaves that end of the range open.
+func GetTelemetry(ctx context.Context, deviceID, metric string, from, to time.Time) ([]TelemetryPoint, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT value, created_at FROM telemetry WHERE device_id = ? AND metric = ?"
+	args := []any{deviceID, metric}
+
+	if !from.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += " AND created_at <= ?"
+		args = append(args, to.UTC())
+	}
+	query += " ORDER BY created_at ASC"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry: %w", err)
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		var level float64
-		if err := rows.Scan(&level); err != nil {
-			return 0, fmt.Errorf("failed to scan level: %w", err)
+	points := []TelemetryPoint{}
+	for rows.Next() {
+		var p TelemetryPoint
+		if err := rows.Scan(&p.Value, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry: %w", err)
 		}
-		return level, nil
+		points = append(points, p)
 	}
 
-	return 0, fmt.Errorf("no level data found")
+	return points, rows.Err()
 }
 
-// Close closes the database connection
+// GetLatestTelemetry retrieves the latest value of a device metric recorded since the
+// given time, or nil if there is none
+func GetLatestTelemetry(ctx context.Context, deviceID, metric string, since time.Time) (*TelemetryPoint, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var p TelemetryPoint
+	err := db.QueryRowContext(ctx,
+		"SELECT value, created_at FROM telemetry WHERE device_id = ? AND metric = ? AND created_at >= ? ORDER BY created_at DESC LIMIT 1",
+		deviceID, metric, since.UTC(),
+	).Scan(&p.Value, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry: %w", err)
+	}
+
+	return &p, nil
+}
+
+// Notification is a message sent through a notification channel
+type Notification struct {
+	ID         int64     `json:"id"`
+	AlertID    string    `json:"alert_id,omitempty"`
+	Channel    string    `json:"channel"`
+	Recipient  string    `json:"recipient"`
+	Message    string    `json:"message"`
+	ProviderID string    `json:"provider_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SaveNotification records a sent notification and returns its ID
+func SaveNotification(ctx context.Context, alertID, channel, recipient, message, providerID, status string) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.QueryRowContext(ctx,
+		"INSERT INTO notifications (alert_id, channel, recipient, message, provider_id, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		alertID, channel, recipient, message, providerID, status, time.Now().UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert notification: %w", err)
+	}
+
+	return id, nil
+}
+
+// CountNotifications counts the notifications sent on the channel since the given time
+func CountNotifications(ctx context.Context, channel string, since time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE channel = ? AND created_at >= ?",
+		channel, since.UTC(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	return count, nil
+}
+
+// UpdateNotificationStatus sets the status of the notification with the given provider ID
+// and returns the notification, and whether the status changed. A repeated report of the
+// status the notification already has leaves it as it is.
+func UpdateNotificationStatus(ctx context.Context, channel, providerID, status string) (*Notification, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx,
+		"UPDATE notifications SET status = ?, updated_at = ? WHERE channel = ? AND provider_id = ? AND status <> ?",
+		status, time.Now(), channel, providerID, status,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update notification: %w", err)
+	}
+	changed, _ := result.RowsAffected()
+
+	var n Notification
+	err = db.QueryRowContext(ctx,
+		"SELECT id, alert_id, channel, recipient, message, provider_id, status, created_at FROM notifications WHERE channel = ? AND provider_id = ?",
+		channel, providerID,
+	).Scan(&n.ID, &n.AlertID, &n.Channel, &n.Recipient, &n.Message, &n.ProviderID, &n.Status, &n.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, false, fmt.Errorf("no notification found for provider ID %s", providerID)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query notification: %w", err)
+	}
+
+	return &n, changed > 0, nil
+}
+
+// GetLatestReadingTime retrieves the time of the latest reading, or nil if there are none
+func GetLatestReadingTime(ctx context.Context) (*time.Time, error) {
+	return store.LatestReadingTime(ctx)
+}
+
+// Close closes the store and the database connection
 func Close() error {
+	if _, ok := store.(*sqlStore); store != nil && !ok {
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing store: %v", err)
+		}
+	}
 	if db != nil {
 		return db.Close()
 	}
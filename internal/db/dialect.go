@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlDialect holds the SQL that differs between the supported databases
+type sqlDialect struct {
+	name          string
+	numbered      bool              // Placeholders are $1, $2... instead of ?
+	bucketStarts  map[string]string // Expressions truncating created_at to the start of its bucket in UTC, as text
+	seasonPeriods map[string]string // Expressions extracting the period of created_at in UTC, as integer
+	day           string            // Expression truncating created_at to its date in UTC
+}
+
+var sqliteDialect = sqlDialect{
+	name:          "sqlite",
+	bucketStarts:  bucketStarts,
+	seasonPeriods: seasonPeriods,
+	day:           "date(created_at)",
+}
+
+// dialect is the dialect of the database opened by Init
+var dialect = sqliteDialect
+
+// conn is the database connection, rewriting the placeholders of queries to the dialect
+type conn struct {
+	*sql.DB
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, rebind(query), args...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, rebind(query), args...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, rebind(query), args...)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.DB.PrepareContext(ctx, rebind(query))
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*txn, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{tx}, nil
+}
+
+// txn is a transaction, rewriting the placeholders of queries to the dialect
+type txn struct {
+	*sql.Tx
+}
+
+func (t *txn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, rebind(query), args...)
+}
+
+func (t *txn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, rebind(query), args...)
+}
+
+func (t *txn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, rebind(query), args...)
+}
+
+func (t *txn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.Tx.PrepareContext(ctx, rebind(query))
+}
+
+// aggregateTime scans a time selected through an aggregate such as MAX, which SQLite
+// returns as text rather than as a time
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value any) error {
+	var s string
+	switch v := value.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unsupported time value %T", value)
+	}
+
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			t.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported time format %q", s)
+}
+
+// rebind rewrites the ? placeholders of a query to $1, $2... if the dialect numbers
+// them, leaving string literals alone
+func rebind(query string) string {
+	if !dialect.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// downsampleWindow is the span of readings rolled up per transaction, keeping the
+// database responsive while a long history is downsampled
+const downsampleWindow = 7 * 24 * time.Hour
+
+// Downsample rolls the readings stored before the cutoff up into aggregates per hour or
+// day and deletes them, oldest first. Only trusted readings count towards the
+// aggregates, like in the level stats. The latest reading of every device is kept, so
+// its level stays known. Readings arriving late for a bucket already rolled up are
+// merged into its aggregate. It returns the number of readings deleted.
+func Downsample(ctx context.Context, bucket string, before time.Time) (int64, error) {
+	if bucket != BucketHour && bucket != BucketDay {
+		return 0, fmt.Errorf("unknown bucket %q", bucket)
+	}
+
+	// Cut at a day boundary, so no bucket is split between aggregate and readings
+	before = before.UTC().Truncate(24 * time.Hour)
+
+	var deleted int64
+	for {
+		from, err := oldestDownsampleReading(ctx, before)
+		if err != nil {
+			return deleted, err
+		}
+		if from == nil {
+			return deleted, nil
+		}
+
+		to := from.UTC().Truncate(24 * time.Hour).Add(downsampleWindow)
+		if to.After(before) {
+			to = before
+		}
+
+		n, err := downsampleWindowReadings(ctx, bucket, to)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+}
+
+// oldestDownsampleReading retrieves the time of the oldest reading due for downsampling
+// before the cutoff, or nil if there is none
+func oldestDownsampleReading(ctx context.Context, before time.Time) (*time.Time, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT created_at FROM level_data
+		WHERE created_at < ? AND id NOT IN (SELECT MAX(id) FROM level_data GROUP BY device_id)
+		ORDER BY created_at ASC LIMIT 1`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query oldest reading: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var t time.Time
+	if err := rows.Scan(&t); err != nil {
+		return nil, fmt.Errorf("failed to scan oldest reading: %w", err)
+	}
+	return &t, nil
+}
+
+// downsampleWindowReadings rolls up and deletes the readings stored before to in one
+// transaction and returns the number deleted
+func downsampleWindowReadings(ctx context.Context, bucket string, to time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	const expired = "created_at < ? AND id NOT IN (SELECT MAX(id) FROM level_data GROUP BY device_id)"
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT device_id, `+dialect.bucketStarts[bucket]+` AS bucket, MIN(level), MAX(level), SUM(level), COUNT(*)
+		FROM level_data WHERE quality IN `+trustedQualities+` AND `+expired+`
+		GROUP BY device_id, bucket`, to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to aggregate readings: %w", err)
+	}
+
+	type aggregate struct {
+		deviceID      string
+		start         time.Time
+		min, max, sum float64
+		readings      int64
+	}
+	var aggregates []aggregate
+	for rows.Next() {
+		var a aggregate
+		var start string
+		if err := rows.Scan(&a.deviceID, &start, &a.min, &a.max, &a.sum, &a.readings); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan aggregate: %w", err)
+		}
+		if a.start, err = time.Parse(time.DateTime, start); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to parse bucket start: %w", err)
+		}
+		aggregates = append(aggregates, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, a := range aggregates {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO level_aggregates (device_id, bucket, created_at, min_level, max_level, sum_level, readings)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (device_id, bucket, created_at) DO UPDATE SET
+				min_level = CASE WHEN excluded.min_level < level_aggregates.min_level THEN excluded.min_level ELSE level_aggregates.min_level END,
+				max_level = CASE WHEN excluded.max_level > level_aggregates.max_level THEN excluded.max_level ELSE level_aggregates.max_level END,
+				sum_level = level_aggregates.sum_level + excluded.sum_level,
+				readings = level_aggregates.readings + excluded.readings`,
+			a.deviceID, bucket, a.start, a.min, a.max, a.sum, a.readings); err != nil {
+			return 0, fmt.Errorf("failed to save aggregate: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM level_data WHERE "+expired, to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete downsampled readings: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count downsampled readings: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit downsampling: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// path is the SQLite database file, from DATABASE_PATH (default: ./data.db)
+var path = "./data.db"
+
+// watermarkPath is the file recording the latest committed reading outside the
+// database, so a rollback of the database after a power cut can be told on the next startup
+func watermarkPath() string {
+	return path + "-watermark"
+}
+
+// Watermark is the latest reading known to have been committed
+type Watermark struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// watermark is the last watermark written, readings only move it forward
+var (
+	watermarkMux sync.Mutex
+	watermark    Watermark
+)
+
+// synchronousModes are the accepted DB_SYNCHRONOUS values
+var synchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+// dsn returns the data source name of the database: WAL journaling with the
+// synchronous mode from DB_SYNCHRONOUS (default: FULL, every commit is synced to
+// disk before a reading is acknowledged)
+func dsn() string {
+	mode := strings.ToUpper(os.Getenv("DB_SYNCHRONOUS"))
+	valid := false
+	for _, m := range synchronousModes {
+		valid = valid || mode == m
+	}
+	if !valid {
+		if mode != "" {
+			log.Printf("Invalid DB_SYNCHRONOUS value: %s, using default: FULL", mode)
+		}
+		mode = "FULL"
+	}
+
+	return "file:" + path + "?_journal_mode=WAL&_synchronous=" + mode
+}
+
+// markWritten advances the watermark to the committed reading and syncs it to disk.
+// PostgreSQL runs on a server of its own and needs no watermark.
+func markWritten(id int64, createdAt time.Time) {
+	if isPostgres() {
+		return
+	}
+
+	watermarkMux.Lock()
+	defer watermarkMux.Unlock()
+
+	if id <= watermark.ID {
+		return
+	}
+	watermark = Watermark{ID: id, CreatedAt: createdAt.UTC()}
+
+	if err := writeWatermark(watermark); err != nil {
+		log.Printf("Error writing watermark: %v", err)
+	}
+}
+
+// writeWatermark replaces the watermark file atomically, a power cut leaves either
+// the old or the new watermark
+func writeWatermark(w Watermark) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+
+	tmp := watermarkPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, watermarkPath()); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a power cut
+	dir, err := os.Open(filepath.Dir(watermarkPath()))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// readWatermark reads the watermark file, nil if there is none yet
+func readWatermark() (*Watermark, error) {
+	data, err := os.ReadFile(watermarkPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var w Watermark
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Consistency is the outcome of the startup consistency check
+type Consistency struct {
+	Integrity  string     // "ok", or the problems found by the integrity check
+	Watermark  *Watermark // Latest reading committed before the restart, nil if unknown
+	LatestID   int64      // Latest reading in the database
+	RolledBack bool       // Readings committed before the restart are missing
+}
+
+// OK reports whether the database passed the check
+func (c *Consistency) OK() bool {
+	return c.Integrity == "ok" && !c.RolledBack
+}
+
+// CheckConsistency checks the integrity of the database and compares its latest
+// reading to the watermark, which is then moved to the latest reading so a rollback
+// is reported once. A PostgreSQL database is checked by its server and always passes.
+func CheckConsistency(ctx context.Context) (*Consistency, error) {
+	if isPostgres() {
+		return &Consistency{Integrity: "ok"}, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var c Consistency
+	rows, err := db.QueryContext(ctx, "PRAGMA quick_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		problems = append(problems, problem)
+	}
+	rows.Close()
+	c.Integrity = strings.Join(problems, "; ")
+
+	var latestAt *time.Time
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM level_data").Scan(&c.LatestID); err != nil {
+		return nil, fmt.Errorf("failed to query latest reading: %w", err)
+	}
+	if c.LatestID > 0 {
+		var t time.Time
+		if err := db.QueryRowContext(ctx, "SELECT created_at FROM level_data WHERE id = ?", c.LatestID).Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to query latest reading: %w", err)
+		}
+		latestAt = &t
+	}
+
+	if c.Watermark, err = readWatermark(); err != nil {
+		return nil, fmt.Errorf("failed to read watermark: %w", err)
+	}
+	c.RolledBack = c.Watermark != nil && c.Watermark.ID > c.LatestID
+
+	// Start over from the database as it is now
+	watermarkMux.Lock()
+	watermark = Watermark{ID: c.LatestID}
+	if latestAt != nil {
+		watermark.CreatedAt = latestAt.UTC()
+	}
+	err = writeWatermark(watermark)
+	watermarkMux.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write watermark: %w", err)
+	}
+
+	return &c, nil
+}
+
+// Checkpoint copies the WAL into the database file and truncates it, bounding both
+// the WAL size and the recovery work after a power cut
+func Checkpoint(ctx context.Context) error {
+	if isPostgres() {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var busy, walPages, checkpointed int
+	err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &walPages, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("checkpoint blocked by a reader, %d of %d pages copied", checkpointed, walPages)
+	}
+	return nil
+}
+
+// StartCheckpoints checkpoints the WAL every DB_CHECKPOINT_INTERVAL seconds
+// (default: 300 seconds, 0 leaves it to SQLite's automatic checkpoints)
+func StartCheckpoints() {
+	interval := envInt("DB_CHECKPOINT_INTERVAL", 300, 0)
+	if interval == 0 || isPostgres() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := Checkpoint(context.Background()); err != nil {
+				log.Printf("Error checkpointing database: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ColumnType is the type of an exported column
+type ColumnType int
+
+const (
+	ColumnInt ColumnType = iota
+	ColumnFloat
+	ColumnText
+	ColumnTime
+)
+
+// ExportColumn is a column of an exported table
+type ExportColumn struct {
+	Name     string
+	Type     ColumnType
+	Nullable bool
+}
+
+// ExportTable is a table of the dataset exported for offline analysis
+type ExportTable struct {
+	Name    string // Name in the export
+	Columns []ExportColumn
+	from    string // Table queried
+	key     string // Unique column the rows are read in order of, by keyset, or empty to page by offset
+	order   string // Order of the rows when paged by offset
+}
+
+// ExportTables are the tables of the dataset: the readings, their downsampled
+// aggregates, device telemetry, pump-outs and alerts
+var ExportTables = []ExportTable{
+	{
+		Name: "readings",
+		Columns: []ExportColumn{
+			{Name: "id", Type: ColumnInt},
+			{Name: "device_id", Type: ColumnText},
+			{Name: "level", Type: ColumnFloat},
+			{Name: "created_at", Type: ColumnTime, Nullable: true},
+			{Name: "raw_timestamp", Type: ColumnTime, Nullable: true},
+			{Name: "raw_level", Type: ColumnFloat, Nullable: true},
+			{Name: "quality", Type: ColumnText},
+			{Name: "transport", Type: ColumnText},
+			{Name: "source", Type: ColumnText},
+		},
+		from: "level_data",
+		key:  "id",
+	},
+	{
+		Name: "aggregates",
+		Columns: []ExportColumn{
+			{Name: "device_id", Type: ColumnText},
+			{Name: "bucket", Type: ColumnText},
+			{Name: "created_at", Type: ColumnTime},
+			{Name: "min_level", Type: ColumnFloat},
+			{Name: "max_level", Type: ColumnFloat},
+			{Name: "sum_level", Type: ColumnFloat},
+			{Name: "readings", Type: ColumnInt},
+		},
+		from:  "level_aggregates",
+		order: "device_id, bucket, created_at",
+	},
+	{
+		Name: "telemetry",
+		Columns: []ExportColumn{
+			{Name: "id", Type: ColumnInt},
+			{Name: "device_id", Type: ColumnText},
+			{Name: "metric", Type: ColumnText},
+			{Name: "value", Type: ColumnFloat},
+			{Name: "created_at", Type: ColumnTime, Nullable: true},
+		},
+		from: "telemetry",
+		key:  "id",
+	},
+	{
+		Name: "pump_outs",
+		Columns: []ExportColumn{
+			{Name: "id", Type: ColumnInt},
+			{Name: "device_id", Type: ColumnText},
+			{Name: "pumped_at", Type: ColumnTime},
+			{Name: "source", Type: ColumnText},
+			{Name: "level_before", Type: ColumnFloat, Nullable: true},
+			{Name: "level_after", Type: ColumnFloat, Nullable: true},
+			{Name: "notes", Type: ColumnText},
+		},
+		from: "pump_outs",
+		key:  "id",
+	},
+	{
+		Name: "alerts",
+		Columns: []ExportColumn{
+			{Name: "id", Type: ColumnText},
+			{Name: "device_id", Type: ColumnText},
+			{Name: "kind", Type: ColumnText},
+			{Name: "severity", Type: ColumnText},
+			{Name: "level", Type: ColumnFloat},
+			{Name: "threshold", Type: ColumnFloat},
+			{Name: "state", Type: ColumnText},
+			{Name: "raised_at", Type: ColumnTime},
+			{Name: "notified_at", Type: ColumnTime, Nullable: true},
+			{Name: "acknowledged_at", Type: ColumnTime, Nullable: true},
+			{Name: "resolved_at", Type: ColumnTime, Nullable: true},
+			{Name: "latency_ms", Type: ColumnInt, Nullable: true},
+		},
+		from: "alerts",
+		key:  "id",
+	},
+}
+
+// Export reads the rows of the table in chunks of the size, calling fn with each. Values
+// are int64, float64, string, time.Time in UTC or nil, according to the columns.
+// Every chunk is read with a timeout of its own, so large histories don't time out.
+func (t ExportTable) Export(ctx context.Context, size int, fn func(rows [][]any) error) error {
+	names := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		names[i] = c.Name
+	}
+	query := "SELECT " + strings.Join(names, ", ") + " FROM " + t.from
+
+	var after any
+	for offset := 0; ; offset += size {
+		var rows [][]any
+		var err error
+		switch {
+		case t.key == "":
+			rows, err = t.exportChunk(ctx, query+" ORDER BY "+t.order+" LIMIT ? OFFSET ?", size, offset)
+		case after == nil:
+			rows, err = t.exportChunk(ctx, query+" ORDER BY "+t.key+" LIMIT ?", size)
+		default:
+			rows, err = t.exportChunk(ctx, query+" WHERE "+t.key+" > ? ORDER BY "+t.key+" LIMIT ?", after, size)
+		}
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		if len(rows) < size {
+			return nil
+		}
+
+		// The key is the first column of the tables read by keyset
+		after = rows[len(rows)-1][0]
+	}
+}
+
+func (t ExportTable) exportChunk(ctx context.Context, query string, args ...any) ([][]any, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", t.from, err)
+	}
+	defer rows.Close()
+
+	var chunk [][]any
+	for rows.Next() {
+		dest := make([]any, len(t.Columns))
+		for i, c := range t.Columns {
+			switch c.Type {
+			case ColumnInt:
+				dest[i] = &sql.NullInt64{}
+			case ColumnFloat:
+				dest[i] = &sql.NullFloat64{}
+			case ColumnText:
+				dest[i] = &sql.NullString{}
+			case ColumnTime:
+				dest[i] = &sql.NullTime{}
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", t.from, err)
+		}
+
+		row := make([]any, len(dest))
+		for i, d := range dest {
+			switch v := d.(type) {
+			case *sql.NullInt64:
+				if v.Valid {
+					row[i] = v.Int64
+				}
+			case *sql.NullFloat64:
+				if v.Valid {
+					row[i] = v.Float64
+				}
+			case *sql.NullString:
+				if v.Valid {
+					row[i] = v.String
+				}
+			case *sql.NullTime:
+				if v.Valid {
+					row[i] = v.Time.UTC()
+				}
+			}
+		}
+		chunk = append(chunk, row)
+	}
+
+	return chunk, rows.Err()
+}
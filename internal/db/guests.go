@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GuestLink lets a guest opt in to the alerts until the end of their stay. The link
+// itself carries a random token, only its hash is stored as the ID.
+type GuestLink struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Severities []string  `json:"severities"` // Alert severities the guest is notified of, empty for all
+	Until      time.Time `json:"until"`      // End of the stay, when the link and the guest expire
+}
+
+// CreateGuestLink stores a guest link
+func CreateGuestLink(ctx context.Context, l GuestLink) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO guest_links (id, name, severities, until, created_at) VALUES (?, ?, ?, ?, ?)",
+		l.ID, l.Name, strings.Join(l.Severities, ","), l.Until.UTC(), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create guest link: %w", err)
+	}
+
+	return nil
+}
+
+// GetGuestLink retrieves a guest link, or nil if it doesn't exist
+func GetGuestLink(ctx context.Context, id string) (*GuestLink, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	l, err := scanGuestLink(db.QueryRowContext(ctx, "SELECT id, name, severities, until FROM guest_links WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query guest link: %w", err)
+	}
+
+	return l, nil
+}
+
+// GetGuestLinks retrieves all guest links, the stays ending first
+func GetGuestLinks(ctx context.Context) ([]GuestLink, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name, severities, until FROM guest_links ORDER BY until, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query guest links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []GuestLink{}
+	for rows.Next() {
+		l, err := scanGuestLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan guest link: %w", err)
+		}
+		links = append(links, *l)
+	}
+
+	return links, rows.Err()
+}
+
+// DeleteGuestLink deletes a guest link, reporting whether it existed
+func DeleteGuestLink(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM guest_links WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete guest link: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted guest links: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// DeleteExpiredGuests deletes the recipients and guest links expired by the time,
+// returning the number of recipients deleted
+func DeleteExpiredGuests(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM recipients WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired recipients: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted recipients: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM guest_links WHERE until <= ?", now.UTC()); err != nil {
+		return 0, fmt.Errorf("failed to delete expired guest links: %w", err)
+	}
+
+	return n, nil
+}
+
+func scanGuestLink(row interface{ Scan(...any) error }) (*GuestLink, error) {
+	var l GuestLink
+	var severities string
+	if err := row.Scan(&l.ID, &l.Name, &severities, &l.Until); err != nil {
+		return nil, err
+	}
+
+	l.Severities = splitList(severities)
+	return &l, nil
+}
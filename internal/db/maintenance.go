@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TableStats describes the contents of one table
+type TableStats struct {
+	Name   string     `json:"name"`
+	Rows   int64      `json:"rows"`
+	Oldest *time.Time `json:"oldest"`
+	Newest *time.Time `json:"newest"`
+}
+
+// Stats describes the size and contents of the database
+type Stats struct {
+	SizeBytes int64        `json:"size_bytes"`
+	Tables    []TableStats `json:"tables"`
+}
+
+// statsTables lists the tables reported in Stats with the column dating their rows
+var statsTables = []struct{ name, timeColumn string }{
+	{"level_data", "created_at"},
+	{"level_aggregates", "created_at"},
+	{"telemetry", "created_at"},
+	{"notifications", "created_at"},
+	{"alerts", "raised_at"},
+	{"downlinks", "created_at"},
+	{"devices", "created_at"},
+	{"device_config", "updated_at"},
+	{"sites", "created_at"},
+	{"webhook_deliveries", "created_at"},
+}
+
+// GetStats retrieves the database size and per-table row counts and time ranges
+func GetStats(ctx context.Context) (*Stats, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	size, err := size(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{SizeBytes: size, Tables: []TableStats{}}
+	for _, t := range statsTables {
+		ts := TableStats{Name: t.name}
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+t.name).Scan(&ts.Rows); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", t.name, err)
+		}
+
+		// Select the column itself rather than MIN/MAX so the driver parses it as a time
+		if ts.Oldest, err = boundaryTime(ctx, t.name, t.timeColumn, "ASC"); err != nil {
+			return nil, err
+		}
+		if ts.Newest, err = boundaryTime(ctx, t.name, t.timeColumn, "DESC"); err != nil {
+			return nil, err
+		}
+
+		stats.Tables = append(stats.Tables, ts)
+	}
+
+	return stats, nil
+}
+
+// boundaryTime retrieves the first time in a table in the given order, or nil if there is none
+func boundaryTime(ctx context.Context, table, column, order string) (*time.Time, error) {
+	var t sql.NullTime
+	err := db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s IS NOT NULL ORDER BY %s %s LIMIT 1",
+		column, table, column, column, order,
+	)).Scan(&t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s time range: %w", table, err)
+	}
+
+	return timeOrNil(t), nil
+}
+
+// size returns the size of the database in bytes
+func size(ctx context.Context) (int64, error) {
+	if isPostgres() {
+		var n int64
+		if err := db.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&n); err != nil {
+			return 0, fmt.Errorf("failed to query database size: %w", err)
+		}
+		return n, nil
+	}
+
+	var pages, pageSize int64
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("failed to query page count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to query page size: %w", err)
+	}
+
+	return pages * pageSize, nil
+}
+
+// Size returns the size of the database in bytes
+func Size(ctx context.Context) (int64, error) {
+	return size(ctx)
+}
+
+// Compact rebuilds the database file to reclaim free pages, returning its size after.
+// Expired readings are removed first by the caller, see PurgeReadings and Downsample.
+func Compact(ctx context.Context) (int64, error) {
+	vacuum := "VACUUM"
+	if isPostgres() {
+		vacuum = "VACUUM FULL"
+	}
+	if _, err := db.ExecContext(ctx, vacuum); err != nil {
+		return 0, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+
+	return size(ctx)
+}
+
+// Ping verifies the database answers a query, e.g. for a readiness probe
+func Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	return nil
+}
+
+// CheckWritable verifies the database accepts writes by committing a write that leaves
+// no trace, e.g. to tell a read-only or full disk after a power cut
+func CheckWritable(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO settings (key, value) VALUES ('_self_test', '')"); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM settings WHERE key = '_self_test'"); err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// Recalibration is a linear correction of the stored levels of a device, e.g. after the
+// sensor height was found to be off: level' = level * Scale + Offset
+type Recalibration struct {
+	DeviceID string
+	From     time.Time // Zero for no lower bound
+	To       time.Time // Zero for no upper bound
+	Scale    float64
+	Offset   float64
+}
+
+// RecalibratedReading is a reading changed by a recalibration
+type RecalibratedReading struct {
+	ID          int64     `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	LevelBefore float64   `json:"level_before"`
+	LevelAfter  float64   `json:"level_after"`
+}
+
+// RecalibrateReadings applies the recalibration to the matching readings and returns
+// their number with the first few as a preview. A dry run rolls the change back.
+func RecalibrateReadings(ctx context.Context, r Recalibration, previewSize int, dryRun bool) (int64, []RecalibratedReading, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	where := " WHERE device_id = ?"
+	args := []any{r.DeviceID}
+	if !r.From.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, r.From.UTC())
+	}
+	if !r.To.IsZero() {
+		where += " AND created_at <= ?"
+		args = append(args, r.To.UTC())
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, created_at, level, level * ? + ? FROM level_data"+where+" ORDER BY created_at ASC, id ASC LIMIT ?",
+		append(append([]any{r.Scale, r.Offset}, args...), previewSize)...,
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+
+	preview := []RecalibratedReading{}
+	for rows.Next() {
+		var p RecalibratedReading
+		if err := rows.Scan(&p.ID, &p.Timestamp, &p.LevelBefore, &p.LevelAfter); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		preview = append(preview, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE level_data SET level = level * ? + ?"+where, append([]any{r.Scale, r.Offset}, args...)...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to recalibrate readings: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to count recalibrated readings: %w", err)
+	}
+
+	if dryRun {
+		return n, preview, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit recalibration: %w", err)
+	}
+
+	return n, preview, nil
+}
+
+// PurgeReadings deletes up to limit of the oldest readings stored before the cutoff,
+// passing them to archive first, if set, and returns their number. The latest reading
+// of every device is kept, so its level stays known however long it has been offline.
+// Call it until it returns 0 to purge every expired reading.
+func PurgeReadings(ctx context.Context, before time.Time, limit int, archive func([]HistoryReading) error) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	const where = " WHERE created_at < ? AND id <= ? AND id NOT IN (SELECT MAX(id) FROM level_data GROUP BY device_id)"
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Select by ID, so the readings up to the last one selected are exactly the ones deleted
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, device_id, level, created_at, quality, transport, source FROM level_data
+		WHERE created_at < ? AND id NOT IN (SELECT MAX(id) FROM level_data GROUP BY device_id)
+		ORDER BY id ASC LIMIT ?`, before.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query expired readings: %w", err)
+	}
+
+	var readings []HistoryReading
+	for rows.Next() {
+		var r HistoryReading
+		if err := rows.Scan(&r.ID, &r.DeviceID, &r.Level, &r.Timestamp, &r.Quality, &r.Transport, &r.Source); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(readings) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(readings); err != nil {
+			return 0, fmt.Errorf("failed to archive readings: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM level_data"+where, before.UTC(), readings[len(readings)-1].ID); err != nil {
+		return 0, fmt.Errorf("failed to delete expired readings: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return len(readings), nil
+}
+
+// PurgeTelemetry deletes the telemetry samples stored before the cutoff and returns their number
+func PurgeTelemetry(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM telemetry WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired telemetry: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Vacuum reclaims the space of deleted rows. SQLite rebuilds the file, shrinking it
+// once the rebuilt pages are checkpointed from the WAL; PostgreSQL only makes the space
+// reusable, without locking the tables like Compact.
+func Vacuum(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return Checkpoint(ctx)
+}
@@ -0,0 +1,365 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var postgresDialect = sqlDialect{
+	name:     "postgres",
+	numbered: true,
+	bucketStarts: map[string]string{
+		BucketHour: "to_char(date_trunc('hour', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+		BucketDay:  "to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+		BucketWeek: "to_char(date_trunc('week', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+	},
+	seasonPeriods: map[string]string{
+		SeasonMonth:   "CAST(EXTRACT(MONTH FROM created_at AT TIME ZONE 'UTC') AS INTEGER)",
+		SeasonWeekday: "CAST(EXTRACT(DOW FROM created_at AT TIME ZONE 'UTC') AS INTEGER)",
+	},
+	day: "CAST(created_at AT TIME ZONE 'UTC' AS DATE)",
+}
+
+// isPostgres reports whether Init opened a PostgreSQL database
+func isPostgres() bool {
+	return dialect.name == postgresDialect.name
+}
+
+// postgresMigrations lists the migrations of the PostgreSQL schema, applied in order
+// on startup and recorded in schema_migrations by their position. Migrations are
+// never edited once released, schema changes are appended.
+var postgresMigrations = []string{
+	`CREATE TABLE level_data (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL DEFAULT 'default',
+		level DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		raw_timestamp TIMESTAMPTZ,
+		raw_level DOUBLE PRECISION,
+		quality TEXT NOT NULL DEFAULT 'ok'
+	);
+	CREATE INDEX idx_level_data_device ON level_data (device_id, created_at);
+	CREATE INDEX idx_level_data_created_at ON level_data (created_at);
+
+	CREATE TABLE notifications (
+		id BIGSERIAL PRIMARY KEY,
+		alert_id TEXT NOT NULL DEFAULT '',
+		channel TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		message TEXT NOT NULL,
+		provider_id TEXT,
+		status TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ
+	);
+	CREATE INDEX idx_notifications_provider_id ON notifications (provider_id);
+
+	CREATE TABLE telemetry (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		value DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_telemetry_device_metric ON telemetry (device_id, metric, created_at);
+
+	CREATE TABLE devices (
+		id TEXT PRIMARY KEY,
+		firmware TEXT NOT NULL DEFAULT '',
+		last_seen TIMESTAMPTZ,
+		error_count INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		last_seq BIGINT NOT NULL DEFAULT 0,
+		clock_skew BIGINT NOT NULL DEFAULT 0,
+		site_id TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		location TEXT NOT NULL DEFAULT '',
+		notes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE device_config (
+		device_id TEXT PRIMARY KEY,
+		report_interval INTEGER NOT NULL,
+		buzzer_threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+		sleep_start TEXT NOT NULL DEFAULT '',
+		sleep_end TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE downlinks (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE alerts (
+		id TEXT PRIMARY KEY,
+		device_id TEXT NOT NULL DEFAULT 'default',
+		kind TEXT NOT NULL DEFAULT 'level',
+		severity TEXT NOT NULL,
+		level DOUBLE PRECISION NOT NULL,
+		threshold DOUBLE PRECISION NOT NULL,
+		state TEXT NOT NULL,
+		raised_at TIMESTAMPTZ NOT NULL,
+		notified_at TIMESTAMPTZ,
+		acknowledged_at TIMESTAMPTZ,
+		resolved_at TIMESTAMPTZ,
+		last_notified_at TIMESTAMPTZ,
+		latency_ms BIGINT
+	);
+	CREATE INDEX idx_alerts_raised_at ON alerts (raised_at);
+
+	CREATE TABLE sites (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		recipients TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE pump_outs (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		pumped_at TIMESTAMPTZ NOT NULL,
+		source TEXT NOT NULL,
+		level_before DOUBLE PRECISION,
+		level_after DOUBLE PRECISION,
+		notes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_pump_outs_device ON pump_outs (device_id, pumped_at);
+
+	CREATE TABLE webhooks (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE calibrations (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		scale DOUBLE PRECISION NOT NULL,
+		level_offset DOUBLE PRECISION NOT NULL,
+		session_id BIGINT,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_calibrations_device ON calibrations (device_id, id);
+
+	CREATE TABLE calibration_sessions (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		started_at TIMESTAMPTZ NOT NULL,
+		ended_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE calibration_points (
+		id BIGSERIAL PRIMARY KEY,
+		session_id BIGINT NOT NULL,
+		level DOUBLE PRECISION NOT NULL,
+		raw_level DOUBLE PRECISION NOT NULL,
+		recorded_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE temperature_compensation (
+		device_id TEXT PRIMARY KEY,
+		sensor_height DOUBLE PRECISION,
+		reference_temperature DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE compliance_log (
+		seq BIGINT PRIMARY KEY,
+		recorded_at TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	);
+	CREATE FUNCTION compliance_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'compliance log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	CREATE TRIGGER compliance_log_no_update BEFORE UPDATE OR DELETE ON compliance_log
+	FOR EACH ROW EXECUTE FUNCTION compliance_log_append_only();`,
+
+	`ALTER TABLE level_data
+		ADD COLUMN transport TEXT NOT NULL DEFAULT '',
+		ADD COLUMN source TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE TABLE level_aggregates (
+		device_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		min_level DOUBLE PRECISION NOT NULL,
+		max_level DOUBLE PRECISION NOT NULL,
+		sum_level DOUBLE PRECISION NOT NULL,
+		readings BIGINT NOT NULL,
+		PRIMARY KEY (device_id, bucket, created_at)
+	);`,
+
+	`CREATE TABLE recipients (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		phone TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		telegram_chat_id TEXT NOT NULL DEFAULT '',
+		channels TEXT NOT NULL DEFAULT '',
+		severities TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);`,
+
+	`ALTER TABLE recipients ADD COLUMN vacation_only BOOLEAN NOT NULL DEFAULT FALSE;`,
+
+	`ALTER TABLE recipients ADD COLUMN expires_at TIMESTAMPTZ;
+	CREATE TABLE guest_links (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		severities TEXT NOT NULL DEFAULT '',
+		until TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);`,
+
+	`CREATE TABLE device_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+	CREATE INDEX idx_device_tokens_device_id ON device_tokens (device_id);`,
+
+	`CREATE TABLE webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		webhook_id BIGINT NOT NULL DEFAULT 0,
+		url TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		response TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		latency_ms BIGINT NOT NULL DEFAULT 0,
+		redelivery_of BIGINT,
+		created_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);`,
+}
+
+// postgresDSN returns the data source name of the DATABASE_URL, with the session time
+// zone set to UTC so times are read back in UTC as from SQLite
+func postgresDSN(databaseURL string) (string, error) {
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		u, err := url.Parse(databaseURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		q := u.Query()
+		if q.Get("timezone") == "" {
+			q.Set("timezone", "UTC")
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	// Key/value connection string, e.g. "host=nas dbname=septic"
+	if !strings.Contains(databaseURL, "timezone=") {
+		databaseURL += " timezone=UTC"
+	}
+	return databaseURL, nil
+}
+
+// initPostgres connects to the PostgreSQL database at the URL and migrates its schema
+func initPostgres(databaseURL string) error {
+	dsn, err := postgresDSN(databaseURL)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	db = &conn{sqlDB}
+	dialect = postgresDialect
+
+	db.SetMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", 10, 0))
+	db.SetMaxIdleConns(envInt("DB_MAX_IDLE_CONNS", 2, 0))
+	db.SetConnMaxLifetime(time.Duration(envInt("DB_CONN_MAX_LIFETIME", 0, 0)) * time.Second)
+
+	ctx, cancel := withTimeout(context.Background())
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return migratePostgres(ctx)
+}
+
+// migratePostgres applies the migrations not yet recorded in schema_migrations, in a
+// single transaction holding an advisory lock so concurrent servers don't race
+func migratePostgres(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+		return fmt.Errorf("failed to lock schema_migrations: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("failed to query schema version: %w", err)
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this server supports (%d)", version, len(postgresMigrations))
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", i+1, time.Now().UTC()); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		log.Printf("Applied database migration %d", i+1)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+	return nil
+}
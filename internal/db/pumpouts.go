@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Pump-out sources
+const (
+	PumpOutDetected = "detected" // Level drop detected in the readings
+	PumpOutManual   = "manual"   // Logged by the user
+)
+
+// PumpOut is a recorded emptying of the tank
+type PumpOut struct {
+	ID          int64     `json:"id"`
+	DeviceID    string    `json:"device_id"`
+	PumpedAt    time.Time `json:"pumped_at"`
+	Source      string    `json:"source"`
+	LevelBefore *float64  `json:"level_before,omitempty"` // Only known for detected pump-outs
+	LevelAfter  *float64  `json:"level_after,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+}
+
+const pumpOutColumns = "id, device_id, pumped_at, source, level_before, level_after, notes"
+
+// scanPumpOut scans a row selected with pumpOutColumns
+func scanPumpOut(row interface{ Scan(...any) error }) (*PumpOut, error) {
+	var p PumpOut
+	var levelBefore, levelAfter sql.NullFloat64
+	if err := row.Scan(&p.ID, &p.DeviceID, &p.PumpedAt, &p.Source, &levelBefore, &levelAfter, &p.Notes); err != nil {
+		return nil, err
+	}
+
+	if levelBefore.Valid {
+		p.LevelBefore = &levelBefore.Float64
+	}
+	if levelAfter.Valid {
+		p.LevelAfter = &levelAfter.Float64
+	}
+	return &p, nil
+}
+
+// SavePumpOut records a pump-out and returns its ID
+func SavePumpOut(ctx context.Context, p PumpOut) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.QueryRowContext(ctx,
+		"INSERT INTO pump_outs (device_id, pumped_at, source, level_before, level_after, notes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		p.DeviceID, p.PumpedAt.UTC(), p.Source, p.LevelBefore, p.LevelAfter, p.Notes, time.Now().UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save pump-out: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetLastPumpOut retrieves the most recent pump-out of a device, or nil if there is none
+func GetLastPumpOut(ctx context.Context, deviceID string) (*PumpOut, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	p, err := scanPumpOut(db.QueryRowContext(ctx,
+		"SELECT "+pumpOutColumns+" FROM pump_outs WHERE device_id = ? ORDER BY pumped_at DESC LIMIT 1",
+		deviceID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pump-out: %w", err)
+	}
+
+	return p, nil
+}
+
+// GetPumpOuts retrieves the most recent pump-outs, newest first, optionally of a single device
+func GetPumpOuts(ctx context.Context, deviceID string, limit int) ([]PumpOut, error) {
+	query := "SELECT " + pumpOutColumns + " FROM pump_outs WHERE 1 = 1"
+	var args []any
+	if deviceID != "" {
+		query += " AND device_id = ?"
+		args = append(args, deviceID)
+	}
+	query += " ORDER BY pumped_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	return queryPumpOuts(ctx, query, args...)
+}
+
+// GetPumpOutsAfter retrieves the pump-outs recorded after the one with the given ID, latest recorded first
+func GetPumpOutsAfter(ctx context.Context, afterID int64, limit int) ([]PumpOut, error) {
+	return queryPumpOuts(ctx,
+		"SELECT "+pumpOutColumns+" FROM pump_outs WHERE id > ? ORDER BY id DESC LIMIT ?",
+		afterID, limit,
+	)
+}
+
+// GetPumpOutsBetween retrieves the pump-outs in the time range, oldest first
+func GetPumpOutsBetween(ctx context.Context, from, to time.Time) ([]PumpOut, error) {
+	return queryPumpOuts(ctx,
+		"SELECT "+pumpOutColumns+" FROM pump_outs WHERE pumped_at >= ? AND pumped_at <= ? ORDER BY pumped_at ASC",
+		from.UTC(), to.UTC(),
+	)
+}
+
+// queryPumpOuts runs a query selecting pumpOutColumns
+func queryPumpOuts(ctx context.Context, query string, args ...any) ([]PumpOut, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pump-outs: %w", err)
+	}
+	defer rows.Close()
+
+	pumpOuts := []PumpOut{}
+	for rows.Next() {
+		p, err := scanPumpOut(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pump-out: %w", err)
+		}
+		pumpOuts = append(pumpOuts, *p)
+	}
+
+	return pumpOuts, rows.Err()
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidQuery is returned for a query that isn't a single read-only statement
+var ErrInvalidQuery = errors.New("only a single SELECT or WITH statement is allowed")
+
+// readOnlyQuery matches the statements allowed by Query
+var readOnlyQuery = regexp.MustCompile(`(?is)^\s*(SELECT|WITH)\b`)
+
+// QueryResult is the result of an ad-hoc query
+type QueryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated"` // More rows matched than the limit
+}
+
+// Query runs an ad-hoc read-only query for analytics with the positional (?) parameters,
+// returning at most limit rows. The statement is checked, then run on a read-only SQLite
+// connection or in a read-only PostgreSQL transaction, so it can't write whatever it
+// contains. The context bounds how long it runs.
+func Query(ctx context.Context, query string, params []any, limit int) (*QueryResult, error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if !readOnlyQuery.MatchString(query) || strings.Contains(query, ";") {
+		return nil, ErrInvalidQuery
+	}
+
+	if isPostgres() {
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		rows, err := tx.QueryContext(ctx, query, params...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run query: %w", err)
+		}
+		return scanQueryResult(rows, limit)
+	}
+
+	// A connection of its own, opened read-only, rather than one of the pool
+	roDB, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_query_only=1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer roDB.Close()
+
+	rows, err := roDB.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	return scanQueryResult(rows, limit)
+}
+
+func scanQueryResult(rows *sql.Rows, limit int) (*QueryResult, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	result := &QueryResult{Columns: columns, Rows: [][]any{}}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		// Text comes back as bytes from some drivers
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recipient is a person notified about alerts, on the channels and for the severities
+// they chose
+type Recipient struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Phone          string     `json:"phone"`
+	Email          string     `json:"email"`
+	TelegramChatID string     `json:"telegram_chat_id"`
+	Channels       []string   `json:"channels"`      // Messaging backends, empty for every one with an address
+	Severities     []string   `json:"severities"`    // Alert severities, empty for all
+	VacationOnly   bool       `json:"vacation_only"` // Only notified in vacation mode, e.g. a neighbour
+	ExpiresAt      *time.Time `json:"expires_at"`    // Removed from then on, e.g. a guest at the end of their stay
+}
+
+// SaveRecipient creates or updates a recipient
+func SaveRecipient(ctx context.Context, r Recipient) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO recipients (id, name, phone, email, telegram_chat_id, channels, severities, vacation_only, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			phone = excluded.phone,
+			email = excluded.email,
+			telegram_chat_id = excluded.telegram_chat_id,
+			channels = excluded.channels,
+			severities = excluded.severities,
+			vacation_only = excluded.vacation_only,
+			expires_at = excluded.expires_at`,
+		r.ID, r.Name, r.Phone, r.Email, r.TelegramChatID, strings.Join(r.Channels, ","), strings.Join(r.Severities, ","), r.VacationOnly, utcOrNil(r.ExpiresAt), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save recipient: %w", err)
+	}
+
+	return nil
+}
+
+// GetRecipient retrieves a recipient, or nil if it doesn't exist
+func GetRecipient(ctx context.Context, id string) (*Recipient, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	r, err := scanRecipient(db.QueryRowContext(ctx,
+		"SELECT id, name, phone, email, telegram_chat_id, channels, severities, vacation_only, expires_at FROM recipients WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recipient: %w", err)
+	}
+
+	return r, nil
+}
+
+// GetRecipients retrieves all recipients ordered by ID
+func GetRecipients(ctx context.Context) ([]Recipient, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name, phone, email, telegram_chat_id, channels, severities, vacation_only, expires_at FROM recipients ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recipients: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := []Recipient{}
+	for rows.Next() {
+		r, err := scanRecipient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan recipient: %w", err)
+		}
+		recipients = append(recipients, *r)
+	}
+
+	return recipients, rows.Err()
+}
+
+// DeleteRecipient deletes a recipient, reporting whether it existed
+func DeleteRecipient(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM recipients WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete recipient: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted recipients: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func scanRecipient(row interface{ Scan(...any) error }) (*Recipient, error) {
+	var r Recipient
+	var channels, severities string
+	var expiresAt sql.NullTime
+	if err := row.Scan(&r.ID, &r.Name, &r.Phone, &r.Email, &r.TelegramChatID, &channels, &severities, &r.VacationOnly, &expiresAt); err != nil {
+		return nil, err
+	}
+
+	r.Channels = splitList(channels)
+	r.Severities = splitList(severities)
+	r.ExpiresAt = timeOrNil(expiresAt)
+	return &r, nil
+}
+
+// splitList splits a comma-separated list stored in a column, dropping empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Site is a property grouping the devices installed at one address
+type Site struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Address    string   `json:"address"`
+	Recipients []string `json:"recipients"` // Phone numbers alerted about the site's devices
+}
+
+// SaveSite creates or updates a site
+func SaveSite(ctx context.Context, s Site) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sites (id, name, address, recipients, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			address = excluded.address,
+			recipients = excluded.recipients`,
+		s.ID, s.Name, s.Address, strings.Join(s.Recipients, ","), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save site: %w", err)
+	}
+
+	return nil
+}
+
+// GetSite retrieves a site, or nil if it doesn't exist
+func GetSite(ctx context.Context, id string) (*Site, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	s, err := scanSite(db.QueryRowContext(ctx, "SELECT id, name, address, recipients FROM sites WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query site: %w", err)
+	}
+
+	return s, nil
+}
+
+// GetSites retrieves all sites ordered by ID
+func GetSites(ctx context.Context) ([]Site, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name, address, recipients FROM sites ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sites: %w", err)
+	}
+	defer rows.Close()
+
+	sites := []Site{}
+	for rows.Next() {
+		s, err := scanSite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan site: %w", err)
+		}
+		sites = append(sites, *s)
+	}
+
+	return sites, rows.Err()
+}
+
+// GetDeviceSite retrieves the site a device belongs to, or nil if it isn't assigned to one
+func GetDeviceSite(ctx context.Context, deviceID string) (*Site, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	s, err := scanSite(db.QueryRowContext(ctx, `
+		SELECT s.id, s.name, s.address, s.recipients
+		FROM sites s JOIN devices d ON d.site_id = s.id
+		WHERE d.id = ?`, deviceID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device site: %w", err)
+	}
+
+	return s, nil
+}
+
+// AssignDeviceSite moves a device to a site, an empty site ID removes it from its site
+func AssignDeviceSite(ctx context.Context, deviceID, siteID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO devices (id, site_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET site_id = excluded.site_id`,
+		deviceID, siteID, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to assign device site: %w", err)
+	}
+
+	return nil
+}
+
+// GetSiteDeviceIDs retrieves the IDs of the devices at a site
+func GetSiteDeviceIDs(ctx context.Context, siteID string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id FROM devices WHERE site_id = ? ORDER BY id", siteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query site devices: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func scanSite(row interface{ Scan(...any) error }) (*Site, error) {
+	var s Site
+	var recipients string
+	if err := row.Scan(&s.ID, &s.Name, &s.Address, &recipients); err != nil {
+		return nil, err
+	}
+
+	s.Recipients = []string{}
+	for _, r := range strings.Split(recipients, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			s.Recipients = append(s.Recipients, r)
+		}
+	}
+	return &s, nil
+}
+
+// SiteUsage is the usage billed to a site over a period
+type SiteUsage struct {
+	SiteID   string `json:"site_id"` // Empty for devices not assigned to a site
+	Readings int    `json:"readings"`
+	SMS      int    `json:"sms"`
+	Voice    int    `json:"voice"`
+}
+
+// GetSiteUsage counts the readings stored and the alert notifications sent per site
+// within [from, to). Usage is attributed to the site a device currently belongs to.
+func GetSiteUsage(ctx context.Context, from, to time.Time) ([]SiteUsage, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	usage := map[string]*SiteUsage{}
+	siteUsage := func(siteID string) *SiteUsage {
+		if usage[siteID] == nil {
+			usage[siteID] = &SiteUsage{SiteID: siteID}
+		}
+		return usage[siteID]
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(d.site_id, ''), COUNT(*)
+		FROM level_data l LEFT JOIN devices d ON d.id = l.device_id
+		WHERE l.created_at >= ? AND l.created_at < ?
+		GROUP BY 1`,
+		from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reading usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID string
+		var count int
+		if err := rows.Scan(&siteID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan reading usage: %w", err)
+		}
+		siteUsage(siteID).Readings += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT COALESCE(d.site_id, ''), n.channel, COUNT(*)
+		FROM notifications n
+		JOIN alerts a ON a.id = n.alert_id
+		LEFT JOIN devices d ON d.id = a.device_id
+		WHERE n.created_at >= ? AND n.created_at < ?
+		GROUP BY 1, 2`,
+		from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID, channel string
+		var count int
+		if err := rows.Scan(&siteID, &channel, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan notification usage: %w", err)
+		}
+		switch channel {
+		case "sms":
+			siteUsage(siteID).SMS += count
+		case "voice":
+			siteUsage(siteID).Voice += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]SiteUsage, 0, len(usage))
+	for _, u := range usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].SiteID < result[j].SiteID })
+
+	return result, nil
+}
@@ -0,0 +1,487 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqlStore is the Store keeping the readings in the level_data table of the
+// database, SQLite or PostgreSQL
+type sqlStore struct {
+	db *conn
+}
+
+// Close closes the database connection
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+const insertReading = "INSERT INTO level_data (device_id, level, created_at, raw_timestamp, raw_level, quality, transport, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"
+
+// SaveReading saves the level reading of the device to the database
+func (s *sqlStore) SaveReading(ctx context.Context, deviceID string, r Reading) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, insertReading)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var id int64
+	err = stmt.QueryRowContext(ctx, deviceID, r.Level, r.Timestamp.UTC(), utcOrNil(r.RawTimestamp), r.RawLevel, r.Quality, r.Transport, r.Source).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to insert data: %w", err)
+	}
+	markWritten(id, r.Timestamp)
+
+	return nil
+}
+
+// Latest retrieves the latest trusted level data from the database
+func (s *sqlStore) Latest(ctx context.Context) (float64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT level FROM level_data WHERE quality IN "+trustedQualities+" ORDER BY created_at DESC LIMIT 1")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var level float64
+		if err := rows.Scan(&level); err != nil {
+			return 0, fmt.Errorf("failed to scan level: %w", err)
+		}
+		return level, nil
+	}
+
+	return 0, fmt.Errorf("no level data found")
+}
+
+// DeviceLatest retrieves the latest trusted level of a device
+func (s *sqlStore) DeviceLatest(ctx context.Context, deviceID string) (float64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var level float64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT level FROM level_data WHERE device_id = ? AND quality IN "+trustedQualities+" ORDER BY created_at DESC LIMIT 1",
+		deviceID,
+	).Scan(&level)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no level data found for device %s", deviceID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database: %w", err)
+	}
+
+	return level, nil
+}
+
+// Levels retrieves the latest trusted level of every device, ordered by device ID
+func (s *sqlStore) Levels(ctx context.Context) ([]DeviceLevel, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT device_id, level FROM (
+			SELECT device_id, level, ROW_NUMBER() OVER (PARTITION BY device_id ORDER BY created_at DESC) AS n
+			FROM level_data WHERE quality IN `+trustedQualities+`
+		) AS latest WHERE n = 1 ORDER BY device_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer rows.Close()
+
+	levels := []DeviceLevel{}
+	for rows.Next() {
+		var l DeviceLevel
+		if err := rows.Scan(&l.DeviceID, &l.Level); err != nil {
+			return nil, fmt.Errorf("failed to scan level: %w", err)
+		}
+		levels = append(levels, l)
+	}
+
+	return levels, rows.Err()
+}
+
+// LastReadingTimes retrieves the time of the latest reading of every device, whatever its quality
+func (s *sqlStore) LastReadingTimes(ctx context.Context) (map[string]time.Time, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT device_id, created_at FROM (
+			SELECT device_id, created_at, ROW_NUMBER() OVER (PARTITION BY device_id ORDER BY created_at DESC) AS n
+			FROM level_data
+		) AS latest WHERE n = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer rows.Close()
+
+	times := map[string]time.Time{}
+	for rows.Next() {
+		var deviceID string
+		var t time.Time
+		if err := rows.Scan(&deviceID, &t); err != nil {
+			return nil, fmt.Errorf("failed to scan reading time: %w", err)
+		}
+		times[deviceID] = t
+	}
+
+	return times, rows.Err()
+}
+
+// Range retrieves the trusted readings between from and to, oldest first
+func (s *sqlStore) Range(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT level, created_at, quality FROM level_data WHERE quality IN "+trustedQualities+
+			" AND created_at >= ? AND created_at <= ? ORDER BY created_at ASC",
+		from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	readings := []Reading{}
+	for rows.Next() {
+		var r Reading
+		if err := rows.Scan(&r.Level, &r.Timestamp, &r.Quality); err != nil {
+			return nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, r)
+	}
+
+	return readings, rows.Err()
+}
+
+// DeviceRange retrieves the trusted readings of a device within the time range, oldest first
+func (s *sqlStore) DeviceRange(ctx context.Context, deviceID string, from, to time.Time) ([]Reading, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT level, created_at, quality FROM level_data WHERE device_id = ? AND quality IN "+trustedQualities+
+			" AND created_at >= ? AND created_at <= ? ORDER BY created_at ASC",
+		deviceID, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	readings := []Reading{}
+	for rows.Next() {
+		var r Reading
+		if err := rows.Scan(&r.Level, &r.Timestamp, &r.Quality); err != nil {
+			return nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, r)
+	}
+
+	return readings, rows.Err()
+}
+
+// Stats aggregates the trusted readings per hour, day or week, including downsampled
+// ones, optionally of a single device and within a time range, oldest bucket first
+func (s *sqlStore) Stats(ctx context.Context, bucket string, f ReadingFilter) ([]LevelStats, error) {
+	start, ok := dialect.bucketStarts[bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q", bucket)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if len(f.Qualities) == 0 {
+		f.Qualities = []string{QualityOK, QualityCorrected}
+	}
+	filter, args := readingConditions(f)
+	query := `
+		SELECT ` + start + ` AS bucket, MIN(min_level), MAX(max_level), SUM(sum_level) / SUM(readings), SUM(readings) FROM (
+			SELECT created_at, level AS min_level, level AS max_level, level AS sum_level, 1 AS readings
+			FROM level_data WHERE 1 = 1` + filter
+
+	// Downsampled readings count through their aggregates, which fall in the bucket of
+	// their start: day aggregates land in the first hour of their day. Aggregates have
+	// no provenance, and hold trusted readings, they count unless the filter rules
+	// those out.
+	if f.Transport == "" && f.Source == "" && slices.Contains(f.Qualities, QualityOK) && !slices.Contains(f.ExcludeQualities, QualityOK) {
+		aggregateFilter, aggregateArgs := readingConditions(ReadingFilter{DeviceID: f.DeviceID, From: f.From, To: f.To})
+		query += `
+			UNION ALL
+			SELECT created_at, min_level, max_level, sum_level, readings FROM level_aggregates WHERE 1 = 1` + aggregateFilter
+		args = append(args, aggregateArgs...)
+	}
+	query += `
+		) AS readings GROUP BY bucket ORDER BY bucket ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query level stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []LevelStats{}
+	for rows.Next() {
+		var s LevelStats
+		var bucketStart string
+		if err := rows.Scan(&bucketStart, &s.Min, &s.Max, &s.Avg, &s.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan level stats: %w", err)
+		}
+		if s.Start, err = time.Parse(time.DateTime, bucketStart); err != nil {
+			return nil, fmt.Errorf("failed to parse bucket start: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
+// Seasonal summarizes the trusted readings of a device by month or weekday. The
+// inflow is the sum of the rises between consecutive readings, drops such as pump-outs
+// don't count against it.
+func (s *sqlStore) Seasonal(ctx context.Context, deviceID, season string) ([]SeasonalUsage, error) {
+	period, ok := dialect.seasonPeriods[season]
+	if !ok {
+		return nil, fmt.Errorf("unknown season %q", season)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH deltas AS (
+			SELECT created_at, level, level - LAG(level) OVER (ORDER BY created_at) AS delta
+			FROM level_data WHERE device_id = ? AND quality IN `+trustedQualities+`
+		)
+		SELECT `+period+` AS period,
+			SUM(CASE WHEN delta > 0 THEN delta ELSE 0 END) / COUNT(DISTINCT `+dialect.day+`),
+			MAX(level), AVG(level), COUNT(DISTINCT `+dialect.day+`)
+		FROM deltas GROUP BY period ORDER BY period`,
+		deviceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query seasonal usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []SeasonalUsage{}
+	for rows.Next() {
+		var u SeasonalUsage
+		if err := rows.Scan(&u.Period, &u.AvgDailyInflow, &u.PeakLevel, &u.AvgLevel, &u.Days); err != nil {
+			return nil, fmt.Errorf("failed to scan seasonal usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
+}
+
+// History retrieves up to limit stored readings matching the filter, oldest first.
+// Readings up to and including the one at afterTime with ID afterID are skipped, for
+// paging through the history.
+func (s *sqlStore) History(ctx context.Context, f ReadingFilter, afterTime time.Time, afterID int64, limit int) ([]HistoryReading, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter, args := readingConditions(f)
+	query := "SELECT id, device_id, level, created_at, quality, transport, source FROM level_data WHERE 1 = 1" + filter
+	if afterID != 0 {
+		query += " AND (created_at > ? OR (created_at = ? AND id > ?))"
+		args = append(args, afterTime.UTC(), afterTime.UTC(), afterID)
+	}
+	query += " ORDER BY created_at ASC, id ASC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	readings := []HistoryReading{}
+	for rows.Next() {
+		var r HistoryReading
+		if err := rows.Scan(&r.ID, &r.DeviceID, &r.Level, &r.Timestamp, &r.Quality, &r.Transport, &r.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, r)
+	}
+
+	return readings, rows.Err()
+}
+
+// Provenance counts the stored readings per device, transport and source, optionally
+// of a single device and within a time range
+func (s *sqlStore) Provenance(ctx context.Context, deviceID string, from, to time.Time) ([]Provenance, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT device_id, transport, source, COUNT(*), MIN(created_at), MAX(created_at) FROM level_data WHERE 1 = 1"
+	var args []any
+	if deviceID != "" {
+		query += " AND device_id = ?"
+		args = append(args, deviceID)
+	}
+	if !from.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += " AND created_at <= ?"
+		args = append(args, to.UTC())
+	}
+	query += " GROUP BY device_id, transport, source ORDER BY device_id, transport, source"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query provenance: %w", err)
+	}
+	defer rows.Close()
+
+	provenance := []Provenance{}
+	for rows.Next() {
+		var p Provenance
+		var first, last aggregateTime
+		if err := rows.Scan(&p.DeviceID, &p.Transport, &p.Source, &p.Readings, &first, &last); err != nil {
+			return nil, fmt.Errorf("failed to scan provenance: %w", err)
+		}
+		p.First, p.Last = first.Time, last.Time
+		provenance = append(provenance, p)
+	}
+
+	return provenance, rows.Err()
+}
+
+// SaveBacklog saves backlog readings and advances the device sequence number to
+// lastSeq in one transaction, so a chunk is never half-accepted
+func (s *sqlStore) SaveBacklog(ctx context.Context, deviceID string, lastSeq int64, readings []Reading) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if len(readings) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertReading)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var lastID int64
+	for _, r := range readings {
+		err := stmt.QueryRowContext(ctx, deviceID, r.Level, r.Timestamp.UTC(), utcOrNil(r.RawTimestamp), r.RawLevel, r.Quality, r.Transport, r.Source).Scan(&lastID)
+		if err != nil {
+			return fmt.Errorf("failed to insert data: %w", err)
+		}
+	}
+
+	if err := setDeviceSeq(ctx, tx, deviceID, lastSeq); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit backlog: %w", err)
+	}
+	markWritten(lastID, readings[len(readings)-1].Timestamp)
+
+	return nil
+}
+
+// LatestReadingTime retrieves the time of the latest reading, or nil if there are none
+func (s *sqlStore) LatestReadingTime(ctx context.Context) (*time.Time, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var t time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT created_at FROM level_data ORDER BY created_at DESC LIMIT 1").Scan(&t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest reading: %w", err)
+	}
+
+	return &t, nil
+}
+
+// LatestRawLevel retrieves the level the sensor of a device last reported, before calibration,
+// or nil if the device has no readings
+func (s *sqlStore) LatestRawLevel(ctx context.Context, deviceID string) (*float64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var level float64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(raw_level, level) FROM level_data WHERE device_id = ? ORDER BY created_at DESC, id DESC LIMIT 1",
+		deviceID,
+	).Scan(&level)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest raw level: %w", err)
+	}
+	return &level, nil
+}
+
+// readingConditions returns the SQL conditions selecting the readings matching the
+// filter, each starting with AND, and their arguments
+func readingConditions(f ReadingFilter) (string, []any) {
+	conditions := ""
+	var args []any
+	if f.DeviceID != "" {
+		conditions += " AND device_id = ?"
+		args = append(args, f.DeviceID)
+	}
+	if f.Transport != "" {
+		conditions += " AND transport = ?"
+		args = append(args, f.Transport)
+	}
+	if f.Source != "" {
+		conditions += " AND source = ?"
+		args = append(args, f.Source)
+	}
+	if len(f.Qualities) > 0 {
+		conditions += " AND quality IN (?" + strings.Repeat(", ?", len(f.Qualities)-1) + ")"
+		for _, q := range f.Qualities {
+			args = append(args, q)
+		}
+	}
+	if len(f.ExcludeQualities) > 0 {
+		conditions += " AND quality NOT IN (?" + strings.Repeat(", ?", len(f.ExcludeQualities)-1) + ")"
+		for _, q := range f.ExcludeQualities {
+			args = append(args, q)
+		}
+	}
+	if !f.From.IsZero() {
+		conditions += " AND created_at >= ?"
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions += " AND created_at <= ?"
+		args = append(args, f.To.UTC())
+	}
+	return conditions, args
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists the level readings. By default they are kept in the database opened
+// by Init, SQLite or PostgreSQL; other backends can be plugged in with SetStore, the
+// remaining data (devices, alerts, settings...) stays in the database.
+type Store interface {
+	// SaveReading saves the level reading of the device
+	SaveReading(ctx context.Context, deviceID string, r Reading) error
+	// SaveBacklog saves backlog readings and advances the device sequence number to
+	// lastSeq atomically
+	SaveBacklog(ctx context.Context, deviceID string, lastSeq int64, readings []Reading) error
+	// Latest retrieves the latest trusted level of any device
+	Latest(ctx context.Context) (float64, error)
+	// DeviceLatest retrieves the latest trusted level of a device
+	DeviceLatest(ctx context.Context, deviceID string) (float64, error)
+	// Levels retrieves the latest trusted level of every device, ordered by device ID
+	Levels(ctx context.Context) ([]DeviceLevel, error)
+	// LastReadingTimes retrieves the time of the latest reading of every device, whatever its quality
+	LastReadingTimes(ctx context.Context) (map[string]time.Time, error)
+	// LatestReadingTime retrieves the time of the latest reading, or nil if there are none
+	LatestReadingTime(ctx context.Context) (*time.Time, error)
+	// LatestRawLevel retrieves the level the sensor of a device last reported, before
+	// calibration, or nil if the device has no readings
+	LatestRawLevel(ctx context.Context, deviceID string) (*float64, error)
+	// Range retrieves the trusted readings between from and to, oldest first
+	Range(ctx context.Context, from, to time.Time) ([]Reading, error)
+	// DeviceRange retrieves the trusted readings of a device within the time range, oldest first
+	DeviceRange(ctx context.Context, deviceID string, from, to time.Time) ([]Reading, error)
+	// Stats aggregates the readings matching the filter per hour, day or week, the
+	// trusted ones unless the filter selects qualities
+	Stats(ctx context.Context, bucket string, f ReadingFilter) ([]LevelStats, error)
+	// Seasonal summarizes the trusted readings of a device by month or weekday
+	Seasonal(ctx context.Context, deviceID, season string) ([]SeasonalUsage, error)
+	// History retrieves up to limit stored readings matching the filter, oldest first, for paging
+	History(ctx context.Context, f ReadingFilter, afterTime time.Time, afterID int64, limit int) ([]HistoryReading, error)
+	// Provenance counts the stored readings per device, transport and source
+	Provenance(ctx context.Context, deviceID string, from, to time.Time) ([]Provenance, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// store is the storage of the readings, set up by Init
+var store Store
+
+// GetStore returns the storage of the readings, e.g. to wrap it before SetStore
+func GetStore() Store {
+	return store
+}
+
+// SetStore replaces the storage of the readings, to be called after Init. The
+// previous store is not closed.
+func SetStore(s Store) {
+	store = s
+}
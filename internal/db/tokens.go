@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeviceToken is a credential a device submits its readings with. Only the hash of
+// the token is stored, the token itself is shown once when created.
+type DeviceToken struct {
+	ID         int64      `json:"id"`
+	DeviceID   string     `json:"device_id"`
+	Label      string     `json:"label"` // e.g. the sensor board the token was flashed onto
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// tokenUseInterval is how often the last-used time of a token is updated at most,
+// so every reading doesn't cost a write
+const tokenUseInterval = time.Minute
+
+// CreateDeviceToken registers the device if needed and stores a token of it by the
+// hash, revoking its other tokens if rotate is set
+func CreateDeviceToken(ctx context.Context, deviceID, label, hash string, rotate bool) (*DeviceToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO devices (id, created_at) VALUES (?, ?) ON CONFLICT (id) DO NOTHING", deviceID, now); err != nil {
+		return nil, fmt.Errorf("failed to register device: %w", err)
+	}
+	if rotate {
+		if _, err := tx.ExecContext(ctx, "UPDATE device_tokens SET revoked_at = ? WHERE device_id = ? AND revoked_at IS NULL", now, deviceID); err != nil {
+			return nil, fmt.Errorf("failed to revoke device tokens: %w", err)
+		}
+	}
+
+	t := DeviceToken{DeviceID: deviceID, Label: label, CreatedAt: now}
+	if err := tx.QueryRowContext(ctx,
+		"INSERT INTO device_tokens (device_id, label, token_hash, created_at) VALUES (?, ?, ?, ?) RETURNING id",
+		deviceID, label, hash, now,
+	).Scan(&t.ID); err != nil {
+		return nil, fmt.Errorf("failed to create device token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit device token: %w", err)
+	}
+	return &t, nil
+}
+
+// GetDeviceTokens retrieves the tokens of a device, revoked ones included, newest first
+func GetDeviceTokens(ctx context.Context, deviceID string) ([]DeviceToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, device_id, label, created_at, last_used_at, revoked_at FROM device_tokens WHERE device_id = ? ORDER BY id DESC",
+		deviceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []DeviceToken{}
+	for rows.Next() {
+		var t DeviceToken
+		var lastUsed, revoked sql.NullTime
+		if err := rows.Scan(&t.ID, &t.DeviceID, &t.Label, &t.CreatedAt, &lastUsed, &revoked); err != nil {
+			return nil, fmt.Errorf("failed to scan device token: %w", err)
+		}
+		t.LastUsedAt = timeOrNil(lastUsed)
+		t.RevokedAt = timeOrNil(revoked)
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeDeviceToken revokes a token of the device, reporting whether it existed and
+// wasn't revoked already
+func RevokeDeviceToken(ctx context.Context, deviceID string, id int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx,
+		"UPDATE device_tokens SET revoked_at = ? WHERE id = ? AND device_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, deviceID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke device token: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count revoked device tokens: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// UseDeviceToken reports whether the hash is of an active token of the device, and
+// records that the token was used
+func UseDeviceToken(ctx context.Context, deviceID, hash string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	var lastUsed sql.NullTime
+	err := db.QueryRowContext(ctx,
+		"SELECT id, last_used_at FROM device_tokens WHERE token_hash = ? AND device_id = ? AND revoked_at IS NULL",
+		hash, deviceID,
+	).Scan(&id, &lastUsed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query device token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if !lastUsed.Valid || now.Sub(lastUsed.Time) >= tokenUseInterval {
+		if _, err := db.ExecContext(ctx, "UPDATE device_tokens SET last_used_at = ? WHERE id = ?", now, id); err != nil {
+			return false, fmt.Errorf("failed to update device token: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// HasDeviceTokens reports whether any device has an active token
+func HasDeviceTokens(ctx context.Context) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM device_tokens WHERE revoked_at IS NULL)").Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to query device tokens: %w", err)
+	}
+
+	return exists, nil
+}
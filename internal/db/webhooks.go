@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Webhook is a URL registered through the API to receive alert events
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveWebhook registers a webhook URL, registering the same URL again returns the existing webhook
+func SaveWebhook(ctx context.Context, url string) (*Webhook, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO webhooks (url, created_at) VALUES (?, ?) ON CONFLICT (url) DO NOTHING",
+		url, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %w", err)
+	}
+
+	var w Webhook
+	err = db.QueryRowContext(ctx, "SELECT id, url, created_at FROM webhooks WHERE url = ?", url).Scan(&w.ID, &w.URL, &w.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook: %w", err)
+	}
+
+	return &w, nil
+}
+
+// GetWebhooks retrieves the registered webhooks ordered by ID
+func GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, url, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(&w.ID, &w.URL, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook unregisters a webhook and reports whether it existed
+func DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an attempt to post an event to a webhook. WebhookID is 0 for the
+// webhooks in WEBHOOK_URLS, which aren't registered through the API.
+type WebhookDelivery struct {
+	ID           int64     `json:"id"`
+	WebhookID    int64     `json:"webhook_id"`
+	URL          string    `json:"url"`
+	Event        string    `json:"event"`
+	Payload      string    `json:"payload"` // JSON posted, resent as is on redelivery
+	Status       string    `json:"status"`
+	ResponseCode int       `json:"response_code,omitempty"` // 0 if no response was received
+	Response     string    `json:"response,omitempty"`      // Start of the response body
+	Error        string    `json:"error,omitempty"`
+	LatencyMs    int64     `json:"latency_ms"`
+	RedeliveryOf *int64    `json:"redelivery_of,omitempty"` // Delivery this one resent, if any
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const webhookDeliveryColumns = "id, webhook_id, url, event, payload, status, response_code, response, error, latency_ms, redelivery_of, created_at"
+
+// SaveWebhookDelivery records a delivery attempt and returns it with its ID
+func SaveWebhookDelivery(ctx context.Context, d WebhookDelivery) (*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	d.CreatedAt = time.Now().UTC()
+	err := db.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, url, event, payload, status, response_code, response, error, latency_ms, redelivery_of, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		d.WebhookID, d.URL, d.Event, d.Payload, d.Status, d.ResponseCode, d.Response, d.Error, d.LatencyMs, d.RedeliveryOf, d.CreatedAt,
+	).Scan(&d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+
+	return &d, nil
+}
+
+// GetWebhookDeliveries retrieves the latest delivery attempts to a webhook, newest first
+func GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?",
+		webhookID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetWebhookDelivery retrieves a delivery attempt to a webhook, or nil if there is none
+func GetWebhookDelivery(ctx context.Context, webhookID, id int64) (*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE webhook_id = ? AND id = ?",
+		webhookID, id,
+	)
+	d, err := scanWebhookDelivery(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook delivery: %w", err)
+	}
+	return d, nil
+}
+
+// PurgeWebhookDeliveries deletes the delivery attempts made before the cutoff and returns their number
+func PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired webhook deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// scanWebhookDelivery scans a row selected with webhookDeliveryColumns
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var redeliveryOf sql.NullInt64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Event, &d.Payload, &d.Status, &d.ResponseCode, &d.Response, &d.Error, &d.LatencyMs, &redeliveryOf, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if redeliveryOf.Valid {
+		d.RedeliveryOf = &redeliveryOf.Int64
+	}
+	return &d, nil
+}
@@ -0,0 +1,214 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/notify"
+)
+
+// TLS modes of the SMTP connection
+const (
+	TLSStartTLS = "starttls" // Upgrade a plain connection, usually on port 587
+	TLSImplicit = "tls"      // TLS from the start, usually on port 465
+	TLSNone     = "none"     // Plain text, only for relays on a trusted network
+)
+
+// Notifier sends alert notifications by email to the recipient's address, by default
+// to the addresses in EMAIL_TO
+type Notifier struct{}
+
+// Name returns the channel name
+func (Notifier) Name() string {
+	return "email"
+}
+
+// Send emails the alert message to the recipient, or the configured addresses
+func (Notifier) Send(ctx context.Context, alert notify.Alert) (string, error) {
+	if alert.Recipient != "" {
+		return "", SendTo(ctx, []string{alert.Recipient}, alert.Message)
+	}
+	return "", Send(ctx, alert.Message)
+}
+
+// Check verifies the SMTP server and credentials by logging in without sending an email
+func (Notifier) Check(ctx context.Context) error {
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Quit()
+}
+
+// Send emails the message to the comma-separated addresses in EMAIL_TO
+func Send(ctx context.Context, message string) error {
+	to := Addresses()
+	if len(to) == 0 {
+		return fmt.Errorf("EMAIL_TO not configured")
+	}
+	return SendTo(ctx, to, message)
+}
+
+// Addresses returns the addresses in EMAIL_TO
+func Addresses() []string {
+	var to []string
+	for _, addr := range strings.Split(os.Getenv("EMAIL_TO"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// SendTo emails the message to the addresses through the SMTP server at
+// SMTP_HOST:SMTP_PORT, authenticating with SMTP_USER and SMTP_PASS if set
+func SendTo(ctx context.Context, to []string, message string) error {
+	// Get sender from environment, default to the SMTP user if not set
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USER")
+	}
+	if from == "" {
+		return fmt.Errorf("EMAIL_FROM not configured")
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", addr, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(compose(from, to, message)); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// connect connects and logs in to the SMTP server at SMTP_HOST:SMTP_PORT
+func connect(ctx context.Context) (*smtp.Client, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, fmt.Errorf("SMTP_HOST not configured")
+	}
+
+	// Get TLS mode from environment, default to STARTTLS if not set
+	mode := os.Getenv("SMTP_TLS")
+	if mode == "" {
+		mode = TLSStartTLS
+	}
+
+	// Get port from environment, default to the usual port of the TLS mode
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		switch mode {
+		case TLSImplicit:
+			port = "465"
+		case TLSNone:
+			port = "25"
+		default:
+			port = "587"
+		}
+	}
+
+	client, err := dial(ctx, host, port, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		if err := client.Auth(smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// dial connects to the SMTP server in the TLS mode, bounded by the context deadline
+func dial(ctx context.Context, host, port, mode string) (*smtp.Client, error) {
+	addr := net.JoinHostPort(host, port)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	switch mode {
+	case TLSImplicit:
+		dialer := &tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	case TLSStartTLS, TLSNone:
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	default:
+		return nil, fmt.Errorf("invalid SMTP_TLS value: %s", mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	// The SMTP client has no context support, the deadline bounds the whole conversation
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+
+	if mode == TLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// compose builds the email with the message as subject and plain text body
+func compose(from string, to []string, message string) []byte {
+	// Keep line breaks of the message out of the subject header
+	subject := strings.Join(strings.Fields(message), " ")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[Septic monitor] "+subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
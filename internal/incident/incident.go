@@ -0,0 +1,27 @@
+package incident
+
+import "context"
+
+// Severities used for alerts
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert describes an alert as reported to incident management tools
+type Alert struct {
+	ID       string
+	Summary  string
+	Severity string
+}
+
+// Notifier is an incident management tool following the alert lifecycle:
+// an incident is created when the alert is raised, acknowledged when someone
+// acknowledges the alert and closed once the level has recovered.
+// Implementations must deduplicate repeated Create calls by alert ID.
+type Notifier interface {
+	Name() string
+	Create(ctx context.Context, alert Alert) error
+	Acknowledge(ctx context.Context, alertID string) error
+	Close(ctx context.Context, alertID string) error
+}
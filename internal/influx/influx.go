@@ -0,0 +1,246 @@
+// Package influx mirrors the level readings to InfluxDB, for dashboards built on a
+// time-series database. Points are written in line protocol through the v2 write API,
+// which InfluxDB 1.8 and later also serve.
+package influx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// Bounds of the points waiting to be written
+const (
+	batchSize  = 500   // Points per write, a full batch is written right away
+	maxPending = 10000 // Points kept while InfluxDB is unreachable, the oldest are dropped beyond
+)
+
+// Enabled reports whether InfluxDB is configured
+func Enabled() bool {
+	return os.Getenv("INFLUXDB_URL") != ""
+}
+
+// Store saves the readings to the wrapped store and mirrors them to InfluxDB. Queries
+// are served by the wrapped store, the alerts and dashboard keep working from it.
+type Store struct {
+	db.Store
+
+	mu      sync.Mutex
+	pending []string // Lines not written yet, oldest first
+	dropped int      // Lines dropped from pending so far, as the backlog was full
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewStore wraps the store to mirror the readings to InfluxDB at INFLUXDB_URL, in the
+// INFLUXDB_BUCKET (default: septic) of the INFLUXDB_ORG, authenticated with
+// INFLUXDB_TOKEN. With InfluxDB 1.x, the bucket is database/retention-policy and the
+// token username:password. Points are written every INFLUXDB_FLUSH_INTERVAL seconds
+// (default: 10) and retried until InfluxDB accepts them, so an outage doesn't hold
+// up ingest.
+func NewStore(next db.Store) *Store {
+	s := &Store{
+		Store:   next,
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run(flushInterval())
+	return s
+}
+
+// SaveReading saves the reading to the wrapped store, then queues it for InfluxDB
+func (s *Store) SaveReading(ctx context.Context, deviceID string, r db.Reading) error {
+	if err := s.Store.SaveReading(ctx, deviceID, r); err != nil {
+		return err
+	}
+	s.enqueue(deviceID, r)
+	return nil
+}
+
+// SaveBacklog saves the backlog to the wrapped store, then queues it for InfluxDB
+func (s *Store) SaveBacklog(ctx context.Context, deviceID string, lastSeq int64, readings []db.Reading) error {
+	if err := s.Store.SaveBacklog(ctx, deviceID, lastSeq, readings); err != nil {
+		return err
+	}
+	s.enqueue(deviceID, readings...)
+	return nil
+}
+
+// Close writes the pending points. The wrapped store is closed by db.Close.
+func (s *Store) Close() error {
+	close(s.done)
+	<-s.stopped
+	return nil
+}
+
+// enqueue adds the readings to the pending points, waking the writer on a full batch
+func (s *Store) enqueue(deviceID string, readings ...db.Reading) {
+	measurement := os.Getenv("INFLUXDB_MEASUREMENT")
+	if measurement == "" {
+		measurement = "septic_level"
+	}
+
+	s.mu.Lock()
+	for _, r := range readings {
+		s.pending = append(s.pending, line(measurement, deviceID, r))
+	}
+	if dropped := len(s.pending) - maxPending; dropped > 0 {
+		log.Printf("InfluxDB backlog full, dropping %d oldest points", dropped)
+		s.pending = s.pending[dropped:]
+		s.dropped += dropped
+	}
+	full := len(s.pending) >= batchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run writes the pending points every interval, or as soon as a batch is full, until
+// the store is closed
+func (s *Store) run(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flush:
+		case <-s.done:
+			s.writePending()
+			return
+		}
+		s.writePending()
+	}
+}
+
+// writePending writes the pending points in batches, keeping them for the next round
+// if InfluxDB fails
+func (s *Store) writePending() {
+	for {
+		s.mu.Lock()
+		batch := s.pending[:min(len(s.pending), batchSize)]
+		droppedBefore := s.dropped
+		s.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := write(ctx, batch)
+		cancel()
+		if err != nil {
+			log.Printf("Error writing %d points to InfluxDB: %v", len(batch), err)
+			return
+		}
+
+		// Points dropped from the front while writing may include part of the batch
+		s.mu.Lock()
+		s.pending = s.pending[max(0, len(batch)-(s.dropped-droppedBefore)):]
+		s.mu.Unlock()
+	}
+}
+
+// write posts the lines to the v2 write API
+func write(ctx context.Context, lines []string) error {
+	bucket := os.Getenv("INFLUXDB_BUCKET")
+	if bucket == "" {
+		bucket = "septic"
+	}
+
+	query := url.Values{}
+	query.Set("bucket", bucket)
+	if org := os.Getenv("INFLUXDB_ORG"); org != "" {
+		query.Set("org", org)
+	}
+	query.Set("precision", "ns")
+	endpoint := strings.TrimSuffix(os.Getenv("INFLUXDB_URL"), "/") + "/api/v2/write?" + query.Encode()
+
+	body := strings.NewReader(strings.Join(lines, "\n"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "septic-monitor")
+	if token := os.Getenv("INFLUXDB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("InfluxDB returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// line formats the reading as a line protocol point, tagged with the device, quality
+// and transport so dashboards can filter untrusted readings
+func line(measurement, deviceID string, r db.Reading) string {
+	var b strings.Builder
+	b.WriteString(escape(measurement, ", "))
+	for _, tag := range [][2]string{{"device_id", deviceID}, {"quality", r.Quality}, {"transport", r.Transport}} {
+		if tag[1] != "" { // Empty tag values are invalid
+			fmt.Fprintf(&b, ",%s=%s", tag[0], escape(tag[1], ",= "))
+		}
+	}
+
+	fmt.Fprintf(&b, " level=%s", strconv.FormatFloat(r.Level, 'f', -1, 64))
+	if r.RawLevel != nil {
+		fmt.Fprintf(&b, ",raw_level=%s", strconv.FormatFloat(*r.RawLevel, 'f', -1, 64))
+	}
+
+	fmt.Fprintf(&b, " %d", r.Timestamp.UnixNano())
+	return b.String()
+}
+
+// escape backslash-escapes the special characters of a line protocol element
+func escape(s, special string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(special, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// flushInterval returns INFLUXDB_FLUSH_INTERVAL (default: 10 seconds)
+func flushInterval() time.Duration {
+	s := os.Getenv("INFLUXDB_FLUSH_INTERVAL")
+	if s == "" {
+		return 10 * time.Second
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v <= 0 {
+		log.Printf("Invalid INFLUXDB_FLUSH_INTERVAL value: %q, using default 10", s)
+		return 10 * time.Second
+	}
+	return time.Duration(v) * time.Second
+}
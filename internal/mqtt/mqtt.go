@@ -0,0 +1,187 @@
+// Package mqtt manages the connection to the MQTT broker shared by the MQTT integrations.
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Handler processes a message received on a subscribed topic
+type Handler func(topic string, payload []byte)
+
+var (
+	mu         sync.Mutex
+	client     paho.Client
+	handlers   = map[string]Handler{}
+	onConnects []func()
+)
+
+// Enabled reports whether a broker is configured
+func Enabled() bool {
+	return os.Getenv("MQTT_BROKER") != ""
+}
+
+// Subscribe registers a handler for a topic filter. Topics are (re)subscribed
+// whenever the connection to the broker is established.
+func Subscribe(topic string, handler Handler) {
+	mu.Lock()
+	handlers[topic] = handler
+	c := client
+	mu.Unlock()
+
+	if c != nil && c.IsConnected() {
+		subscribe(c, topic, handler)
+	}
+}
+
+// OnConnect registers a function run whenever the connection to the broker is established
+func OnConnect(fn func()) {
+	mu.Lock()
+	onConnects = append(onConnects, fn)
+	mu.Unlock()
+}
+
+// Publish publishes the payload to the topic, retained for clients subscribing later
+// if retain is set. It fails if the broker isn't connected.
+func Publish(topic string, payload []byte, retain bool) error {
+	mu.Lock()
+	c := client
+	mu.Unlock()
+
+	if c == nil || !c.IsConnected() {
+		return errors.New("not connected to the MQTT broker")
+	}
+
+	token := c.Publish(topic, qos(), retain, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// Matches reports whether the topic matches the filter, with + matching a single
+// topic level and a trailing # any number of them
+func Matches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// Connect starts connecting to the broker at MQTT_BROKER, e.g. tcp://localhost:1883.
+// It returns right away, the connection is retried in the background until it succeeds.
+func Connect() {
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "septic-monitor"
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(os.Getenv("MQTT_BROKER")).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+
+	opts.SetOnConnectHandler(func(c paho.Client) {
+		log.Println("Connected to MQTT broker")
+
+		mu.Lock()
+		defer mu.Unlock()
+		for topic, handler := range handlers {
+			subscribe(c, topic, handler)
+		}
+		for _, fn := range onConnects {
+			go fn()
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		log.Printf("MQTT connection lost: %v", err)
+	})
+
+	c := paho.NewClient(opts)
+	mu.Lock()
+	client = c
+	mu.Unlock()
+
+	c.Connect()
+}
+
+// Unsubscribe stops receiving messages: it unsubscribes from every topic and forgets
+// the handlers, so a reconnect doesn't subscribe again. Publishing goes on.
+func Unsubscribe() {
+	mu.Lock()
+	topics := make([]string, 0, len(handlers))
+	for topic := range handlers {
+		topics = append(topics, topic)
+	}
+	handlers = map[string]Handler{}
+	c := client
+	mu.Unlock()
+
+	if c == nil || !c.IsConnected() || len(topics) == 0 {
+		return
+	}
+	token := c.Unsubscribe(topics...)
+	if !token.WaitTimeout(time.Second) {
+		log.Println("Timed out unsubscribing from MQTT topics")
+	} else if token.Error() != nil {
+		log.Printf("Error unsubscribing from MQTT topics: %v", token.Error())
+	}
+}
+
+// Disconnect disconnects from the broker, giving the publications in flight a second
+// to go out
+func Disconnect() {
+	mu.Lock()
+	c := client
+	mu.Unlock()
+
+	if c != nil {
+		c.Disconnect(1000)
+	}
+}
+
+// qos returns the QoS of subscriptions and publications from MQTT_QOS (default: 1, at least once)
+func qos() byte {
+	s := os.Getenv("MQTT_QOS")
+	if s == "" {
+		return 1
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 2 {
+		log.Printf("Invalid MQTT_QOS value: %q, using default 1", s)
+		return 1
+	}
+	return byte(v)
+}
+
+// subscribe subscribes the client to a topic, logging failures
+func subscribe(c paho.Client, topic string, handler Handler) {
+	token := c.Subscribe(topic, qos(), func(_ paho.Client, m paho.Message) {
+		handler(m.Topic(), m.Payload())
+	})
+
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("Error subscribing to MQTT topic %s: %v", topic, token.Error())
+		}
+	}()
+}
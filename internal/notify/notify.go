@@ -0,0 +1,24 @@
+package notify
+
+import "context"
+
+// Alert is a notification about an alert sent to one recipient
+type Alert struct {
+	ID        string // Alert ID, empty for messages not about an alert
+	Recipient string // Phone number, email address or chat ID, empty for the backend's configured destination
+	Message   string
+}
+
+// Notifier is a messaging backend delivering alert notifications, such as SMS.
+// Send returns the provider's message ID, if any, so delivery reports can be
+// correlated with the notification.
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, alert Alert) (string, error)
+}
+
+// Checker is implemented by notifiers that can verify their configuration and
+// credentials without sending a message, e.g. with a balance call
+type Checker interface {
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,100 @@
+package opsgenie
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"sceptic-monitor/internal/incident"
+)
+
+// Notifier reports alerts to Opsgenie, using the alert ID as the Opsgenie alias
+type Notifier struct{}
+
+// Enabled reports whether an Opsgenie API key is configured
+func Enabled() bool {
+	return os.Getenv("OPSGENIE_API_KEY") != ""
+}
+
+// Name returns the channel name
+func (Notifier) Name() string {
+	return "opsgenie"
+}
+
+// Create opens an Opsgenie alert, Opsgenie deduplicates open alerts by alias
+func (Notifier) Create(ctx context.Context, alert incident.Alert) error {
+	// Map severities to Opsgenie priorities
+	priority := "P3"
+	if alert.Severity == incident.SeverityCritical {
+		priority = "P1"
+	}
+
+	return send(ctx, "/v2/alerts", map[string]string{
+		"message":  alert.Summary,
+		"alias":    alert.ID,
+		"priority": priority,
+		"source":   "septic-monitor",
+	})
+}
+
+// Acknowledge acknowledges the Opsgenie alert with the alert ID alias
+func (Notifier) Acknowledge(ctx context.Context, alertID string) error {
+	return send(ctx, "/v2/alerts/"+url.PathEscape(alertID)+"/acknowledge?identifierType=alias", map[string]string{
+		"source": "septic-monitor",
+		"note":   "Acknowledged via septic-monitor",
+	})
+}
+
+// Close closes the Opsgenie alert with the alert ID alias
+func (Notifier) Close(ctx context.Context, alertID string) error {
+	return send(ctx, "/v2/alerts/"+url.PathEscape(alertID)+"/close?identifierType=alias", map[string]string{
+		"source": "septic-monitor",
+		"note":   "Level back below the threshold",
+	})
+}
+
+// send posts a request to the Opsgenie Alert API
+func send(ctx context.Context, path string, payload map[string]string) error {
+	apiKey := os.Getenv("OPSGENIE_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("OPSGENIE_API_KEY not configured")
+	}
+
+	// Get API URL from environment, EU accounts use https://api.eu.opsgenie.com
+	apiURL := os.Getenv("OPSGENIE_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.opsgenie.com"
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "GenieKey "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Requests are processed asynchronously and answered with 202 Accepted
+	if resp.StatusCode != http.StatusAccepted {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Opsgenie API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
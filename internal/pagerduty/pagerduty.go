@@ -0,0 +1,102 @@
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"sceptic-monitor/internal/incident"
+)
+
+// Event actions supported by the Events API v2
+const (
+	ActionTrigger     = "trigger"
+	ActionAcknowledge = "acknowledge"
+	ActionResolve     = "resolve"
+)
+
+// Notifier reports alerts to PagerDuty as incidents keyed by the alert ID
+type Notifier struct{}
+
+// Enabled reports whether a PagerDuty routing key is configured
+func Enabled() bool {
+	return os.Getenv("PAGERDUTY_ROUTING_KEY") != ""
+}
+
+// Name returns the channel name
+func (Notifier) Name() string {
+	return "pagerduty"
+}
+
+// Create opens an incident, or updates the open one with the same alert ID
+func (Notifier) Create(ctx context.Context, alert incident.Alert) error {
+	return sendEvent(ctx, ActionTrigger, alert.ID, alert.Summary, alert.Severity)
+}
+
+// Acknowledge acknowledges the incident for the alert
+func (Notifier) Acknowledge(ctx context.Context, alertID string) error {
+	return sendEvent(ctx, ActionAcknowledge, alertID, "", "")
+}
+
+// Close resolves the incident for the alert
+func (Notifier) Close(ctx context.Context, alertID string) error {
+	return sendEvent(ctx, ActionResolve, alertID, "", "")
+}
+
+// sendEvent sends an event to the PagerDuty Events API v2
+func sendEvent(ctx context.Context, action, dedupKey, summary, severity string) error {
+	routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY")
+	if routingKey == "" {
+		return fmt.Errorf("PAGERDUTY_ROUTING_KEY not configured")
+	}
+
+	// Get source name from environment, default to "septic-monitor" if not set
+	source := os.Getenv("PAGERDUTY_SOURCE")
+	if source == "" {
+		source = "septic-monitor"
+	}
+
+	event := map[string]any{
+		"routing_key":  routingKey,
+		"event_action": action,
+		"dedup_key":    dedupKey,
+	}
+
+	// Only trigger events carry a payload
+	if action == ActionTrigger {
+		event["payload"] = map[string]string{
+			"summary":  summary,
+			"source":   source,
+			"severity": severity,
+		}
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://events.pagerduty.com/v2/enqueue", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The Events API answers 202 Accepted for queued events
+	if resp.StatusCode != http.StatusAccepted {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("PagerDuty API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
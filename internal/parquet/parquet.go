@@ -0,0 +1,293 @@
+// Package parquet writes flat tables to Apache Parquet files: int64, double, string
+// and UTC timestamp columns, optionally nullable, PLAIN-encoded and Snappy
+// compressed. Rows are written in row groups as they come, so a table doesn't need to
+// fit in memory. It covers what the analytics export needs without a dependency.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// Type is the type of a column
+type Type int
+
+const (
+	Int64     Type = iota
+	Double         // float64
+	String         // UTF-8 string
+	Timestamp      // time.Time, stored as microseconds since the epoch in UTC
+)
+
+// Column describes a column of a table
+type Column struct {
+	Name     string
+	Type     Type
+	Nullable bool // Values may be nil
+}
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// Physical types, encodings and codecs of the Parquet format
+const (
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecSnappy = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+)
+
+// Writer writes a table to a Parquet file
+type Writer struct {
+	w         io.Writer
+	columns   []Column
+	offset    int64
+	numRows   int64
+	rowGroups [][]byte // Encoded RowGroup structs
+	err       error
+}
+
+// NewWriter starts a Parquet file of the columns on the writer
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	pw := &Writer{w: w, columns: columns}
+	pw.write([]byte(magic))
+	return pw, pw.err
+}
+
+func (pw *Writer) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// WriteRowGroup writes the rows as a row group. Each row holds a value per column, of
+// int64, float64, string or time.Time according to the column type, or nil.
+func (pw *Writer) WriteRowGroup(rows [][]any) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var group encoder
+	group.begin()
+	group.list(1, typeStruct, len(pw.columns))
+
+	var totalSize int64
+	for i, c := range pw.columns {
+		page, err := encodePage(c, rows, i)
+		if err != nil {
+			return err
+		}
+		compressed := snappy.Encode(nil, page)
+
+		var header encoder
+		header.begin()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(compressed)))
+		header.structField(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		pageOffset := pw.offset
+		pw.write(header.buf.Bytes())
+		pw.write(compressed)
+		if pw.err != nil {
+			return pw.err
+		}
+
+		uncompressedSize := int64(header.buf.Len() + len(page))
+		compressedSize := int64(header.buf.Len() + len(compressed))
+		totalSize += uncompressedSize
+
+		// ColumnChunk
+		group.element()
+		group.i64(2, pageOffset)
+		group.structField(3)
+		group.i32(1, physicalType(c.Type))
+		group.list(2, typeI32, 2)
+		group.varint(zigzag(encodingPlain))
+		group.varint(zigzag(encodingRLE))
+		group.list(3, typeBinary, 1)
+		group.bytes([]byte(c.Name))
+		group.i32(4, codecSnappy)
+		group.i64(5, int64(len(rows)))
+		group.i64(6, uncompressedSize)
+		group.i64(7, compressedSize)
+		group.i64(9, pageOffset)
+		group.end()
+		group.end()
+	}
+
+	group.i64(2, totalSize)
+	group.i64(3, int64(len(rows)))
+	group.end()
+
+	pw.rowGroups = append(pw.rowGroups, group.buf.Bytes())
+	pw.numRows += int64(len(rows))
+	return nil
+}
+
+// Close writes the file footer. It doesn't close the underlying writer.
+func (pw *Writer) Close() error {
+	if pw.err != nil {
+		return pw.err
+	}
+
+	var meta encoder
+	meta.begin()
+	meta.i32(1, 1) // Format version
+
+	// The schema is a root element holding the columns
+	meta.list(2, typeStruct, len(pw.columns)+1)
+	meta.element()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(pw.columns)))
+	meta.end()
+	for _, c := range pw.columns {
+		meta.element()
+		meta.i32(1, physicalType(c.Type))
+		if c.Nullable {
+			meta.i32(3, 1) // OPTIONAL
+		} else {
+			meta.i32(3, 0) // REQUIRED
+		}
+		meta.binary(4, c.Name)
+		switch c.Type {
+		case String:
+			meta.i32(6, convertedUTF8)
+			meta.structField(10)
+			meta.structField(1) // STRING
+			meta.end()
+			meta.end()
+		case Timestamp:
+			meta.i32(6, convertedTimestampMicros)
+			meta.structField(10)
+			meta.structField(8) // TIMESTAMP
+			meta.boolean(1, true)
+			meta.structField(2)
+			meta.structField(2) // MICROS
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+
+	meta.i64(3, pw.numRows)
+	meta.list(4, typeStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		meta.buf.Write(group)
+	}
+	meta.binary(6, "septic-monitor")
+	meta.end()
+
+	pw.write(meta.buf.Bytes())
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	pw.write([]byte(magic))
+	return pw.err
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Double:
+		return physicalDouble
+	case String:
+		return physicalByteArray
+	default:
+		return physicalInt64
+	}
+}
+
+// encodePage encodes the values of a column of the rows as the body of a data page:
+// the definition levels of a nullable column, then the non-null values
+func encodePage(c Column, rows [][]any, i int) ([]byte, error) {
+	var page, values bytes.Buffer
+	defined := make([]bool, len(rows))
+
+	for r, row := range rows {
+		v := row[i]
+		if v == nil {
+			if !c.Nullable {
+				return nil, fmt.Errorf("column %s: null value in row %d", c.Name, r)
+			}
+			continue
+		}
+		defined[r] = true
+
+		var ok bool
+		switch c.Type {
+		case Int64:
+			var n int64
+			n, ok = v.(int64)
+			values.Write(binary.LittleEndian.AppendUint64(nil, uint64(n)))
+		case Double:
+			var f float64
+			f, ok = v.(float64)
+			values.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+		case String:
+			var s string
+			s, ok = v.(string)
+			values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+			values.WriteString(s)
+		case Timestamp:
+			var t time.Time
+			t, ok = v.(time.Time)
+			values.Write(binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMicro())))
+		}
+		if !ok {
+			return nil, fmt.Errorf("column %s: unexpected %T in row %d", c.Name, v, r)
+		}
+	}
+
+	if c.Nullable {
+		levels := encodeLevels(defined)
+		page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		page.Write(levels)
+	}
+	page.Write(values.Bytes())
+	return page.Bytes(), nil
+}
+
+// encodeLevels encodes the definition levels, 1 for a value and 0 for a null, in runs
+// of the RLE/bit-packing hybrid encoding with a bit width of 1
+func encodeLevels(defined []bool) []byte {
+	var b []byte
+	for start := 0; start < len(defined); {
+		end := start + 1
+		for end < len(defined) && defined[end] == defined[start] {
+			end++
+		}
+		b = binary.AppendUvarint(b, uint64(end-start)<<1)
+		if defined[start] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		start = end
+	}
+	return b
+}
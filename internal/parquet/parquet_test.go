@@ -0,0 +1,286 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// tstruct is a decoded Thrift struct, by field ID
+type tstruct map[int16]any
+
+// decoder reads the Thrift compact protocol, enough to read back what encoder writes
+type decoder struct {
+	b   []byte
+	err bool
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) == 0 {
+		d.err = true
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *decoder) value(typ byte) any {
+	switch typ {
+	case typeBooleanTrue:
+		return true
+	case typeBooleanFalse:
+		return false
+	case typeI32, typeI64:
+		return d.varint()
+	case typeBinary:
+		n := int(d.uvarint())
+		if n > len(d.b) {
+			d.err = true
+			return ""
+		}
+		s := string(d.b[:n])
+		d.b = d.b[n:]
+		return s
+	case typeList:
+		header := d.byte()
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(d.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = d.value(elem)
+		}
+		return list
+	case typeStruct:
+		return d.structure()
+	default:
+		d.err = true
+		return nil
+	}
+}
+
+func (d *decoder) structure() tstruct {
+	s := tstruct{}
+	var last int16
+	for !d.err {
+		header := d.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(d.varint())
+		}
+		s[id] = d.value(header & 0x0f)
+		last = id
+	}
+	return s
+}
+
+// readFile decodes a Parquet file written by Writer back into its column names and rows
+func readFile(t *testing.T, file []byte) ([]string, [][]any) {
+	t.Helper()
+
+	if !bytes.HasPrefix(file, []byte(magic)) || !bytes.HasSuffix(file, []byte(magic)) {
+		t.Fatalf("file doesn't start and end with %s", magic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &decoder{b: file[len(file)-8-footerLen : len(file)-8]}
+	meta := footer.structure()
+	if footer.err || len(footer.b) != 0 {
+		t.Fatalf("invalid footer")
+	}
+
+	schema := meta[2].([]any)
+	var names []string
+	var columns []Column
+	for _, e := range schema[1:] {
+		element := e.(tstruct)
+		c := Column{Name: element[4].(string), Nullable: element[3].(int64) == 1}
+		switch element[1].(int64) {
+		case physicalDouble:
+			c.Type = Double
+		case physicalByteArray:
+			c.Type = String
+		default:
+			c.Type = Int64
+			if element[6] != nil && element[6].(int64) == convertedTimestampMicros {
+				c.Type = Timestamp
+			}
+		}
+		names = append(names, c.Name)
+		columns = append(columns, c)
+	}
+
+	var rows [][]any
+	for _, g := range meta[4].([]any) {
+		group := g.(tstruct)
+		n := int(group[3].(int64))
+		groupRows := make([][]any, n)
+		for r := range groupRows {
+			groupRows[r] = make([]any, len(columns))
+		}
+
+		for i, chunk := range group[1].([]any) {
+			chunkMeta := chunk.(tstruct)[3].(tstruct)
+			page := &decoder{b: file[chunkMeta[9].(int64):]}
+			header := page.structure()
+			if page.err {
+				t.Fatalf("invalid page header of column %s", columns[i].Name)
+			}
+			body, err := snappy.Decode(nil, page.b[:header[3].(int64)])
+			if err != nil {
+				t.Fatalf("failed to decompress column %s: %v", columns[i].Name, err)
+			}
+			if int64(len(body)) != header[2].(int64) {
+				t.Fatalf("column %s: uncompressed size %d, header says %d", columns[i].Name, len(body), header[2])
+			}
+
+			defined := make([]bool, n)
+			for r := range defined {
+				defined[r] = true
+			}
+			if columns[i].Nullable {
+				levelsLen := binary.LittleEndian.Uint32(body)
+				levels := body[4 : 4+levelsLen]
+				body = body[4+levelsLen:]
+				for r := 0; len(levels) > 0; {
+					run, n := binary.Uvarint(levels)
+					value := levels[n] == 1
+					levels = levels[n+1:]
+					for j := 0; j < int(run>>1); j++ {
+						defined[r] = value
+						r++
+					}
+				}
+			}
+
+			for r := range groupRows {
+				if !defined[r] {
+					continue
+				}
+				switch columns[i].Type {
+				case Int64:
+					groupRows[r][i] = int64(binary.LittleEndian.Uint64(body))
+					body = body[8:]
+				case Double:
+					groupRows[r][i] = math.Float64frombits(binary.LittleEndian.Uint64(body))
+					body = body[8:]
+				case String:
+					l := binary.LittleEndian.Uint32(body)
+					groupRows[r][i] = string(body[4 : 4+l])
+					body = body[4+l:]
+				case Timestamp:
+					groupRows[r][i] = time.UnixMicro(int64(binary.LittleEndian.Uint64(body))).UTC()
+					body = body[8:]
+				}
+			}
+			if len(body) != 0 {
+				t.Fatalf("column %s: %d bytes left over", columns[i].Name, len(body))
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+
+	if int(meta[3].(int64)) != len(rows) {
+		t.Fatalf("footer says %d rows, row groups hold %d", meta[3], len(rows))
+	}
+	return names, rows
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "level", Type: Double},
+		{Name: "device_id", Type: String},
+		{Name: "created_at", Type: Timestamp},
+		{Name: "raw_level", Type: Double, Nullable: true},
+	}
+	at := time.Date(2026, 10, 16, 8, 30, 0, 123456000, time.UTC)
+	groups := [][][]any{
+		{
+			{int64(1), 120.5, "tank", at, 118.0},
+			{int64(2), 121.0, "tank", at.Add(time.Minute), nil},
+			{int64(3), -1.25, "zażółć", at.Add(2 * time.Minute), nil},
+		},
+		{
+			{int64(4), 0.0, "", at.Add(time.Hour), 3.5},
+		},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]any
+	for _, rows := range groups {
+		if err := w.WriteRowGroup(rows); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, rows...)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, rows := readFile(t, buf.Bytes())
+	if want := []string{"id", "level", "device_id", "created_at", "raw_level"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("columns = %v, want %v", names, want)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+}
+
+func TestWriterRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		row  []any
+	}{
+		{"null in required column", []any{nil}},
+		{"wrong type", []any{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewWriter(&bytes.Buffer{}, []Column{{Name: "id", Type: Int64}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteRowGroup([][]any{tt.row}); err == nil {
+				t.Error("WriteRowGroup succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEncodeLevels(t *testing.T) {
+	got := encodeLevels([]bool{true, true, false, true})
+	// Runs of 2 defined, 1 null and 1 defined, each a varint of count<<1 and the value
+	want := []byte{4, 1, 2, 0, 2, 1}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encodeLevels = %v, want %v", got, want)
+	}
+}
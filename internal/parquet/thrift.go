@@ -0,0 +1,105 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types
+const (
+	typeBooleanTrue  = 1
+	typeBooleanFalse = 2
+	typeI32          = 5
+	typeI64          = 6
+	typeBinary       = 8
+	typeList         = 9
+	typeStruct       = 12
+)
+
+// encoder encodes the Thrift structs of the Parquet metadata in the compact protocol.
+// Fields are written in ascending ID order, each struct begun with begin, element or
+// structField and ended with end.
+type encoder struct {
+	buf  bytes.Buffer
+	last []int16 // ID of the last field written in each open struct
+}
+
+// begin starts a top-level struct
+func (e *encoder) begin() {
+	e.last = append(e.last, 0)
+}
+
+// element starts a struct element of a list
+func (e *encoder) element() {
+	e.last = append(e.last, 0)
+}
+
+// structField starts a struct-typed field of the open struct
+func (e *encoder) structField(id int16) {
+	e.field(id, typeStruct)
+	e.last = append(e.last, 0)
+}
+
+// end ends the open struct
+func (e *encoder) end() {
+	e.buf.WriteByte(0)
+	e.last = e.last[:len(e.last)-1]
+}
+
+func (e *encoder) field(id int16, typ byte) {
+	last := &e.last[len(e.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		e.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		e.buf.WriteByte(typ)
+		e.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (e *encoder) i32(id int16, v int32) {
+	e.field(id, typeI32)
+	e.varint(zigzag(int64(v)))
+}
+
+func (e *encoder) i64(id int16, v int64) {
+	e.field(id, typeI64)
+	e.varint(zigzag(v))
+}
+
+func (e *encoder) boolean(id int16, v bool) {
+	if v {
+		e.field(id, typeBooleanTrue)
+	} else {
+		e.field(id, typeBooleanFalse)
+	}
+}
+
+func (e *encoder) binary(id int16, s string) {
+	e.field(id, typeBinary)
+	e.bytes([]byte(s))
+}
+
+// list starts a list field of n elements of the type, written next
+func (e *encoder) list(id int16, elem byte, n int) {
+	e.field(id, typeList)
+	if n < 15 {
+		e.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		e.buf.WriteByte(0xf0 | elem)
+		e.varint(uint64(n))
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.varint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) varint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,137 @@
+// Package pdf writes simple PDF documents: text in the standard Helvetica fonts, lines
+// and filled rectangles on A4 pages. It covers what the reports need without a dependency.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// Document is a PDF document of pages
+type Document struct {
+	pages []*Page
+}
+
+// Page is a page of a document. Coordinates are in points from the top left corner.
+type Page struct {
+	content bytes.Buffer
+}
+
+// New returns an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage appends a page to the document
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws the text with its baseline at x, y, in Helvetica or Helvetica-Bold
+func (p *Page) Text(x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(text))
+}
+
+// TextWidth returns the approximate width of the text, Helvetica averages about half the font size per character
+func TextWidth(text string, size float64) float64 {
+	return float64(len([]rune(text))) * size * 0.5
+}
+
+// Line draws a line of the width in the RGB color, components from 0 to 1
+func (p *Page) Line(x1, y1, x2, y2, width float64, r, g, b float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		r, g, b, width, x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Polyline draws connected lines through the points, given as x, y pairs
+func (p *Page) Polyline(points [][2]float64, width float64, r, g, b float64) {
+	if len(points) < 2 {
+		return
+	}
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f RG %.2f w %.2f %.2f m", r, g, b, width, points[0][0], PageHeight-points[0][1])
+	for _, pt := range points[1:] {
+		fmt.Fprintf(&p.content, " %.2f %.2f l", pt[0], PageHeight-pt[1])
+	}
+	p.content.WriteString(" S\n")
+}
+
+// Rect fills a rectangle with its top left corner at x, y in the RGB color
+func (p *Page) Rect(x, y, w, h float64, r, g, b float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f rg %.2f %.2f %.2f %.2f re f\n", r, g, b, x, PageHeight-y-h, w, h)
+}
+
+// escape encodes the text as a PDF string in WinAnsiEncoding, characters outside of
+// Latin-1 become "?"
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '–' || r == '—':
+			b.WriteByte('-')
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// WriteTo writes the document as a PDF file
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1 and 2 are the catalog and the page tree, 3 and 4 the fonts, then
+	// each page is followed by its content stream
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,101 @@
+package pdf
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// sample draws a bit of everything a report uses on two pages
+func sample() *Document {
+	d := New()
+	p := d.AddPage()
+	p.Text(50, 60, 16, true, "Incident report (tank \\ 1)")
+	p.Text(50, 80, 10, false, "Level 180 – critical, zażółć")
+	p.Line(50, 90, PageWidth-50, 90, 0.5, 0.6, 0.6, 0.6)
+	p.Polyline([][2]float64{{50, 200}, {100, 150}, {150, 175}}, 1, 0.2, 0.4, 0.8)
+	p.Polyline([][2]float64{{50, 200}}, 1, 0, 0, 0) // Too short, draws nothing
+	p.Rect(50, 220, 100, 20, 1, 0.9, 0.9)
+	d.AddPage().Text(50, 60, 10, false, "Page 2")
+	return d
+}
+
+func TestWriteToGolden(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := sample().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+
+	golden := filepath.Join("testdata", "sample.pdf")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("output differs from %s, run go test -update after checking the new output opens", golden)
+	}
+}
+
+// TestWriteToXref checks that every cross-reference entry points at its object and
+// startxref at the table, so readers can find the objects without repairing the file
+func TestWriteToXref(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := sample().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(out[xref:], -1)
+	// Catalog, page tree, two fonts and a page and its content per page
+	if len(entries) != 4+2*2 {
+		t.Fatalf("%d xref entries, want %d", len(entries), 4+2*2)
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if obj := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[offset:], []byte(obj)) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, out[offset:offset+len(obj)], obj)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`(a) \ b`, `\(a\) \\ b`},
+		{"a – b — c", "a - b - c"},
+		{"ó", `\363`},
+		{"ż€", "??"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package remoteread implements the server side of the Prometheus remote-read
+// protocol: snappy-compressed protobuf requests and sample responses.
+package remoteread
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ContentType is the media type of remote-read requests and responses
+const ContentType = "application/x-protobuf"
+
+// Version is the protocol version reported in the X-Prometheus-Remote-Read-Version header
+const Version = "0.1.0"
+
+// Label matcher types
+const (
+	MatchEqual = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// Matcher selects series by a label value
+type Matcher struct {
+	Type  int
+	Name  string
+	Value string
+}
+
+// Query asks for the samples of matching series within a time range
+type Query struct {
+	Start    time.Time
+	End      time.Time
+	Matchers []Matcher
+}
+
+// Sample is a single value of a series
+type Sample struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+// Series is a labelled sequence of samples, oldest first
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Matches reports whether a series with the given labels satisfies all matchers.
+// Missing labels match as empty strings, as in Prometheus.
+func (q Query) Matches(labels map[string]string) (bool, error) {
+	for _, m := range q.Matchers {
+		value := labels[m.Name]
+
+		var ok bool
+		switch m.Type {
+		case MatchEqual:
+			ok = value == m.Value
+		case MatchNotEqual:
+			ok = value != m.Value
+		case MatchRegexp, MatchNotRegexp:
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return false, fmt.Errorf("invalid regexp for label %s: %w", m.Name, err)
+			}
+			ok = re.MatchString(value) == (m.Type == MatchRegexp)
+		default:
+			return false, fmt.Errorf("unknown matcher type %d", m.Type)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// DecodeRequest parses a compressed ReadRequest into its queries
+func DecodeRequest(compressed []byte) ([]Query, error) {
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress request: %w", err)
+	}
+
+	var queries []Query
+	err = walk(b, func(num protowire.Number, value []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+
+		q, err := decodeQuery(value)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, q)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// decodeQuery parses a Query message
+func decodeQuery(b []byte) (Query, error) {
+	var q Query
+	err := walk(b, func(num protowire.Number, value []byte, v uint64) error {
+		switch num {
+		case 1:
+			q.Start = time.UnixMilli(int64(v)).UTC()
+		case 2:
+			q.End = time.UnixMilli(int64(v)).UTC()
+		case 3:
+			var m Matcher
+			err := walk(value, func(num protowire.Number, value []byte, v uint64) error {
+				switch num {
+				case 1:
+					m.Type = int(v)
+				case 2:
+					m.Name = string(value)
+				case 3:
+					m.Value = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			q.Matchers = append(q.Matchers, m)
+		}
+		return nil
+	})
+
+	return q, err
+}
+
+// walk calls fn for every field of a message with its bytes or varint value,
+// skipping fields of other wire types
+func walk(b []byte, fn func(num protowire.Number, value []byte, v uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("failed to decode request: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var value []byte
+		var v uint64
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("failed to decode request: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, value, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// EncodeResponse builds a compressed ReadResponse holding one result per query
+func EncodeResponse(results [][]Series) []byte {
+	var b []byte
+	for _, result := range results {
+		var qr []byte
+		for _, s := range result {
+			qr = protowire.AppendTag(qr, 1, protowire.BytesType)
+			qr = protowire.AppendBytes(qr, encodeSeries(s))
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, qr)
+	}
+
+	return snappy.Encode(nil, b)
+}
+
+// encodeSeries builds a TimeSeries message, labels sorted by name as Prometheus requires
+func encodeSeries(s Series) []byte {
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []byte
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, s.Labels[name])
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+
+	for _, sample := range s.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(sample.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(sample.Timestamp.UnixMilli()))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+
+	return b
+}
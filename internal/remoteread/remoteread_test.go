@@ -0,0 +1,221 @@
+package remoteread
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeRequest builds a compressed ReadRequest the way Prometheus sends one
+func encodeRequest(queries []Query) []byte {
+	var b []byte
+	for _, q := range queries {
+		var qb []byte
+		qb = protowire.AppendTag(qb, 1, protowire.VarintType)
+		qb = protowire.AppendVarint(qb, uint64(q.Start.UnixMilli()))
+		qb = protowire.AppendTag(qb, 2, protowire.VarintType)
+		qb = protowire.AppendVarint(qb, uint64(q.End.UnixMilli()))
+		for _, m := range q.Matchers {
+			var mb []byte
+			mb = protowire.AppendTag(mb, 1, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, uint64(m.Type))
+			mb = protowire.AppendTag(mb, 2, protowire.BytesType)
+			mb = protowire.AppendString(mb, m.Name)
+			mb = protowire.AppendTag(mb, 3, protowire.BytesType)
+			mb = protowire.AppendString(mb, m.Value)
+
+			qb = protowire.AppendTag(qb, 3, protowire.BytesType)
+			qb = protowire.AppendBytes(qb, mb)
+		}
+		// Read hints, which the server ignores
+		qb = protowire.AppendTag(qb, 4, protowire.BytesType)
+		qb = protowire.AppendBytes(qb, []byte{0x08, 0x01})
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, qb)
+	}
+	// Accepted response types, also ignored
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, 0)
+
+	return snappy.Encode(nil, b)
+}
+
+// decodeResponse parses a compressed ReadResponse the way Prometheus reads one
+func decodeResponse(t *testing.T, compressed []byte) [][]Series {
+	t.Helper()
+
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results [][]Series
+	err = walk(b, func(num protowire.Number, value []byte, _ uint64) error {
+		result := []Series{}
+		err := walk(value, func(num protowire.Number, value []byte, _ uint64) error {
+			s := Series{Labels: map[string]string{}}
+			err := walk(value, func(num protowire.Number, value []byte, _ uint64) error {
+				switch num {
+				case 1:
+					var name, labelValue string
+					walk(value, func(num protowire.Number, value []byte, _ uint64) error {
+						if num == 1 {
+							name = string(value)
+						} else {
+							labelValue = string(value)
+						}
+						return nil
+					})
+					s.Labels[name] = labelValue
+				case 2:
+					var sample Sample
+					for len(value) > 0 {
+						num, typ, n := protowire.ConsumeTag(value)
+						value = value[n:]
+						switch {
+						case num == 1 && typ == protowire.Fixed64Type:
+							v, n := protowire.ConsumeFixed64(value)
+							sample.Value = math.Float64frombits(v)
+							value = value[n:]
+						case num == 2 && typ == protowire.VarintType:
+							v, n := protowire.ConsumeVarint(value)
+							sample.Timestamp = time.UnixMilli(int64(v)).UTC()
+							value = value[n:]
+						default:
+							t.Fatalf("unexpected sample field %d of type %d", num, typ)
+						}
+					}
+					s.Samples = append(s.Samples, sample)
+				}
+				return nil
+			})
+			result = append(result, s)
+			return err
+		})
+		results = append(results, result)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestDecodeRequest(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	want := []Query{
+		{
+			Start: start,
+			End:   start.Add(time.Hour),
+			Matchers: []Matcher{
+				{Type: MatchEqual, Name: "__name__", Value: "septic_level"},
+				{Type: MatchRegexp, Name: "device_id", Value: "tank-.*"},
+			},
+		},
+		{
+			Start:    start.Add(-24 * time.Hour),
+			End:      start,
+			Matchers: []Matcher{{Type: MatchNotEqual, Name: "site", Value: ""}},
+		},
+	}
+
+	got, err := DecodeRequest(encodeRequest(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeRequest = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeRequestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"not snappy", []byte("not snappy")},
+		{"truncated protobuf", snappy.Encode(nil, []byte{0x0a, 0x05, 0x08})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeRequest(tt.body); err == nil {
+				t.Error("DecodeRequest succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEncodeResponseRoundTrip(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	want := [][]Series{
+		{
+			{
+				Labels: map[string]string{"__name__": "septic_level", "device_id": "tank", "site": "home"},
+				Samples: []Sample{
+					{Value: 120.5, Timestamp: at},
+					{Value: -0.25, Timestamp: at.Add(5 * time.Minute)},
+				},
+			},
+			{Labels: map[string]string{"__name__": "septic_level", "device_id": "empty"}},
+		},
+		{},
+	}
+
+	got := decodeResponse(t, EncodeResponse(want))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded response = %+v, want %+v", got, want)
+	}
+}
+
+func TestEncodeSeriesSortsLabels(t *testing.T) {
+	s := Series{Labels: map[string]string{"z": "1", "a": "2", "m": "3"}}
+	var names []string
+	walk(encodeSeries(s), func(num protowire.Number, value []byte, _ uint64) error {
+		walk(value, func(num protowire.Number, value []byte, _ uint64) error {
+			if num == 1 {
+				names = append(names, string(value))
+			}
+			return nil
+		})
+		return nil
+	})
+	if want := []string{"a", "m", "z"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("label order = %v, want %v", names, want)
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	labels := map[string]string{"__name__": "septic_level", "device_id": "tank-1"}
+	tests := []struct {
+		name    string
+		matcher Matcher
+		want    bool
+		wantErr bool
+	}{
+		{"equal", Matcher{MatchEqual, "device_id", "tank-1"}, true, false},
+		{"equal mismatch", Matcher{MatchEqual, "device_id", "tank-2"}, false, false},
+		{"not equal", Matcher{MatchNotEqual, "device_id", "tank-2"}, true, false},
+		{"regexp is anchored", Matcher{MatchRegexp, "device_id", "tank"}, false, false},
+		{"regexp", Matcher{MatchRegexp, "device_id", "tank-\\d"}, true, false},
+		{"not regexp", Matcher{MatchNotRegexp, "device_id", "tank-\\d"}, false, false},
+		{"missing label matches empty", Matcher{MatchEqual, "site", ""}, true, false},
+		{"invalid regexp", Matcher{MatchRegexp, "device_id", "("}, false, true},
+		{"unknown type", Matcher{9, "device_id", ""}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Query{Matchers: []Matcher{tt.matcher}}.Matches(labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Matches error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package sms
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,19 +10,39 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"time"
+
+	"sceptic-monitor/internal/notify"
 )
 
-func Send(message string) error {
-	// Get configuration from environment variables
-	apiKey := os.Getenv("SMS_API_KEY")
-	if apiKey == "" {
-		return fmt.Errorf("SMS_API_KEY not configured")
-	}
+// Notifier sends alert notifications by SMS through smsapi.pl
+type Notifier struct{}
+
+// Name returns the channel name
+func (Notifier) Name() string {
+	return "sms"
+}
 
+// Send sends the alert message to the recipient's phone number
+func (Notifier) Send(ctx context.Context, alert notify.Alert) (string, error) {
+	return SendTo(ctx, alert.Recipient, alert.Message)
+}
+
+// Send sends the message to the configured phone number and returns the provider message ID
+func Send(ctx context.Context, message string) (string, error) {
 	phoneNumber := os.Getenv("SMS_PHONE_NUMBER")
 	if phoneNumber == "" {
-		return fmt.Errorf("phone number not configured")
+		return "", fmt.Errorf("phone number not configured")
+	}
+
+	return SendTo(ctx, phoneNumber, message)
+}
+
+// SendTo sends the message to the given phone number and returns the provider message ID
+func SendTo(ctx context.Context, phoneNumber, message string) (string, error) {
+	// Get configuration from environment variables
+	apiKey := os.Getenv("SMS_API_KEY")
+	if apiKey == "" {
+		return "", fmt.Errorf("SMS_API_KEY not configured")
 	}
 
 	// Get sender name from environment, default to "Test" if not set
@@ -38,10 +59,15 @@ func Send(message string) error {
 	params.Set("from", senderName)
 	params.Set("format", "json")
 
+	// Ask smsapi.pl to post delivery reports back to us, if configured
+	if notifyURL := os.Getenv("SMS_NOTIFY_URL"); notifyURL != "" {
+		params.Set("notify_url", notifyURL)
+	}
+
 	// Create HTTP request
-	req, err := http.NewRequest("POST", apiURL, strings.NewReader(params.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(params.Encode()))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
@@ -49,22 +75,21 @@ func Send(message string) error {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Send request
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("SMS API returned status %d: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("SMS API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse JSON response to check for errors
@@ -84,14 +109,82 @@ func Send(message string) error {
 	}
 
 	if apiResponse.Error != 0 {
-		return fmt.Errorf("SMS API error %d: %s", apiResponse.Error, apiResponse.Message)
+		return "", fmt.Errorf("SMS API error %d: %s", apiResponse.Error, apiResponse.Message)
 	}
 
 	if len(apiResponse.List) > 0 {
 		log.Printf("SMS sent successfully. Message ID: %s, Points: %.2f", apiResponse.List[0].ID, apiResponse.List[0].Points)
-	} else {
-		log.Printf("SMS sent successfully. Response: %s", string(body))
+		return apiResponse.List[0].ID, nil
 	}
 
+	log.Printf("SMS sent successfully. Response: %s", string(body))
+	return "", nil
+}
+
+// Check verifies the API key by asking for the account balance
+func (Notifier) Check(ctx context.Context) error {
+	points, err := Balance(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("SMS account balance: %.2f points", points)
 	return nil
 }
+
+// Balance returns the points left on the smsapi.pl account
+func Balance(ctx context.Context) (float64, error) {
+	apiKey := os.Getenv("SMS_API_KEY")
+	if apiKey == "" {
+		return 0, fmt.Errorf("SMS_API_KEY not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.smsapi.pl/profile", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("SMS API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var profile struct {
+		Points float64 `json:"points"`
+	}
+	if err := json.Unmarshal(body, &profile); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return profile.Points, nil
+}
+
+// Delivery statuses reported by smsapi.pl delivery reports
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// DeliveryStatus maps an smsapi.pl delivery report status code to a delivery status
+func DeliveryStatus(code int) string {
+	switch code {
+	case 404: // DELIVERED
+		return StatusDelivered
+	case 401, 402, 405, 406, 407: // NOT_FOUND, EXPIRED, UNDELIVERED, FAILED, REJECTED
+		return StatusFailed
+	default: // SENT, UNKNOWN, QUEUE, ACCEPTED, RENEWAL, STOP
+		return StatusSent
+	}
+}
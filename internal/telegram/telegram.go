@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/notify"
+)
+
+// apiURL is the Telegram Bot API endpoint
+var apiURL = "https://api.telegram.org"
+
+// Notifier sends alert notifications to the recipient's Telegram chat, by default the
+// one in TELEGRAM_CHAT_ID
+type Notifier struct{}
+
+// Enabled reports whether a bot token and chat are configured
+func Enabled() bool {
+	return os.Getenv("TELEGRAM_BOT_TOKEN") != "" && os.Getenv("TELEGRAM_CHAT_ID") != ""
+}
+
+// Name returns the channel name
+func (Notifier) Name() string {
+	return "telegram"
+}
+
+// Send sends the alert message to the recipient's chat, or the configured one, and
+// returns the Telegram message ID
+func (Notifier) Send(ctx context.Context, alert notify.Alert) (string, error) {
+	chatID := alert.Recipient
+	if chatID == "" {
+		chatID = os.Getenv("TELEGRAM_CHAT_ID")
+	}
+	if chatID == "" {
+		return "", fmt.Errorf("TELEGRAM_CHAT_ID not configured")
+	}
+
+	return SendTo(ctx, chatID, alert.Message)
+}
+
+// Chat is the chat a message was sent in
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+// Message is a message received by the bot
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+// Update is an incoming update of the bot, only messages are of interest
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+// SendTo sends the text to the chat and returns the Telegram message ID
+func SendTo(ctx context.Context, chatID, text string) (string, error) {
+	var message Message
+	if err := call(ctx, "sendMessage", map[string]string{"chat_id": chatID, "text": text}, &message); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(message.MessageID, 10), nil
+}
+
+// GetUpdates long-polls for updates after offset, waiting up to timeout for one to arrive
+func GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]Update, error) {
+	params := map[string]any{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	}
+
+	var updates []Update
+	if err := call(ctx, "getUpdates", params, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// Check verifies the bot token with the no-op getMe method
+func (Notifier) Check(ctx context.Context) error {
+	if os.Getenv("TELEGRAM_CHAT_ID") == "" {
+		return fmt.Errorf("TELEGRAM_CHAT_ID not configured")
+	}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+	return call(ctx, "getMe", struct{}{}, &me)
+}
+
+// call calls a Bot API method and decodes its result into out
+func call(ctx context.Context, method string, params, out any) error {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return fmt.Errorf("TELEGRAM_BOT_TOKEN not configured")
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/bot"+token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL includes the token, keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var apiResponse struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &apiResponse); err != nil {
+		return fmt.Errorf("Telegram API returned status %d: %s", resp.StatusCode, string(data))
+	}
+	if !apiResponse.OK {
+		return fmt.Errorf("Telegram API error: %s", apiResponse.Description)
+	}
+
+	if err := json.Unmarshal(apiResponse.Result, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ttn
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Enabled reports whether The Things Network downlinks are configured
+func Enabled() bool {
+	return os.Getenv("TTN_API_KEY") != "" && os.Getenv("TTN_APP_ID") != ""
+}
+
+// QueueDownlink appends a downlink to the TTN queue of the device, it is sent
+// after the device's next uplink
+func QueueDownlink(ctx context.Context, deviceID string, port int, payload []byte) error {
+	apiKey := os.Getenv("TTN_API_KEY")
+	appID := os.Getenv("TTN_APP_ID")
+	if apiKey == "" || appID == "" {
+		return fmt.Errorf("TTN_API_KEY and TTN_APP_ID not configured")
+	}
+
+	// Get cluster URL from environment, default to the European cluster
+	apiURL := os.Getenv("TTN_API_URL")
+	if apiURL == "" {
+		apiURL = "https://eu1.cloud.thethings.network"
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"downlinks": []map[string]any{{
+			"f_port":      port,
+			"frm_payload": base64.StdEncoding.EncodeToString(payload),
+			"priority":    "NORMAL",
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode downlink: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v3/as/applications/%s/devices/%s/down/push",
+		apiURL, url.PathEscape(appID), url.PathEscape(deviceID))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("TTN API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
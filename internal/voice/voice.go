@@ -0,0 +1,105 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// PhoneNumbers returns the numbers to call, falling back to the SMS recipient
+func PhoneNumbers() []string {
+	numbers := os.Getenv("VOICE_PHONE_NUMBERS")
+	if numbers == "" {
+		numbers = os.Getenv("SMS_PHONE_NUMBER")
+	}
+
+	var result []string
+	for _, number := range strings.Split(numbers, ",") {
+		if number = strings.TrimSpace(number); number != "" {
+			result = append(result, number)
+		}
+	}
+	return result
+}
+
+// CallTo places a voice call to the given phone number reading the message via
+// text-to-speech (smsapi.pl VMS) and returns the provider message ID
+func CallTo(ctx context.Context, phoneNumber, message string) (string, error) {
+	// Voice calls go through the same smsapi.pl account as SMS
+	apiKey := os.Getenv("SMS_API_KEY")
+	if apiKey == "" {
+		return "", fmt.Errorf("SMS_API_KEY not configured")
+	}
+
+	// Prepare URL with parameters
+	apiURL := "https://api.smsapi.pl/vms.do"
+	params := url.Values{}
+	params.Set("to", phoneNumber)
+	params.Set("tts", message)
+	params.Set("format", "json")
+
+	// Optional TTS voice, e.g. "ewa" or "jacek"
+	if lector := os.Getenv("VOICE_LECTOR"); lector != "" {
+		params.Set("tts_lector", lector)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Send request
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("VMS API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse JSON response to check for errors
+	var apiResponse struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+		List    []struct {
+			ID     string  `json:"id"`
+			Points float64 `json:"points"`
+		} `json:"list"`
+	}
+
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		log.Printf("VMS API response: %s", string(body))
+	}
+
+	if apiResponse.Error != 0 {
+		return "", fmt.Errorf("VMS API error %d: %s", apiResponse.Error, apiResponse.Message)
+	}
+
+	if len(apiResponse.List) > 0 {
+		log.Printf("Voice call queued. Message ID: %s, Points: %.2f", apiResponse.List[0].ID, apiResponse.List[0].Points)
+		return apiResponse.List[0].ID, nil
+	}
+
+	log.Printf("Voice call queued. Response: %s", string(body))
+	return "", nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// defaultKioskCIDRs are the networks the kiosk is served to by default: loopback and
+// the private LAN ranges
+const defaultKioskCIDRs = "127.0.0.0/8,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,::1/128,fc00::/7"
+
+// kioskNetworks returns the networks the kiosk is served to from KIOSK_ALLOWED_CIDRS
+// (default: loopback and private ranges)
+func kioskNetworks() []*net.IPNet {
+	cidrs := os.Getenv("KIOSK_ALLOWED_CIDRS")
+	if cidrs == "" {
+		cidrs = defaultKioskCIDRs
+	}
+
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Printf("Invalid KIOSK_ALLOWED_CIDRS entry: %q", cidr)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// kioskAllowed reports whether the client is on one of the kiosk networks. The kiosk
+// has no login, it is only served on the LAN the display is on.
+func kioskAllowed(r *http.Request) bool {
+	ip := net.ParseIP(remoteHost(r))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range kioskNetworks() {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// kioskRefresh returns the seconds between reloads of the kiosk from KIOSK_REFRESH
+// (default: 30 seconds)
+func kioskRefresh() int {
+	if s := os.Getenv("KIOSK_REFRESH"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			return v
+		}
+		log.Printf("Invalid KIOSK_REFRESH value: %q, using default 30", s)
+	}
+	return 30
+}
+
+var kioskTemplate = template.Must(template.New("kiosk").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Septic tank</title>
+<style>
+html, body { height: 100%; margin: 0; }
+body { font-family: sans-serif; display: flex; flex-direction: column; align-items: center; justify-content: center; color: #fff; background: #2e7d32; cursor: none; }
+body.Warning { background: #f9a825; color: #000; }
+body.Critical { background: #c62828; animation: blink 1s step-start infinite; }
+@keyframes blink { 50% { background: #7f0000; } }
+.level { font-size: 28vh; font-weight: bold; line-height: 1; }
+.trend { font-size: 7vh; margin-top: 2vh; }
+.alarm { font-size: 9vh; margin-top: 3vh; text-transform: uppercase; }
+.alerts { font-size: 4vh; margin-top: 1vh; }
+.updated { font-size: 3vh; margin-top: 3vh; opacity: 0.8; }
+</style>
+</head>
+<body class="{{.Status}}">
+<div class="level">{{.Label}}</div>
+<div class="trend">{{.Trend}}</div>
+<div class="alarm">{{.Status}}</div>
+{{range .Alerts}}<div class="alerts">{{.}}</div>
+{{end}}<div class="updated">{{if .UpdatedAt}}Last reading {{.UpdatedAt.Local.Format "15:04, 2 Jan"}}{{else}}No readings yet{{end}}</div>
+</body>
+</html>
+`))
+
+// handleKiosk renders a full-screen view of the level, its trend and the alarm state
+// for a wall-mounted display, reloading itself every KIOSK_REFRESH seconds
+func handleKiosk(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !kioskAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	widget, err := currentWidget(ctx)
+	if err != nil {
+		log.Printf("Error getting kiosk data: %v", err)
+		http.Error(w, "Failed to get kiosk data", http.StatusInternalServerError)
+		return
+	}
+
+	label := "–"
+	switch {
+	case widget.Percent != nil:
+		label = strconv.FormatFloat(*widget.Percent, 'f', 0, 64) + "%"
+	case widget.Level != nil:
+		label = strconv.FormatFloat(*widget.Level, 'f', 1, 64)
+	}
+
+	// Trend of the device the level is of, over the risk window
+	trend := ""
+	if deviceID, err := latestDeviceID(ctx); err != nil {
+		log.Printf("Error getting latest device: %v", err)
+	} else if deviceID != "" {
+		if risk, err := computeRisk(ctx, deviceID); err == nil {
+			trend = "Steady"
+			if risk.FillRate > 0.05 {
+				trend = fmt.Sprintf("▲ Rising %.1f per day", risk.FillRate)
+			}
+			if risk.ETA != nil && risk.ETA.After(time.Now()) {
+				trend += ", threshold on " + risk.ETA.Local().Format("2 Jan")
+			}
+		}
+	}
+
+	alerts, err := db.GetActiveAlerts(ctx)
+	if err != nil {
+		log.Printf("Error getting active alerts: %v", err)
+	}
+	var alertLines []string
+	for _, alert := range alerts {
+		line := fmt.Sprintf("%s %s alert", alert.Severity, strings.ReplaceAll(alert.Kind, "_", " "))
+		if label := deviceLabel(ctx, alert.DeviceID); label != "" {
+			line += " – " + label
+		}
+		if alert.AcknowledgedAt != nil {
+			line += " (acknowledged)"
+		}
+		alertLines = append(alertLines, line)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	data := map[string]any{
+		"Refresh":   kioskRefresh(),
+		"Status":    widget.Status,
+		"Label":     label,
+		"Trend":     trend,
+		"Alerts":    alertLines,
+		"UpdatedAt": widget.UpdatedAt,
+	}
+	if err := kioskTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering kiosk: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// AlertLatency summarizes the delivery latencies of the alerts raised in a time range,
+// from the reading that raised each alert to the first notification accepted by a provider
+type AlertLatency struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Count    int       `json:"count"`
+	P50Ms    *int64    `json:"p50_ms"` // Null without alerts
+	P95Ms    *int64    `json:"p95_ms"`
+	MaxMs    *int64    `json:"max_ms"`
+	SLOMs    *int64    `json:"slo_ms"`   // ALERT_LATENCY_SLO, null if not configured
+	Breaches int       `json:"breaches"` // Alerts slower than the SLO
+}
+
+// latencySLO returns the delivery latency objective from ALERT_LATENCY_SLO in seconds,
+// or false if none is configured
+func latencySLO() (time.Duration, bool) {
+	seconds, ok := envLevel("ALERT_LATENCY_SLO")
+	if !ok || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// measureLatency wraps the deliveries of a notification round so the time from the
+// reading at receivedAt to the first delivery accepted by a provider is recorded
+// on the alert and checked against the latency objective
+func measureLatency(alert db.Alert, receivedAt time.Time, deliveries []delivery) []delivery {
+	var once sync.Once
+	for i := range deliveries {
+		send := deliveries[i].send
+		deliveries[i].send = func(ctx context.Context) error {
+			if err := send(ctx); err != nil {
+				return err
+			}
+
+			once.Do(func() { recordLatency(ctx, alert, time.Since(receivedAt)) })
+			return nil
+		}
+	}
+	return deliveries
+}
+
+// recordLatency records the delivery latency of a notification round of the alert and
+// raises a latency alarm while it exceeds ALERT_LATENCY_SLO
+func recordLatency(ctx context.Context, alert db.Alert, latency time.Duration) {
+	log.Printf("Alert %s delivered %v after the reading", alert.ID, latency.Round(time.Millisecond))
+
+	if err := db.SetAlertLatency(ctx, alert.ID, latency); err != nil {
+		log.Printf("Error recording alert latency: %v", err)
+	}
+
+	slo, ok := latencySLO()
+	if !ok {
+		return
+	}
+	if latency > slo {
+		log.Printf("Alert %s latency %v exceeds the objective of %v", alert.ID, latency.Round(time.Millisecond), slo)
+	}
+	goBackground(func() { updateHardLimit(alert.DeviceID, db.AlertKindLatency, latency > slo) })
+}
+
+// handleGetAlertLatency serves the delivery latency percentiles of the alerts raised in
+// the from and to query parameters (default: the last 30 days)
+func handleGetAlertLatency(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -30)
+	}
+
+	latencies, err := db.GetAlertLatencies(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error getting alert latencies: %v", err)
+		http.Error(w, "Failed to get alert latencies", http.StatusInternalServerError)
+		return
+	}
+
+	summary := AlertLatency{From: from, To: to, Count: len(latencies)}
+	if len(latencies) > 0 {
+		summary.P50Ms = latencyPercentile(latencies, 50)
+		summary.P95Ms = latencyPercentile(latencies, 95)
+		summary.MaxMs = &latencies[len(latencies)-1]
+	}
+	if slo, ok := latencySLO(); ok {
+		ms := slo.Milliseconds()
+		summary.SLOMs = &ms
+		for _, l := range latencies {
+			if l > ms {
+				summary.Breaches++
+			}
+		}
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// latencyPercentile returns the nearest-rank percentile of the sorted latencies
+func latencyPercentile(sorted []int64, p int) *int64 {
+	rank := (p*len(sorted) + 99) / 100
+	return &sorted[max(rank, 1)-1]
+}
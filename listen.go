@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenAddr builds the HOST:PORT address to listen on. PORT may be a bare
+// port ("8080"), a port with a leading colon (":8080") or a full address
+// ("127.0.0.1:8080"), HOST sets the interface when PORT doesn't include one.
+func listenAddr() (string, error) {
+	host := os.Getenv("HOST")
+	port := strings.TrimSpace(os.Getenv("PORT"))
+	if port == "" {
+		port = "8080"
+	}
+
+	if strings.Contains(port, ":") {
+		h, p, err := net.SplitHostPort(port)
+		if err != nil {
+			return "", fmt.Errorf("invalid PORT value %q: %w", port, err)
+		}
+		if h != "" {
+			host = h
+		}
+		port = p
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid PORT value %q: expected a port number between 1 and 65535", port)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// displayHost returns the host to show in the startup message for a listen address
+func displayHost(addr string) string {
+	host, _, _ := net.SplitHostPort(addr)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		return "localhost"
+	}
+	return host
+}
@@ -1,24 +1,42 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
 	"time"
 
 	"sceptic-monitor/internal/db"
-	"sceptic-monitor/internal/sms"
+	"sceptic-monitor/internal/influx"
+	"sceptic-monitor/internal/mqtt"
 
 	"github.com/joho/godotenv"
 )
 
+// defaultDeviceID is used for readings that don't name a device
+const defaultDeviceID = "default"
+
 // Request represents the incoming POST request body
 type Request struct {
-	Level float64 `json:"level"`
+	Level       float64    `json:"level"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"` // Device clock time of the reading
+	DeviceID    string     `json:"device_id,omitempty"`
+	Battery     *float64   `json:"battery,omitempty"`
+	RSSI        *float64   `json:"rssi,omitempty"`
+	Temperature *float64   `json:"temperature,omitempty"` // °C at the sensor
+	Firmware    string     `json:"firmware,omitempty"`
+	Error       string     `json:"error,omitempty"`     // Sensor fault reported by the device instead of a reading
+	Simulated   bool       `json:"simulated,omitempty"` // Test reading, stored but never alerted on
+	Overflow    *bool      `json:"overflow,omitempty"`  // Leak sensor state reported instead of a reading
+
+	// Provenance of the request, set by the transport it came in on
+	Transport string `json:"-"`
+	Source    string `json:"-"`
 }
 
 // Response represents the API response
@@ -27,100 +45,186 @@ type Response struct {
 	Message string `json:"message"`
 }
 
-var (
-	lastNotifiedAt  time.Time
-	notificationMux sync.Mutex
-)
-
-// checkAndNotify checks if level threshold is reached and sends SMS if needed
-func checkAndNotify(level float64) {
-	notificationMux.Lock()
-	defer notificationMux.Unlock()
-
-	// Get threshold from environment
-	thresholdStr := os.Getenv("LEVEL_THRESHOLD")
-	if thresholdStr == "" {
-		return // No threshold configured
+func handleSaveLevelData(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	threshold, err := strconv.ParseFloat(thresholdStr, 64)
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Parse request body
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Invalid LEVEL_THRESHOLD value: %v", err)
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
 		return
 	}
 
-	// Check if level has reached or exceeded threshold
-	if level < threshold {
-		return // Level below threshold, no notification needed
+	// Translate the field names of third-party sensor firmwares, if configured
+	if fields := ingestFieldMap(); fields != nil {
+		if body, err = remapFields(body, fields); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid payload: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
 	}
+	req.Transport, req.Source = db.TransportHTTP, remoteHost(r)
 
-	// Get cooldown period from environment in minutes (default: 60 minutes = 1 hour)
-	cooldownMinutesStr := os.Getenv("SMS_COOLDOWN")
-	if cooldownMinutesStr == "" {
-		cooldownMinutesStr = "60" // Default to 60 minutes (1 hour)
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = defaultDeviceID
+	}
+	if !authorizeIngest(w, r, deviceID) {
+		return
 	}
 
-	cooldownMinutes, err := strconv.Atoi(cooldownMinutesStr)
+	response, err := saveRequest(r.Context(), req)
 	if err != nil {
-		log.Printf("Invalid SMS_COOLDOWN value: %v, using default 60 minutes", err)
-		cooldownMinutes = 60
+		log.Printf("Error saving to database: %v", err)
+		http.Error(w, "Failed to save data", http.StatusInternalServerError)
+		return
 	}
 
-	cooldown := time.Duration(cooldownMinutes) * time.Minute
+	// Send response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Prevent duplicate notifications within cooldown period
-	if time.Since(lastNotifiedAt) < cooldown {
-		log.Printf("Notification already sent recently, skipping (level: %.2f, threshold: %.2f, cooldown: %v)", level, threshold, cooldown)
-		return
+// saveRequest stores a reading request, or the error or overflow state reported instead,
+// whichever transport it came in on. LTE modems retrying at the TCP layer may post the
+// same reading twice, and MQTT may deliver it twice: identical requests within
+// DUPLICATE_POST_WINDOW seconds are answered with the original response.
+func saveRequest(ctx context.Context, req Request) (*Response, error) {
+	if req.DeviceID == "" {
+		req.DeviceID = defaultDeviceID
 	}
 
-	// Send SMS notification
-	message := fmt.Sprintf("Alert: Level %.2f has reached the threshold of %.2f", level, threshold)
-	if err := sms.Send(message); err != nil {
-		log.Printf("Error sending SMS notification: %v", err)
-		return
+	if window := envSeconds("DUPLICATE_POST_WINDOW", 0); window > 0 && req.Error == "" && req.Overflow == nil {
+		key := duplicatePostKey(req)
+		original, post := beginPost(key, window)
+		if original != nil {
+			log.Printf("Duplicate reading from %s over %s (%s) within %v, not stored again", req.DeviceID, req.Transport, req.Source, window)
+			return original, nil
+		}
+
+		response, err := processRequest(ctx, req)
+		finishPost(key, post, response)
+		return response, err
 	}
 
-	lastNotifiedAt = time.Now()
-	log.Printf("SMS notification sent: level %.2f reached threshold %.2f", level, threshold)
+	return processRequest(ctx, req)
 }
 
-func handleSaveLevelData(w http.ResponseWriter, r *http.Request) {
-	// Only allow POST method
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// processRequest stores a reading request, see saveRequest
+func processRequest(ctx context.Context, req Request) (*Response, error) {
+	// Keep the device inventory up to date
+	if err := db.TouchDevice(ctx, req.DeviceID, req.Firmware); err != nil {
+		log.Printf("Error updating device: %v", err)
 	}
 
-	// Set content type
-	w.Header().Set("Content-Type", "application/json")
+	// A device reporting a sensor fault has no usable reading
+	if req.Error != "" {
+		log.Printf("Device %s reported an error: %s", req.DeviceID, req.Error)
+		if err := db.RecordDeviceError(ctx, req.DeviceID, req.Error); err != nil {
+			log.Printf("Error recording device error: %v", err)
+		}
+
+		return &Response{
+			Status:  "success",
+			Message: "Device error recorded",
+		}, nil
+	}
 
-	// Parse request body
-	var req Request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+	// A leak sensor at the tank reports overflows rather than levels
+	if req.Overflow != nil {
+		updateHardLimit(req.DeviceID, db.AlertKindOverflow, *req.Overflow)
+
+		return &Response{
+			Status:  "success",
+			Message: "Overflow state recorded",
+		}, nil
 	}
 
 	// Save to database
-	if err := db.SaveLevelData(req.Level); err != nil {
-		log.Printf("Error saving to database: %v", err)
-		http.Error(w, "Failed to save data", http.StatusInternalServerError)
-		return
+	reading := db.Reading{Level: req.Level, Timestamp: time.Now(), Transport: req.Transport, Source: req.Source}
+	if req.Timestamp != nil {
+		reading.RawTimestamp = req.Timestamp
+		reading.Timestamp = correctClockSkew(ctx, req.DeviceID, *req.Timestamp, time.Now())
 	}
 
-	// Check if level threshold is reached and send SMS notification
-	go checkAndNotify(req.Level)
+	// Save optional device telemetry alongside the reading, before ingesting it as
+	// the temperature compensation uses the temperature
+	saveTelemetry(ctx, req)
+
+	stored, err := ingestReading(ctx, req.DeviceID, reading, req.Simulated)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create response
-	response := Response{
+	response := &Response{
 		Status:  "success",
 		Message: fmt.Sprintf("Received and saved: %f", req.Level),
 	}
+	if !stored {
+		response.Message = fmt.Sprintf("Received unchanged level, not stored: %f", req.Level)
+	}
 
-	// Send response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	return response, nil
+}
+
+// ingestReading runs a reading through the ingest filters, stores it and checks it
+// against the alert thresholds. It reports whether the reading was stored, the
+// storage policy may skip readings that don't add information.
+func ingestReading(ctx context.Context, deviceID string, reading db.Reading, simulated bool) (bool, error) {
+	receivedAt := time.Now()
+	reading = prepareReading(ctx, deviceID, reading, simulated)
+
+	// Sensors reporting at a fixed rate mostly repeat themselves, the storage
+	// policy keeps only the changes while still alerting on every reading
+	redundant := db.IsTrustedQuality(reading.Quality) && isRedundantReading(deviceID, reading)
+	if !redundant {
+		if err := db.SaveReading(ctx, deviceID, reading); err != nil {
+			if err := db.RecordDeviceError(ctx, deviceID, err.Error()); err != nil {
+				log.Printf("Error recording device error: %v", err)
+			}
+			return false, err
+		}
+		logComplianceReadings(ctx, deviceID, reading)
+		announceReading(deviceID, reading)
+	}
+
+	// Check if level threshold is reached and send SMS notification
+	if db.IsTrustedQuality(reading.Quality) {
+		goBackground(func() { checkAndNotify(deviceID, reading.Level, receivedAt) })
+		goBackground(func() { checkRisk(deviceID) })
+		goBackground(func() { checkVacationRise(deviceID, reading.Level) })
+		detectPumpOut(ctx, deviceID, reading)
+	}
+
+	return !redundant, nil
+}
+
+// prepareReading runs a reading through the ingest filters: temperature compensation,
+// calibration and the quality flag
+func prepareReading(ctx context.Context, deviceID string, reading db.Reading, simulated bool) db.Reading {
+	reading = compensateTemperature(ctx, deviceID, reading)
+	reading = calibrate(deviceCalibration(ctx, deviceID), reading)
+	reading.Quality = readingQuality(reading, simulated)
+	return reading
+}
+
+// announceReading publishes a stored reading on MQTT and the live stream
+func announceReading(deviceID string, reading db.Reading) {
+	goBackground(func() { publishReading(deviceID, reading) })
+	broadcastStream(streamReading, deviceID, readingEvent(deviceID, reading))
 }
 
 func handleGetLevelData(w http.ResponseWriter, r *http.Request) {
@@ -130,8 +234,14 @@ func handleGetLevelData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get latest level data
-	levelData, err := db.GetLatestLevelData()
+	// Get latest level data, of a single device if given as device=<id>
+	var levelData float64
+	var err error
+	if deviceID := r.URL.Query().Get("device"); deviceID != "" {
+		levelData, err = db.GetDeviceLevel(r.Context(), deviceID)
+	} else {
+		levelData, err = db.GetLatestLevelData(r.Context())
+	}
 	if err != nil {
 		log.Printf("Error getting level data: %v", err)
 		http.Error(w, "Failed to get level data", http.StatusInternalServerError)
@@ -143,15 +253,33 @@ func handleGetLevelData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(levelData)
 }
 
+// subcommands are the commands run instead of the server, by name
+var subcommands = map[string]func(args []string) error{
+	"simulate-scenario": runSimulateScenario,
+	"backup":            runBackup,
+	"restore":           runRestore,
+	"analyze-export":    runAnalyzeExport,
+}
+
 func main() {
+	// Subcommands run against a server or its database instead of starting one
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found.")
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	addr, err := listenAddr()
+	if err != nil {
+		log.Fatalf("Failed to configure listen address: %v", err)
 	}
 
 	// Initialize database
@@ -160,15 +288,164 @@ func main() {
 	}
 	defer db.Close()
 
+	// Optionally mirror the readings to InfluxDB, e.g. for Grafana
+	if influx.Enabled() {
+		db.SetStore(influx.NewStore(db.GetStore()))
+		log.Printf("Mirroring readings to InfluxDB at %s", os.Getenv("INFLUXDB_URL"))
+	}
+
+	// Settings changed through the API override the environment
+	if err := loadSettings(context.Background()); err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+
+	// Tell readings lost in a power cut, and bound the WAL from now on
+	checkDatabase(context.Background())
+	db.StartCheckpoints()
+
 	// Register the POST endpoint
-	http.HandleFunc("/api", handleSaveLevelData)
-	http.HandleFunc("/api/level", handleGetLevelData)
+	http.HandleFunc("/api", countIngest(db.TransportHTTP, handleSaveLevelData))
+	http.HandleFunc("/api/admin/backup", requireAdmin(handleBackup))
+	http.HandleFunc("/api/admin/db", requireAdmin(handleGetDBStats))
+	http.HandleFunc("/api/admin/db/compact", requireAdmin(handleCompactDB))
+	http.HandleFunc("/api/admin/ingest-stats", requireAdmin(handleGetIngestStats))
+	http.HandleFunc("/api/admin/recalibrate", requireAdmin(handleRecalibrate))
+	http.HandleFunc("/api/admin/restore", requireAdmin(handleRestore))
+	http.HandleFunc("/api/history", requireReadAuth(cacheable(handleGetHistory)))
+	http.HandleFunc("/api/provenance", requireReadAuth(handleGetProvenance))
+	http.HandleFunc("/api/level", requireReadAuth(handleGetLevelData))
+	http.HandleFunc("/api/alerts", requireReadAuth(handleGetAlerts))
+	http.HandleFunc("/api/analytics/seasonal", requireReadAuth(cacheable(handleGetSeasonal)))
+	http.HandleFunc("/api/alerts/{id}/ack", requireAdmin(handleAcknowledgeAlert))
+	http.HandleFunc("/api/alerts/latency", requireReadAuth(handleGetAlertLatency))
+	http.HandleFunc("/api/compare", requireReadAuth(cacheable(handleGetComparison)))
+	http.HandleFunc("/api/compliance/export", requireReadAuth(handleComplianceExport))
+	http.HandleFunc("/api/compliance/verify", requireReadAuth(handleComplianceVerify))
+	http.HandleFunc("/api/config", requireReadOrAdmin(handleConfig))
+	http.HandleFunc("/api/devices", requireReadAuth(handleGetDevices))
+	http.HandleFunc("/api/devices/{id}", requireReadOrAdmin(handleDevice))
+	http.HandleFunc("/api/devices/{id}/backlog", countIngest(db.TransportBacklog, handleUploadBacklog))
+	http.HandleFunc("/api/devices/{id}/calibration", requireReadOrAdmin(handleCalibration))
+	http.HandleFunc("/api/devices/{id}/calibration/session", requireReadOrAdmin(handleCalibrationSession))
+	http.HandleFunc("/api/devices/{id}/calibration/session/complete", requireAdmin(handleCompleteCalibrationSession))
+	http.HandleFunc("/api/devices/{id}/calibration/session/points", requireAdmin(handleAddCalibrationPoint))
+	http.HandleFunc("/api/devices/{id}/temperature-compensation", requireReadOrAdmin(handleTemperatureCompensation))
+	http.HandleFunc("/api/devices/{id}/config", handleDeviceConfig)
+	http.HandleFunc("/api/devices/{id}/downlinks", requireReadAuth(handleGetDownlinks))
+	http.HandleFunc("/api/devices/{id}/telemetry", requireReadAuth(handleGetTelemetry))
+	http.HandleFunc("/api/devices/{id}/tokens", requireAdmin(handleDeviceTokens))
+	http.HandleFunc("/api/devices/{id}/tokens/{token}", requireAdmin(handleRevokeDeviceToken))
+	http.HandleFunc("/api/events", requireReadAuth(handleEvents))
+	http.HandleFunc("/api/guest-links", requireAdmin(handleGuestLinks))
+	http.HandleFunc("/api/guest-links/{id}", requireAdmin(handleDeleteGuestLink))
+	http.HandleFunc("/api/gauge", requireReadAuth(handleWidgetJSON))
+	http.HandleFunc("/api/pumpouts", requireReadOrAdmin(handlePumpOuts))
+	http.HandleFunc("/api/reports/incident.pdf", requireReadAuth(handleIncidentReport))
+	http.HandleFunc("/api/risk", requireReadAuth(handleGetRisk))
+	http.HandleFunc("/api/schema", handleGetSchemas)
+	http.HandleFunc("/api/schema/{name}", handleGetSchema)
+	http.HandleFunc("/api/recipients", requireAdmin(handleGetRecipients))
+	http.HandleFunc("/api/recipients/{id}", requireAdmin(handleRecipient))
+	http.HandleFunc("/api/sites", requireReadAuth(handleGetSites))
+	http.HandleFunc("/api/sites/{id}", requireReadOrAdmin(handleSite))
+	http.HandleFunc("/api/sites/{id}/devices/{device}", requireAdmin(handleAssignDeviceSite))
+	http.HandleFunc("/api/notifications/health", requireReadAuth(handleGetChannelHealth))
+	http.HandleFunc("/api/sms/budget", requireReadAuth(handleGetSMSBudget))
+	http.HandleFunc("/api/sms/dlr", requireSMSCallbackToken(handleSMSDeliveryReport))
+	http.HandleFunc("/api/sms/inbound", requireSMSCallbackToken(handleInboundSMS))
+	http.HandleFunc("/api/stats", requireReadAuth(cacheable(handleGetStats)))
+	http.HandleFunc("/api/stream", requireReadAuth(handleStream))
+	http.HandleFunc("/api/triggers/alerts", requireReadAuth(handleAlertTrigger))
+	http.HandleFunc("/api/triggers/pumpouts", requireReadAuth(handlePumpOutTrigger))
+	http.HandleFunc("/api/usage", requireReadAuth(cacheable(handleGetUsage)))
+	http.HandleFunc("/api/v1/read", requireReadAuth(handleRemoteRead))
+	http.HandleFunc("/api/vacation", requireReadOrAdmin(handleVacation))
+	http.HandleFunc("/api/webhooks", requireAdmin(handleWebhooks))
+	http.HandleFunc("/api/webhooks/{id}", requireAdmin(handleDeleteWebhook))
+	http.HandleFunc("/api/webhooks/{id}/deliveries", requireAdmin(handleGetWebhookDeliveries))
+	http.HandleFunc("/api/webhooks/{id}/deliveries/{n}/redeliver", requireAdmin(handleRedeliverWebhook))
+	http.HandleFunc("/compare", requireReadAuth(handleCompare))
+	http.HandleFunc("/guest/{token}", handleGuest)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+
+	// Dashboard web UI at /, built into the binary
+	http.HandleFunc("/", requireReadAuth(dashboardHandler().ServeHTTP))
+
+	// Optional public status page, e.g. for a holiday-rental guest info page
+	if os.Getenv("STATUS_PAGE_ENABLED") == "true" {
+		http.HandleFunc("/status", handleStatusPage)
+		http.HandleFunc("/status.json", handleStatusJSON)
+	}
+
+	// Optional embeddable level widget, e.g. for a home dashboard
+	if os.Getenv("WIDGET_ENABLED") == "true" {
+		http.HandleFunc("/widget", handleWidget)
+		http.HandleFunc("/widget.json", handleWidgetJSON)
+	}
+
+	// Optional full-screen kiosk view, e.g. for a wall-mounted display on the LAN
+	if os.Getenv("KIOSK_ENABLED") == "true" {
+		http.HandleFunc("/kiosk", handleKiosk)
+	}
+
+	if os.Getenv("ADMIN_API_KEY") == "" {
+		log.Println("ADMIN_API_KEY not set, the admin endpoints are disabled")
+	}
+
+	if os.Getenv("SMS_NOTIFY_URL") != "" && os.Getenv("SMS_CALLBACK_TOKEN") == "" {
+		log.Println("SMS_CALLBACK_TOKEN not set, SMS delivery reports and commands are rejected")
+	}
+
+	if readAuthConfigured() {
+		log.Println("Dashboard and read endpoints require credentials")
+	}
+
+	if len(ingestKeys()) == 0 && !ingestTokensRequired(context.Background()) {
+		log.Println("Neither INGEST_API_KEYS nor device tokens set, readings are accepted without an API key")
+	}
+
+	// Optional read-only SQL for ad-hoc analytics on the device itself
+	if sqlQueryEnabled() {
+		http.HandleFunc("/api/admin/query", requireAdmin(handleSQLQuery))
+	}
+
+	// Optional generic webhook for services posting their own JSON payloads
+	if ingestWebhookEnabled() {
+		http.HandleFunc("/api/ingest/webhook", countIngest(db.TransportWebhook, handleIngestWebhook))
+	}
+
+	// Optional inputs and MQTT integrations
+	startFloatSwitch()
+	startPumpOutReminders()
+	startStaleWatchdog()
+	startRetention()
+	startTelegramBot()
+	startSelfTest()
+	startChannelChecks()
+	startGuestCleanup()
+	if mqtt.Enabled() {
+		subscribeMQTTReadings()
+		subscribeZigbee2MQTT()
+		startHADiscovery()
+		if prefix := mqttPublishPrefix(); prefix != "" {
+			log.Printf("Publishing readings and alerts to MQTT under %s/", prefix)
+		}
+		mqtt.Connect()
+	}
 
 	// Start server
-	fmt.Printf("Server starting on port :%s\n", port)
-	fmt.Printf("POST endpoint available at: http://localhost:%s/api\n", port)
+	fmt.Printf("Server starting on %s\n", addr)
+	_, port, _ := net.SplitHostPort(addr)
+	scheme := "http"
+	if tlsMode() != "" {
+		scheme = "https"
+	}
+	fmt.Printf("POST endpoint available at: %s://%s/api\n", scheme, net.JoinHostPort(displayHost(addr), port))
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := runServer(addr); err != nil {
+		db.Close()
 		log.Fatal(err)
 	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/mqtt"
+	"sceptic-monitor/internal/webhook"
+)
+
+// ReadingEvent is the payload of the readings published to MQTT and the live streams
+type ReadingEvent struct {
+	DeviceID  string    `json:"device_id"`
+	Level     float64   `json:"level"`
+	Quality   string    `json:"quality"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// readingEvent returns the event of a stored reading
+func readingEvent(deviceID string, reading db.Reading) ReadingEvent {
+	return ReadingEvent{
+		DeviceID:  deviceID,
+		Level:     reading.Level,
+		Quality:   reading.Quality,
+		Timestamp: reading.Timestamp.UTC(),
+	}
+}
+
+// mqttPublishPrefix returns MQTT_PUBLISH_PREFIX, the topic prefix readings and alerts
+// are published under, or "" if publishing is disabled
+func mqttPublishPrefix() string {
+	if !mqtt.Enabled() {
+		return ""
+	}
+	return strings.TrimSuffix(os.Getenv("MQTT_PUBLISH_PREFIX"), "/")
+}
+
+// mqttTopic returns the topic of a device's readings ("level") or alerts ("alert")
+func mqttTopic(prefix, deviceID, kind string) string {
+	return prefix + "/" + deviceID + "/" + kind
+}
+
+// publishesOwnReadings reports whether readings published under the prefix would be
+// read back in through MQTT_LEVEL_TOPIC
+func publishesOwnReadings(prefix, deviceID string) bool {
+	filter := os.Getenv("MQTT_LEVEL_TOPIC")
+	return filter != "" && mqtt.Matches(filter, mqttTopic(prefix, deviceID, "level"))
+}
+
+// publishReading publishes a stored reading to <prefix>/<device>/level, retained so
+// dashboards get the latest level as soon as they subscribe
+func publishReading(deviceID string, reading db.Reading) {
+	prefix := mqttPublishPrefix()
+	if prefix == "" || publishesOwnReadings(prefix, deviceID) {
+		return
+	}
+	announceHADevice(deviceID)
+
+	payload, err := json.Marshal(readingEvent(deviceID, reading))
+	if err != nil {
+		log.Printf("Error encoding MQTT reading: %v", err)
+		return
+	}
+
+	if err := mqtt.Publish(mqttTopic(prefix, deviceID, "level"), payload, true); err != nil {
+		log.Printf("Error publishing reading of device %s to MQTT: %v", deviceID, err)
+	}
+}
+
+// publishAlertEvent publishes an alert event, in the webhook payload format, to
+// <prefix>/<device>/alert, retained so the latest state of the alerts is kept
+func publishAlertEvent(event webhook.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return mqtt.Publish(mqttTopic(mqttPublishPrefix(), event.DeviceID, "alert"), payload, true)
+}
+
+// mqttDeliveries returns a delivery of the event to MQTT, none if publishing is disabled
+func mqttDeliveries(event webhook.Event) []delivery {
+	if mqttPublishPrefix() == "" {
+		return nil
+	}
+
+	return []delivery{{
+		channel: "mqtt",
+		send: func(ctx context.Context) error {
+			return publishAlertEvent(event)
+		},
+	}}
+}
+
+// publishResolved publishes the resolved event of the alert to MQTT
+func publishResolved(ctx context.Context, alert db.Alert, reason string) {
+	if mqttPublishPrefix() == "" {
+		return
+	}
+
+	if err := publishAlertEvent(alertEvent(ctx, webhook.EventResolved, alert, "Resolved: "+reason)); err != nil {
+		log.Printf("Error publishing resolved event of alert %s to MQTT: %v", alert.ID, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/remoteread"
+)
+
+// Metric names of the series served over remote-read
+const (
+	promLevelMetric     = "septic_level"
+	promTelemetryPrefix = "septic_"
+)
+
+// handleRemoteRead serves historical readings and telemetry over the Prometheus
+// remote-read protocol, so the server can be added as a remote-read endpoint
+func handleRemoteRead(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	queries, err := remoteread.DecodeRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := db.GetDevices(r.Context())
+	if err != nil {
+		log.Printf("Error getting devices: %v", err)
+		http.Error(w, "Failed to get devices", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([][]remoteread.Series, 0, len(queries))
+	for _, q := range queries {
+		series, err := remoteReadSeries(r.Context(), q, devices)
+		if err != nil {
+			log.Printf("Error answering remote-read query: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results = append(results, series)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", remoteread.ContentType)
+	w.Header().Set("Content-Encoding", "snappy")
+	w.Header().Set("X-Prometheus-Remote-Read-Version", remoteread.Version)
+	w.WriteHeader(http.StatusOK)
+	w.Write(remoteread.EncodeResponse(results))
+}
+
+// remoteReadSeries loads the series matching a query. Readings are exposed as
+// septic_level and device telemetry as septic_<metric>{device="<id>"}.
+func remoteReadSeries(ctx context.Context, q remoteread.Query, devices []db.Device) ([]remoteread.Series, error) {
+	series := []remoteread.Series{}
+
+	labels := map[string]string{"__name__": promLevelMetric}
+	ok, err := q.Matches(labels)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		readings, err := db.GetReadings(ctx, q.Start, q.End)
+		if err != nil {
+			return nil, err
+		}
+
+		s := remoteread.Series{Labels: labels}
+		for _, reading := range readings {
+			s.Samples = append(s.Samples, remoteread.Sample{Value: reading.Level, Timestamp: reading.Timestamp})
+		}
+		if len(s.Samples) > 0 {
+			series = append(series, s)
+		}
+	}
+
+	for _, device := range devices {
+		for _, metric := range []string{metricBattery, metricRSSI, metricTemperature} {
+			labels := map[string]string{"__name__": promTelemetryPrefix + metric, "device": device.ID}
+			ok, err := q.Matches(labels)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			points, err := db.GetTelemetry(ctx, device.ID, metric, q.Start, q.End)
+			if err != nil {
+				return nil, err
+			}
+
+			s := remoteread.Series{Labels: labels}
+			for _, p := range points {
+				s.Samples = append(s.Samples, remoteread.Sample{Value: p.Value, Timestamp: p.CreatedAt})
+			}
+			if len(s.Samples) > 0 {
+				series = append(series, s)
+			}
+		}
+	}
+
+	return series, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+
+	"sceptic-monitor/internal/db"
+)
+
+// remoteHost returns the address of the client, without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleGetProvenance counts the stored readings per device, transport and source,
+// filtered by the optional device, from and to query parameters. A device counted
+// under two sources, or missing the transport it should report over, shows which
+// path duplicated or dropped readings; /api/history?transport=&source= lists them.
+func handleGetProvenance(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	provenance, err := db.GetProvenance(r.Context(), r.URL.Query().Get("device"), from, to)
+	if err != nil {
+		log.Printf("Error getting provenance: %v", err)
+		http.Error(w, "Failed to get provenance", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(provenance)
+}
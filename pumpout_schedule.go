@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// pumpOutBookingDays is how many days before the due date booking a pump-out is suggested,
+// leaving the contractor some room to schedule it
+const pumpOutBookingDays = 4
+
+// pumpOutForecastRange is the fill history the forecast uses when no pump-out is recorded
+const pumpOutForecastRange = 30 * 24 * time.Hour
+
+// What a pump-out suggestion is based on
+const (
+	pumpOutBasisLevel    = "level"    // Forecast level reaching the threshold
+	pumpOutBasisInterval = "interval" // PUMP_OUT_INTERVAL since the last pump-out
+	pumpOutBasisHistory  = "history"  // Average time between past pump-outs
+)
+
+// PumpOutSuggestion is the suggested window for booking the next pump-out
+type PumpOutSuggestion struct {
+	DueAt       time.Time `json:"due_at"`
+	Basis       string    `json:"basis"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Message     string    `json:"message"` // e.g. "Book a pump-out within 10–14 days"
+}
+
+// suggestPumpOut suggests when to book the next pump-out of a device, from the earliest of
+// the date the level is forecast to reach the alert threshold at the fill rate since the last
+// pump-out and the date the pump-out interval ends. The interval is PUMP_OUT_INTERVAL if
+// configured, the average of the past ones otherwise. history holds the device's
+// pump-outs newest first. It returns nil if neither date can be told.
+func suggestPumpOut(ctx context.Context, deviceID string, history []db.PumpOut) (*PumpOutSuggestion, error) {
+	now := time.Now()
+
+	var suggestion *PumpOutSuggestion
+	propose := func(due time.Time, basis string) {
+		if suggestion == nil || due.Before(suggestion.DueAt) {
+			suggestion = &PumpOutSuggestion{DueAt: due, Basis: basis}
+		}
+	}
+
+	if threshold, ok := alertThreshold(); ok && threshold > 0 {
+		from := now.Add(-pumpOutForecastRange)
+		if len(history) > 0 {
+			from = history[0].PumpedAt
+		}
+
+		readings, err := db.GetDeviceReadings(ctx, deviceID, from, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(readings) > 0 {
+			level := readings[len(readings)-1].Level
+			if rate := fillRate(readings); level >= threshold {
+				propose(now, pumpOutBasisLevel)
+			} else if rate > 0 {
+				propose(now.Add(time.Duration((threshold-level)/rate*float64(24*time.Hour))), pumpOutBasisLevel)
+			}
+		}
+	}
+
+	if len(history) > 0 {
+		if interval, ok := pumpOutInterval(); ok {
+			propose(history[0].PumpedAt.Add(time.Duration(interval*float64(24*time.Hour))), pumpOutBasisInterval)
+		} else if len(history) > 1 {
+			average := history[0].PumpedAt.Sub(history[len(history)-1].PumpedAt) / time.Duration(len(history)-1)
+			propose(history[0].PumpedAt.Add(average), pumpOutBasisHistory)
+		}
+	}
+
+	if suggestion == nil {
+		return nil, nil
+	}
+
+	// Suggest booking in the days leading up to the due date, right away if it is that close
+	days := max(int(math.Ceil(suggestion.DueAt.Sub(now).Hours()/24)), 0)
+	start := max(days-pumpOutBookingDays, 0)
+	suggestion.WindowStart = now.AddDate(0, 0, start)
+	suggestion.WindowEnd = now.AddDate(0, 0, days)
+
+	switch {
+	case days == 0:
+		suggestion.Message = "Book a pump-out now"
+	case start == 0:
+		suggestion.Message = fmt.Sprintf("Book a pump-out within %d days", days)
+	default:
+		suggestion.Message = fmt.Sprintf("Book a pump-out within %d–%d days", start, days)
+	}
+
+	switch suggestion.Basis {
+	case pumpOutBasisLevel:
+		suggestion.Message += ", the tank is forecast to reach the threshold by " + suggestion.DueAt.Format("Jan 2")
+	case pumpOutBasisInterval:
+		suggestion.Message += ", the pump-out interval ends on " + suggestion.DueAt.Format("Jan 2")
+	case pumpOutBasisHistory:
+		suggestion.Message += ", going by the time between past pump-outs"
+	}
+
+	return suggestion, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// pumpOutTrack is the reference for detecting a pump-out in the readings of a device
+type pumpOutTrack struct {
+	peak float64 // Level before the current fall, reset whenever the level rises
+	last float64
+}
+
+var (
+	pumpOutMux    sync.Mutex
+	pumpOutTracks = map[string]pumpOutTrack{}
+)
+
+// pumpOutDrop returns the level fall recorded as a pump-out, from PUMP_OUT_DROP
+// (default: half the alert threshold), or false if detection is disabled
+func pumpOutDrop() (float64, bool) {
+	if drop, ok := envLevel("PUMP_OUT_DROP"); ok {
+		return drop, drop > 0
+	}
+	if threshold, ok := alertThreshold(); ok && threshold > 0 {
+		return threshold / 2, true
+	}
+	return 0, false
+}
+
+// detectPumpOut records a pump-out once the level of a device has fallen by the
+// pump-out drop since it last rose. The fall may span several readings, as
+// pumping out a tank takes a while.
+func detectPumpOut(ctx context.Context, deviceID string, reading db.Reading) {
+	drop, ok := pumpOutDrop()
+	if !ok {
+		return
+	}
+
+	pumpOutMux.Lock()
+	track, known := pumpOutTracks[deviceID]
+	if !known || reading.Level >= track.last {
+		track.peak = reading.Level
+	}
+	track.last = reading.Level
+
+	detected := track.peak-reading.Level >= drop
+	before := track.peak
+	if detected {
+		// A continued fall has to drop as far again to count as another pump-out
+		track.peak = reading.Level
+	}
+	pumpOutTracks[deviceID] = track
+	pumpOutMux.Unlock()
+
+	if !detected {
+		return
+	}
+
+	after := reading.Level
+	recordPumpOut(ctx, db.PumpOut{
+		DeviceID:    deviceID,
+		PumpedAt:    reading.Timestamp,
+		Source:      db.PumpOutDetected,
+		LevelBefore: &before,
+		LevelAfter:  &after,
+	})
+}
+
+// recordPumpOut saves a pump-out and resolves the device's pump-out reminder
+func recordPumpOut(ctx context.Context, p db.PumpOut) (int64, error) {
+	id, err := db.SavePumpOut(ctx, p)
+	if err != nil {
+		log.Printf("Error saving pump-out: %v", err)
+		return 0, err
+	}
+	log.Printf("Pump-out (%s) recorded for %s at %s", p.Source, p.DeviceID, p.PumpedAt.Format(time.RFC3339))
+
+	p.ID = id
+	logCompliance(ctx, db.CompliancePumpOut, p.DeviceID, p)
+
+	goBackground(func() { checkPumpOutDue(p.DeviceID) })
+
+	return id, nil
+}
+
+// daysSince returns the days passed since t, rounded to one decimal
+func daysSince(t time.Time) float64 {
+	return math.Round(time.Since(t).Hours()/24*10) / 10
+}
+
+// pumpOutInterval returns the days allowed between pump-outs from PUMP_OUT_INTERVAL, if configured
+func pumpOutInterval() (float64, bool) {
+	interval, ok := envLevel("PUMP_OUT_INTERVAL")
+	return interval, ok && interval > 0
+}
+
+// checkPumpOutDue raises a warning once the last pump-out of the device is longer
+// ago than PUMP_OUT_INTERVAL days, whatever the level, and resolves it after the
+// next pump-out. Devices without any recorded pump-out are not reminded.
+func checkPumpOutDue(deviceID string) {
+	interval, ok := pumpOutInterval()
+	if !ok {
+		return
+	}
+
+	last, err := db.GetLastPumpOut(context.Background(), deviceID)
+	if err != nil {
+		log.Printf("Error getting last pump-out: %v", err)
+		return
+	}
+	if last == nil {
+		return
+	}
+
+	updateHardLimit(deviceID, db.AlertKindPumpOutDue, daysSince(last.PumpedAt) > interval)
+}
+
+// startPumpOutReminders checks every hour whether a pump-out is due, if PUMP_OUT_INTERVAL is configured
+func startPumpOutReminders() {
+	interval, ok := pumpOutInterval()
+	if !ok {
+		return
+	}
+
+	every(time.Hour, func() {
+		devices, err := db.GetDevices(context.Background())
+		if err != nil {
+			log.Printf("Error getting devices: %v", err)
+		}
+		for _, d := range devices {
+			checkPumpOutDue(d.ID)
+		}
+	})
+	log.Printf("Reminding of pump-outs every %g days", interval)
+}
+
+// PumpOutHistory is the pump-out record of a device
+type PumpOutHistory struct {
+	DeviceID         string             `json:"device_id"`
+	DaysSincePumpOut *float64           `json:"days_since_pump_out"` // Null if no pump-out is recorded
+	PumpOuts         []db.PumpOut       `json:"pump_outs"`           // Newest first
+	Suggestion       *PumpOutSuggestion `json:"suggestion"`          // Null if the next pump-out can't be forecast
+}
+
+// PumpOutRequest logs a pump-out by hand
+type PumpOutRequest struct {
+	DeviceID string     `json:"device_id,omitempty"`
+	PumpedAt *time.Time `json:"pumped_at,omitempty"` // Now if not set
+	Notes    string     `json:"notes,omitempty"`
+}
+
+func handlePumpOuts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetPumpOuts(w, r)
+	case http.MethodPost:
+		handleLogPumpOut(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetPumpOuts serves the days since the last pump-out, the latest pump-outs and
+// when to book the next one for the device given as device (default: the default device)
+func handleGetPumpOuts(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		deviceID = defaultDeviceID
+	}
+
+	pumpOuts, err := db.GetPumpOuts(r.Context(), deviceID, 100)
+	if err != nil {
+		log.Printf("Error getting pump-outs: %v", err)
+		http.Error(w, "Failed to get pump-outs", http.StatusInternalServerError)
+		return
+	}
+
+	history := PumpOutHistory{DeviceID: deviceID, PumpOuts: pumpOuts}
+	if len(pumpOuts) > 0 {
+		days := daysSince(pumpOuts[0].PumpedAt)
+		history.DaysSincePumpOut = &days
+	}
+
+	if history.Suggestion, err = suggestPumpOut(r.Context(), deviceID, pumpOuts); err != nil {
+		log.Printf("Error suggesting pump-out: %v", err)
+		http.Error(w, "Failed to get pump-outs", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// handleLogPumpOut records a pump-out reported by the user, e.g. one the level
+// sensor missed or one done while the sensor was offline
+func handleLogPumpOut(w http.ResponseWriter, r *http.Request) {
+	var req PumpOutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	p := db.PumpOut{
+		DeviceID: req.DeviceID,
+		PumpedAt: time.Now(),
+		Source:   db.PumpOutManual,
+		Notes:    strings.TrimSpace(req.Notes),
+	}
+	if p.DeviceID == "" {
+		p.DeviceID = defaultDeviceID
+	}
+	if req.PumpedAt != nil {
+		if req.PumpedAt.After(time.Now()) {
+			http.Error(w, "pumped_at must not be in the future", http.StatusBadRequest)
+			return
+		}
+		p.PumpedAt = *req.PumpedAt
+	}
+
+	id, err := recordPumpOut(r.Context(), p)
+	if err != nil {
+		http.Error(w, "Failed to save pump-out", http.StatusInternalServerError)
+		return
+	}
+	p.ID = id
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p)
+}
+
+// pumpOutSummary describes the last pump-out of a device for status messages, empty if none is recorded
+func pumpOutSummary(ctx context.Context, deviceID string) string {
+	last, err := db.GetLastPumpOut(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error getting last pump-out: %v", err)
+	}
+	if last == nil {
+		return ""
+	}
+	return fmt.Sprintf("last pumped out %.0f days ago", math.Floor(daysSince(last.PumpedAt)))
+}
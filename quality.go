@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"sceptic-monitor/internal/db"
+)
+
+// readingQuality runs the ingest filters over a reading and returns its quality flag
+func readingQuality(reading db.Reading, simulated bool) string {
+	if simulated {
+		return db.QualitySimulated
+	}
+
+	// Readings outside the physically plausible range are kept but flagged,
+	// so a glitching sensor doesn't poison alerts and statistics
+	if minLevel, ok := envLevel("LEVEL_MIN"); ok && reading.Level < minLevel {
+		return db.QualityAnomalous
+	}
+	if maxLevel, ok := envLevel("LEVEL_MAX"); ok && reading.Level > maxLevel {
+		return db.QualityAnomalous
+	}
+
+	if reading.RawTimestamp != nil && !reading.RawTimestamp.Equal(reading.Timestamp) {
+		return db.QualityCorrected
+	}
+
+	return db.QualityOK
+}
+
+// envLevel returns the level configured in the environment variable, or stored
+// through /api/config for the settings that can be, if any
+func envLevel(key string) (float64, bool) {
+	s := setting(key)
+	if s == "" {
+		return 0, false
+	}
+
+	level, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Printf("Invalid %s value: %v", key, err)
+		return 0, false
+	}
+
+	return level, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// readAuthConfigured reports whether the dashboard and the read endpoints are protected,
+// by a username and password from READ_AUTH_USER and READ_AUTH_PASSWORD or a token
+// from READ_AUTH_TOKEN
+func readAuthConfigured() bool {
+	return os.Getenv("READ_AUTH_PASSWORD") != "" || os.Getenv("READ_AUTH_TOKEN") != ""
+}
+
+// readAuthorized reports whether the request may read the levels: it carries the
+// READ_AUTH_TOKEN as an API key or bearer token, or the username and password with
+// basic auth, if either is configured
+func readAuthorized(r *http.Request) bool {
+	if !readAuthConfigured() {
+		return true
+	}
+
+	if token := os.Getenv("READ_AUTH_TOKEN"); token != "" {
+		if given := requestAPIKey(r); given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	if password := os.Getenv("READ_AUTH_PASSWORD"); password != "" {
+		if user, given, ok := r.BasicAuth(); ok {
+			// Compare both, so the time taken doesn't tell which one is wrong
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(os.Getenv("READ_AUTH_USER")))
+			passwordOK := subtle.ConstantTimeCompare([]byte(given), []byte(password))
+			return userOK&passwordOK == 1
+		}
+	}
+
+	return false
+}
+
+// requireReadAuth wraps a handler serving the levels so it answers 401 unless the
+// request is authorized, by the read credentials or ADMIN_API_KEY. Browsers then ask
+// for the password once and send it along with the dashboard's own requests.
+func requireReadAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !readAuthorized(r) && !adminAuthorized(r) {
+			rejectRead(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// rejectRead answers 401, asking for the read credentials configured
+func rejectRead(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Rejected %s %s from %s: missing or invalid credentials", r.Method, r.URL.Path, remoteHost(r))
+	if os.Getenv("READ_AUTH_PASSWORD") != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="septic-monitor", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="septic-monitor"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// requireReadOrAdmin wraps a handler serving both reads and changes: GET requests need
+// the read credentials like requireReadAuth, the other methods ADMIN_API_KEY
+func requireReadOrAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	read, admin := requireReadAuth(handler), requireAdmin(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			read(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
+// sameOriginOrUnprotected reports whether a browser request may use the read
+// credentials it carries: it comes from a page served here, or read auth isn't set.
+// Browsers send basic auth along with cross-site WebSocket handshakes, which would let
+// any site read the stream otherwise.
+func sameOriginOrUnprotected(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !readAuthConfigured() {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/email"
+	"sceptic-monitor/internal/incident"
+)
+
+// recipientChannels are the messaging backends recipients can choose, in delivery order
+var recipientChannels = []string{"sms", "email", "telegram"}
+
+// recipientAddress returns the address of the recipient on the channel, empty if they have none
+func recipientAddress(r db.Recipient, channel string) string {
+	switch channel {
+	case "sms":
+		return r.Phone
+	case "email":
+		return r.Email
+	case "telegram":
+		return r.TelegramChatID
+	}
+	return ""
+}
+
+// backendDestinations returns the addresses the backends send to regardless of the
+// recipients, by channel: the alert phone numbers by SMS and the configured destinations
+func backendDestinations(backends []notifierBackend, phoneNumbers []string) map[string][]string {
+	destinations := map[string][]string{}
+	for _, backend := range backends {
+		switch name := backend.notifier.Name(); name {
+		case "sms":
+			destinations[name] = phoneNumbers
+		case "email":
+			destinations[name] = email.Addresses()
+		case "telegram":
+			destinations[name] = []string{os.Getenv("TELEGRAM_CHAT_ID")}
+		}
+	}
+	return destinations
+}
+
+// recipientDeliveries returns a delivery of the message to every recipient wanting alerts
+// of the severity, on each channel they chose. Vacation-only recipients are left out
+// unless vacation mode is on, expired ones always. Addresses the backends already send the
+// message to are skipped, so nobody gets it twice.
+func recipientDeliveries(ctx context.Context, id, severity string, backends []notifierBackend, phoneNumbers []string, message string) []delivery {
+	recipients, err := db.GetRecipients(ctx)
+	if err != nil {
+		log.Printf("Error getting recipients: %v", err)
+		return nil
+	}
+
+	covered := backendDestinations(backends, phoneNumbers)
+	var deliveries []delivery
+	for _, r := range recipients {
+		if len(r.Severities) > 0 && !slices.Contains(r.Severities, severity) {
+			continue
+		}
+		if r.VacationOnly && !vacationActive(time.Now()) || recipientExpired(r, time.Now()) {
+			continue
+		}
+
+		channels := r.Channels
+		if len(channels) == 0 {
+			channels = recipientChannels
+		}
+		for _, channel := range channels {
+			address := recipientAddress(r, channel)
+			if address == "" || slices.Contains(covered[channel], address) {
+				continue
+			}
+			covered[channel] = append(covered[channel], address)
+
+			notifier := notifierBackends[channel].notifier
+			deliveries = append(deliveries, delivery{
+				channel: channel,
+				send: func(ctx context.Context) error {
+					return sendNotification(ctx, notifier, id, address, message)
+				},
+			})
+		}
+	}
+	return deliveries
+}
+
+// validateRecipient checks the channels and severities of a recipient, each chosen
+// channel needs an address
+func validateRecipient(r db.Recipient) error {
+	for _, channel := range r.Channels {
+		if !slices.Contains(recipientChannels, channel) {
+			return fmt.Errorf("channels must be sms, email or telegram")
+		}
+		if recipientAddress(r, channel) == "" {
+			return fmt.Errorf("no address for channel %s", channel)
+		}
+	}
+	for _, severity := range r.Severities {
+		if severity != incident.SeverityWarning && severity != incident.SeverityCritical {
+			return fmt.Errorf("severities must be warning or critical")
+		}
+	}
+	return nil
+}
+
+func handleGetRecipients(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipients, err := db.GetRecipients(r.Context())
+	if err != nil {
+		log.Printf("Error getting recipients: %v", err)
+		http.Error(w, "Failed to get recipients", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recipients)
+}
+
+// handleRecipient serves a recipient with GET, creates or replaces it with PUT and
+// deletes it with DELETE
+func handleRecipient(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetRecipient(w, r)
+	case http.MethodPut:
+		handlePutRecipient(w, r)
+	case http.MethodDelete:
+		handleDeleteRecipient(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleGetRecipient(w http.ResponseWriter, r *http.Request) {
+	recipient, err := db.GetRecipient(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting recipient: %v", err)
+		http.Error(w, "Failed to get recipient", http.StatusInternalServerError)
+		return
+	}
+	if recipient == nil {
+		http.Error(w, "Recipient not found", http.StatusNotFound)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recipient)
+}
+
+func handlePutRecipient(w http.ResponseWriter, r *http.Request) {
+	var recipient db.Recipient
+	if err := json.NewDecoder(r.Body).Decode(&recipient); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	recipient.ID = r.PathValue("id")
+	if recipient.Channels == nil {
+		recipient.Channels = []string{}
+	}
+	if recipient.Severities == nil {
+		recipient.Severities = []string{}
+	}
+
+	if err := validateRecipient(recipient); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.SaveRecipient(r.Context(), recipient); err != nil {
+		log.Printf("Error saving recipient: %v", err)
+		http.Error(w, "Failed to save recipient", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recipient)
+}
+
+func handleDeleteRecipient(w http.ResponseWriter, r *http.Request) {
+	deleted, err := db.DeleteRecipient(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error deleting recipient: %v", err)
+		http.Error(w, "Failed to delete recipient", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Recipient not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+	"sceptic-monitor/internal/pdf"
+)
+
+// Layout of the report pages in points
+const (
+	reportMargin = 40.0
+	reportBottom = pdf.PageHeight - 50
+)
+
+// incidentReport is the data of an incident and maintenance report
+type incidentReport struct {
+	from, to   time.Time
+	deviceID   string // Empty for all devices
+	alerts     []db.Alert
+	pumpOuts   []db.PumpOut
+	daily      []db.LevelStats
+	thresholds []levelThreshold
+}
+
+// loadIncidentReport gathers the alerts, pump-outs and daily levels in the time range,
+// of a single device if deviceID is set
+func loadIncidentReport(ctx context.Context, deviceID string, from, to time.Time) (*incidentReport, error) {
+	report := &incidentReport{from: from, to: to, deviceID: deviceID, thresholds: levelThresholds()}
+
+	alerts, err := db.GetAlertsBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	pumpOuts, err := db.GetPumpOutsBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range alerts {
+		if deviceID == "" || a.DeviceID == deviceID {
+			report.alerts = append(report.alerts, a)
+		}
+	}
+	for _, p := range pumpOuts {
+		if deviceID == "" || p.DeviceID == deviceID {
+			report.pumpOuts = append(report.pumpOuts, p)
+		}
+	}
+
+	if report.daily, err = db.GetLevelStats(ctx, db.BucketDay, db.ReadingFilter{DeviceID: deviceID, From: from, To: to}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// reportWriter lays out lines of the report top to bottom, starting new pages as needed
+type reportWriter struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+}
+
+func newReportWriter() *reportWriter {
+	w := &reportWriter{doc: pdf.New()}
+	w.newPage()
+	return w
+}
+
+func (w *reportWriter) newPage() {
+	w.page = w.doc.AddPage()
+	w.y = reportMargin
+}
+
+// space makes room for the height, starting a new page if it doesn't fit
+func (w *reportWriter) space(height float64) {
+	if w.y+height > reportBottom {
+		w.newPage()
+	}
+}
+
+// text writes a line of text and moves below it
+func (w *reportWriter) text(size float64, bold bool, text string) {
+	w.space(size * 1.5)
+	w.y += size * 1.2
+	w.page.Text(reportMargin, w.y, size, bold, text)
+	w.y += size * 0.3
+}
+
+// heading writes a section heading with some room above it
+func (w *reportWriter) heading(text string) {
+	w.space(60)
+	w.y += 12
+	w.text(13, true, text)
+	w.page.Line(reportMargin, w.y+2, pdf.PageWidth-reportMargin, w.y+2, 0.5, 0.6, 0.6, 0.6)
+	w.y += 6
+}
+
+// table writes rows of cells at the column offsets, the first row as the header,
+// which is repeated on every page
+func (w *reportWriter) table(columns []float64, rows [][]string) {
+	const size, height = 8.0, 12.0
+	row := func(cells []string, bold bool) {
+		for i, cell := range cells {
+			width := pdf.PageWidth - reportMargin - columns[i]
+			if i+1 < len(columns) {
+				width = columns[i+1] - columns[i] - 4
+			}
+			for pdf.TextWidth(cell, size) > width && len([]rune(cell)) > 4 {
+				cell = string([]rune(cell)[:len([]rune(cell))-4]) + "..."
+			}
+			w.page.Text(reportMargin+columns[i], w.y+size, size, bold, cell)
+		}
+		w.y += height
+	}
+
+	row(rows[0], true)
+	for _, cells := range rows[1:] {
+		if w.y+height > reportBottom {
+			w.newPage()
+			row(rows[0], true)
+		}
+		row(cells, false)
+	}
+}
+
+// chart draws the daily highest level with the thresholds as dashed lines
+func (w *reportWriter) chart(r *incidentReport) {
+	const height = 160.0
+	w.space(height + 20)
+	left, top := reportMargin+30, w.y+4
+	width := pdf.PageWidth - reportMargin - left
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range r.daily {
+		lo, hi = math.Min(lo, s.Max), math.Max(hi, s.Max)
+	}
+	for _, t := range r.thresholds {
+		lo, hi = math.Min(lo, t.level), math.Max(hi, t.level)
+	}
+	lo = math.Min(lo, 0)
+	if hi <= lo {
+		hi = lo + 1
+	}
+
+	x := func(t time.Time) float64 {
+		return left + t.Sub(r.from).Seconds()/r.to.Sub(r.from).Seconds()*width
+	}
+	y := func(level float64) float64 {
+		return top + height - (level-lo)/(hi-lo)*height
+	}
+
+	w.page.Line(left, top, left, top+height, 0.5, 0.4, 0.4, 0.4)
+	w.page.Line(left, top+height, left+width, top+height, 0.5, 0.4, 0.4, 0.4)
+	for _, level := range []float64{lo, hi} {
+		w.page.Text(reportMargin, y(level)+3, 7, false, fmt.Sprintf("%.0f", level))
+	}
+	w.page.Text(left, top+height+10, 7, false, r.from.Format(time.DateOnly))
+	w.page.Text(left+width-40, top+height+10, 7, false, r.to.Format(time.DateOnly))
+
+	for _, t := range r.thresholds {
+		red, green := 0.98, 0.66
+		if t.severity == incident.SeverityCritical {
+			red, green = 0.78, 0.16
+		}
+		for sx := left; sx < left+width; sx += 8 {
+			w.page.Line(sx, y(t.level), math.Min(sx+4, left+width), y(t.level), 0.5, red, green, 0.15)
+		}
+		w.page.Text(left+width-pdf.TextWidth(t.name, 7), y(t.level)-2, 7, false, t.name)
+	}
+
+	var points [][2]float64
+	for _, s := range r.daily {
+		points = append(points, [2]float64{x(s.Start.Add(12 * time.Hour)), y(s.Max)})
+	}
+	w.page.Polyline(points, 1, 0.08, 0.4, 0.75)
+
+	w.y = top + height + 16
+}
+
+// render lays out the report: a summary, the daily level chart, the alerts and the pump-outs
+func (r *incidentReport) render(ctx context.Context) *pdf.Document {
+	w := newReportWriter()
+	dateTime := "2006-01-02 15:04"
+
+	w.text(18, true, "Septic system incident and maintenance report")
+	w.text(10, false, fmt.Sprintf("Period: %s to %s", r.from.Local().Format(time.DateOnly), r.to.Local().Format(time.DateOnly)))
+	if r.deviceID != "" {
+		device := r.deviceID
+		if label := deviceLabel(ctx, r.deviceID); label != "" && label != r.deviceID {
+			device += " (" + label + ")"
+		}
+		w.text(10, false, "Device: "+device)
+	}
+	w.text(10, false, "Generated: "+time.Now().Local().Format(dateTime))
+
+	// Summary
+	w.heading("Summary")
+	counts := map[string]int{}
+	var inAlert time.Duration
+	for _, a := range r.alerts {
+		counts[a.Severity+" "+strings.ReplaceAll(a.Kind, "_", " ")]++
+		end := r.to
+		if a.ResolvedAt != nil && a.ResolvedAt.Before(end) {
+			end = *a.ResolvedAt
+		}
+		inAlert += end.Sub(a.RaisedAt)
+	}
+	w.text(10, false, fmt.Sprintf("Alerts: %d, in alert for %s in total", len(r.alerts), formatReportDuration(inAlert)))
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		w.text(9, false, fmt.Sprintf("    %s: %d", kind, counts[kind]))
+	}
+	w.text(10, false, fmt.Sprintf("Pump-outs: %d", len(r.pumpOuts)))
+	if len(r.thresholds) > 0 {
+		var thresholds []string
+		for _, t := range r.thresholds {
+			thresholds = append(thresholds, fmt.Sprintf("%s %.1f (%s)", t.name, t.level, t.severity))
+		}
+		w.text(10, false, "Thresholds: "+strings.Join(thresholds, ", "))
+	}
+
+	// Daily levels
+	w.heading("Daily highest level")
+	if len(r.daily) == 0 {
+		w.text(10, false, "No readings in this period.")
+	} else {
+		w.chart(r)
+	}
+
+	// Alerts
+	w.heading("Alerts")
+	if len(r.alerts) == 0 {
+		w.text(10, false, "No alerts in this period.")
+	} else {
+		rows := [][]string{{"Raised", "Device", "Kind", "Severity", "Level", "Threshold", "Acknowledged", "Resolved"}}
+		for _, a := range r.alerts {
+			rows = append(rows, []string{
+				a.RaisedAt.Local().Format(dateTime),
+				a.DeviceID,
+				strings.ReplaceAll(a.Kind, "_", " "),
+				a.Severity,
+				fmt.Sprintf("%.1f", a.Level),
+				fmt.Sprintf("%.1f", a.Threshold),
+				formatReportTime(a.AcknowledgedAt, dateTime),
+				formatReportTime(a.ResolvedAt, dateTime),
+			})
+		}
+		w.table([]float64{0, 70, 130, 195, 245, 285, 330, 420}, rows)
+	}
+
+	// Pump-outs
+	w.heading("Pump-outs")
+	if len(r.pumpOuts) == 0 {
+		w.text(10, false, "No pump-outs in this period.")
+	} else {
+		rows := [][]string{{"Date", "Device", "Source", "Before", "After", "Notes"}}
+		for _, p := range r.pumpOuts {
+			rows = append(rows, []string{
+				p.PumpedAt.Local().Format(dateTime),
+				p.DeviceID,
+				p.Source,
+				formatReportLevel(p.LevelBefore),
+				formatReportLevel(p.LevelAfter),
+				p.Notes,
+			})
+		}
+		w.table([]float64{0, 70, 130, 185, 225, 265}, rows)
+	}
+
+	return w.doc
+}
+
+// formatReportTime formats an optional time, "-" if unset
+func formatReportTime(t *time.Time, layout string) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(layout)
+}
+
+// formatReportLevel formats an optional level, "-" if unknown
+func formatReportLevel(level *float64) string {
+	if level == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *level)
+}
+
+// formatReportDuration formats a duration in days and hours
+func formatReportDuration(d time.Duration) string {
+	hours := int(d.Round(time.Hour).Hours())
+	if hours < 24 {
+		return fmt.Sprintf("%d h", hours)
+	}
+	return fmt.Sprintf("%d days %d h", hours/24, hours%24)
+}
+
+// handleIncidentReport serves a PDF report of the alerts, pump-outs and daily levels
+// between the optional from and to query parameters (default: the last year), of a single
+// device if given as device=<id>
+func handleIncidentReport(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.AddDate(-1, 0, 0)
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	report, err := loadIncidentReport(r.Context(), r.URL.Query().Get("device"), from, to)
+	if err != nil {
+		log.Printf("Error getting report data: %v", err)
+		http.Error(w, "Failed to create report", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("incident-report-%s-%s.pdf", from.Format(time.DateOnly), to.Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := report.render(r.Context()).WriteTo(w); err != nil {
+		log.Printf("Error writing report: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// purgeBatchSize is the number of readings deleted per transaction, keeping the database
+// responsive while a long history is purged
+const purgeBatchSize = 5000
+
+// lastVacuum is when the retention job last vacuumed the database, zero before the first time
+var lastVacuum time.Time
+
+// retentionPeriod returns how long readings and telemetry are kept from RETENTION_DAYS,
+// or false to keep them forever (the default)
+func retentionPeriod() (time.Duration, bool) {
+	s := os.Getenv("RETENTION_DAYS")
+	if s == "" {
+		return 0, false
+	}
+
+	days, err := strconv.Atoi(s)
+	if err != nil || days <= 0 {
+		log.Printf("Invalid RETENTION_DAYS value: %s, keeping readings forever", s)
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// startRetention downsamples and purges the expired readings daily, from startup on.
+// Readings are downsampled first, so those due for both count in the aggregates.
+func startRetention() {
+	period, purge := retentionPeriod()
+	age, downsample := downsampleAge()
+	if !purge && !downsample {
+		return
+	}
+
+	every(24*time.Hour, func() {
+		ctx := context.Background()
+		if downsampled, purged := expireReadings(ctx); downsampled+purged > 0 {
+			vacuumAfterDelete(ctx)
+		}
+	})
+	if downsample {
+		log.Printf("Downsampling readings older than %v", age)
+	}
+	if purge {
+		log.Printf("Keeping readings for %v", period)
+	}
+}
+
+// expireReadings downsamples the readings older than DOWNSAMPLE_AFTER_DAYS and then purges
+// those older than RETENTION_DAYS, whichever is set, and returns the number of readings
+// downsampled and of rows purged
+func expireReadings(ctx context.Context) (downsampled, purged int) {
+	if age, ok := downsampleAge(); ok {
+		downsampled = downsampleExpired(ctx, time.Now().Add(-age))
+	}
+	if period, ok := retentionPeriod(); ok {
+		purged = purgeExpired(ctx, time.Now().Add(-period))
+	}
+	return downsampled, purged
+}
+
+// purgeExpired deletes the readings and telemetry stored before the cutoff, archiving the
+// readings to a gzipped CSV file in RETENTION_ARCHIVE_DIR first, if set, and returns the
+// number of rows deleted
+func purgeExpired(ctx context.Context, before time.Time) int {
+	var archive *readingArchive
+	if dir := os.Getenv("RETENTION_ARCHIVE_DIR"); dir != "" {
+		archive = &readingArchive{path: filepath.Join(dir, "readings-"+time.Now().UTC().Format("20060102T150405Z")+".csv.gz")}
+		defer func() {
+			if err := archive.close(); err != nil {
+				log.Printf("Error closing reading archive: %v", err)
+			}
+		}()
+	}
+
+	purged := 0
+	for {
+		var write func([]db.HistoryReading) error
+		if archive != nil {
+			write = archive.write
+		}
+
+		n, err := db.PurgeReadings(ctx, before, purgeBatchSize, write)
+		if err != nil {
+			log.Printf("Error purging readings: %v", err)
+			break
+		}
+		purged += n
+		if n < purgeBatchSize {
+			break
+		}
+	}
+
+	telemetry, err := db.PurgeTelemetry(ctx, before)
+	if err != nil {
+		log.Printf("Error purging telemetry: %v", err)
+	}
+
+	// Webhook delivery attempts expire with the readings
+	if _, err := db.PurgeWebhookDeliveries(ctx, before); err != nil {
+		log.Printf("Error purging webhook deliveries: %v", err)
+	}
+
+	if purged == 0 && telemetry == 0 {
+		return 0
+	}
+	log.Printf("Purged %d readings and %d telemetry samples stored before %s", purged, telemetry, before.Format(time.RFC3339))
+	if archive != nil && archive.file != nil {
+		log.Printf("Archived purged readings to %s", archive.path)
+	}
+	return purged + int(telemetry)
+}
+
+// vacuumAfterDelete reclaims the space freed by deleted rows with a VACUUM every
+// RETENTION_VACUUM_INTERVAL seconds at most (default: a week, 0 disables it), as it
+// rewrites the whole database file
+func vacuumAfterDelete(ctx context.Context) {
+	interval := envSeconds("RETENTION_VACUUM_INTERVAL", 7*24*time.Hour)
+	if interval <= 0 || time.Since(lastVacuum) < interval {
+		return
+	}
+	if err := db.Vacuum(ctx); err != nil {
+		log.Printf("Error vacuuming database: %v", err)
+		return
+	}
+	lastVacuum = time.Now()
+	log.Printf("Database vacuumed")
+}
+
+// readingArchive is a gzipped CSV file of purged readings, created with the first readings written
+type readingArchive struct {
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	csv  *csv.Writer
+}
+
+// write appends the readings to the archive and flushes them to disk, so they are
+// safe before they are deleted from the database
+func (a *readingArchive) write(readings []db.HistoryReading) error {
+	if a.file == nil {
+		if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		a.file = file
+		a.gz = gzip.NewWriter(file)
+		a.csv = csv.NewWriter(a.gz)
+		a.csv.Write([]string{"id", "device_id", "timestamp", "level", "quality", "transport", "source"})
+	}
+
+	for _, r := range readings {
+		a.csv.Write([]string{
+			strconv.FormatInt(r.ID, 10),
+			r.DeviceID,
+			r.Timestamp.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(r.Level, 'f', -1, 64),
+			r.Quality,
+			r.Transport,
+			r.Source,
+		})
+	}
+	a.csv.Flush()
+	if err := a.csv.Error(); err != nil {
+		return err
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// close completes the archive, if it was created
+func (a *readingArchive) close() error {
+	if a.file == nil {
+		return nil
+	}
+	if err := a.gz.Close(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
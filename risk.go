@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// Risk score components
+const (
+	riskLevel = "level" // How full the tank is
+	riskETA   = "eta"   // How soon the threshold is reached at the current fill rate
+)
+
+// riskWeights are the weights of the components in the risk score, summing to 1
+var riskWeights = map[string]float64{
+	riskLevel: 0.6,
+	riskETA:   0.4,
+}
+
+// RiskScore is the overflow risk of a device
+type RiskScore struct {
+	DeviceID   string             `json:"device_id"`
+	Score      float64            `json:"score"` // 0 (no risk) to 100 (at the threshold)
+	Level      float64            `json:"level"`
+	FillRate   float64            `json:"fill_rate"`  // Level rise per day over the last RISK_WINDOW
+	ETA        *time.Time         `json:"eta"`        // Threshold reached at the current fill rate, null if not rising
+	Components map[string]float64 `json:"components"` // Each from 0 to 1, before weighting
+}
+
+// computeRisk scores the overflow risk of a device from its level relative to the
+// threshold and how soon the threshold is reached at the fill rate over the last
+// RISK_WINDOW hours (default: 72). The ETA component counts from RISK_HORIZON days
+// (default: 14) ahead.
+func computeRisk(ctx context.Context, deviceID string) (*RiskScore, error) {
+	threshold, ok := alertThreshold()
+	if !ok || threshold <= 0 {
+		return nil, fmt.Errorf("level threshold not configured")
+	}
+
+	// The level component reaches 1 at the highest threshold, e.g. the critical level
+	full, _ := topThreshold()
+
+	window := 72 * time.Hour
+	if hours, ok := envLevel("RISK_WINDOW"); ok && hours > 0 {
+		window = time.Duration(hours * float64(time.Hour))
+	}
+	horizon, ok := envLevel("RISK_HORIZON")
+	if !ok || horizon <= 0 {
+		horizon = 14
+	}
+
+	now := time.Now()
+	readings, err := db.GetDeviceReadings(ctx, deviceID, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("no readings of device %s in the last %v", deviceID, window)
+	}
+
+	risk := &RiskScore{
+		DeviceID:   deviceID,
+		Level:      readings[len(readings)-1].Level,
+		FillRate:   math.Max(fillRate(readings), 0),
+		Components: map[string]float64{},
+	}
+
+	risk.Components[riskLevel] = math.Min(math.Max(risk.Level/full, 0), 1)
+
+	switch {
+	case risk.Level >= threshold:
+		risk.ETA = &now
+		risk.Components[riskETA] = 1
+	case risk.FillRate > 0:
+		days := (threshold - risk.Level) / risk.FillRate
+		eta := now.Add(time.Duration(days * float64(24*time.Hour)))
+		risk.ETA = &eta
+		risk.Components[riskETA] = math.Max(1-days/horizon, 0)
+	default:
+		risk.Components[riskETA] = 0
+	}
+
+	for component, weight := range riskWeights {
+		risk.Score += weight * risk.Components[component]
+	}
+	risk.Score = math.Round(risk.Score*1000) / 10
+
+	return risk, nil
+}
+
+// fillRate returns the least-squares slope of the readings in level per day
+func fillRate(readings []db.Reading) float64 {
+	if len(readings) < 2 {
+		return 0
+	}
+
+	start := readings[0].Timestamp
+	var n, sumX, sumY, sumXY, sumXX float64
+	for _, r := range readings {
+		x := r.Timestamp.Sub(start).Hours() / 24
+		n++
+		sumX += x
+		sumY += r.Level
+		sumXY += x * r.Level
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// checkRisk raises a warning once the risk score of the device reaches RISK_ALERT_SCORE,
+// if configured, and resolves it when the score drops below again
+func checkRisk(deviceID string) {
+	limit, ok := envLevel("RISK_ALERT_SCORE")
+	if !ok {
+		return
+	}
+
+	risk, err := computeRisk(context.Background(), deviceID)
+	if err != nil {
+		log.Printf("Error computing overflow risk: %v", err)
+		return
+	}
+
+	updateHardLimit(deviceID, db.AlertKindRisk, risk.Score >= limit)
+}
+
+// handleGetRisk serves the overflow risk score of the device given as device
+// (default: the default device)
+func handleGetRisk(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		deviceID = defaultDeviceID
+	}
+
+	risk, err := computeRisk(r.Context(), deviceID)
+	if err != nil {
+		log.Printf("Error computing overflow risk: %v", err)
+		http.Error(w, "Failed to compute overflow risk", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(risk)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+	"sceptic-monitor/internal/webhook"
+)
+
+// schemaVersion is the version of the payload schemas, shared with the webhook events
+// and bumped on incompatible changes. Fields may be added within a version.
+const schemaVersion = webhook.EventVersion
+
+// schemaPayload is a payload described by a JSON Schema, with an example of it
+type schemaPayload struct {
+	name        string
+	description string
+	example     any
+}
+
+// schemaPayloads are the payloads the API accepts and sends, in the order they are listed
+var schemaPayloads = func() []schemaPayload {
+	raisedAt := time.Date(2026, 10, 16, 7, 30, 0, 0, time.UTC)
+	level, before, after := 182.5, 190.0, 12.0
+
+	return []schemaPayload{
+		{"reading", "Reading posted to /api", Request{Level: 182.5, DeviceID: "tank-1"}},
+		{"alert", "Alert as listed by /api/alerts", db.Alert{
+			ID: "septic-monitor-1792134600000000000", DeviceID: "tank-1", Kind: db.AlertKindLevel, Severity: incident.SeverityWarning,
+			Level: level, Threshold: 180, State: db.AlertNotified, RaisedAt: raisedAt, NotifiedAt: &raisedAt, LastNotifiedAt: &raisedAt,
+		}},
+		{"event", "Alert event posted to webhooks", webhook.Event{
+			Version: webhook.EventVersion, Event: webhook.EventAlert, AlertID: "septic-monitor-1792134600000000000", DeviceID: "tank-1",
+			Device: "Main tank, Garden", Kind: db.AlertKindLevel, Severity: incident.SeverityWarning, Level: level, Threshold: 180,
+			Message: "Alert (Main tank, Garden): Level 182.50 has reached the threshold of 180.00", Timestamp: raisedAt,
+		}},
+		{"pump_out", "Pump-out as listed by /api/pumpouts", db.PumpOut{
+			ID: 7, DeviceID: "tank-1", PumpedAt: raisedAt, Source: db.PumpOutDetected, LevelBefore: &before, LevelAfter: &after,
+		}},
+		{"risk", "Overflow risk served by /api/risk", RiskScore{
+			DeviceID: "tank-1", Score: 71.3, Level: level, FillRate: 4.2, ETA: &raisedAt,
+			Components: map[string]float64{riskLevel: 0.91, riskETA: 0.42},
+		}},
+		{"level", "Latest level of a device, as served by /api/level", level},
+	}
+}()
+
+// handleGetSchemas serves the JSON Schemas of all payloads, keyed by name under $defs
+func handleGetSchemas(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defs := map[string]any{}
+	for _, p := range schemaPayloads {
+		defs[p.name] = payloadSchema(p)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"version": schemaVersion,
+		"$defs":   defs,
+	})
+}
+
+// handleGetSchema serves the JSON Schema of the payload given by name, e.g. /api/schema/event,
+// for validating single payloads in low-code tools such as Node-RED
+func handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	for _, p := range schemaPayloads {
+		if p.name != r.PathValue("name") {
+			continue
+		}
+
+		schema := payloadSchema(p)
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+
+		// Send response
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(schema)
+		return
+	}
+
+	http.Error(w, "Schema not found", http.StatusNotFound)
+}
+
+// payloadSchema returns the schema of a payload, with its version, description and example
+func payloadSchema(p schemaPayload) map[string]any {
+	schema := typeSchema(reflect.TypeOf(p.example))
+	schema["title"] = p.name
+	schema["description"] = p.description
+	schema["examples"] = []any{p.example}
+	schema["x-version"] = schemaVersion
+	return schema
+}
+
+// timeType is encoded as an RFC 3339 string rather than an object
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema derives the JSON Schema of a type from its encoding/json encoding. Fields
+// tagged omitempty are optional, pointers may be null.
+func typeSchema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := typeSchema(t.Elem())
+		schema["type"] = []any{schema["type"], "null"}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		addStructFields(t, properties, &required)
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	}
+
+	return map[string]any{}
+}
+
+// addStructFields adds the JSON fields of a struct to the properties, flattening embedded structs
+func addStructFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/notify"
+)
+
+// saneClockFloor is a time the clock can't be before, a clock reset to 1970 after a power cut fails the self-test
+var saneClockFloor = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// selfTestCheck is a check run by the startup self-test
+type selfTestCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// startSelfTest runs the self-test in the background when SELF_TEST is enabled and sends
+// a "monitor online" message with its outcome, so it is known the monitor recovered
+// after e.g. a power cut
+func startSelfTest() {
+	if os.Getenv("SELF_TEST") != "true" {
+		return
+	}
+
+	goJob(func() {
+		notifyOnline(jobsCtx, runSelfTest(jobsCtx))
+	})
+}
+
+// selfTestChecks returns the checks of the database, the clock and every messaging backend in use
+func selfTestChecks() []selfTestCheck {
+	checks := []selfTestCheck{
+		{"database", db.CheckWritable},
+		{"clock", checkClock},
+	}
+
+	for _, backend := range alertBackends() {
+		checker, ok := backend.notifier.(notify.Checker)
+		if !ok {
+			continue
+		}
+
+		name := backend.notifier.Name()
+		checks = append(checks, selfTestCheck{name, func(ctx context.Context) error {
+			ctx, cancel := notifyContext(ctx)
+			defer cancel()
+
+			err := checker.Check(ctx)
+			recordCheck(name, err)
+			return err
+		}})
+	}
+
+	return checks
+}
+
+// runSelfTest runs the self-test checks and returns the failures
+func runSelfTest(ctx context.Context) []string {
+	var failures []string
+	for _, check := range selfTestChecks() {
+		if err := check.run(ctx); err != nil {
+			log.Printf("Self-test: %s failed: %v", check.name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", check.name, err))
+			continue
+		}
+		log.Printf("Self-test: %s ok", check.name)
+	}
+	return failures
+}
+
+// checkClock fails if the clock is unset or behind the latest stored reading
+func checkClock(ctx context.Context) error {
+	now := time.Now()
+	if now.Before(saneClockFloor) {
+		return fmt.Errorf("clock is not set, it reads %s", now.Format(time.RFC3339))
+	}
+
+	latest, err := db.GetLatestReadingTime(ctx)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.Sub(now) > clockSkewTolerance() {
+		return fmt.Errorf("clock is behind the latest reading at %s", latest.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// notifyOnline sends the "monitor online" message with the self-test failures, if any,
+// on the default messaging backends
+func notifyOnline(ctx context.Context, failures []string) {
+	message := "Septic monitor online, self-test passed"
+	if len(failures) > 0 {
+		message = "Septic monitor online, self-test failed: " + strings.Join(failures, "; ")
+	}
+
+	var recipients []string
+	if phoneNumber := setting("SMS_PHONE_NUMBER"); phoneNumber != "" {
+		recipients = append(recipients, phoneNumber)
+	}
+
+	for _, backend := range messageNotifiers("") {
+		if err := notifyAll(ctx, backend, "", recipients, message); err != nil {
+			log.Printf("Error sending online notification via %s: %v", backend.notifier.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"sceptic-monitor/internal/mqtt"
+)
+
+// background tracks the work started by readings and alerts that outlives the request,
+// e.g. checking the thresholds and sending the notifications
+var background sync.WaitGroup
+
+// goBackground runs the work in the background. Shutdown waits for it to finish, so a
+// restart doesn't drop an alert on the floor. The work pending counts towards the
+// ingestion queue depth.
+func goBackground(work func()) {
+	background.Add(1)
+	backgroundPending.Add(1)
+	go func() {
+		defer background.Done()
+		defer backgroundPending.Add(-1)
+		work()
+	}()
+}
+
+// jobs tracks the periodic jobs and polled inputs, which end once jobsCtx is cancelled
+// on shutdown
+var (
+	jobs              sync.WaitGroup
+	jobsCtx, stopJobs = context.WithCancel(context.Background())
+)
+
+// goJob runs a periodic job or polled input, which returns once jobsCtx is done
+func goJob(job func()) {
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		job()
+	}()
+}
+
+// every runs the job right away and then every interval until shutdown
+func every(interval time.Duration, job func()) {
+	goJob(func() {
+		for {
+			job()
+			select {
+			case <-time.After(interval):
+			case <-jobsCtx.Done():
+				return
+			}
+		}
+	})
+}
+
+// runServer serves on the address until SIGINT or SIGTERM, then shuts down gracefully:
+// it stops accepting connections, ends the live streams and drains the requests in
+// flight, stops the periodic jobs and MQTT input so no new work comes in, and waits
+// for the jobs and the background work, for at most SHUTDOWN_TIMEOUT seconds
+// (default: 30). The caller closes the database afterwards.
+func runServer(addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: addr}
+	server.RegisterOnShutdown(closeStreams)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve(server)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the server right away
+	stop()
+
+	log.Println("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), envSeconds("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error draining requests: %v", err)
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Error serving: %v", err)
+	}
+
+	// Stop the inputs first, the background work would keep growing otherwise
+	stopJobs()
+	if mqtt.Enabled() {
+		mqtt.Unsubscribe()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("Gave up waiting for pending notifications")
+	}
+
+	if mqtt.Enabled() {
+		mqtt.Disconnect()
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"sceptic-monitor/client"
+)
+
+// ScenarioStep is a reading, or a sensor fault, sent by a scenario
+type ScenarioStep struct {
+	Level  float64 `json:"level"`
+	Error  string  `json:"error,omitempty"`  // Report a sensor fault instead of a reading
+	Repeat int     `json:"repeat,omitempty"` // Send the step this many times (default: once)
+}
+
+// Scenario is a scripted sequence of readings replayed against a running server
+type Scenario struct {
+	Name  string         `json:"name"`
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// builtinScenario returns a named built-in scenario with levels relative to the threshold
+func builtinScenario(name string, threshold float64) (*Scenario, bool) {
+	var rise, plateau, pumpOut, sensorFailure []ScenarioStep
+	for i := 0; i <= 10; i++ {
+		rise = append(rise, ScenarioStep{Level: threshold * (0.6 + 0.05*float64(i))})
+	}
+	plateau = []ScenarioStep{{Level: threshold * 1.05, Repeat: 10}}
+	pumpOut = []ScenarioStep{{Level: threshold * 0.1}}
+	sensorFailure = []ScenarioStep{{Error: "sensor timeout", Repeat: 3}}
+
+	scenarios := map[string][]ScenarioStep{
+		"rise":           rise,
+		"plateau":        plateau,
+		"pump-out":       pumpOut,
+		"sensor-failure": sensorFailure,
+		"full":           append(append(append(append([]ScenarioStep{}, rise...), plateau...), pumpOut...), sensorFailure...),
+	}
+
+	steps, ok := scenarios[name]
+	if !ok {
+		return nil, false
+	}
+	return &Scenario{Name: name, Steps: steps}, true
+}
+
+// runSimulateScenario implements the simulate-scenario subcommand: it replays a scenario
+// against a test instance and reports which alerts changed state after each reading
+func runSimulateScenario(args []string) error {
+	flags := flag.NewFlagSet("simulate-scenario", flag.ExitOnError)
+	serverURL := flags.String("url", "http://localhost:8080", "base URL of the test instance")
+	deviceID := flags.String("device", "simulator", "device ID the readings are sent as")
+	threshold := flags.Float64("threshold", 200, "alert threshold the built-in scenarios are scaled to")
+	wait := flags.Duration("wait", 500*time.Millisecond, "time to let alerts settle after each reading")
+	apiKey := flags.String("api-key", "", "API key the readings are sent with, if the instance requires one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: simulate-scenario [flags] <rise|plateau|pump-out|sensor-failure|full|scenario.json>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one scenario")
+	}
+
+	scenario, ok := builtinScenario(flags.Arg(0), *threshold)
+	if !ok {
+		data, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to read scenario: %w", err)
+		}
+
+		scenario = &Scenario{Name: flags.Arg(0)}
+		if err := json.Unmarshal(data, scenario); err != nil {
+			return fmt.Errorf("failed to parse scenario: %w", err)
+		}
+	}
+
+	fmt.Printf("Running scenario %s against %s as device %s\n", scenario.Name, *serverURL, *deviceID)
+
+	ctx := context.Background()
+	c := client.New(*serverURL)
+	c.APIKey = *apiKey
+
+	seen := map[string]string{} // Alert ID to last reported state and severity
+	if _, err := alertChanges(ctx, c, *deviceID, seen); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	n := 0
+	for _, step := range scenario.Steps {
+		for i := 0; i < max(step.Repeat, 1); i++ {
+			n++
+			reading := client.Reading{Level: step.Level, DeviceID: *deviceID, Error: step.Error}
+			if err := c.Send(ctx, reading); err != nil {
+				return fmt.Errorf("step %d: %w", n, err)
+			}
+
+			desc := fmt.Sprintf("level %.2f", step.Level)
+			if step.Error != "" {
+				desc = "error " + step.Error
+			}
+
+			time.Sleep(*wait)
+
+			changes, err := alertChanges(ctx, c, *deviceID, seen)
+			if err != nil {
+				return fmt.Errorf("step %d: %w", n, err)
+			}
+
+			fmt.Printf("%6.1fs  #%-3d %-20s", time.Since(start).Seconds(), n, desc)
+			for _, a := range changes {
+				fmt.Printf("  alert %s %s (%s)", a.ID, a.State, a.Severity)
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// alertChanges returns the alerts of the device whose state or severity changed since last seen
+func alertChanges(ctx context.Context, c *client.Client, deviceID string, seen map[string]string) ([]client.Alert, error) {
+	alerts, err := c.Alerts(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alerts: %w", err)
+	}
+
+	var changes []client.Alert
+	for _, a := range alerts {
+		key := a.State + "/" + a.Severity
+		if a.DeviceID != deviceID || seen[a.ID] == key {
+			continue
+		}
+		seen[a.ID] = key
+		changes = append(changes, a)
+	}
+
+	return changes, nil
+}
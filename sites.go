@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// siteDevice is the state of one device on the site overview
+type siteDevice struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name,omitempty"`
+	Alert *db.Alert `json:"alert"` // Active alert, if any
+}
+
+// siteOverview is the site-level dashboard: the site with the state of its devices
+type siteOverview struct {
+	db.Site
+	Devices []siteDevice `json:"devices"`
+}
+
+func handleGetSites(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sites, err := db.GetSites(r.Context())
+	if err != nil {
+		log.Printf("Error getting sites: %v", err)
+		http.Error(w, "Failed to get sites", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sites)
+}
+
+func handleSite(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetSite(w, r)
+	case http.MethodPut:
+		handlePutSite(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetSite serves the site overview with the active alert of each device
+func handleGetSite(w http.ResponseWriter, r *http.Request) {
+	site, err := db.GetSite(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error getting site: %v", err)
+		http.Error(w, "Failed to get site", http.StatusInternalServerError)
+		return
+	}
+	if site == nil {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+
+	deviceIDs, err := db.GetSiteDeviceIDs(r.Context(), site.ID)
+	if err != nil {
+		log.Printf("Error getting site devices: %v", err)
+		http.Error(w, "Failed to get site", http.StatusInternalServerError)
+		return
+	}
+
+	overview := siteOverview{Site: *site, Devices: []siteDevice{}}
+	for _, id := range deviceIDs {
+		alert, err := db.GetActiveAlert(r.Context(), id, db.AlertKindLevel)
+		if err != nil {
+			log.Printf("Error getting active alert: %v", err)
+		}
+		device := siteDevice{ID: id, Alert: alert}
+		if d, err := db.GetDevice(r.Context(), id); err != nil {
+			log.Printf("Error getting device: %v", err)
+		} else if d != nil {
+			device.Name = d.Name
+		}
+		overview.Devices = append(overview.Devices, device)
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(overview)
+}
+
+func handlePutSite(w http.ResponseWriter, r *http.Request) {
+	var site db.Site
+	if err := json.NewDecoder(r.Body).Decode(&site); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	site.ID = r.PathValue("id")
+
+	if err := db.SaveSite(r.Context(), site); err != nil {
+		log.Printf("Error saving site: %v", err)
+		http.Error(w, "Failed to save site", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(site)
+}
+
+// handleAssignDeviceSite adds a device to a site with PUT and removes it with DELETE
+func handleAssignDeviceSite(w http.ResponseWriter, r *http.Request) {
+	siteID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodPut:
+		site, err := db.GetSite(r.Context(), siteID)
+		if err != nil {
+			log.Printf("Error getting site: %v", err)
+			http.Error(w, "Failed to get site", http.StatusInternalServerError)
+			return
+		}
+		if site == nil {
+			http.Error(w, "Site not found", http.StatusNotFound)
+			return
+		}
+	case http.MethodDelete:
+		siteID = ""
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := db.AssignDeviceSite(r.Context(), r.PathValue("device"), siteID); err != nil {
+		log.Printf("Error assigning device site: %v", err)
+		http.Error(w, "Failed to assign device", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UsageReport is the monthly usage per site, for billing property owners
+type UsageReport struct {
+	Month string      `json:"month"` // e.g. 2026-10
+	Sites []siteUsage `json:"sites"`
+}
+
+// siteUsage is the usage of one site in the usage report
+type siteUsage struct {
+	db.SiteUsage
+	Name string `json:"name,omitempty"`
+}
+
+// handleGetUsage reports the readings and notifications per site in the month given
+// as month=YYYY-MM (default: the current month)
+func handleGetUsage(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if s := r.URL.Query().Get("month"); s != "" {
+		month, err := time.ParseInLocation("2006-01", s, now.Location())
+		if err != nil {
+			http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		from = month
+	}
+	to := from.AddDate(0, 1, 0)
+
+	usage, err := db.GetSiteUsage(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error getting usage: %v", err)
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	sites, err := db.GetSites(r.Context())
+	if err != nil {
+		log.Printf("Error getting sites: %v", err)
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+	names := map[string]string{}
+	for _, site := range sites {
+		names[site.ID] = site.Name
+	}
+
+	report := UsageReport{Month: from.Format("2006-01"), Sites: []siteUsage{}}
+	for _, u := range usage {
+		report.Sites = append(report.Sites, siteUsage{SiteUsage: u, Name: names[u.SiteID]})
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
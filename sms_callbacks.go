@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/sms"
+)
+
+// requireSMSCallbackToken wraps an smsapi.pl callback handler so it answers 403 unless
+// the request carries SMS_CALLBACK_TOKEN as the token query parameter, e.g.
+// SMS_NOTIFY_URL=https://example.com/api/sms/dlr?token=... Without the token set the
+// callbacks are disabled, anyone could fake delivery reports or SMS commands otherwise.
+func requireSMSCallbackToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("SMS_CALLBACK_TOKEN")
+		given := r.URL.Query().Get("token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			log.Printf("Rejected SMS callback %s from %s: missing or invalid token", r.URL.Path, remoteHost(r))
+			http.Error(w, "Invalid token", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleSMSDeliveryReport receives smsapi.pl delivery reports (DLR) and updates the
+// status of the matching notifications. Reports repeating the status a notification
+// already has are ignored, so a failed notification is rerouted only once.
+func handleSMSDeliveryReport(w http.ResponseWriter, r *http.Request) {
+	// smsapi.pl calls the callback URL with GET, allow POST for manual testing
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Several reports may be batched into one callback as comma-separated lists
+	msgIDs := strings.Split(r.Form.Get("MsgId"), ",")
+	statuses := strings.Split(r.Form.Get("status"), ",")
+
+	for i, msgID := range msgIDs {
+		if msgID == "" || i >= len(statuses) {
+			continue
+		}
+
+		code, err := strconv.Atoi(statuses[i])
+		if err != nil {
+			log.Printf("Invalid delivery report status for message %s: %q", msgID, statuses[i])
+			continue
+		}
+
+		status := sms.DeliveryStatus(code)
+		notification, changed, err := db.UpdateNotificationStatus(r.Context(), "sms", msgID, status)
+		if err != nil {
+			log.Printf("Error updating notification status: %v", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		log.Printf("SMS delivery report: message %s to %s is %s (code %d)", msgID, notification.Recipient, status, code)
+
+		if status == sms.StatusFailed {
+			goBackground(func() { rerouteNotification(notification) })
+		}
+	}
+
+	// smsapi.pl expects a plain "OK" acknowledgment
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// smsFailoverBackends returns the backends undelivered SMS are rerouted to: those in
+// SMS_FAILOVER_NOTIFIERS, which may also name voice, or else the other backends alerts
+// go to. SMS itself is never one of them, a report of the failover failing would
+// reroute it again.
+func smsFailoverBackends() (backends []notifierBackend, viaVoice bool) {
+	names := os.Getenv("SMS_FAILOVER_NOTIFIERS")
+	if names == "" {
+		for _, backend := range alertBackends() {
+			if backend.notifier.Name() != "sms" {
+				backends = append(backends, backend)
+			}
+		}
+		return backends, false
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "voice":
+			viaVoice = true
+		case "sms":
+			log.Printf("Invalid SMS_FAILOVER_NOTIFIERS entry: %q, SMS can't fail over to SMS", name)
+		default:
+			backends = append(backends, parseNotifiers("SMS_FAILOVER_NOTIFIERS", name)...)
+		}
+	}
+	return backends, viaVoice
+}
+
+// rerouteNotification resends an undelivered SMS notification through the failover backends
+func rerouteNotification(notification *db.Notification) {
+	// Rerouting runs in the background, after the delivery report was answered
+	ctx := context.Background()
+
+	backends, viaVoice := smsFailoverBackends()
+	if len(backends) == 0 && !viaVoice {
+		log.Printf("Notification %d was not delivered and no failover channel is configured", notification.ID)
+		return
+	}
+
+	// The alert may have been acknowledged on another channel meanwhile
+	if notification.AlertID != "" && !alertPending(ctx, notification.AlertID) {
+		log.Printf("Alert %s acknowledged or resolved, not rerouting notification %d", notification.AlertID, notification.ID)
+		return
+	}
+
+	message := fmt.Sprintf("SMS to %s not delivered: %s", notification.Recipient, notification.Message)
+	for _, backend := range backends {
+		if err := sendNotification(ctx, backend.notifier, notification.AlertID, "", message); err != nil {
+			log.Printf("Error rerouting notification %d to %s: %v", notification.ID, backend.notifier.Name(), err)
+			continue
+		}
+		log.Printf("Notification %d rerouted to %s", notification.ID, backend.notifier.Name())
+	}
+
+	if viaVoice {
+		if err := callVoice(ctx, notification.AlertID, notification.Message); err != nil {
+			log.Printf("Error rerouting notification %d to a voice call: %v", notification.ID, err)
+			return
+		}
+		log.Printf("Notification %d rerouted to a voice call", notification.ID)
+	}
+}
+
+// handleInboundSMS receives smsapi.pl inbound SMS callbacks and executes the
+// STATUS and ACK commands sent from a known phone number
+func handleInboundSMS(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	sender := r.Form.Get("sms_from")
+	command := strings.ToUpper(strings.TrimSpace(r.Form.Get("sms_text")))
+
+	// Only accept commands from the numbers we send alerts to
+	if !isKnownPhoneNumber(r.Context(), sender) {
+		log.Printf("Ignoring SMS command from unknown number %s", sender)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
+
+	var reply string
+	switch command {
+	case "STATUS":
+		reply = statusReply(r.Context())
+	case "ACK":
+		if acknowledgeAlert(r.Context(), "") {
+			reply = "Alert acknowledged, notifications paused until the level drops below the threshold"
+		} else {
+			reply = "No active alert to acknowledge"
+		}
+	default:
+		reply = "Unknown command. Reply STATUS for the current level or ACK to acknowledge the active alert"
+	}
+
+	log.Printf("SMS command %q from %s", command, sender)
+
+	if err := sendSMS(r.Context(), "", sender, reply); err != nil {
+		log.Printf("Error sending SMS reply: %v", err)
+	}
+
+	// smsapi.pl expects a plain "OK" acknowledgment
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// isKnownPhoneNumber reports whether the number is one of the configured alert recipients
+func isKnownPhoneNumber(ctx context.Context, phoneNumber string) bool {
+	number := digitsOnly(phoneNumber)
+	if number == "" {
+		return false
+	}
+
+	known := []string{os.Getenv("SMS_PHONE_NUMBER")}
+
+	sites, err := db.GetSites(ctx)
+	if err != nil {
+		log.Printf("Error getting sites: %v", err)
+	}
+	for _, site := range sites {
+		known = append(known, site.Recipients...)
+	}
+
+	for _, known := range known {
+		if known != "" && digitsOnly(known) == number {
+			return true
+		}
+	}
+	return false
+}
+
+// digitsOnly strips everything but digits so "+48 500-000-000" matches "48500000000"
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// statusReply describes the current level, of every tank when several are monitored
+func statusReply(ctx context.Context) string {
+	levels, err := db.GetDeviceLevels(ctx)
+	if err != nil {
+		log.Printf("Error getting level data: %v", err)
+		return "Status: no level data available"
+	}
+
+	switch len(levels) {
+	case 0:
+		return "Status: no level data available"
+	case 1:
+		reply := fmt.Sprintf("Status: current level is %.2f", levels[0].Level)
+		if pumpOut := pumpOutSummary(ctx, levels[0].DeviceID); pumpOut != "" {
+			reply += ", " + pumpOut
+		}
+		return reply
+	}
+
+	var parts []string
+	for _, l := range levels {
+		label := deviceLabel(ctx, l.DeviceID)
+		if label == "" {
+			label = l.DeviceID
+		}
+		parts = append(parts, fmt.Sprintf("%s %.2f", label, l.Level))
+	}
+	return "Status: current levels are " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// sqlQueryEnabled reports whether the SQL endpoint is turned on with SQL_QUERY_ENABLED.
+// It reads the whole database, so it needs ADMIN_API_KEY to be set as well.
+func sqlQueryEnabled() bool {
+	if os.Getenv("SQL_QUERY_ENABLED") != "true" {
+		return false
+	}
+	if os.Getenv("ADMIN_API_KEY") == "" {
+		log.Println("SQL_QUERY_ENABLED is set but ADMIN_API_KEY isn't, /api/admin/query is disabled")
+		return false
+	}
+	return true
+}
+
+// sqlQueryMaxRows returns the most rows a query returns from SQL_QUERY_MAX_ROWS
+// (default: 1000)
+func sqlQueryMaxRows() int {
+	maxRows := 1000
+	if s := os.Getenv("SQL_QUERY_MAX_ROWS"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			log.Printf("Invalid SQL_QUERY_MAX_ROWS value: %s, using default: %d", s, maxRows)
+		} else {
+			maxRows = v
+		}
+	}
+	return maxRows
+}
+
+// handleSQLQuery runs a read-only SQL query for ad-hoc analytics, e.g.
+// {"query": "SELECT date(created_at), max(level) FROM level_data WHERE device_id = ? GROUP BY 1", "params": ["tank1"]}.
+// Rows are limited to the given limit, at most SQL_QUERY_MAX_ROWS, and queries are
+// cancelled after SQL_QUERY_TIMEOUT seconds (default: 10).
+func handleSQLQuery(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Query  string `json:"query"`
+		Params []any  `json:"params"`
+		Limit  int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	limit := sqlQueryMaxRows()
+	if body.Limit > 0 && body.Limit < limit {
+		limit = body.Limit
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), envSeconds("SQL_QUERY_TIMEOUT", 10*time.Second))
+	defer cancel()
+
+	result, err := db.Query(ctx, body.Query, body.Params, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidQuery):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case ctx.Err() != nil:
+			http.Error(w, "Query timed out", http.StatusGatewayTimeout)
+		default:
+			// Mostly mistakes in the query, which the admin needs to see
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	log.Printf("SQL query from %s returned %d rows", remoteHost(r), len(result.Rows))
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
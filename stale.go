@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// staleAfter returns how long a device may go without a reading before it is reported
+// offline, from STALE_AFTER as a duration such as 2h, or false if the watchdog is disabled
+func staleAfter() (time.Duration, bool) {
+	s := os.Getenv("STALE_AFTER")
+	if s == "" {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid STALE_AFTER value: %q, sensor offline watchdog disabled", s)
+		return 0, false
+	}
+	return d, true
+}
+
+// startStaleWatchdog checks every minute for devices that stopped sending readings,
+// if STALE_AFTER is configured. A dead sensor or flat battery would fail silently otherwise.
+func startStaleWatchdog() {
+	after, ok := staleAfter()
+	if !ok {
+		return
+	}
+
+	every(time.Minute, func() { checkStale(after) })
+	log.Printf("Reporting devices not heard from for %v", after)
+}
+
+// checkStale raises a stale alert for every device last seen longer than after ago, and
+// resolves it once messages arrive again. Last seen is updated by every message, also
+// those the storage policy doesn't store, so a steady level doesn't look like silence.
+func checkStale(after time.Duration) {
+	devices, err := db.GetDevices(context.Background())
+	if err != nil {
+		log.Printf("Error getting devices: %v", err)
+		return
+	}
+
+	for _, device := range devices {
+		if device.LastSeen == nil {
+			continue // Registered, e.g. with a token, but never heard from
+		}
+		updateHardLimit(device.ID, db.AlertKindStale, time.Since(*device.LastSeen) > after)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+)
+
+// Coarse system states shown on the public status page
+const (
+	statusOK       = "OK"
+	statusWarning  = "Warning"
+	statusCritical = "Critical"
+)
+
+// PublicStatus is the coarse state exposed without authentication. It deliberately
+// leaves out levels, device IDs and anything else beyond what a guest needs.
+type PublicStatus struct {
+	Status      string     `json:"status"`
+	LastUpdated *time.Time `json:"last_updated"`
+}
+
+// publicStatus derives the coarse state from the active alerts
+func publicStatus(ctx context.Context) (PublicStatus, error) {
+	alerts, err := db.GetActiveAlerts(ctx)
+	if err != nil {
+		return PublicStatus{}, err
+	}
+
+	status := PublicStatus{Status: statusOK}
+	for _, alert := range alerts {
+		if alert.Severity == incident.SeverityCritical {
+			status.Status = statusCritical
+			break
+		}
+		status.Status = statusWarning
+	}
+
+	if status.LastUpdated, err = db.GetLatestReadingTime(ctx); err != nil {
+		return PublicStatus{}, err
+	}
+
+	return status, nil
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="300">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; padding: 1.5em; text-align: center; }
+.status { display: inline-block; padding: 0.5em 1.5em; border-radius: 0.5em; font-size: 2em; color: #fff; }
+.OK { background: #2e7d32; }
+.Warning { background: #f9a825; }
+.Critical { background: #c62828; }
+.updated { color: #666; margin-top: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="status {{.Status.Status}}">{{.Status.Status}}</div>
+<p class="updated">{{if .Status.LastUpdated}}Last update: {{.Status.LastUpdated.Format "2006-01-02 15:04 MST"}}{{else}}No data yet{{end}}</p>
+</body>
+</html>
+`))
+
+func handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := publicStatus(r.Context())
+	if err != nil {
+		log.Printf("Error getting status: %v", err)
+		http.Error(w, "Failed to get status", http.StatusInternalServerError)
+		return
+	}
+
+	// Get page title from environment, default to "Septic system status" if not set
+	title := os.Getenv("STATUS_PAGE_TITLE")
+	if title == "" {
+		title = "Septic system status"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := statusPageTemplate.Execute(w, map[string]any{"Title": title, "Status": status}); err != nil {
+		log.Printf("Error rendering status page: %v", err)
+	}
+}
+
+func handleStatusJSON(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := publicStatus(r.Context())
+	if err != nil {
+		log.Printf("Error getting status: %v", err)
+		http.Error(w, "Failed to get status", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// Storage policies deciding which trusted readings are persisted
+const (
+	storageAll = "all" // Every reading, optionally skipping duplicates within DEDUP_WINDOW
+	storageCOV = "cov" // Only changes of value beyond COV_DELTA, plus a heartbeat every COV_HEARTBEAT
+)
+
+// lastStored holds the last reading stored per device, the reference for the storage policy
+var (
+	lastStoredMux sync.Mutex
+	lastStored    = map[string]db.Reading{}
+)
+
+// storagePolicy returns the deadband within which the storage policy skips a reading
+// and for how long after the reference reading, a zero interval stores every reading
+func storagePolicy() (float64, time.Duration) {
+	var deadband float64
+	var interval time.Duration
+	switch policy := os.Getenv("STORAGE_POLICY"); policy {
+	case storageCOV:
+		// Change of value: the deadband is kept however long the level stays
+		// within it, except for a heartbeat (default: 1 hour)
+		deadband, _ = envLevel("COV_DELTA")
+		interval = envSeconds("COV_HEARTBEAT", time.Hour)
+	case "", storageAll:
+		// Dedup: identical readings are skipped only within a short window (default: 0, disabled)
+		deadband, _ = envLevel("DEDUP_EPSILON")
+		interval = envSeconds("DEDUP_WINDOW", 0)
+	default:
+		log.Printf("Invalid STORAGE_POLICY value: %s, storing every reading", policy)
+	}
+	return deadband, interval
+}
+
+// repeatsReading reports whether the reading is within the deadband of the reference
+// reading and the interval since it
+func repeatsReading(reference, reading db.Reading, deadband float64, interval time.Duration) bool {
+	return math.Abs(reading.Level-reference.Level) <= deadband && reading.Timestamp.Sub(reference.Timestamp) < interval
+}
+
+// isRedundantReading reports whether the storage policy skips the reading because it
+// is within the deadband of the last one stored for the device and that one is recent
+// enough. Readings that are stored become the new reference.
+func isRedundantReading(deviceID string, reading db.Reading) bool {
+	deadband, interval := storagePolicy()
+	if interval <= 0 {
+		return false
+	}
+
+	lastStoredMux.Lock()
+	defer lastStoredMux.Unlock()
+
+	last, ok := lastStored[deviceID]
+	if ok && repeatsReading(last, reading, deadband, interval) {
+		return true
+	}
+
+	lastStored[deviceID] = reading
+	return false
+}
+
+// envSeconds returns the duration configured in seconds in the environment variable, or def
+func envSeconds(key string, def time.Duration) time.Duration {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
+	}
+
+	seconds, err := strconv.Atoi(s)
+	if err != nil {
+		log.Printf("Invalid %s value: %v, using default %v", key, err, def)
+		return def
+	}
+
+	return time.Duration(seconds) * time.Second
+}
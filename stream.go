@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sceptic-monitor/internal/webhook"
+)
+
+// Types of the messages pushed on the live stream
+const (
+	streamReading = "reading" // Stored reading, a ReadingEvent
+	streamAlert   = "alert"   // Alert or resolved event, as posted to webhooks
+)
+
+// Timing of the live stream connections
+const (
+	streamPingInterval = 30 * time.Second // Also the interval of Server-Sent Events keep-alives
+	streamWriteTimeout = 10 * time.Second
+	streamBuffer       = 64 // Messages queued per client before it is dropped as too slow
+)
+
+// StreamMessage is a message pushed on the live stream
+type StreamMessage struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// streamClient is a connected live stream client
+type streamClient struct {
+	deviceID string // Only messages of this device, empty for all
+	messages chan StreamMessage
+}
+
+// streamClients holds the connected clients
+var (
+	streamClientsMux sync.Mutex
+	streamClients    = map[*streamClient]bool{}
+)
+
+// streamsClosed is closed when the server shuts down, ending the live streams
+var (
+	streamsClosed    = make(chan struct{})
+	closeStreamsOnce sync.Once
+)
+
+// closeStreams ends the live streams on shutdown, which doesn't wait for the hijacked
+// WebSockets and would wait on the event streams until it times out
+func closeStreams() {
+	closeStreamsOnce.Do(func() { close(streamsClosed) })
+}
+
+// streamUpgrader accepts connections from any origin, like the widget JSON, so dashboards
+// hosted elsewhere can connect, unless read auth is set
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: sameOriginOrUnprotected,
+}
+
+// broadcastStream pushes a message of the device to the connected clients. Clients too
+// slow to keep up are disconnected rather than holding up the readings.
+func broadcastStream(typ, deviceID string, data any) {
+	streamClientsMux.Lock()
+	defer streamClientsMux.Unlock()
+
+	for client := range streamClients {
+		if client.deviceID != "" && client.deviceID != deviceID {
+			continue
+		}
+		select {
+		case client.messages <- StreamMessage{Type: typ, Data: data}:
+		default:
+			delete(streamClients, client)
+			close(client.messages)
+		}
+	}
+}
+
+// subscribeStream connects a client to the stream, of a single device unless deviceID is empty
+func subscribeStream(deviceID string) *streamClient {
+	client := &streamClient{deviceID: deviceID, messages: make(chan StreamMessage, streamBuffer)}
+	streamClientsMux.Lock()
+	streamClients[client] = true
+	streamClientsMux.Unlock()
+	return client
+}
+
+// unsubscribeStream disconnects a client, unless it was already dropped as too slow
+func unsubscribeStream(client *streamClient) {
+	streamClientsMux.Lock()
+	if streamClients[client] {
+		delete(streamClients, client)
+		close(client.messages)
+	}
+	streamClientsMux.Unlock()
+}
+
+// streamThrottle downsamples the readings of a stream for clients that only want a
+// point per interval, e.g. low-power displays next to a chatty sensor. Readings are
+// held back and emitted once per interval and device: the latest, or the average level
+// within the interval with the time and quality of the latest. Alerts aren't held back.
+type streamThrottle struct {
+	interval time.Duration
+	average  bool
+	pending  map[string]*pendingReading
+	devices  []string // Devices with pending readings, in order of their first one
+}
+
+type pendingReading struct {
+	latest ReadingEvent
+	sum    float64
+	count  int
+}
+
+// parseStreamThrottle returns the throttle requested with interval=<seconds> and
+// mode=latest|average (default: latest), or nil if no interval is given
+func parseStreamThrottle(r *http.Request) (*streamThrottle, error) {
+	query := r.URL.Query()
+	if query.Get("interval") == "" {
+		return nil, nil
+	}
+
+	seconds, err := strconv.Atoi(query.Get("interval"))
+	if err != nil || seconds < 1 {
+		return nil, fmt.Errorf("interval must be a positive number of seconds")
+	}
+
+	t := &streamThrottle{interval: time.Duration(seconds) * time.Second, pending: map[string]*pendingReading{}}
+	switch query.Get("mode") {
+	case "", "latest":
+	case "average":
+		t.average = true
+	default:
+		return nil, fmt.Errorf("mode must be latest or average")
+	}
+	return t, nil
+}
+
+// hold holds back the message if it is a reading, reporting whether it did
+func (t *streamThrottle) hold(message StreamMessage) bool {
+	reading, ok := message.Data.(ReadingEvent)
+	if message.Type != streamReading || !ok {
+		return false
+	}
+
+	p := t.pending[reading.DeviceID]
+	if p == nil {
+		p = &pendingReading{}
+		t.pending[reading.DeviceID] = p
+		t.devices = append(t.devices, reading.DeviceID)
+	}
+	p.latest = reading
+	p.sum += reading.Level
+	p.count++
+	return true
+}
+
+// flush returns the readings held back during the interval
+func (t *streamThrottle) flush() []StreamMessage {
+	messages := make([]StreamMessage, 0, len(t.devices))
+	for _, deviceID := range t.devices {
+		p := t.pending[deviceID]
+		reading := p.latest
+		if t.average {
+			reading.Level = p.sum / float64(p.count)
+		}
+		messages = append(messages, StreamMessage{Type: streamReading, Data: reading})
+	}
+
+	clear(t.pending)
+	t.devices = t.devices[:0]
+	return messages
+}
+
+// ticks returns the channel the throttle is flushed on, nil without a throttle so it
+// never fires
+func (t *streamThrottle) ticks() (<-chan time.Time, func()) {
+	if t == nil {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(t.interval)
+	return ticker.C, ticker.Stop
+}
+
+// broadcastAlertEvent pushes an alert or resolved event to the connected clients
+func broadcastAlertEvent(event webhook.Event) {
+	broadcastStream(streamAlert, event.DeviceID, event)
+}
+
+// handleStream upgrades the request to a WebSocket and pushes the stored readings and
+// alert events as they happen, of a single device if given as device=<id>. With
+// interval=<seconds> the readings are downsampled, see streamThrottle.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	throttle, err := parseStreamThrottle(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with the error
+		log.Printf("Error upgrading stream connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := subscribeStream(r.URL.Query().Get("device"))
+	defer unsubscribeStream(client)
+
+	// Read until the client disconnects, it has nothing to say beyond control frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(message StreamMessage) bool {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Error encoding stream message: %v", err)
+			return true
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteMessage(websocket.TextMessage, payload) == nil
+	}
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	ticks, stop := throttle.ticks()
+	defer stop()
+
+	for {
+		select {
+		case message, ok := <-client.messages:
+			if !ok {
+				log.Printf("Stream client %s too slow, disconnecting", r.RemoteAddr)
+				return
+			}
+			if throttle != nil && throttle.hold(message) {
+				continue
+			}
+			if !send(message) {
+				return
+			}
+		case <-ticks:
+			for _, message := range throttle.flush() {
+				if !send(message) {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-streamsClosed:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(streamWriteTimeout))
+			return
+		}
+	}
+}
+
+// handleEvents pushes the same messages as /api/stream as Server-Sent Events, for clients
+// that can't use WebSockets, e.g. curl -N. Each event is named after the message type and
+// carries its data as JSON. It takes the same device, interval and mode parameters.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	throttle, err := parseStreamThrottle(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := subscribeStream(r.URL.Query().Get("device"))
+	defer unsubscribeStream(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !readAuthConfigured() {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	send := func(message StreamMessage) {
+		data, err := json.Marshal(message.Data)
+		if err != nil {
+			log.Printf("Error encoding event: %v", err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, data)
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(streamPingInterval)
+	defer keepAlive.Stop()
+	ticks, stop := throttle.ticks()
+	defer stop()
+
+	for {
+		select {
+		case message, ok := <-client.messages:
+			if !ok {
+				log.Printf("Event client %s too slow, disconnecting", r.RemoteAddr)
+				return
+			}
+			if throttle != nil && throttle.hold(message) {
+				continue
+			}
+			send(message)
+		case <-ticks:
+			for _, message := range throttle.flush() {
+				send(message)
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-streamsClosed:
+			return
+		}
+	}
+}
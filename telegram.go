@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/telegram"
+)
+
+// telegramPollTimeout is how long a getUpdates long poll waits for a message
+const telegramPollTimeout = 30 * time.Second
+
+// startTelegramBot answers the /status and /ack commands sent in the configured chat,
+// if a Telegram bot is configured
+func startTelegramBot() {
+	if !telegram.Enabled() {
+		return
+	}
+
+	chatID, err := strconv.ParseInt(os.Getenv("TELEGRAM_CHAT_ID"), 10, 64)
+	if err != nil {
+		log.Printf("Invalid TELEGRAM_CHAT_ID value: %v, not answering Telegram commands", err)
+		return
+	}
+
+	goJob(func() { pollTelegram(chatID) })
+	log.Printf("Answering Telegram commands in chat %d", chatID)
+}
+
+// pollTelegram long-polls the bot's updates and answers the commands in the chat,
+// messages from other chats are ignored. It returns on shutdown.
+func pollTelegram(chatID int64) {
+	var offset int64
+	lastErr := ""
+	for jobsCtx.Err() == nil {
+		ctx, cancel := context.WithTimeout(jobsCtx, telegramPollTimeout+10*time.Second)
+		updates, err := telegram.GetUpdates(ctx, offset, telegramPollTimeout)
+		cancel()
+		if jobsCtx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// Log poll errors once until polling recovers
+			if err.Error() != lastErr {
+				log.Printf("Error polling Telegram: %v", err)
+				lastErr = err.Error()
+			}
+			select {
+			case <-time.After(5 * time.Second):
+			case <-jobsCtx.Done():
+			}
+			continue
+		}
+		lastErr = ""
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+
+			if update.Message == nil || update.Message.Chat.ID != chatID {
+				continue
+			}
+			if reply := telegramReply(context.Background(), update.Message.Text); reply != "" {
+				if err := sendNotification(context.Background(), telegram.Notifier{}, "", "", reply); err != nil {
+					log.Printf("Error sending Telegram reply: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// telegramReply executes a bot command and returns the reply, empty for messages that aren't commands
+func telegramReply(ctx context.Context, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+
+	// Commands in group chats may be addressed to the bot, e.g. /status@SepticBot
+	command, _, _ := strings.Cut(strings.ToLower(fields[0]), "@")
+	log.Printf("Telegram command %q", command)
+
+	switch command {
+	case "/status":
+		return statusReply(ctx) + "\n" + lastAlertSummary(ctx)
+	case "/ack":
+		if acknowledgeAlert(ctx, "") {
+			return "Alert acknowledged, notifications paused until the level drops below the threshold"
+		}
+		return "No active alert to acknowledge"
+	default:
+		return "Unknown command. Send /status for the current level and last alert or /ack to acknowledge the active alert"
+	}
+}
+
+// lastAlertSummary describes the most recent alert of any device
+func lastAlertSummary(ctx context.Context) string {
+	alerts, err := db.GetAlerts(ctx, "", "", 1)
+	if err != nil {
+		log.Printf("Error getting alerts: %v", err)
+		return "Last alert: unknown"
+	}
+	if len(alerts) == 0 {
+		return "Last alert: none"
+	}
+
+	alert := alerts[0]
+	summary := fmt.Sprintf("Last alert: %s %s", alert.Severity, alert.Kind)
+	if label := deviceLabel(ctx, alert.DeviceID); label != "" {
+		summary += " (" + label + ")"
+	}
+	return fmt.Sprintf("%s raised %s, %s", summary, alert.RaisedAt.Local().Format("2006-01-02 15:04"), alert.State)
+}
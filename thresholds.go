@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/incident"
+)
+
+// levelThreshold is a named level that raises a level alert, with its own notification settings
+type levelThreshold struct {
+	name      string
+	level     float64
+	severity  string
+	message   string        // Template of the alert message, empty for the default message
+	cooldown  time.Duration // Between notifications of an alert at this threshold
+	notifiers string        // Messaging backends, empty for those of the severity
+	once      bool          // Notified once per state change, without reminders
+}
+
+// Notification policies of a threshold
+const (
+	notifyRepeat = "repeat" // Reminders every cooldown until the alert is acknowledged or resolved
+	notifyOnce   = "once"   // One notification when raised or escalated, one when resolved
+)
+
+// levelThresholds returns the configured thresholds, lowest first, from LEVEL_THRESHOLDS
+// entries of the form "name=level,...", e.g. "warning=140,critical=180". Without it,
+// LEVEL_THRESHOLD and LEVEL_CRITICAL_THRESHOLD are the warning and critical thresholds.
+// In vacation mode they are all lowered by VACATION_THRESHOLD_OFFSET.
+//
+// Each threshold can be configured further with
+//   - THRESHOLD_<NAME>_SEVERITY: warning or critical (default: the name if it is one of
+//     them, otherwise warning)
+//   - THRESHOLD_<NAME>_MESSAGE: the alert message, where {name}, {level}, {threshold}
+//     and {device} are replaced with their values
+//   - THRESHOLD_<NAME>_COOLDOWN: minutes between notifications (default: SMS_COOLDOWN)
+//   - THRESHOLD_<NAME>_NOTIFIERS: the messaging backends, like NOTIFIERS (default: those
+//     of the severity)
+//   - THRESHOLD_<NAME>_NOTIFY: repeat to remind every cooldown (default) or once to notify
+//     only when an alert at the threshold is raised or escalated, and when it resolves
+func levelThresholds() []levelThreshold {
+	entries := setting("LEVEL_THRESHOLDS")
+	if entries == "" {
+		// Legacy single thresholds
+		for _, t := range []struct{ name, key string }{
+			{incident.SeverityWarning, "LEVEL_THRESHOLD"},
+			{incident.SeverityCritical, "LEVEL_CRITICAL_THRESHOLD"},
+		} {
+			if level := setting(t.key); level != "" {
+				entries += "," + t.name + "=" + level
+			}
+		}
+	}
+
+	var thresholds []levelThreshold
+	for _, entry := range strings.Split(entries, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, levelStr, ok := strings.Cut(entry, "=")
+		level, err := strconv.ParseFloat(strings.TrimSpace(levelStr), 64)
+		if !ok || err != nil {
+			log.Printf("Invalid LEVEL_THRESHOLDS entry: %q", entry)
+			continue
+		}
+
+		thresholds = append(thresholds, newLevelThreshold(strings.TrimSpace(name), level))
+	}
+
+	// Vacation mode lowers every threshold, a slow leak reaches them sooner
+	if offset := vacationOffset(); offset != 0 {
+		for i := range thresholds {
+			thresholds[i].level -= offset
+		}
+	}
+
+	sort.SliceStable(thresholds, func(i, j int) bool {
+		return thresholds[i].level < thresholds[j].level
+	})
+	return thresholds
+}
+
+// newLevelThreshold returns the threshold at the level with the settings configured for its name
+func newLevelThreshold(name string, level float64) levelThreshold {
+	t := levelThreshold{
+		name:      name,
+		level:     level,
+		severity:  incident.SeverityWarning,
+		message:   setting(thresholdKey(name, "MESSAGE")),
+		cooldown:  smsCooldown(),
+		notifiers: setting(thresholdKey(name, "NOTIFIERS")),
+	}
+
+	switch severity := setting(thresholdKey(name, "SEVERITY")); severity {
+	case "":
+		if name == incident.SeverityCritical {
+			t.severity = incident.SeverityCritical
+		}
+	case incident.SeverityWarning, incident.SeverityCritical:
+		t.severity = severity
+	default:
+		log.Printf("Invalid %s value: %s, using warning", thresholdKey(name, "SEVERITY"), severity)
+	}
+
+	switch policy := setting(thresholdKey(name, "NOTIFY")); policy {
+	case "", notifyRepeat:
+	case notifyOnce:
+		t.once = true
+	default:
+		log.Printf("Invalid %s value: %s, using repeat", thresholdKey(name, "NOTIFY"), policy)
+	}
+
+	if cooldownStr := setting(thresholdKey(name, "COOLDOWN")); cooldownStr != "" {
+		minutes, err := strconv.Atoi(cooldownStr)
+		if err != nil {
+			log.Printf("Invalid %s value: %v, using SMS_COOLDOWN", thresholdKey(name, "COOLDOWN"), err)
+		} else {
+			t.cooldown = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	return t
+}
+
+// thresholdAt returns the threshold at the level, or the fallback if it is no longer configured
+func thresholdAt(thresholds []levelThreshold, level float64, fallback levelThreshold) levelThreshold {
+	for _, t := range thresholds {
+		if t.level == level {
+			return t
+		}
+	}
+	return fallback
+}
+
+// thresholdKey returns the variable configuring the setting of the named threshold,
+// e.g. THRESHOLD_WARNING_COOLDOWN
+func thresholdKey(name, setting string) string {
+	return "THRESHOLD_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_" + setting
+}
+
+// alertThreshold returns the lowest threshold level, the one level alerts are raised at
+func alertThreshold() (float64, bool) {
+	thresholds := levelThresholds()
+	if len(thresholds) == 0 {
+		return 0, false
+	}
+	return thresholds[0].level, true
+}
+
+// topThreshold returns the highest threshold level
+func topThreshold() (float64, bool) {
+	thresholds := levelThresholds()
+	if len(thresholds) == 0 {
+		return 0, false
+	}
+	return thresholds[len(thresholds)-1].level, true
+}
+
+// smsCooldown returns the time between notifications of an alert from SMS_COOLDOWN
+// in minutes (default: 60 minutes)
+func smsCooldown() time.Duration {
+	// Get cooldown period from environment in minutes (default: 60 minutes = 1 hour)
+	cooldownMinutesStr := setting("SMS_COOLDOWN")
+	if cooldownMinutesStr == "" {
+		cooldownMinutesStr = "60" // Default to 60 minutes (1 hour)
+	}
+
+	cooldownMinutes, err := strconv.Atoi(cooldownMinutesStr)
+	if err != nil {
+		log.Printf("Invalid SMS_COOLDOWN value: %v, using default 60 minutes", err)
+		cooldownMinutes = 60
+	}
+
+	return time.Duration(cooldownMinutes) * time.Minute
+}
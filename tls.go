@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsMode returns how the server is served over HTTPS: "cert" with the certificate in
+// TLS_CERT and TLS_KEY, "autocert" with certificates from Let's Encrypt for the domains
+// in TLS_AUTOCERT_DOMAINS, or "" for plain HTTP
+func tlsMode() string {
+	switch {
+	case os.Getenv("TLS_AUTOCERT_DOMAINS") != "":
+		return "autocert"
+	case os.Getenv("TLS_CERT") != "" || os.Getenv("TLS_KEY") != "":
+		return "cert"
+	default:
+		return ""
+	}
+}
+
+// certReloader serves the certificate in TLS_CERT and TLS_KEY, reloading it when the
+// files change, so certificates renewed by e.g. certbot are picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// getCertificate returns the certificate, reloaded if the certificate file changed.
+// The previous certificate is kept if the new one fails to load, e.g. while the files
+// are being replaced.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.certFile)
+	if err == nil && info.ModTime().Equal(c.modTime) && c.cert != nil {
+		return c.cert, nil
+	}
+
+	cert, loadErr := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if loadErr != nil {
+		if c.cert != nil {
+			log.Printf("Error reloading TLS certificate, keeping the current one: %v", loadErr)
+			return c.cert, nil
+		}
+		return nil, loadErr
+	}
+	if c.cert != nil {
+		log.Printf("Reloaded TLS certificate from %s", c.certFile)
+	}
+	c.cert = &cert
+	if err == nil {
+		c.modTime = info.ModTime()
+	}
+	return c.cert, nil
+}
+
+// httpsRedirect redirects plain HTTP requests to HTTPS. 308 keeps the method and body,
+// so sensors still posting over HTTP are told to repeat their POST over HTTPS.
+func httpsRedirect(addr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(addr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
+
+// serve runs the server over HTTPS if configured, with an optional plain HTTP listener
+// on TLS_HTTP_PORT redirecting to it and closed along with the server. With autocert
+// the HTTP listener answers the Let's Encrypt challenges too, and defaults to port 80.
+func serve(server *http.Server) error {
+	mode := tlsMode()
+	if mode == "" {
+		return server.ListenAndServe()
+	}
+
+	addr := server.Addr
+	httpPort := os.Getenv("TLS_HTTP_PORT")
+	var httpHandler http.Handler = httpsRedirect(addr)
+
+	switch mode {
+	case "cert":
+		if os.Getenv("TLS_CERT") == "" || os.Getenv("TLS_KEY") == "" {
+			return fmt.Errorf("TLS_CERT and TLS_KEY must both be set")
+		}
+		reloader := &certReloader{certFile: os.Getenv("TLS_CERT"), keyFile: os.Getenv("TLS_KEY")}
+		if _, err := reloader.getCertificate(nil); err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: reloader.getCertificate}
+	case "autocert":
+		var domains []string
+		for _, domain := range strings.Split(os.Getenv("TLS_AUTOCERT_DOMAINS"), ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		cache := os.Getenv("TLS_AUTOCERT_CACHE")
+		if cache == "" {
+			cache = "autocert"
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cache),
+			Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		httpHandler = manager.HTTPHandler(httpHandler)
+		if httpPort == "" {
+			httpPort = "80"
+		}
+		log.Printf("Getting certificates for %s from Let's Encrypt, cached in %s", strings.Join(domains, ", "), cache)
+	}
+
+	if httpPort != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		redirect := &http.Server{Addr: net.JoinHostPort(host, httpPort), Handler: httpHandler}
+		server.RegisterOnShutdown(func() { redirect.Close() })
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Error serving HTTP: %v", err)
+			}
+		}()
+	}
+
+	// The certificates come from the TLS config
+	return server.ListenAndServeTLS("", "")
+}
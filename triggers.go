@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// triggerLimit is the most items a polling trigger returns at once
+const triggerLimit = 50
+
+// alertTrigger is an alert as returned by the alert polling trigger
+type alertTrigger struct {
+	ID        string    `json:"id"`     // Alert ID, for deduplication by the polling service
+	Cursor    string    `json:"cursor"` // Pass as cursor to only get newer alerts
+	DeviceID  string    `json:"device_id"`
+	Device    string    `json:"device,omitempty"` // Name and location of the device, if set
+	Kind      string    `json:"kind"`
+	Severity  string    `json:"severity"`
+	Level     float64   `json:"level"`
+	Threshold float64   `json:"threshold"`
+	State     string    `json:"state"`
+	RaisedAt  time.Time `json:"raised_at"`
+}
+
+// pumpOutTrigger is a pump-out as returned by the pump-out polling trigger
+type pumpOutTrigger struct {
+	db.PumpOut
+	Cursor string `json:"cursor"` // Pass as cursor to only get newer pump-outs
+	Device string `json:"device,omitempty"`
+}
+
+// handleAlertTrigger serves the alerts raised after cursor (default: the latest ones),
+// newest first, as a flat list of items with an id as polling services such as Zapier
+// and IFTTT expect
+func handleAlertTrigger(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var after time.Time
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		nanos, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = time.Unix(0, nanos)
+	}
+
+	alerts, err := db.GetAlertsRaisedAfter(r.Context(), after, triggerLimit)
+	if err != nil {
+		log.Printf("Error getting alerts: %v", err)
+		http.Error(w, "Failed to get alerts", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]alertTrigger, 0, len(alerts))
+	for _, a := range alerts {
+		items = append(items, alertTrigger{
+			ID:        a.ID,
+			Cursor:    strconv.FormatInt(a.RaisedAt.UnixNano(), 10),
+			DeviceID:  a.DeviceID,
+			Device:    deviceLabel(r.Context(), a.DeviceID),
+			Kind:      a.Kind,
+			Severity:  a.Severity,
+			Level:     a.Level,
+			Threshold: a.Threshold,
+			State:     a.State,
+			RaisedAt:  a.RaisedAt,
+		})
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(items)
+}
+
+// handlePumpOutTrigger serves the pump-outs recorded after cursor (default: the latest ones),
+// latest recorded first, shaped like the alert trigger
+func handlePumpOutTrigger(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var afterID int64
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		if afterID, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pumpOuts, err := db.GetPumpOutsAfter(r.Context(), afterID, triggerLimit)
+	if err != nil {
+		log.Printf("Error getting pump-outs: %v", err)
+		http.Error(w, "Failed to get pump-outs", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]pumpOutTrigger, 0, len(pumpOuts))
+	for _, p := range pumpOuts {
+		items = append(items, pumpOutTrigger{
+			PumpOut: p,
+			Cursor:  strconv.FormatInt(p.ID, 10),
+			Device:  deviceLabel(r.Context(), p.DeviceID),
+		})
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(items)
+}
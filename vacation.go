@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"sceptic-monitor/internal/db"
+)
+
+// vacationPeriod returns the start and end of vacation mode from VACATION_FROM and
+// VACATION_UNTIL (RFC 3339), a zero end for open-ended. It reports false if no start
+// is set.
+func vacationPeriod() (time.Time, time.Time, bool) {
+	var from, until time.Time
+	for _, p := range []struct {
+		key string
+		t   *time.Time
+	}{{"VACATION_FROM", &from}, {"VACATION_UNTIL", &until}} {
+		s := setting(p.key)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			log.Printf("Invalid %s value: %v", p.key, err)
+			return time.Time{}, time.Time{}, false
+		}
+		*p.t = t
+	}
+	return from, until, !from.IsZero()
+}
+
+// vacationActive reports whether vacation mode is on at the time: the house is empty,
+// so alerts are more sensitive and go to the vacation recipients as well
+func vacationActive(now time.Time) bool {
+	from, until, ok := vacationPeriod()
+	return ok && !now.Before(from) && (until.IsZero() || now.Before(until))
+}
+
+// vacationOffset returns how much lower the thresholds are in vacation mode, from
+// VACATION_THRESHOLD_OFFSET (default: 0), or 0 if vacation mode is off
+func vacationOffset() float64 {
+	if !vacationActive(time.Now()) {
+		return 0
+	}
+	offset, _ := envLevel("VACATION_THRESHOLD_OFFSET")
+	return offset
+}
+
+// checkVacationRise raises a warning when the level of the device rises by more than
+// VACATION_MAX_RISE, if configured, above its lowest level since vacation mode started.
+// Nobody uses water while the house is empty, so a rise points to a leak or to
+// groundwater getting in. The alert resolves when vacation mode ends.
+func checkVacationRise(deviceID string, level float64) {
+	maxRise, ok := envLevel("VACATION_MAX_RISE")
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if !vacationActive(now) {
+		updateHardLimit(deviceID, db.AlertKindVacationRise, false)
+		return
+	}
+
+	from, _, _ := vacationPeriod()
+	stats, err := db.GetLevelStats(context.Background(), db.BucketWeek, db.ReadingFilter{DeviceID: deviceID, From: from, To: now})
+	if err != nil {
+		log.Printf("Error getting level stats: %v", err)
+		return
+	}
+
+	lowest := level
+	for _, s := range stats {
+		lowest = min(lowest, s.Min)
+	}
+
+	updateHardLimit(deviceID, db.AlertKindVacationRise, level-lowest > maxRise)
+}
+
+// vacationStatus is the state of vacation mode served by /api/vacation
+type vacationStatus struct {
+	Active          bool       `json:"active"`
+	From            *time.Time `json:"from"`
+	Until           *time.Time `json:"until"`
+	ThresholdOffset float64    `json:"threshold_offset"`
+	MaxRise         *float64   `json:"max_rise"`
+}
+
+// currentVacation returns the state of vacation mode
+func currentVacation() vacationStatus {
+	status := vacationStatus{Active: vacationActive(time.Now())}
+	if from, until, ok := vacationPeriod(); ok {
+		status.From = &from
+		if !until.IsZero() {
+			status.Until = &until
+		}
+	}
+	status.ThresholdOffset, _ = envLevel("VACATION_THRESHOLD_OFFSET")
+	if maxRise, ok := envLevel("VACATION_MAX_RISE"); ok {
+		status.MaxRise = &maxRise
+	}
+	return status
+}
+
+// handleVacation handles GET and PUT requests on vacation mode
+func handleVacation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetVacation(w, r)
+	case http.MethodPut:
+		handlePutVacation(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleGetVacation(w http.ResponseWriter, r *http.Request) {
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(currentVacation())
+}
+
+// handlePutVacation turns vacation mode on from the given time (default: now) until the
+// given time (default: until turned off), which schedules it if the start is in the
+// future. {"active": false} turns it off. It needs ADMIN_API_KEY, vacation mode changes
+// the thresholds and who is alerted.
+func handlePutVacation(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Active *bool      `json:"active"`
+		From   *time.Time `json:"from"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Empty values are stored rather than removed, so they override the environment
+	settings := map[string]string{"VACATION_FROM": "", "VACATION_UNTIL": ""}
+	if body.Active == nil || *body.Active {
+		from := time.Now().UTC()
+		if body.From != nil {
+			from = body.From.UTC()
+		}
+		settings["VACATION_FROM"] = from.Format(time.RFC3339)
+
+		if body.Until != nil {
+			if !body.Until.After(from) {
+				http.Error(w, "until must be after from", http.StatusBadRequest)
+				return
+			}
+			settings["VACATION_UNTIL"] = body.Until.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if err := storeSettings(r.Context(), settings, nil); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	status := currentVacation()
+	if status.From == nil {
+		log.Printf("Vacation mode turned off")
+	} else {
+		log.Printf("Vacation mode set from %s", status.From.Format(time.RFC3339))
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
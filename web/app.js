@@ -0,0 +1,198 @@
+// Dashboard: the level gauge from /api/gauge, a chart of the readings from /api/history,
+// the notification channel health from /api/notifications/health and the vacation mode
+// toggle on /api/vacation
+"use strict";
+
+const svgNS = "http://www.w3.org/2000/svg";
+const refreshMs = 60000;
+const maxPages = 10;
+
+let hours = 24;
+let gauge = null;
+let vacation = null;
+
+function el(name, attrs) {
+  const e = document.createElementNS(svgNS, name);
+  for (const [k, v] of Object.entries(attrs)) e.setAttribute(k, v);
+  return e;
+}
+
+async function getJSON(url) {
+  const resp = await fetch(url);
+  if (!resp.ok) throw new Error(url + ": " + resp.status);
+  return resp.json();
+}
+
+// drawGauge fills the semicircle (length 157) in proportion to the percentage, with
+// the threshold zones along an outer semicircle (length 179)
+function drawGauge(g) {
+  const fill = document.getElementById("fill");
+  let label = "–";
+  let dash = 0;
+  if (g.percent !== null) {
+    label = Math.round(g.percent) + "%";
+    dash = Math.min(Math.max(g.percent, 0), 100) / 100 * 157;
+  } else if (g.level !== null) {
+    label = g.level.toFixed(1);
+  }
+  fill.setAttribute("class", g.status);
+  fill.setAttribute("stroke-dasharray", dash + " 158");
+  document.getElementById("label").textContent = label;
+  document.getElementById("status").textContent = g.status;
+
+  const zones = document.getElementById("zones");
+  zones.replaceChildren();
+  if (g.max_level) {
+    for (const z of g.zones) {
+      const from = Math.min(z.from / g.max_level, 1);
+      const to = Math.min(z.to / g.max_level, 1);
+      zones.appendChild(el("path", {
+        class: "zone " + z.status, d: "M 3 60 A 57 57 0 0 1 117 60", fill: "none", "stroke-width": 3,
+        "stroke-dasharray": (to - from) * 179 + " 180", "stroke-dashoffset": -from * 179,
+      }));
+    }
+  }
+
+  let eta = "";
+  if (g.eta) {
+    const days = (new Date(g.eta) - Date.now()) / 86400000;
+    eta = days < 1 ? "Threshold within a day" :
+      "Threshold in " + Math.round(days) + " days (" + new Date(g.eta).toLocaleDateString(undefined, { day: "numeric", month: "short" }) + ")";
+  }
+  document.getElementById("eta").textContent = eta;
+
+  document.getElementById("updated").textContent = g.updated_at ?
+    "Last reading " + new Date(g.updated_at).toLocaleString() : "No readings yet";
+}
+
+// loadReadings pages through the history of the last hours, up to maxPages pages
+async function loadReadings() {
+  const from = new Date(Date.now() - hours * 3600000).toISOString().replace(/\.\d+Z$/, "Z");
+  let readings = [];
+  let cursor = "";
+  for (let page = 0; page < maxPages; page++) {
+    const params = new URLSearchParams({ from: from, limit: 1000 });
+    if (cursor) params.set("cursor", cursor);
+    const resp = await getJSON("/api/history?" + params);
+    readings = readings.concat(resp.readings);
+    cursor = resp.next_cursor;
+    if (!cursor) break;
+  }
+  return readings;
+}
+
+// drawChart plots the readings over the selected period with the threshold zones as lines
+function drawChart(readings) {
+  const chart = document.getElementById("chart");
+  chart.replaceChildren();
+  document.getElementById("chart-empty").hidden = readings.length > 0;
+  if (readings.length === 0) return;
+
+  const w = 600, h = 240, pad = 24;
+  const end = Date.now();
+  const start = end - hours * 3600000;
+  const levels = readings.map(r => r.level);
+  const limits = gauge ? gauge.zones.map(z => z.from).filter(l => l > 0) : [];
+  let lo = Math.min(...levels, ...limits);
+  let hi = Math.max(...levels, ...limits);
+  if (hi === lo) { hi += 1; lo -= 1; }
+
+  const x = t => pad + (t - start) / (end - start) * (w - 2 * pad);
+  const y = l => h - pad - (l - lo) / (hi - lo) * (h - 2 * pad);
+
+  if (gauge) {
+    for (const z of gauge.zones) {
+      if (z.from <= 0) continue;
+      chart.appendChild(el("line", { class: "threshold " + z.status, x1: pad, x2: w - pad, y1: y(z.from), y2: y(z.from) }));
+    }
+  }
+
+  const points = readings.map(r => x(new Date(r.timestamp)).toFixed(1) + "," + y(r.level).toFixed(1));
+  chart.appendChild(el("polyline", { class: "line", points: points.join(" ") }));
+
+  for (const l of [lo, hi]) {
+    const t = el("text", { class: "axis", x: 2, y: y(l) + 3 });
+    t.textContent = l.toFixed(0);
+    chart.appendChild(t);
+  }
+}
+
+// drawChannels lists the notification channels, flagging failing ones
+function drawChannels(channels) {
+  const time = t => t ? new Date(t).toLocaleString() : "–";
+  const rows = channels.map(c => {
+    const tr = document.createElement("tr");
+    if (c.breaker !== "closed" || c.consecutive_failures > 0 || c.check_error) tr.className = "failing";
+    const cells = [
+      c.channel,
+      time(c.last_success),
+      c.last_failure ? time(c.last_failure) + " (" + c.last_error + ")" : "–",
+      c.consecutive_failures,
+      c.breaker.replace("_", "-") + (c.open_until ? " until " + new Date(c.open_until).toLocaleTimeString() : ""),
+      c.last_check ? (c.check_error || "ok") : "–",
+    ];
+    for (const text of cells) {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.appendChild(td);
+    }
+    return tr;
+  });
+  document.querySelector("#channels tbody").replaceChildren(...rows);
+}
+
+// drawVacation shows whether vacation mode is on, or when it is scheduled to start
+function drawVacation(v) {
+  vacation = v;
+  const button = document.getElementById("vacation");
+  const date = t => new Date(t).toLocaleDateString(undefined, { day: "numeric", month: "short" });
+  let text = "Vacation mode: off";
+  if (v.active) {
+    text = "Vacation mode: on" + (v.until ? " until " + date(v.until) : "");
+  } else if (v.from && new Date(v.from) > Date.now()) {
+    text = "Vacation mode: from " + date(v.from);
+  }
+  button.textContent = text;
+  button.classList.toggle("active", v.active);
+}
+
+async function refresh() {
+  try {
+    gauge = await getJSON("/api/gauge");
+    drawGauge(gauge);
+    drawChart(await loadReadings());
+    drawChannels(await getJSON("/api/notifications/health"));
+    drawVacation(await getJSON("/api/vacation"));
+  } catch (err) {
+    document.getElementById("updated").textContent = "Failed to load: " + err.message;
+  }
+}
+
+for (const button of document.querySelectorAll(".ranges button")) {
+  button.addEventListener("click", () => {
+    document.querySelector(".ranges .active").classList.remove("active");
+    button.classList.add("active");
+    hours = Number(button.dataset.hours);
+    refresh();
+  });
+}
+
+// Toggles vacation mode, turning it on now until turned off again
+document.getElementById("vacation").addEventListener("click", async () => {
+  if (!vacation) return;
+  try {
+    const resp = await fetch("/api/vacation", {
+      method: "PUT",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ active: !vacation.active }),
+    });
+    if (!resp.ok) throw new Error("/api/vacation: " + resp.status);
+    drawVacation(await resp.json());
+    refresh();
+  } catch (err) {
+    document.getElementById("updated").textContent = "Failed to switch vacation mode: " + err.message;
+  }
+});
+
+refresh();
+setInterval(refresh, refreshMs);
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/webhook"
+)
+
+// webhookTarget is a URL alert events are posted to, with the ID it was registered
+// under through the API, or 0 if it's in WEBHOOK_URLS
+type webhookTarget struct {
+	id  int64
+	url string
+}
+
+// webhookTargets returns the webhooks alert events are posted to: the comma-separated
+// WEBHOOK_URLS plus the webhooks registered through the API
+func webhookTargets(ctx context.Context) []webhookTarget {
+	var targets []webhookTarget
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			targets = append(targets, webhookTarget{url: u})
+		}
+	}
+
+	webhooks, err := db.GetWebhooks(ctx)
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+	}
+	for _, w := range webhooks {
+		targets = append(targets, webhookTarget{id: w.ID, url: w.URL})
+	}
+
+	return targets
+}
+
+// alertEvent returns the webhook event of an alert
+func alertEvent(ctx context.Context, event string, alert db.Alert, message string) webhook.Event {
+	return webhook.Event{
+		Version:   webhook.EventVersion,
+		Event:     event,
+		AlertID:   alert.ID,
+		DeviceID:  alert.DeviceID,
+		Device:    deviceLabel(ctx, alert.DeviceID),
+		Kind:      alert.Kind,
+		Severity:  alert.Severity,
+		Level:     alert.Level,
+		Threshold: alert.Threshold,
+		Message:   message,
+		Timestamp: time.Now(),
+	}
+}
+
+// webhookDeliveries returns a delivery of the event to the webhooks, none if no webhook is configured
+func webhookDeliveries(ctx context.Context, event webhook.Event) []delivery {
+	targets := webhookTargets(ctx)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return []delivery{{
+		channel: "webhook",
+		send: func(ctx context.Context) error {
+			return postWebhooks(ctx, targets, event)
+		},
+	}}
+}
+
+// postWebhooks posts the event to every webhook. It fails only if no webhook accepted it.
+func postWebhooks(ctx context.Context, targets []webhookTarget, event webhook.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var lastErr error
+	posted := false
+	for _, t := range targets {
+		d := postWebhook(ctx, db.WebhookDelivery{WebhookID: t.id, URL: t.url, Event: event.Event, Payload: string(payload)})
+		if d.Status != db.DeliveryDelivered {
+			log.Printf("Error posting %s event to webhook %s: %s", event.Event, t.url, d.Error)
+			lastErr = errors.New(d.Error)
+			continue
+		}
+		posted = true
+	}
+
+	if !posted {
+		return lastErr
+	}
+	return nil
+}
+
+// postWebhook posts the payload of the delivery to its URL and records the attempt,
+// returning the recorded delivery
+func postWebhook(ctx context.Context, d db.WebhookDelivery) *db.WebhookDelivery {
+	nctx, cancel := notifyContext(ctx)
+	result, err := webhook.Post(nctx, d.URL, []byte(d.Payload))
+	cancel()
+
+	d.Status = db.DeliveryDelivered
+	if err != nil {
+		d.Status, d.Error = db.DeliveryFailed, err.Error()
+	}
+	d.ResponseCode, d.Response, d.LatencyMs = result.StatusCode, result.Response, result.Latency.Milliseconds()
+
+	saved, err := db.SaveWebhookDelivery(ctx, d)
+	if err != nil {
+		log.Printf("Error recording webhook delivery: %v", err)
+		return &d
+	}
+	return saved
+}
+
+// notifyWebhooksResolved posts the resolved event of the alert to the webhooks
+func notifyWebhooksResolved(ctx context.Context, alert db.Alert, reason string) {
+	targets := webhookTargets(ctx)
+	if len(targets) == 0 {
+		return
+	}
+
+	if err := postWebhooks(ctx, targets, alertEvent(ctx, webhook.EventResolved, alert, "Resolved: "+reason)); err != nil {
+		log.Printf("Error posting resolved event of alert %s: %v", alert.ID, err)
+	}
+}
+
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetWebhooks(w, r)
+	case http.MethodPost:
+		handleRegisterWebhook(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetWebhooks lists the webhooks registered through the API, not those in WEBHOOK_URLS
+func handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := db.GetWebhooks(r.Context())
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+		http.Error(w, "Failed to get webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// WebhookRequest registers a webhook
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+// handleRegisterWebhook registers an http or https URL to receive alert events
+func handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := db.SaveWebhook(r.Context(), u.String())
+	if err != nil {
+		log.Printf("Error saving webhook: %v", err)
+		http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// handleDeleteWebhook unregisters a webhook registered through the API
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE method
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid webhook ID: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := db.DeleteWebhook(r.Context(), id)
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetWebhookDeliveries lists the latest delivery attempts to a webhook, newest first
+// (limit, default: 50). Webhook 0 lists the deliveries to the webhooks in WEBHOOK_URLS.
+func handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid webhook ID: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(r.Context(), id, limit)
+	if err != nil {
+		log.Printf("Error getting webhook deliveries: %v", err)
+		http.Error(w, "Failed to get webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// handleRedeliverWebhook posts the payload of a past delivery again, e.g. once a failing
+// receiver is fixed, to the URL it was first posted to. The new attempt is recorded as a
+// delivery of its own and returned.
+func handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid webhook ID: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.ParseInt(r.PathValue("n"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid delivery ID: %s", r.PathValue("n")), http.StatusBadRequest)
+		return
+	}
+
+	original, err := db.GetWebhookDelivery(r.Context(), id, n)
+	if err != nil {
+		log.Printf("Error getting webhook delivery: %v", err)
+		http.Error(w, "Failed to get webhook delivery", http.StatusInternalServerError)
+		return
+	}
+	if original == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	d := postWebhook(r.Context(), db.WebhookDelivery{
+		WebhookID:    original.WebhookID,
+		URL:          original.URL,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		RedeliveryOf: &original.ID,
+	})
+	log.Printf("Redelivered webhook delivery %d to %s: %s", original.ID, original.URL, d.Status)
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(d)
+}
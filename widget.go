@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/incident"
+)
+
+// widgetVersion is bumped whenever the widget JSON shape changes incompatibly
+const widgetVersion = 1
+
+// Widget is the stable public JSON shape of the level widget
+type Widget struct {
+	Version   int        `json:"version"`
+	Level     *float64   `json:"level"`     // Latest level, null without data
+	MaxLevel  *float64   `json:"max_level"` // Full scale of the gauge, null if not configured
+	Percent   *float64   `json:"percent"`   // Level as a percentage of max_level
+	Status    string     `json:"status"`    // OK, Warning or Critical
+	UpdatedAt *time.Time `json:"updated_at"`
+	Zones     []Zone     `json:"zones"` // Derived from the thresholds, lowest first
+	ETA       *time.Time `json:"eta"`   // Alert threshold reached at the current fill rate, null if not rising
+}
+
+// Zone is a band of the gauge, colored by the status levels within it map to
+type Zone struct {
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Status string  `json:"status"` // OK, Warning or Critical
+}
+
+// currentWidget assembles the widget from the latest reading and the alert state
+func currentWidget(ctx context.Context) (Widget, error) {
+	status, err := publicStatus(ctx)
+	if err != nil {
+		return Widget{}, err
+	}
+
+	widget := Widget{
+		Version:   widgetVersion,
+		Status:    status.Status,
+		UpdatedAt: status.LastUpdated,
+	}
+
+	if status.LastUpdated != nil {
+		level, err := db.GetLatestLevelData(ctx)
+		if err != nil {
+			return Widget{}, err
+		}
+		widget.Level = &level
+	}
+
+	maxLevel, ok := widgetMaxLevel()
+	if ok {
+		widget.MaxLevel = &maxLevel
+		if widget.Level != nil {
+			percent := math.Round(*widget.Level/maxLevel*1000) / 10
+			widget.Percent = &percent
+		}
+	}
+	widget.Zones = widgetZones(maxLevel)
+
+	// Forecast for the device that reported last, the one the level is of
+	if deviceID, err := latestDeviceID(ctx); err != nil {
+		log.Printf("Error getting latest device: %v", err)
+	} else if deviceID != "" {
+		if risk, err := computeRisk(ctx, deviceID); err == nil {
+			widget.ETA = risk.ETA
+		}
+	}
+
+	return widget, nil
+}
+
+// widgetZones returns the zones of the gauge up to the max level: OK below the lowest
+// threshold, then each threshold's severity up to the next. The highest zone only shows
+// if WIDGET_MAX_LEVEL is above the highest threshold.
+func widgetZones(maxLevel float64) []Zone {
+	zones := []Zone{}
+	from, status := 0.0, statusOK
+	for _, t := range levelThresholds() {
+		if t.level > from {
+			zones = append(zones, Zone{From: from, To: t.level, Status: status})
+		}
+		from, status = t.level, statusWarning
+		if t.severity == incident.SeverityCritical {
+			status = statusCritical
+		}
+	}
+
+	if len(zones) > 0 && maxLevel > from {
+		zones = append(zones, Zone{From: from, To: maxLevel, Status: status})
+	}
+	return zones
+}
+
+// latestDeviceID returns the device with the most recent reading, or "" if there is none
+func latestDeviceID(ctx context.Context) (string, error) {
+	times, err := db.GetLastReadingTimes(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	for deviceID, t := range times {
+		if latest == "" || t.After(times[latest]) {
+			latest = deviceID
+		}
+	}
+	return latest, nil
+}
+
+// widgetMaxLevel returns the full scale of the gauge from WIDGET_MAX_LEVEL,
+// falling back to the highest threshold
+func widgetMaxLevel() (float64, bool) {
+	if s := os.Getenv("WIDGET_MAX_LEVEL"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil && v > 0 {
+			return v, true
+		}
+		log.Printf("Invalid WIDGET_MAX_LEVEL value: %q", s)
+	}
+
+	if v, ok := topThreshold(); ok && v > 0 {
+		return v, true
+	}
+	return 0, false
+}
+
+var widgetTemplate = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="300">
+<title>Tank level</title>
+<style>
+html, body { margin: 0; height: 100%; background: transparent; font-family: sans-serif; }
+svg { width: 100%; height: 100%; }
+.track { stroke: #ddd; }
+.OK { stroke: #2e7d32; }
+.Warning { stroke: #f9a825; }
+.Critical { stroke: #c62828; }
+.zone { opacity: 0.6; }
+text { text-anchor: middle; fill: currentColor; }
+</style>
+</head>
+<body>
+<svg viewBox="0 0 120 84">
+{{range .Zones}}<path class="zone {{.Status}}" d="M 3 60 A 57 57 0 0 1 117 60" fill="none" stroke-width="3" stroke-dasharray="{{.Length}} 180" stroke-dashoffset="-{{.Offset}}"/>
+{{end}}<path class="track" d="M 10 60 A 50 50 0 0 1 110 60" fill="none" stroke-width="10"/>
+<path class="{{.Status}}" d="M 10 60 A 50 50 0 0 1 110 60" fill="none" stroke-width="10" stroke-dasharray="{{.Dash}} 158"/>
+<text x="60" y="55" font-size="16">{{.Label}}</text>
+<text x="60" y="70" font-size="8">{{.Status}}</text>
+{{if .ETA}}<text x="60" y="81" font-size="6">{{.ETA}}</text>{{end}}
+</svg>
+</body>
+</html>
+`))
+
+// handleWidget renders the level gauge for embedding in an iframe
+func handleWidget(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	widget, err := currentWidget(r.Context())
+	if err != nil {
+		log.Printf("Error getting widget: %v", err)
+		http.Error(w, "Failed to get widget", http.StatusInternalServerError)
+		return
+	}
+
+	// Fill the semicircle (length 157) in proportion to the percentage
+	label := "–"
+	dash := 0.0
+	switch {
+	case widget.Percent != nil:
+		label = strconv.FormatFloat(*widget.Percent, 'f', 0, 64) + "%"
+		dash = math.Min(math.Max(*widget.Percent, 0), 100) / 100 * 157
+	case widget.Level != nil:
+		label = strconv.FormatFloat(*widget.Level, 'f', 1, 64)
+	}
+
+	// Zones run along an outer semicircle (length 179) in proportion to the max level
+	type zoneArc struct {
+		Status         string
+		Offset, Length float64
+	}
+	var zones []zoneArc
+	if widget.MaxLevel != nil {
+		for _, z := range widget.Zones {
+			zones = append(zones, zoneArc{
+				Status: z.Status,
+				Offset: math.Round(math.Min(z.From / *widget.MaxLevel, 1)*1790) / 10,
+				Length: math.Round((math.Min(z.To / *widget.MaxLevel, 1)-math.Min(z.From / *widget.MaxLevel, 1))*1790) / 10,
+			})
+		}
+	}
+
+	eta := ""
+	if widget.ETA != nil {
+		days := time.Until(*widget.ETA).Hours() / 24
+		eta = fmt.Sprintf("Threshold in %.0f days (%s)", days, widget.ETA.Format("2 Jan"))
+		if days < 1 {
+			eta = "Threshold within a day"
+		}
+	}
+
+	// Allow embedding on any site
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	data := map[string]any{"Status": widget.Status, "Label": label, "Dash": dash, "Zones": zones, "ETA": eta}
+	if err := widgetTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering widget: %v", err)
+	}
+}
+
+func handleWidgetJSON(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET method
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	widget, err := currentWidget(r.Context())
+	if err != nil {
+		log.Printf("Error getting widget: %v", err)
+		http.Error(w, "Failed to get widget", http.StatusInternalServerError)
+		return
+	}
+
+	// Home dashboards fetch this from the browser on other origins
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(widget)
+}
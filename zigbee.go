@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sceptic-monitor/internal/db"
+	"sceptic-monitor/internal/mqtt"
+)
+
+// zigbeeDevices returns the Zigbee2MQTT devices to read from ZIGBEE2MQTT_DEVICES,
+// entries of the form "friendly_name=property,...". A numeric property such as
+// liquid_depth is stored as the level reading of the device, a boolean property
+// such as water_leak is treated as an overflow sensor.
+func zigbeeDevices() map[string]string {
+	devices := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("ZIGBEE2MQTT_DEVICES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, property, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || property == "" {
+			log.Printf("Invalid ZIGBEE2MQTT_DEVICES entry: %q", entry)
+			continue
+		}
+		devices[name] = property
+	}
+	return devices
+}
+
+// subscribeZigbee2MQTT subscribes to the state topics of the configured Zigbee2MQTT devices
+func subscribeZigbee2MQTT() {
+	baseTopic := os.Getenv("ZIGBEE2MQTT_BASE_TOPIC")
+	if baseTopic == "" {
+		baseTopic = "zigbee2mqtt"
+	}
+
+	for name, property := range zigbeeDevices() {
+		mqtt.Subscribe(baseTopic+"/"+name, func(topic string, payload []byte) {
+			err := handleZigbeeMessage(name, property, topic, payload)
+			if err != nil {
+				log.Printf("Error handling Zigbee2MQTT message from %s: %v", name, err)
+			}
+			recordIngest(db.TransportZigbee, topic, err)
+		})
+		log.Printf("Reading %s of Zigbee2MQTT device %s", property, name)
+	}
+}
+
+// handleZigbeeMessage turns a Zigbee2MQTT state message into a reading or an overflow state,
+// the friendly name serves as device ID
+func handleZigbeeMessage(name, property, topic string, payload []byte) error {
+	var state map[string]any
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := db.TouchDevice(ctx, name, ""); err != nil {
+		log.Printf("Error updating device: %v", err)
+	}
+
+	// Zigbee2MQTT reports the battery in percent
+	if battery, ok := state["battery"].(float64); ok {
+		if err := db.SaveTelemetry(ctx, name, metricBattery, battery); err != nil {
+			log.Printf("Error saving %s telemetry: %v", metricBattery, err)
+		}
+	}
+
+	// Climate sensors report the temperature, used for temperature compensation
+	if temperature, ok := state[metricTemperature].(float64); ok {
+		if err := db.SaveTelemetry(ctx, name, metricTemperature, temperature); err != nil {
+			log.Printf("Error saving %s telemetry: %v", metricTemperature, err)
+		}
+	}
+
+	switch value := state[property].(type) {
+	case float64:
+		reading := db.Reading{Level: value, Timestamp: time.Now(), Transport: db.TransportZigbee, Source: topic}
+		if _, err := ingestReading(ctx, name, reading, false); err != nil {
+			return fmt.Errorf("failed to save reading: %w", err)
+		}
+	case bool:
+		updateHardLimit(name, db.AlertKindOverflow, value)
+	case nil:
+		// State messages only carry the properties that changed
+	default:
+		return fmt.Errorf("unsupported value of %s: %v", property, value)
+	}
+
+	return nil
+}